@@ -101,8 +101,12 @@ type Item struct {
 	Signal  signal.Signal
 	Cover   []uint64
 	Updates []ItemUpdate
+	// Score is the fuzzer's quality score of the program (0 if unknown).
+	Score float64
 
 	areas map[*focusAreaState]struct{}
+	// Weight set by SetProgramWeight, applied to the focus areas the program joins later.
+	weight float64
 }
 
 func (item Item) StringCall() string {
@@ -115,6 +119,9 @@ type NewInput struct {
 	Signal   signal.Signal
 	Cover    []uint64
 	RawCover []uint64
+	// Score is optional, 0 means that the program was not scored
+	// (e.g. scoring is disabled or the corpus was saved by an older version).
+	Score float64
 }
 
 type NewItemEvent struct {
@@ -122,6 +129,7 @@ type NewItemEvent struct {
 	Exists   bool
 	ProgData []byte
 	NewCover []uint64
+	Score    float64
 }
 
 func (corpus *Corpus) Save(inp NewInput) {
@@ -151,7 +159,9 @@ func (corpus *Corpus) Save(inp NewInput) {
 			Signal:  newSignal,
 			Cover:   newCover.Serialize(),
 			Updates: append([]ItemUpdate{}, old.Updates...),
+			Score:   max(old.Score, inp.Score),
 			areas:   maps.Clone(old.areas),
+			weight:  old.weight,
 		}
 		const maxUpdates = 32
 		if len(newItem.Updates) < maxUpdates {
@@ -168,10 +178,11 @@ func (corpus *Corpus) Save(inp NewInput) {
 			Signal:  inp.Signal,
 			Cover:   inp.Cover,
 			Updates: []ItemUpdate{update},
+			Score:   inp.Score,
 		}
 		corpus.progsMap[sig] = item
 		corpus.applyFocusAreas(item, inp.Cover)
-		corpus.saveProgram(inp.Prog, inp.Signal)
+		corpus.saveProgram(sig, inp.Prog, inp.Signal)
 	}
 	corpus.signal.Merge(inp.Signal)
 	newCover := corpus.cover.MergeDiff(inp.Cover)
@@ -183,6 +194,7 @@ func (corpus *Corpus) Save(inp NewInput) {
 			Exists:   exists,
 			ProgData: progData,
 			NewCover: newCover,
+			Score:    corpus.progsMap[sig].Score,
 		}:
 		}
 	}
@@ -200,7 +212,8 @@ func (corpus *Corpus) applyFocusAreas(item *Item, coverDelta []uint64) {
 		if !matches {
 			continue
 		}
-		area.saveProgram(item.Prog, item.Signal)
+		area.saveProgram(item.Sig, item.Prog, item.Signal)
+		area.setWeight(item.Sig, item.weight)
 		if item.areas == nil {
 			item.areas = make(map[*focusAreaState]struct{})
 			item.areas[area] = struct{}{}
@@ -230,6 +243,17 @@ func (corpus *Corpus) Item(sig string) *Item {
 	return corpus.progsMap[sig]
 }
 
+// ProgramByHash returns the corpus program with the given hash (see Item.Sig), or nil.
+// The lookup uses the index maintained by Save, so it does not rehash the corpus programs.
+func (corpus *Corpus) ProgramByHash(hash string) *prog.Prog {
+	corpus.mu.RLock()
+	defer corpus.mu.RUnlock()
+	if item := corpus.progsMap[hash]; item != nil {
+		return item.Prog
+	}
+	return nil
+}
+
 type CallCov struct {
 	Count int
 	Cover cover.Cover
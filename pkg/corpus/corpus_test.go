@@ -5,9 +5,11 @@ package corpus
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"testing"
 
+	"github.com/google/syzkaller/pkg/hash"
 	"github.com/google/syzkaller/pkg/signal"
 	"github.com/google/syzkaller/prog"
 	"github.com/google/syzkaller/sys/targets"
@@ -50,6 +52,7 @@ func TestCorpusOperation(t *testing.T) {
 	// Verify the total signal.
 	assert.Equal(t, 5, corpus.StatSignal.Val())
 	assert.Equal(t, 2, corpus.StatProgs.Val())
+	assert.Equal(t, 2, corpus.NumPrograms())
 
 	corpus.Minimize(true)
 }
@@ -76,6 +79,33 @@ func TestCorpusCoverage(t *testing.T) {
 	assert.Equal(t, corpus.StatCover.Val(), 3)
 }
 
+func TestCorpusSaveScore(t *testing.T) {
+	target := getTarget(t, targets.TestOS, targets.TestArch64)
+	ch := make(chan NewItemEvent)
+	corpus := NewMonitoredCorpus(context.Background(), ch)
+	rs := rand.NewSource(0)
+
+	inp := generateInput(target, rs, 5)
+	inp.Score = 0.75
+	go corpus.Save(inp)
+	event := <-ch
+	assert.Equal(t, 0.75, event.Score)
+	assert.Equal(t, 0.75, corpus.Item(event.Sig).Score)
+
+	// Re-saving the program without a score (e.g. from an older corpus) keeps the known score.
+	inp.Score = 0
+	go corpus.Save(inp)
+	event = <-ch
+	assert.True(t, event.Exists)
+	assert.Equal(t, 0.75, corpus.Item(event.Sig).Score)
+
+	// Unscored programs are still accepted.
+	inp2 := generateInput(target, rs, 5)
+	go corpus.Save(inp2)
+	event = <-ch
+	assert.Equal(t, 0.0, corpus.Item(event.Sig).Score)
+}
+
 func TestCorpusSaveConcurrency(t *testing.T) {
 	target := getTarget(t, targets.TestOS, targets.TestArch64)
 	corpus := NewCorpus(context.Background())
@@ -98,6 +128,44 @@ func TestCorpusSaveConcurrency(t *testing.T) {
 	}
 }
 
+func TestProgramByHash(t *testing.T) {
+	target := getTarget(t, targets.TestOS, targets.TestArch64)
+	corpus := NewCorpus(context.Background())
+	rs := rand.NewSource(0)
+	inp := generateInput(target, rs, 5)
+	corpus.Save(inp)
+	sig := hash.String(inp.Prog.Serialize())
+	assert.Same(t, inp.Prog, corpus.ProgramByHash(sig))
+	assert.Nil(t, corpus.ProgramByHash(hash.String(generateInput(target, rs, 5).Prog.Serialize())))
+}
+
+func BenchmarkProgramByHash(b *testing.B) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// The per-lookup time must not grow with the corpus size.
+	for _, size := range []int{100, 10000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			corpus := NewCorpus(context.Background())
+			rs := rand.NewSource(0)
+			var hashes []string
+			for i := 0; i < size; i++ {
+				inp := generateRangedInput(target, rs, i, i)
+				corpus.Save(inp)
+				hashes = append(hashes, hash.String(inp.Prog.Serialize()))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if corpus.ProgramByHash(hashes[i%len(hashes)]) == nil {
+					b.Fatal("program not found")
+				}
+			}
+		})
+	}
+}
+
 func generateInput(target *prog.Target, rs rand.Source, sizeSig int) NewInput {
 	return generateRangedInput(target, rs, 1, sizeSig)
 }
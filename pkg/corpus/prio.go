@@ -4,6 +4,7 @@
 package corpus
 
 import (
+	"math/bits"
 	"math/rand"
 	"sort"
 
@@ -15,6 +16,14 @@ type ProgramsList struct {
 	progs    []*prog.Prog
 	sumPrios int64
 	accPrios []int64
+	// Maps program signatures (Item.Sig) to their positions in progs.
+	index map[string]int
+	// Weights set by SetProgramWeight, by position in progs.
+	weights []float64
+	// Fenwick tree over prio*weight of the programs, so that both weight updates
+	// and weighted choices take O(log n) time.
+	boostTree []float64
+	sumBoost  float64
 }
 
 func (pl *ProgramsList) chooseProgram(r *rand.Rand) *prog.Prog {
@@ -28,17 +37,114 @@ func (pl *ProgramsList) chooseProgram(r *rand.Rand) *prog.Prog {
 	return pl.progs[idx]
 }
 
-func (pl *ProgramsList) saveProgram(p *prog.Prog, signal signal.Signal) {
+// chooseProgramWeighted is like chooseProgram, but additionally scales the priority
+// of the programs with a positive weight (see setWeight) by (1 + weight).
+// Programs without a weight keep their original priority.
+func (pl *ProgramsList) chooseProgramWeighted(r *rand.Rand) *prog.Prog {
+	if len(pl.progs) == 0 {
+		return nil
+	}
+	sumBoost := max(pl.sumBoost, 0)
+	randVal := r.Float64() * (float64(pl.sumPrios) + sumBoost)
+	if randVal < float64(pl.sumPrios) || sumBoost == 0 {
+		return pl.chooseProgram(r)
+	}
+	return pl.progs[pl.searchBoost(randVal-float64(pl.sumPrios))]
+}
+
+// setWeight sets the weight of the program with the given signature.
+// Programs that are not in the list are ignored.
+func (pl *ProgramsList) setWeight(sig string, weight float64) {
+	idx, ok := pl.index[sig]
+	if !ok {
+		return
+	}
+	weight = max(weight, 0)
+	delta := float64(pl.prio(idx)) * (weight - pl.weights[idx])
+	if delta == 0 {
+		return
+	}
+	pl.weights[idx] = weight
+	pl.sumBoost += delta
+	for i := idx + 1; i <= len(pl.boostTree); i += i & -i {
+		pl.boostTree[i-1] += delta
+	}
+}
+
+// searchBoost returns the position of the program the cumulative boost of which
+// first exceeds val.
+func (pl *ProgramsList) searchBoost(val float64) int {
+	pos := 0
+	for step := 1 << bits.Len(uint(len(pl.boostTree))); step > 0; step >>= 1 {
+		if next := pos + step; next <= len(pl.boostTree) && pl.boostTree[next-1] <= val {
+			pos = next
+			val -= pl.boostTree[next-1]
+		}
+	}
+	// Rounding errors may leave val slightly above the total boost.
+	return min(pos, len(pl.progs)-1)
+}
+
+func (pl *ProgramsList) prio(idx int) int64 {
+	if idx == 0 {
+		return pl.accPrios[0]
+	}
+	return pl.accPrios[idx] - pl.accPrios[idx-1]
+}
+
+func (pl *ProgramsList) saveProgram(sig string, p *prog.Prog, signal signal.Signal) {
 	prio := int64(len(signal))
 	if prio == 0 {
 		prio = 1
 	}
+	if pl.index == nil {
+		pl.index = make(map[string]int)
+	}
+	pl.index[sig] = len(pl.progs)
 	pl.sumPrios += prio
 	pl.accPrios = append(pl.accPrios, pl.sumPrios)
 	pl.progs = append(pl.progs, p)
+	// The new Fenwick tree node covers the new program (with no weight yet)
+	// and the nodes of the preceding programs in its range.
+	n := len(pl.progs)
+	node := 0.0
+	for step := 1; step < n&-n; step <<= 1 {
+		node += pl.boostTree[n-step-1]
+	}
+	pl.weights = append(pl.weights, 0)
+	pl.boostTree = append(pl.boostTree, node)
 }
 
 func (corpus *Corpus) ChooseProgram(r *rand.Rand) *prog.Prog {
+	return corpus.chooseProgramFrom(r, false)
+}
+
+// ChooseProgramWeighted chooses a program the same way ChooseProgram does,
+// but the priority of every program with a positive weight (see SetProgramWeight)
+// is multiplied by (1 + weight). Programs without a weight are chosen as before.
+func (corpus *Corpus) ChooseProgramWeighted(r *rand.Rand) *prog.Prog {
+	return corpus.chooseProgramFrom(r, true)
+}
+
+// SetProgramWeight sets the weight used by ChooseProgramWeighted for the corpus program
+// with the given signature (Item.Sig). Non-positive weights remove the boost.
+// Signatures of programs that are not in the corpus are ignored, so the weights
+// only ever cover corpus programs.
+func (corpus *Corpus) SetProgramWeight(sig string, weight float64) {
+	corpus.mu.Lock()
+	defer corpus.mu.Unlock()
+	item := corpus.progsMap[sig]
+	if item == nil {
+		return
+	}
+	item.weight = max(weight, 0)
+	corpus.ProgramsList.setWeight(sig, item.weight)
+	for _, area := range corpus.focusAreas {
+		area.setWeight(sig, item.weight)
+	}
+}
+
+func (corpus *Corpus) chooseProgramFrom(r *rand.Rand, weighted bool) *prog.Prog {
 	corpus.mu.RLock()
 	defer corpus.mu.RUnlock()
 	if len(corpus.progsMap) == 0 {
@@ -66,10 +172,14 @@ func (corpus *Corpus) ChooseProgram(r *rand.Rand) *prog.Prog {
 			currSum += area.Weight
 		}
 	}
+	list := corpus.ProgramsList
 	if randArea != nil {
-		return randArea.chooseProgram(r)
+		list = randArea.ProgramsList
+	}
+	if !weighted {
+		return list.chooseProgram(r)
 	}
-	return corpus.chooseProgram(r)
+	return list.chooseProgramWeighted(r)
 }
 
 func (corpus *Corpus) Programs() []*prog.Prog {
@@ -77,3 +187,11 @@ func (corpus *Corpus) Programs() []*prog.Prog {
 	defer corpus.mu.RUnlock()
 	return corpus.progs
 }
+
+// NumPrograms returns the number of programs in the corpus.
+// It's cheaper than len(Programs()) for callers that only need the count.
+func (corpus *Corpus) NumPrograms() int {
+	corpus.mu.RLock()
+	defer corpus.mu.RUnlock()
+	return len(corpus.progs)
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/google/syzkaller/pkg/hash"
 	"github.com/google/syzkaller/prog"
 	"github.com/google/syzkaller/sys/targets"
 	"github.com/stretchr/testify/assert"
@@ -49,6 +50,84 @@ func TestChooseProgram(t *testing.T) {
 	}
 }
 
+func TestChooseProgramWeighted(t *testing.T) {
+	rs := rand.NewSource(0)
+	r := rand.New(rs)
+	target := getTarget(t, targets.TestOS, targets.TestArch64)
+	corpus := NewCorpus(context.Background())
+
+	var progs []*prog.Prog
+	for i := 0; i < 10; i++ {
+		inp := generateInput(target, rs, 10)
+		corpus.Save(inp)
+		progs = append(progs, inp.Prog)
+	}
+	sig := func(p *prog.Prog) string {
+		return hash.String(p.Serialize())
+	}
+	corpus.SetProgramWeight(sig(progs[0]), 3)
+	// Non-positive weights leave the priority as is.
+	corpus.SetProgramWeight(sig(progs[1]), -1)
+	// Programs that are not in the corpus are ignored.
+	corpus.SetProgramWeight("unknown", 100)
+	const total = 10000
+	choose := func() map[*prog.Prog]int {
+		counters := make(map[*prog.Prog]int)
+		for i := 0; i < total; i++ {
+			counters[corpus.ChooseProgramWeighted(r)]++
+		}
+		return counters
+	}
+	counters := choose()
+	// The weighted program has priority 10*(1+3), the other 9 programs have priority 10.
+	assert.InDelta(t, total*40/130, counters[progs[0]], total/50)
+	for _, p := range progs[1:] {
+		assert.InDelta(t, total*10/130, counters[p], total/50)
+	}
+
+	// Weights are updated in place, the last weight wins.
+	corpus.SetProgramWeight(sig(progs[0]), 0)
+	corpus.SetProgramWeight(sig(progs[9]), 1)
+	counters = choose()
+	assert.InDelta(t, total*20/110, counters[progs[9]], total/50)
+	for _, p := range progs[:9] {
+		assert.InDelta(t, total*10/110, counters[p], total/50)
+	}
+	// Programs saved after the weights were set start without a boost.
+	inp := generateInput(target, rs, 10)
+	corpus.Save(inp)
+	counters = choose()
+	assert.InDelta(t, total*20/120, counters[progs[9]], total/50)
+	assert.InDelta(t, total*10/120, counters[inp.Prog], total/50)
+}
+
+func TestProgramsListBoostTree(t *testing.T) {
+	target := getTarget(t, targets.TestOS, targets.TestArch64)
+	rs := rand.NewSource(0)
+	r := rand.New(rs)
+	var pl ProgramsList
+	var sigs []string
+	for i := 0; i < 37; i++ {
+		inp := generateInput(target, rs, i%5+1)
+		sig := hash.String(inp.Prog.Serialize())
+		pl.saveProgram(sig, inp.Prog, inp.Signal)
+		sigs = append(sigs, sig)
+		// Interleave weight updates with appends to exercise the new tree nodes.
+		pl.setWeight(sigs[r.Intn(len(sigs))], float64(r.Intn(4)))
+	}
+	prefix := 0.0
+	for idx := range pl.progs {
+		boost := float64(pl.prio(idx)) * pl.weights[idx]
+		if boost == 0 {
+			continue
+		}
+		// Any value inside the program's range of the cumulative boost selects it.
+		assert.Equal(t, idx, pl.searchBoost(prefix+boost/2), "program %v", idx)
+		prefix += boost
+	}
+	assert.InDelta(t, prefix, pl.sumBoost, 1e-9)
+}
+
 func TestFocusAreas(t *testing.T) {
 	target := getTarget(t, targets.TestOS, targets.TestArch64)
 	corpus := NewFocusedCorpus(context.Background(), nil, []FocusArea{
@@ -4,6 +4,17 @@
 package flatrpc
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -53,6 +64,37 @@ func NewScoringProgInfo(progInfo *ProgInfo) *ScoringProgInfo {
 	}
 }
 
+// kernelLogKeywords 是 ExtractKernelLogs 保留的日志行中必须包含的关键字之一
+var kernelLogKeywords = [][]byte{
+	[]byte("KASAN"),
+	[]byte("WARNING"),
+	[]byte("ERROR"),
+	[]byte("Oops"),
+	[]byte("panic"),
+}
+
+// ExtractKernelLogs 从执行输出中提取可能与内核错误相关的日志行 (去掉首尾空白)，
+// 用于在组装执行结果时填充 KernelLogs，使评分不必再扫描原始输出。
+func ExtractKernelLogs(output []byte) []string {
+	var logs []string
+	for len(output) > 0 {
+		line := output
+		if i := bytes.IndexByte(output, '\n'); i >= 0 {
+			line, output = output[:i], output[i+1:]
+		} else {
+			output = nil
+		}
+		line = bytes.TrimSpace(line)
+		for _, keyword := range kernelLogKeywords {
+			if bytes.Contains(line, keyword) {
+				logs = append(logs, string(line))
+				break
+			}
+		}
+	}
+	return logs
+}
+
 // UpdateScore 更新评分信息
 func (spi *ScoringProgInfo) UpdateScore(
 	totalScore, coverageScore, rarityScore, kernelLogScore, timeAnomalyScore float64) {
@@ -183,6 +225,9 @@ func (ser *ScoringExecResult) Clone() *ScoringExecResult {
 
 // ScoreMetrics 评分指标统计
 type ScoreMetrics struct {
+	// 保护所有字段的并发更新，只读取字段的方法使用读锁
+	mu sync.RWMutex
+
 	// 总请求数
 	TotalRequests int64 `json:"total_requests"`
 	
@@ -195,7 +240,7 @@ type ScoreMetrics struct {
 	// 最高评分
 	MaxScore float64 `json:"max_score"`
 	
-	// 最低评分
+	// 最低评分 (没有样本时最高评分为 -Inf、最低评分为 +Inf，JSON 和 Prometheus 输出中为 0)
 	MinScore float64 `json:"min_score"`
 	
 	// 各维度平均分数
@@ -203,73 +248,176 @@ type ScoreMetrics struct {
 	AvgRarityScore     float64 `json:"avg_rarity_score"`
 	AvgKernelLogScore  float64 `json:"avg_kernel_log_score"`
 	AvgTimeAnomalyScore float64 `json:"avg_time_anomaly_score"`
+	AvgSequenceScore    float64 `json:"avg_sequence_score"`
+
+	// 各维度最高/最低分数 (最高分一直为 0 说明该维度从未生效)。
+	// 与 MinScore/MaxScore 一样，没有样本时最低分为 +Inf、最高分为 -Inf，序列化时输出 0。
+	MinCoverageScore    float64 `json:"min_coverage_score"`
+	MaxCoverageScore    float64 `json:"max_coverage_score"`
+	MinRarityScore      float64 `json:"min_rarity_score"`
+	MaxRarityScore      float64 `json:"max_rarity_score"`
+	MinKernelLogScore   float64 `json:"min_kernel_log_score"`
+	MaxKernelLogScore   float64 `json:"max_kernel_log_score"`
+	MinTimeAnomalyScore float64 `json:"min_time_anomaly_score"`
+	MaxTimeAnomalyScore float64 `json:"max_time_anomaly_score"`
+	MinSequenceScore    float64 `json:"min_sequence_score"`
+	MaxSequenceScore    float64 `json:"max_sequence_score"`
 	
+	// 总分的分布: 第 i 个桶统计落在 [i/ScoreHistogramBuckets, (i+1)/ScoreHistogramBuckets) 的评分数，
+	// 最后一个桶包含 1.0。评分集中在少数几个桶说明评分没有区分度。
+	ScoreHistogram [ScoreHistogramBuckets]int64 `json:"score_histogram"`
+
 	// 评分计算总耗时 (纳秒)
 	TotalScoreCalculationTime int64 `json:"total_score_calculation_time"`
+
+	// 各维度计算的累计耗时 (纳秒)，只在评分系统启用维度计时 (调试用) 时累加
+	CoverageCalculationTime    int64 `json:"coverage_calculation_time"`
+	RarityCalculationTime      int64 `json:"rarity_calculation_time"`
+	KernelLogCalculationTime   int64 `json:"kernel_log_calculation_time"`
+	TimeAnomalyCalculationTime int64 `json:"time_anomaly_calculation_time"`
+	SequenceCalculationTime    int64 `json:"sequence_calculation_time"`
 	
 	// Smash 统计信息
+	// 计数器达到 math.MaxInt64 后保持不变而不是溢出；即使每秒一百万次变异，
+	// 也需要约 29 万年才会达到上限，因此实际运行中不会饱和。
 	TotalSmashJobs        int64   `json:"total_smash_jobs"`
 	TotalSmashMutations   int64   `json:"total_smash_mutations"`
 	SuccessfulMutations   int64   `json:"successful_mutations"`
 	AverageSmashBaseScore float64 `json:"average_smash_base_score"`
+	// 按变异策略 (例如 "standard"、"conservative"、"aggressive") 分别统计的变异次数和使评分提升的变异次数
+	SmashStrategyMutations  map[string]int64 `json:"smash_strategy_mutations,omitempty"`
+	SmashStrategySuccessful map[string]int64 `json:"smash_strategy_successful,omitempty"`
+
+	// 故障注入作业的执行数以及其中带来新信号的执行数
+	FaultInjectionExecs     int64 `json:"fault_injection_execs"`
+	FaultInjectionNewSignal int64 `json:"fault_injection_new_signal"`
 	
 	// 最后更新时间
 	LastUpdated time.Time `json:"last_updated"`
 }
 
+// ScoreHistogramBuckets 是总分分布直方图在 [0, 1] 上等宽划分的桶数
+const ScoreHistogramBuckets = 10
+
 // NewScoreMetrics 创建评分指标
 func NewScoreMetrics() *ScoreMetrics {
+	inf := math.Inf(1)
 	return &ScoreMetrics{
 		LastUpdated: time.Now(),
-		MinScore:    1.0, // 初始化为最大值，便于后续比较
+		// 最低分从 +Inf、最高分从 -Inf 开始，第一个样本同时成为最低分和最高分
+		MinScore:            inf,
+		MaxScore:            -inf,
+		MinCoverageScore:    inf,
+		MaxCoverageScore:    -inf,
+		MinRarityScore:      inf,
+		MaxRarityScore:      -inf,
+		MinKernelLogScore:   inf,
+		MaxKernelLogScore:   -inf,
+		MinTimeAnomalyScore: inf,
+		MaxTimeAnomalyScore: -inf,
+		MinSequenceScore:    inf,
+		MaxSequenceScore:    -inf,
 	}
 }
 
 // UpdateMetrics 更新评分指标
 func (sm *ScoreMetrics) UpdateMetrics(score float64, scoreSelected bool, calculationTime int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	sm.TotalRequests++
 	
 	if scoreSelected {
 		sm.ScoreSelectedRequests++
 	}
 	
-	// 更新平均分数
-	if sm.TotalRequests == 1 {
-		sm.AverageScore = score
+	// 更新平均分数和范围 (NaN 不改变范围)
+	updateMean(&sm.AverageScore, score, sm.TotalRequests)
+	if score > sm.MaxScore {
 		sm.MaxScore = score
+	}
+	if score < sm.MinScore {
 		sm.MinScore = score
-	} else {
-		sm.AverageScore = (sm.AverageScore*float64(sm.TotalRequests-1) + score) / float64(sm.TotalRequests)
-		if score > sm.MaxScore {
-			sm.MaxScore = score
-		}
-		if score < sm.MinScore {
-			sm.MinScore = score
-		}
 	}
+	sm.ScoreHistogram[scoreHistogramBucket(score)]++
 	
 	sm.TotalScoreCalculationTime += calculationTime
 	sm.LastUpdated = time.Now()
 }
 
+// scoreHistogramBucket 返回评分所在的直方图桶，超出 [0, 1] 的评分 (以及 NaN) 计入两端的桶
+func scoreHistogramBucket(score float64) int {
+	if !(score > 0) {
+		return 0
+	}
+	return min(int(score*ScoreHistogramBuckets), ScoreHistogramBuckets-1)
+}
+
+// GetScoreHistogram 返回总分分布直方图各桶的评分数，见 ScoreHistogram
+func (sm *ScoreMetrics) GetScoreHistogram() []int64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return slices.Clone(sm.ScoreHistogram[:])
+}
+
 // UpdateDimensionScores 更新各维度分数
-func (sm *ScoreMetrics) UpdateDimensionScores(coverage, rarity, kernelLog, timeAnomaly float64) {
-	if sm.TotalRequests == 1 {
-		sm.AvgCoverageScore = coverage
-		sm.AvgRarityScore = rarity
-		sm.AvgKernelLogScore = kernelLog
-		sm.AvgTimeAnomalyScore = timeAnomaly
-	} else {
-		count := float64(sm.TotalRequests)
-		sm.AvgCoverageScore = (sm.AvgCoverageScore*(count-1) + coverage) / count
-		sm.AvgRarityScore = (sm.AvgRarityScore*(count-1) + rarity) / count
-		sm.AvgKernelLogScore = (sm.AvgKernelLogScore*(count-1) + kernelLog) / count
-		sm.AvgTimeAnomalyScore = (sm.AvgTimeAnomalyScore*(count-1) + timeAnomaly) / count
+func (sm *ScoreMetrics) UpdateDimensionScores(coverage, rarity, kernelLog, timeAnomaly, sequence float64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	updateMean(&sm.AvgCoverageScore, coverage, sm.TotalRequests)
+	updateMean(&sm.AvgRarityScore, rarity, sm.TotalRequests)
+	updateMean(&sm.AvgKernelLogScore, kernelLog, sm.TotalRequests)
+	updateMean(&sm.AvgTimeAnomalyScore, timeAnomaly, sm.TotalRequests)
+	updateMean(&sm.AvgSequenceScore, sequence, sm.TotalRequests)
+	updateRange(&sm.MinCoverageScore, &sm.MaxCoverageScore, coverage, coverage)
+	updateRange(&sm.MinRarityScore, &sm.MaxRarityScore, rarity, rarity)
+	updateRange(&sm.MinKernelLogScore, &sm.MaxKernelLogScore, kernelLog, kernelLog)
+	updateRange(&sm.MinTimeAnomalyScore, &sm.MaxTimeAnomalyScore, timeAnomaly, timeAnomaly)
+	updateRange(&sm.MinSequenceScore, &sm.MaxSequenceScore, sequence, sequence)
+}
+
+// UpdateDimensionTimes 累加各维度一次评分计算的耗时
+func (sm *ScoreMetrics) UpdateDimensionTimes(coverage, rarity, kernelLog, timeAnomaly, sequence time.Duration) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	addSaturating(&sm.CoverageCalculationTime, coverage.Nanoseconds())
+	addSaturating(&sm.RarityCalculationTime, rarity.Nanoseconds())
+	addSaturating(&sm.KernelLogCalculationTime, kernelLog.Nanoseconds())
+	addSaturating(&sm.TimeAnomalyCalculationTime, timeAnomaly.Nanoseconds())
+	addSaturating(&sm.SequenceCalculationTime, sequence.Nanoseconds())
+}
+
+// updateMean 用第 n 个样本增量更新平均值。
+// avg*(n-1) 在 n 很大时会损失精度，增量形式只累加与当前平均值的差，误差不随 n 增长。
+func updateMean(avg *float64, value float64, n int64) {
+	*avg += (value - *avg) / float64(n)
+}
+
+// addSaturating 把 delta (>= 0) 加到计数器上，达到 math.MaxInt64 后不再增长
+func addSaturating(counter *int64, delta int64) {
+	if *counter > math.MaxInt64-delta {
+		*counter = math.MaxInt64
+		return
 	}
+	*counter += delta
+}
+
+// updateRange 用样本范围 [low, high] 扩展最小/最大值 (单个样本时 low 与 high 相同)。
+// 没有样本的范围 (low 为 +Inf、high 为 -Inf) 不改变最小/最大值。
+func updateRange(minVal, maxVal *float64, low, high float64) {
+	*minVal = min(*minVal, low)
+	*maxVal = max(*maxVal, high)
 }
 
 // GetScoreSelectionRatio 获取基于评分选择的比例
 func (sm *ScoreMetrics) GetScoreSelectionRatio() float64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.scoreSelectionRatioLocked()
+}
+
+func (sm *ScoreMetrics) scoreSelectionRatioLocked() float64 {
 	if sm.TotalRequests == 0 {
 		return 0.0
 	}
@@ -278,6 +426,12 @@ func (sm *ScoreMetrics) GetScoreSelectionRatio() float64 {
 
 // GetAverageCalculationTime 获取平均评分计算时间
 func (sm *ScoreMetrics) GetAverageCalculationTime() float64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.averageCalculationTimeLocked()
+}
+
+func (sm *ScoreMetrics) averageCalculationTimeLocked() float64 {
 	if sm.TotalRequests == 0 {
 		return 0.0
 	}
@@ -286,23 +440,54 @@ func (sm *ScoreMetrics) GetAverageCalculationTime() float64 {
 
 // UpdateSmashStats 更新 smash 统计信息
 func (sm *ScoreMetrics) UpdateSmashStats(successfulMutations, totalMutations int, baseScore float64) {
-	sm.TotalSmashJobs++
-	sm.TotalSmashMutations += int64(totalMutations)
-	sm.SuccessfulMutations += int64(successfulMutations)
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	addSaturating(&sm.TotalSmashJobs, 1)
+	addSaturating(&sm.TotalSmashMutations, int64(totalMutations))
+	addSaturating(&sm.SuccessfulMutations, int64(successfulMutations))
+
+	// 更新平均基准分数 (第一个样本时即为 baseScore)
+	updateMean(&sm.AverageSmashBaseScore, baseScore, sm.TotalSmashJobs)
 	
-	// 更新平均基准分数
-	if sm.TotalSmashJobs == 1 {
-		sm.AverageSmashBaseScore = baseScore
-	} else {
-		count := float64(sm.TotalSmashJobs)
-		sm.AverageSmashBaseScore = (sm.AverageSmashBaseScore*(count-1) + baseScore) / count
+	sm.LastUpdated = time.Now()
+}
+
+// UpdateSmashStrategyStats 按变异策略累加 smash 的变异次数和成功变异次数，
+// 与 UpdateSmashStats 分开调用，不计入 smash 作业数
+func (sm *ScoreMetrics) UpdateSmashStrategyStats(strategy string, successfulMutations, totalMutations int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.SmashStrategyMutations == nil {
+		sm.SmashStrategyMutations = make(map[string]int64)
+		sm.SmashStrategySuccessful = make(map[string]int64)
+	}
+	mutations, successful := sm.SmashStrategyMutations[strategy], sm.SmashStrategySuccessful[strategy]
+	addSaturating(&mutations, int64(totalMutations))
+	addSaturating(&successful, int64(successfulMutations))
+	sm.SmashStrategyMutations[strategy], sm.SmashStrategySuccessful[strategy] = mutations, successful
+	sm.LastUpdated = time.Now()
+}
+
+// UpdateFaultInjectionStats 记录一次故障注入的执行，newSignal 表示该执行带来了新信号
+func (sm *ScoreMetrics) UpdateFaultInjectionStats(newSignal bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	addSaturating(&sm.FaultInjectionExecs, 1)
+	if newSignal {
+		addSaturating(&sm.FaultInjectionNewSignal, 1)
 	}
-	
 	sm.LastUpdated = time.Now()
 }
 
 // GetSmashSuccessRate 获取 smash 成功率
 func (sm *ScoreMetrics) GetSmashSuccessRate() float64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.smashSuccessRateLocked()
+}
+
+func (sm *ScoreMetrics) smashSuccessRateLocked() float64 {
 	if sm.TotalSmashMutations == 0 {
 		return 0.0
 	}
@@ -311,6 +496,12 @@ func (sm *ScoreMetrics) GetSmashSuccessRate() float64 {
 
 // GetAverageSmashMutationsPerJob 获取每个 smash 作业的平均变异次数
 func (sm *ScoreMetrics) GetAverageSmashMutationsPerJob() float64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.averageSmashMutationsPerJobLocked()
+}
+
+func (sm *ScoreMetrics) averageSmashMutationsPerJobLocked() float64 {
 	if sm.TotalSmashJobs == 0 {
 		return 0.0
 	}
@@ -319,12 +510,347 @@ func (sm *ScoreMetrics) GetAverageSmashMutationsPerJob() float64 {
 
 // GetSmashStats 获取 smash 统计摘要
 func (sm *ScoreMetrics) GetSmashStats() map[string]interface{} {
-	return map[string]interface{}{
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	stats := map[string]interface{}{
 		"total_smash_jobs":              sm.TotalSmashJobs,
 		"total_mutations":               sm.TotalSmashMutations,
 		"successful_mutations":          sm.SuccessfulMutations,
-		"success_rate":                  sm.GetSmashSuccessRate(),
-		"avg_mutations_per_job":         sm.GetAverageSmashMutationsPerJob(),
+		"success_rate":                  sm.smashSuccessRateLocked(),
+		"avg_mutations_per_job":         sm.averageSmashMutationsPerJobLocked(),
 		"avg_base_score":                sm.AverageSmashBaseScore,
 	}
+	// 每种用过的变异策略: <策略>_mutations、<策略>_successful_mutations 和 <策略>_success_rate
+	for strategy, mutations := range sm.SmashStrategyMutations {
+		successful := sm.SmashStrategySuccessful[strategy]
+		rate := 0.0
+		if mutations != 0 {
+			rate = float64(successful) / float64(mutations)
+		}
+		stats[strategy+"_mutations"] = mutations
+		stats[strategy+"_successful_mutations"] = successful
+		stats[strategy+"_success_rate"] = rate
+	}
+	return stats
 }
+
+// Merge 把另一个实例的评分指标合并进来，用于多实例部署时汇总全局视图。
+// 平均值按各自的样本数加权重新计算，而不是直接对平均值求平均。
+func (sm *ScoreMetrics) Merge(other *ScoreMetrics) {
+	if other == nil || other == sm {
+		return
+	}
+	// 先在 other 的锁内取快照，避免同时持有两把锁导致交叉合并时死锁。
+	o := other.snapshot()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if o.TotalRequests > 0 {
+		if sm.TotalRequests == 0 {
+			sm.MinScore, sm.MaxScore = o.MinScore, o.MaxScore
+			sm.MinCoverageScore, sm.MaxCoverageScore = o.MinCoverageScore, o.MaxCoverageScore
+			sm.MinRarityScore, sm.MaxRarityScore = o.MinRarityScore, o.MaxRarityScore
+			sm.MinKernelLogScore, sm.MaxKernelLogScore = o.MinKernelLogScore, o.MaxKernelLogScore
+			sm.MinTimeAnomalyScore, sm.MaxTimeAnomalyScore = o.MinTimeAnomalyScore, o.MaxTimeAnomalyScore
+			sm.MinSequenceScore, sm.MaxSequenceScore = o.MinSequenceScore, o.MaxSequenceScore
+		} else {
+			updateRange(&sm.MinScore, &sm.MaxScore, o.MinScore, o.MaxScore)
+			updateRange(&sm.MinCoverageScore, &sm.MaxCoverageScore, o.MinCoverageScore, o.MaxCoverageScore)
+			updateRange(&sm.MinRarityScore, &sm.MaxRarityScore, o.MinRarityScore, o.MaxRarityScore)
+			updateRange(&sm.MinKernelLogScore, &sm.MaxKernelLogScore, o.MinKernelLogScore, o.MaxKernelLogScore)
+			updateRange(&sm.MinTimeAnomalyScore, &sm.MaxTimeAnomalyScore,
+				o.MinTimeAnomalyScore, o.MaxTimeAnomalyScore)
+			updateRange(&sm.MinSequenceScore, &sm.MaxSequenceScore, o.MinSequenceScore, o.MaxSequenceScore)
+		}
+		n, on := sm.TotalRequests, o.TotalRequests
+		sm.AverageScore = mergeAverage(sm.AverageScore, n, o.AverageScore, on)
+		sm.AvgCoverageScore = mergeAverage(sm.AvgCoverageScore, n, o.AvgCoverageScore, on)
+		sm.AvgRarityScore = mergeAverage(sm.AvgRarityScore, n, o.AvgRarityScore, on)
+		sm.AvgKernelLogScore = mergeAverage(sm.AvgKernelLogScore, n, o.AvgKernelLogScore, on)
+		sm.AvgTimeAnomalyScore = mergeAverage(sm.AvgTimeAnomalyScore, n, o.AvgTimeAnomalyScore, on)
+		sm.AvgSequenceScore = mergeAverage(sm.AvgSequenceScore, n, o.AvgSequenceScore, on)
+		sm.TotalRequests += on
+		sm.ScoreSelectedRequests += o.ScoreSelectedRequests
+		for i, count := range o.ScoreHistogram {
+			sm.ScoreHistogram[i] += count
+		}
+		sm.TotalScoreCalculationTime += o.TotalScoreCalculationTime
+	}
+	addSaturating(&sm.CoverageCalculationTime, o.CoverageCalculationTime)
+	addSaturating(&sm.RarityCalculationTime, o.RarityCalculationTime)
+	addSaturating(&sm.KernelLogCalculationTime, o.KernelLogCalculationTime)
+	addSaturating(&sm.TimeAnomalyCalculationTime, o.TimeAnomalyCalculationTime)
+	addSaturating(&sm.SequenceCalculationTime, o.SequenceCalculationTime)
+
+	sm.AverageSmashBaseScore = mergeAverage(sm.AverageSmashBaseScore, sm.TotalSmashJobs,
+		o.AverageSmashBaseScore, o.TotalSmashJobs)
+	sm.TotalSmashJobs += o.TotalSmashJobs
+	sm.TotalSmashMutations += o.TotalSmashMutations
+	sm.SuccessfulMutations += o.SuccessfulMutations
+	if len(o.SmashStrategyMutations) != 0 && sm.SmashStrategyMutations == nil {
+		sm.SmashStrategyMutations = make(map[string]int64)
+		sm.SmashStrategySuccessful = make(map[string]int64)
+	}
+	for strategy, mutations := range o.SmashStrategyMutations {
+		sm.SmashStrategyMutations[strategy] += mutations
+		sm.SmashStrategySuccessful[strategy] += o.SmashStrategySuccessful[strategy]
+	}
+	addSaturating(&sm.FaultInjectionExecs, o.FaultInjectionExecs)
+	addSaturating(&sm.FaultInjectionNewSignal, o.FaultInjectionNewSignal)
+
+	if o.LastUpdated.After(sm.LastUpdated) {
+		sm.LastUpdated = o.LastUpdated
+	}
+}
+
+// MarshalJSON 在锁内取快照后序列化，保证输出的各字段来自同一时刻。
+// 字段按声明顺序输出，GetSmashStats 等 map 的键由 encoding/json 排序，
+// 因此相同的状态总是得到逐字节相同的输出。
+func (sm *ScoreMetrics) MarshalJSON() ([]byte, error) {
+	type plain ScoreMetrics
+	return json.Marshal((*plain)(sm.snapshot().clearEmptyRanges()))
+}
+
+// ScoreMetricsSchemaVersion 是 MarshalSnapshot 输出的文档格式版本，
+// 删除、重命名字段或改变字段含义时递增，只增加字段时不变。
+const ScoreMetricsSchemaVersion = 1
+
+// scoreMetricsDocument 是 MarshalSnapshot 输出的文档
+type scoreMetricsDocument struct {
+	SchemaVersion int           `json:"schema_version"`
+	Metrics       *ScoreMetrics `json:"metrics"`
+	// 由计数器导出的比例，与 Metrics 来自同一快照
+	SmashSuccessRate            float64 `json:"smash_success_rate"`
+	ScoreSelectionRatio         float64 `json:"score_selection_ratio"`
+	AverageCalculationTime      float64 `json:"average_calculation_time"`
+	AverageSmashMutationsPerJob float64 `json:"average_smash_mutations_per_job"`
+}
+
+// MarshalSnapshot 把所有计数器和由它们导出的比例序列化为带格式版本的 JSON 文档，供外部监控使用。
+// 计数器和比例在同一次读锁内取得，并发的更新不会使输出的数值互相矛盾。
+func (sm *ScoreMetrics) MarshalSnapshot() ([]byte, error) {
+	snapshot := sm.snapshot().clearEmptyRanges()
+	return json.Marshal(&scoreMetricsDocument{
+		SchemaVersion:               ScoreMetricsSchemaVersion,
+		Metrics:                     snapshot,
+		SmashSuccessRate:            snapshot.smashSuccessRateLocked(),
+		ScoreSelectionRatio:         snapshot.scoreSelectionRatioLocked(),
+		AverageCalculationTime:      snapshot.averageCalculationTimeLocked(),
+		AverageSmashMutationsPerJob: snapshot.averageSmashMutationsPerJobLocked(),
+	})
+}
+
+// Snapshot 返回指标的一致性副本，调用方可以不加锁地读取其字段
+func (sm *ScoreMetrics) Snapshot() *ScoreMetrics {
+	return sm.snapshot()
+}
+
+// DeadDimensions 返回已有评分但最高分仍为 0 的维度，这些维度从未对评分产生影响
+func (sm *ScoreMetrics) DeadDimensions() []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if sm.TotalRequests == 0 {
+		return nil
+	}
+	var dead []string
+	for _, dim := range []struct {
+		name string
+		max  float64
+	}{
+		{"coverage", sm.MaxCoverageScore},
+		{"rarity", sm.MaxRarityScore},
+		{"kernel_log", sm.MaxKernelLogScore},
+		{"time_anomaly", sm.MaxTimeAnomalyScore},
+		{"sequence", sm.MaxSequenceScore},
+	} {
+		// 没有维度样本时最高分为 -Inf，同样视为从未生效
+		if !(dim.max > 0) {
+			dead = append(dead, dim.name)
+		}
+	}
+	return dead
+}
+
+// snapshot 返回指标的一致性副本 (不包含锁)
+func (sm *ScoreMetrics) snapshot() *ScoreMetrics {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return &ScoreMetrics{
+		TotalRequests:              sm.TotalRequests,
+		ScoreSelectedRequests:      sm.ScoreSelectedRequests,
+		AverageScore:               sm.AverageScore,
+		MaxScore:                   sm.MaxScore,
+		MinScore:                   sm.MinScore,
+		AvgCoverageScore:           sm.AvgCoverageScore,
+		AvgRarityScore:             sm.AvgRarityScore,
+		AvgKernelLogScore:          sm.AvgKernelLogScore,
+		AvgTimeAnomalyScore:        sm.AvgTimeAnomalyScore,
+		AvgSequenceScore:           sm.AvgSequenceScore,
+		MinCoverageScore:           sm.MinCoverageScore,
+		MaxCoverageScore:           sm.MaxCoverageScore,
+		MinRarityScore:             sm.MinRarityScore,
+		MaxRarityScore:             sm.MaxRarityScore,
+		MinKernelLogScore:          sm.MinKernelLogScore,
+		MaxKernelLogScore:          sm.MaxKernelLogScore,
+		MinTimeAnomalyScore:        sm.MinTimeAnomalyScore,
+		MaxTimeAnomalyScore:        sm.MaxTimeAnomalyScore,
+		MinSequenceScore:           sm.MinSequenceScore,
+		MaxSequenceScore:           sm.MaxSequenceScore,
+		ScoreHistogram:             sm.ScoreHistogram,
+		TotalScoreCalculationTime:  sm.TotalScoreCalculationTime,
+		CoverageCalculationTime:    sm.CoverageCalculationTime,
+		RarityCalculationTime:      sm.RarityCalculationTime,
+		KernelLogCalculationTime:   sm.KernelLogCalculationTime,
+		TimeAnomalyCalculationTime: sm.TimeAnomalyCalculationTime,
+		SequenceCalculationTime:    sm.SequenceCalculationTime,
+		TotalSmashJobs:             sm.TotalSmashJobs,
+		TotalSmashMutations:        sm.TotalSmashMutations,
+		SuccessfulMutations:        sm.SuccessfulMutations,
+		AverageSmashBaseScore:      sm.AverageSmashBaseScore,
+		SmashStrategyMutations:     maps.Clone(sm.SmashStrategyMutations),
+		SmashStrategySuccessful:    maps.Clone(sm.SmashStrategySuccessful),
+		FaultInjectionExecs:        sm.FaultInjectionExecs,
+		FaultInjectionNewSignal:    sm.FaultInjectionNewSignal,
+		LastUpdated:                sm.LastUpdated,
+	}
+}
+
+// clearEmptyRanges 把没有样本的最高/最低分 (±Inf，encoding/json 无法序列化) 置为 0，
+// 只用于 snapshot 返回的副本，返回值是 sm 本身。
+func (sm *ScoreMetrics) clearEmptyRanges() *ScoreMetrics {
+	for _, r := range [][2]*float64{
+		{&sm.MinScore, &sm.MaxScore},
+		{&sm.MinCoverageScore, &sm.MaxCoverageScore},
+		{&sm.MinRarityScore, &sm.MaxRarityScore},
+		{&sm.MinKernelLogScore, &sm.MaxKernelLogScore},
+		{&sm.MinTimeAnomalyScore, &sm.MaxTimeAnomalyScore},
+		{&sm.MinSequenceScore, &sm.MaxSequenceScore},
+	} {
+		if *r[0] > *r[1] {
+			*r[0], *r[1] = 0, 0
+		}
+	}
+	return sm
+}
+
+// mergeAverage 合并两组样本的平均值，权重为各自的样本数
+func mergeAverage(avg1 float64, n1 int64, avg2 float64, n2 int64) float64 {
+	if n1+n2 == 0 {
+		return 0
+	}
+	return (avg1*float64(n1) + avg2*float64(n2)) / float64(n1+n2)
+}
+
+// promMetric 是 Prometheus 文本格式中的一个指标族
+type promMetric struct {
+	name    string
+	typ     string
+	help    string
+	samples []promSample
+}
+
+// promSample 是指标族中的一个样本，label 为空时不输出标签
+type promSample struct {
+	label string
+	value string
+	val   float64
+}
+
+// WritePrometheus 以 Prometheus 文本格式 (0.0.4) 输出评分指标。
+// 指标名称是稳定的，供监控系统抓取；所有数值来自同一次读锁内取得的快照，
+// 因此计数器和由它们导出的比例互相一致。
+func (sm *ScoreMetrics) WritePrometheus(w io.Writer) error {
+	s := sm.snapshot().clearEmptyRanges()
+	dimensions := func(coverage, rarity, kernelLog, timeAnomaly, sequence float64) []promSample {
+		return []promSample{
+			{"dimension", "coverage", coverage},
+			{"dimension", "rarity", rarity},
+			{"dimension", "kernel_log", kernelLog},
+			{"dimension", "time_anomaly", timeAnomaly},
+			{"dimension", "sequence", sequence},
+		}
+	}
+	var strategies []string
+	for strategy := range s.SmashStrategyMutations {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	var strategyMutations, strategySuccessful []promSample
+	for _, strategy := range strategies {
+		strategyMutations = append(strategyMutations,
+			promSample{"strategy", strategy, float64(s.SmashStrategyMutations[strategy])})
+		strategySuccessful = append(strategySuccessful,
+			promSample{"strategy", strategy, float64(s.SmashStrategySuccessful[strategy])})
+	}
+	metrics := []promMetric{
+		{"syz_score_total_requests", "counter", "Number of scored executions.",
+			[]promSample{{val: float64(s.TotalRequests)}}},
+		{"syz_score_selected_requests", "counter", "Number of requests chosen by score-driven selection.",
+			[]promSample{{val: float64(s.ScoreSelectedRequests)}}},
+		{"syz_score_selection_ratio", "gauge", "Fraction of requests chosen by score-driven selection.",
+			[]promSample{{val: s.scoreSelectionRatioLocked()}}},
+		{"syz_score_avg", "gauge", "Average total program score.",
+			[]promSample{{val: s.AverageScore}}},
+		{"syz_score_max", "gauge", "Maximum total program score.",
+			[]promSample{{val: s.MaxScore}}},
+		{"syz_score_min", "gauge", "Minimum total program score.",
+			[]promSample{{val: s.MinScore}}},
+		{"syz_score_dimension_avg", "gauge", "Average score of each scoring dimension.",
+			dimensions(s.AvgCoverageScore, s.AvgRarityScore, s.AvgKernelLogScore, s.AvgTimeAnomalyScore,
+				s.AvgSequenceScore)},
+		{"syz_score_dimension_max", "gauge", "Maximum score of each scoring dimension.",
+			dimensions(s.MaxCoverageScore, s.MaxRarityScore, s.MaxKernelLogScore, s.MaxTimeAnomalyScore,
+				s.MaxSequenceScore)},
+		{"syz_score_dimension_min", "gauge", "Minimum score of each scoring dimension.",
+			dimensions(s.MinCoverageScore, s.MinRarityScore, s.MinKernelLogScore, s.MinTimeAnomalyScore,
+				s.MinSequenceScore)},
+		{"syz_score_calculation_seconds_total", "counter", "Total time spent calculating scores.",
+			[]promSample{{val: float64(s.TotalScoreCalculationTime) / 1e9}}},
+		{"syz_score_dimension_calculation_seconds_total", "counter",
+			"Total time spent calculating each scoring dimension (only with dimension profiling).",
+			dimensions(float64(s.CoverageCalculationTime)/1e9, float64(s.RarityCalculationTime)/1e9,
+				float64(s.KernelLogCalculationTime)/1e9, float64(s.TimeAnomalyCalculationTime)/1e9,
+				float64(s.SequenceCalculationTime)/1e9)},
+		{"syz_smash_jobs_total", "counter", "Number of smash jobs.",
+			[]promSample{{val: float64(s.TotalSmashJobs)}}},
+		{"syz_smash_mutations_total", "counter", "Number of smash mutations.",
+			[]promSample{{val: float64(s.TotalSmashMutations)}}},
+		{"syz_smash_successful_mutations_total", "counter", "Number of smash mutations that improved the score.",
+			[]promSample{{val: float64(s.SuccessfulMutations)}}},
+		{"syz_smash_success_rate", "gauge", "Fraction of smash mutations that improved the score.",
+			[]promSample{{val: s.smashSuccessRateLocked()}}},
+		{"syz_smash_avg_base_score", "gauge", "Average score of smashed base programs.",
+			[]promSample{{val: s.AverageSmashBaseScore}}},
+		{"syz_smash_strategy_mutations_total", "counter", "Number of smash mutations per mutation strategy.",
+			strategyMutations},
+		{"syz_smash_strategy_successful_mutations_total", "counter",
+			"Number of smash mutations that improved the score per mutation strategy.", strategySuccessful},
+		{"syz_fault_injection_execs_total", "counter", "Number of fault injection executions.",
+			[]promSample{{val: float64(s.FaultInjectionExecs)}}},
+		{"syz_fault_injection_new_signal_total", "counter", "Number of fault injection executions with new signal.",
+			[]promSample{{val: float64(s.FaultInjectionNewSignal)}}},
+	}
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		if len(m.samples) == 0 {
+			continue
+		}
+		bw.WriteString("# HELP " + m.name + " " + promHelpEscaper.Replace(m.help) + "\n")
+		bw.WriteString("# TYPE " + m.name + " " + m.typ + "\n")
+		for _, sample := range m.samples {
+			bw.WriteString(m.name)
+			if sample.label != "" {
+				bw.WriteString("{" + sample.label + "=\"" + promLabelEscaper.Replace(sample.value) + "\"}")
+			}
+			bw.WriteString(" " + strconv.FormatFloat(sample.val, 'g', -1, 64) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	// HELP 中只转义反斜杠和换行，标签值还需要转义双引号
+	promHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
@@ -0,0 +1,446 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package flatrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreMetricsDimensionRange(t *testing.T) {
+	sm := NewScoreMetrics()
+	update := func(coverage, rarity, kernelLog, timeAnomaly, sequence float64) {
+		sm.UpdateMetrics(0.5, false, 0)
+		sm.UpdateDimensionScores(coverage, rarity, kernelLog, timeAnomaly, sequence)
+	}
+	update(0.5, 0.2, 0, 0.3, 0.6)
+	update(0.9, 0.1, 0, 0.3, 0.2)
+	update(0.1, 0.7, 0, 0.3, 0.4)
+
+	assert.Equal(t, 0.1, sm.MinCoverageScore)
+	assert.Equal(t, 0.9, sm.MaxCoverageScore)
+	assert.Equal(t, 0.1, sm.MinRarityScore)
+	assert.Equal(t, 0.7, sm.MaxRarityScore)
+	// 内核日志维度从未生效。
+	assert.Equal(t, 0.0, sm.MinKernelLogScore)
+	assert.Equal(t, 0.0, sm.MaxKernelLogScore)
+	assert.Equal(t, 0.3, sm.MinTimeAnomalyScore)
+	assert.Equal(t, 0.3, sm.MaxTimeAnomalyScore)
+	assert.Equal(t, 0.2, sm.MinSequenceScore)
+	assert.Equal(t, 0.6, sm.MaxSequenceScore)
+	assert.InDelta(t, 0.4, sm.AvgSequenceScore, 1e-9)
+	assert.Equal(t, []string{"kernel_log"}, sm.DeadDimensions())
+
+	// 调用序列维度从未生效时同样被报告。
+	sm = NewScoreMetrics()
+	update(0.5, 0.2, 0.1, 0.3, 0)
+	assert.Equal(t, []string{"sequence"}, sm.DeadDimensions())
+}
+
+func TestScoreMetricsEmptyRange(t *testing.T) {
+	// 所有样本都是负数时最高分也是负数，而不是初始值。
+	sm := NewScoreMetrics()
+	sm.UpdateMetrics(-0.5, false, 0)
+	sm.UpdateMetrics(-0.2, false, 0)
+	assert.Equal(t, -0.5, sm.MinScore)
+	assert.Equal(t, -0.2, sm.MaxScore)
+	// 所有样本都大于 1 时最低分不会停留在 1。
+	sm = NewScoreMetrics()
+	sm.UpdateMetrics(1.5, false, 0)
+	assert.Equal(t, 1.5, sm.MinScore)
+	assert.Equal(t, 1.5, sm.MaxScore)
+
+	// 没有样本的范围在 JSON 和 Prometheus 输出中为 0，且不影响合并结果。
+	empty := NewScoreMetrics()
+	data, err := json.Marshal(empty)
+	assert.NoError(t, err)
+	var decoded ScoreMetrics
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 0.0, decoded.MinScore)
+	assert.Equal(t, 0.0, decoded.MaxCoverageScore)
+	_, err = empty.MarshalSnapshot()
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, empty.WritePrometheus(buf))
+	assert.NotContains(t, buf.String(), "Inf")
+
+	// 只有总分样本、没有维度样本的实例。
+	noDimensions := NewScoreMetrics()
+	noDimensions.UpdateMetrics(0.9, false, 0)
+	sm = NewScoreMetrics()
+	sm.UpdateMetrics(0.3, false, 0)
+	sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+	sm.Merge(noDimensions)
+	sm.Merge(empty)
+	assert.Equal(t, 0.3, sm.MinScore)
+	assert.Equal(t, 0.9, sm.MaxScore)
+	assert.Equal(t, 0.1, sm.MinCoverageScore)
+	assert.Equal(t, 0.1, sm.MaxCoverageScore)
+	assert.Equal(t, []string{"coverage", "rarity", "kernel_log", "time_anomaly", "sequence"},
+		noDimensions.DeadDimensions())
+}
+
+func TestScoreMetricsHistogram(t *testing.T) {
+	sm := NewScoreMetrics()
+	assert.Equal(t, make([]int64, ScoreHistogramBuckets), sm.GetScoreHistogram())
+	// 大部分评分集中在 0.5 附近，两端各有少量评分，边界值计入上一个桶。
+	scores := map[float64]int{
+		0:    2,
+		0.05: 1,
+		0.35: 3,
+		0.45: 10,
+		0.55: 20,
+		0.65: 4,
+		0.95: 1,
+		1:    2,
+	}
+	for score, n := range scores {
+		for i := 0; i < n; i++ {
+			sm.UpdateMetrics(score, false, 0)
+		}
+	}
+	// 超出 [0, 1] 的评分计入两端的桶。
+	sm.UpdateMetrics(-0.1, false, 0)
+	sm.UpdateMetrics(1.5, false, 0)
+	sm.UpdateMetrics(math.NaN(), false, 0)
+
+	expected := []int64{5, 0, 0, 3, 10, 20, 4, 0, 0, 4}
+	histogram := sm.GetScoreHistogram()
+	assert.Equal(t, expected, histogram)
+	var total int64
+	for _, count := range histogram {
+		total += count
+	}
+	assert.Equal(t, sm.TotalRequests, total)
+
+	// 返回的是副本，修改它不影响指标。
+	histogram[0] = 100
+	assert.Equal(t, expected, sm.GetScoreHistogram())
+	assert.Equal(t, expected, sm.Snapshot().ScoreHistogram[:])
+}
+
+func TestScoreMetricsMerge(t *testing.T) {
+	type sample struct {
+		score, coverage, rarity, kernelLog, timeAnomaly, sequence float64
+		selected                                                  bool
+	}
+	samples := []sample{
+		{0.2, 0.1, 0.5, 0, 0.2, 0.3, false},
+		{0.9, 0.8, 0.1, 1, 0.4, 0.0, true},
+		{0.4, 0.3, 0.7, 0, 0.1, 0.6, false},
+		{0.6, 0.5, 0.2, 0.5, 0.9, 0.1, true},
+		{0.1, 0.0, 0.3, 0, 0.3, 0.8, false},
+	}
+	feed := func(sm *ScoreMetrics, samples []sample) {
+		for i, s := range samples {
+			sm.UpdateMetrics(s.score, s.selected, int64(i+1))
+			sm.UpdateDimensionScores(s.coverage, s.rarity, s.kernelLog, s.timeAnomaly, s.sequence)
+			sm.UpdateSmashStats(i, 10, s.score)
+			sm.UpdateFaultInjectionStats(s.selected)
+		}
+	}
+	// 两个实例的样本数不同，简单地对平均值求平均会得到错误结果。
+	first, second, combined := NewScoreMetrics(), NewScoreMetrics(), NewScoreMetrics()
+	feed(first, samples[:1])
+	feed(second, samples[1:])
+	feed(combined, samples[:1])
+	// 第二个实例的样本在合并流中继续累加。
+	for i, s := range samples[1:] {
+		combined.UpdateMetrics(s.score, s.selected, int64(i+1))
+		combined.UpdateDimensionScores(s.coverage, s.rarity, s.kernelLog, s.timeAnomaly, s.sequence)
+		combined.UpdateSmashStats(i, 10, s.score)
+		combined.UpdateFaultInjectionStats(s.selected)
+	}
+
+	merged := NewScoreMetrics()
+	merged.Merge(first)
+	merged.Merge(second)
+
+	assert.Equal(t, combined.TotalRequests, merged.TotalRequests)
+	assert.Equal(t, combined.ScoreSelectedRequests, merged.ScoreSelectedRequests)
+	assert.Equal(t, combined.TotalScoreCalculationTime, merged.TotalScoreCalculationTime)
+	assert.InDelta(t, combined.AverageScore, merged.AverageScore, 1e-9)
+	assert.Equal(t, combined.MinScore, merged.MinScore)
+	assert.Equal(t, combined.MaxScore, merged.MaxScore)
+	assert.InDelta(t, combined.AvgCoverageScore, merged.AvgCoverageScore, 1e-9)
+	assert.InDelta(t, combined.AvgRarityScore, merged.AvgRarityScore, 1e-9)
+	assert.InDelta(t, combined.AvgKernelLogScore, merged.AvgKernelLogScore, 1e-9)
+	assert.InDelta(t, combined.AvgTimeAnomalyScore, merged.AvgTimeAnomalyScore, 1e-9)
+	assert.InDelta(t, combined.AvgSequenceScore, merged.AvgSequenceScore, 1e-9)
+	assert.Equal(t, combined.MinCoverageScore, merged.MinCoverageScore)
+	assert.Equal(t, combined.MaxCoverageScore, merged.MaxCoverageScore)
+	assert.Equal(t, combined.MinRarityScore, merged.MinRarityScore)
+	assert.Equal(t, combined.MaxRarityScore, merged.MaxRarityScore)
+	assert.Equal(t, combined.MinKernelLogScore, merged.MinKernelLogScore)
+	assert.Equal(t, combined.MaxKernelLogScore, merged.MaxKernelLogScore)
+	assert.Equal(t, combined.MinTimeAnomalyScore, merged.MinTimeAnomalyScore)
+	assert.Equal(t, combined.MaxTimeAnomalyScore, merged.MaxTimeAnomalyScore)
+	assert.Equal(t, combined.MinSequenceScore, merged.MinSequenceScore)
+	assert.Equal(t, combined.MaxSequenceScore, merged.MaxSequenceScore)
+	assert.Equal(t, combined.GetScoreHistogram(), merged.GetScoreHistogram())
+	assert.Equal(t, combined.TotalSmashJobs, merged.TotalSmashJobs)
+	assert.Equal(t, combined.TotalSmashMutations, merged.TotalSmashMutations)
+	assert.Equal(t, combined.SuccessfulMutations, merged.SuccessfulMutations)
+	assert.InDelta(t, combined.AverageSmashBaseScore, merged.AverageSmashBaseScore, 1e-9)
+	assert.Equal(t, combined.FaultInjectionExecs, merged.FaultInjectionExecs)
+	assert.Equal(t, combined.FaultInjectionNewSignal, merged.FaultInjectionNewSignal)
+}
+
+func TestScoreMetricsJSONStable(t *testing.T) {
+	sm := NewScoreMetrics()
+	for i := 0; i < 10; i++ {
+		sm.UpdateMetrics(float64(i)/10, i%2 == 0, int64(i))
+		sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+		sm.UpdateSmashStats(i, 10, 0.5)
+	}
+	first, err := json.Marshal(sm)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		again, err := json.Marshal(sm)
+		assert.NoError(t, err)
+		assert.Equal(t, string(first), string(again))
+	}
+	smashFirst, err := json.Marshal(sm.GetSmashStats())
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		again, err := json.Marshal(sm.GetSmashStats())
+		assert.NoError(t, err)
+		assert.Equal(t, string(smashFirst), string(again))
+	}
+
+	var decoded ScoreMetrics
+	assert.NoError(t, json.Unmarshal(first, &decoded))
+	assert.Equal(t, sm.TotalRequests, decoded.TotalRequests)
+	assert.Equal(t, sm.MaxScore, decoded.MaxScore)
+}
+
+func TestScoreMetricsMarshalSnapshot(t *testing.T) {
+	sm := NewScoreMetrics()
+	const writers, updates = 4, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				sm.UpdateMetrics(0.5, i%2 == 0, 100)
+				sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+				sm.UpdateSmashStats(1, 2, 0.5)
+			}
+		}()
+	}
+	type document struct {
+		SchemaVersion          int           `json:"schema_version"`
+		Metrics                *ScoreMetrics `json:"metrics"`
+		SmashSuccessRate       float64       `json:"smash_success_rate"`
+		ScoreSelectionRatio    float64       `json:"score_selection_ratio"`
+		AverageCalculationTime float64       `json:"average_calculation_time"`
+	}
+	check := func() *document {
+		data, err := sm.MarshalSnapshot()
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		doc := new(document)
+		if !assert.NoError(t, json.Unmarshal(data, doc)) {
+			return nil
+		}
+		assert.Equal(t, ScoreMetricsSchemaVersion, doc.SchemaVersion)
+		// The derived ratios must agree with the counters from the same document.
+		if m := doc.Metrics; m.TotalRequests != 0 {
+			assert.Equal(t, float64(m.ScoreSelectedRequests)/float64(m.TotalRequests), doc.ScoreSelectionRatio)
+			assert.Equal(t, 100.0, doc.AverageCalculationTime)
+		}
+		if doc.Metrics.TotalSmashMutations != 0 {
+			assert.Equal(t, 0.5, doc.SmashSuccessRate)
+		}
+		return doc
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			check()
+		}
+	}
+	doc := check()
+	if doc != nil {
+		assert.Equal(t, int64(writers*updates), doc.Metrics.TotalRequests)
+		assert.Equal(t, int64(writers*updates/2), doc.Metrics.ScoreSelectedRequests)
+		assert.Equal(t, int64(writers*updates), doc.Metrics.TotalSmashJobs)
+	}
+}
+
+func TestScoreMetricsConcurrentUpdates(t *testing.T) {
+	sm, other := NewScoreMetrics(), NewScoreMetrics()
+	other.UpdateMetrics(0.5, false, 0)
+	const goroutines, calls = 16, 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				sm.UpdateMetrics(float64(i)/calls, true, 1)
+				sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+				sm.UpdateDimensionTimes(time.Nanosecond, 0, 0, 0, 0)
+				sm.UpdateSmashStats(1, 3, 0.5)
+			}
+		}()
+		// Readers and merges run concurrently with the updates.
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls/10; i++ {
+				sm.GetSmashStats()
+				sm.GetScoreSelectionRatio()
+				sm.DeadDimensions()
+				sm.Snapshot()
+				other.Merge(sm)
+			}
+		}()
+	}
+	wg.Wait()
+	snapshot := sm.Snapshot()
+	assert.Equal(t, int64(goroutines*calls), snapshot.TotalRequests)
+	assert.Equal(t, int64(goroutines*calls), snapshot.ScoreSelectedRequests)
+	assert.Equal(t, int64(goroutines*calls), snapshot.TotalScoreCalculationTime)
+	assert.Equal(t, int64(goroutines*calls), snapshot.CoverageCalculationTime)
+	assert.Equal(t, int64(goroutines*calls), snapshot.TotalSmashJobs)
+	assert.Equal(t, int64(3*goroutines*calls), snapshot.TotalSmashMutations)
+	assert.Equal(t, 0.0, snapshot.MinScore)
+	assert.Equal(t, float64(calls-1)/calls, snapshot.MaxScore)
+	assert.InDelta(t, 0.5, snapshot.AverageSmashBaseScore, 1e-9)
+}
+
+func TestScoreMetricsSmashAverage(t *testing.T) {
+	sm := NewScoreMetrics()
+	const jobs = 1000000
+	for i := 0; i < jobs; i++ {
+		sm.UpdateSmashStats(1, 10, float64(i%10)/10)
+	}
+	assert.Equal(t, int64(jobs), sm.TotalSmashJobs)
+	assert.InDelta(t, 0.45, sm.AverageSmashBaseScore, 1e-9)
+	assert.InDelta(t, 0.1, sm.GetSmashSuccessRate(), 1e-9)
+
+	// Counters saturate instead of wrapping around.
+	sm.TotalSmashMutations = math.MaxInt64 - 5
+	sm.SuccessfulMutations = math.MaxInt64 - 5
+	sm.UpdateSmashStats(10, 10, 0.45)
+	assert.Equal(t, int64(math.MaxInt64), sm.TotalSmashMutations)
+	assert.Equal(t, int64(math.MaxInt64), sm.SuccessfulMutations)
+	assert.Equal(t, 1.0, sm.GetSmashSuccessRate())
+	assert.InDelta(t, 0.45, sm.AverageSmashBaseScore, 1e-9)
+}
+
+func TestScoreMetricsSmashStrategies(t *testing.T) {
+	sm, other := NewScoreMetrics(), NewScoreMetrics()
+	sm.UpdateSmashStrategyStats("aggressive", 1, 4)
+	sm.UpdateSmashStrategyStats("aggressive", 1, 4)
+	other.UpdateSmashStrategyStats("aggressive", 2, 2)
+	other.UpdateSmashStrategyStats("standard", 0, 5)
+	sm.Merge(other)
+	stats := sm.GetSmashStats()
+	assert.Equal(t, int64(10), stats["aggressive_mutations"])
+	assert.Equal(t, int64(4), stats["aggressive_successful_mutations"])
+	assert.Equal(t, 0.4, stats["aggressive_success_rate"])
+	assert.Equal(t, int64(5), stats["standard_mutations"])
+	assert.Equal(t, 0.0, stats["standard_success_rate"])
+	assert.NotContains(t, stats, "conservative_mutations")
+	// Strategy counters are not smash jobs.
+	assert.Equal(t, int64(0), stats["total_smash_jobs"])
+}
+
+func TestScoreMetricsDimensionTimes(t *testing.T) {
+	sm := NewScoreMetrics()
+	sm.UpdateDimensionTimes(1*time.Microsecond, 2*time.Microsecond, 30*time.Microsecond, 4*time.Microsecond,
+		5*time.Microsecond)
+	sm.UpdateDimensionTimes(1*time.Microsecond, 2*time.Microsecond, 30*time.Microsecond, 4*time.Microsecond,
+		5*time.Microsecond)
+
+	snapshot := sm.Snapshot()
+	assert.Equal(t, int64(2000), snapshot.CoverageCalculationTime)
+	assert.Equal(t, int64(4000), snapshot.RarityCalculationTime)
+	assert.Equal(t, int64(60000), snapshot.KernelLogCalculationTime)
+	assert.Equal(t, int64(8000), snapshot.TimeAnomalyCalculationTime)
+	assert.Equal(t, int64(10000), snapshot.SequenceCalculationTime)
+
+	// 合并时累加。
+	other := NewScoreMetrics()
+	other.UpdateDimensionTimes(0, 0, 10*time.Microsecond, 0, 1*time.Microsecond)
+	sm.Merge(other)
+	assert.Equal(t, int64(70000), sm.Snapshot().KernelLogCalculationTime)
+	assert.Equal(t, int64(11000), sm.Snapshot().SequenceCalculationTime)
+}
+
+func TestExtractKernelLogs(t *testing.T) {
+	output := []byte("executing program\n  KASAN: use-after-free Read in foo  \r\n" +
+		"some noise\nWARNING: CPU: 0 PID: 1 at bar\nKernel panic - not syncing")
+	assert.Equal(t, []string{
+		"KASAN: use-after-free Read in foo",
+		"WARNING: CPU: 0 PID: 1 at bar",
+		"Kernel panic - not syncing",
+	}, ExtractKernelLogs(output))
+	assert.Empty(t, ExtractKernelLogs(nil))
+	assert.Empty(t, ExtractKernelLogs([]byte("\n\nnothing here\n")))
+}
+
+func TestScoreMetricsWritePrometheus(t *testing.T) {
+	sm := NewScoreMetrics()
+	sm.UpdateMetrics(0.25, true, 1000)
+	sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+	sm.UpdateMetrics(0.75, false, 3000)
+	sm.UpdateDimensionScores(0.1, 0.2, 0.3, 0.4, 0.5)
+	sm.UpdateSmashStats(1, 4, 0.5)
+	sm.UpdateSmashStrategyStats("standard", 1, 4)
+	sm.UpdateSmashStrategyStats("odd\"strategy\\\n", 0, 2)
+	sm.UpdateFaultInjectionStats(true)
+	sm.UpdateFaultInjectionStats(false)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, sm.WritePrometheus(buf))
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE syz_score_total_requests counter",
+		"syz_score_total_requests 2",
+		"syz_score_selected_requests 1",
+		"# TYPE syz_score_avg gauge",
+		"syz_score_avg 0.5",
+		"syz_score_dimension_avg{dimension=\"kernel_log\"} 0.3",
+		"syz_score_dimension_avg{dimension=\"sequence\"} 0.5",
+		"syz_smash_success_rate 0.25",
+		"syz_smash_strategy_mutations_total{strategy=\"standard\"} 4",
+		`syz_smash_strategy_mutations_total{strategy="odd\"strategy\\\n"} 2`,
+		"syz_fault_injection_execs_total 2",
+		"syz_fault_injection_new_signal_total 1",
+	} {
+		assert.Contains(t, strings.Split(out, "\n"), line)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2.0, families["syz_score_total_requests"].GetMetric()[0].GetCounter().GetValue())
+	assert.Equal(t, 0.25, families["syz_smash_success_rate"].GetMetric()[0].GetGauge().GetValue())
+	strategies := map[string]float64{}
+	for _, m := range families["syz_smash_strategy_mutations_total"].GetMetric() {
+		strategies[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"standard": 4, "odd\"strategy\\\n": 2}, strategies)
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// asyncScoreQueueSize 后台评分队列的容量，队列满时提交评分任务会等待
+const asyncScoreQueueSize = 1024
+
+// asyncScorer 在后台 goroutine 池中执行评分和指标更新。
+// 队列的容量是固定的，队列满时提交任务会等待队列中有空位 (反压)，
+// 因此积压不会无限增长，评分跟不上时结果处理随之变慢，而不是丢弃执行结果。
+// 多个 goroutine 时任务的完成顺序不确定，评分的计算可以并行 (见 scoring.Score)，
+// 只有记录评分在评分系统的锁内串行执行。
+// shutdown 会处理完所有已入队的任务后再返回，之后提交的任务被丢弃并计数，
+// 因此关闭后的评分数据和指标是一致的。
+type asyncScorer struct {
+	// 提交任务时持有读锁，因此多个提交可以同时等待队列的空位，关闭队列时持有写锁
+	mu       sync.RWMutex
+	closed   bool
+	work     chan func()
+	done     chan struct{}
+	accepted atomic.Int64
+	dropped  atomic.Int64
+}
+
+// newAsyncScorer 创建有 workers 个后台 goroutine 的评分器 (workers 小于 1 时使用 1 个)
+func newAsyncScorer(workers int) *asyncScorer {
+	as := &asyncScorer{
+		work: make(chan func(), asyncScoreQueueSize),
+		done: make(chan struct{}),
+	}
+	workers = max(workers, 1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			as.loop()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(as.done)
+	}()
+	return as
+}
+
+func (as *asyncScorer) loop() {
+	for fn := range as.work {
+		fn()
+	}
+}
+
+// submit 把评分任务放入队列，队列已满时等待后台 goroutine 取走任务。
+// 已关闭时丢弃任务并返回 false。
+func (as *asyncScorer) submit(fn func()) bool {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	if as.closed {
+		as.dropped.Add(1)
+		return false
+	}
+	as.work <- fn
+	as.accepted.Add(1)
+	return true
+}
+
+// acceptedFraction 返回被接受的评分任务占全部提交任务的比例，即实际参与评分的结果比例。
+// 还没有提交过任务时返回 1。
+func (as *asyncScorer) acceptedFraction() float64 {
+	accepted, dropped := as.accepted.Load(), as.dropped.Load()
+	if accepted+dropped == 0 {
+		return 1
+	}
+	return float64(accepted) / float64(accepted+dropped)
+}
+
+// shutdown 停止接收新任务，等待正在等待的提交和已入队的任务处理完毕，返回被丢弃的任务数量。
+// 可以多次调用。
+func (as *asyncScorer) shutdown() int64 {
+	as.mu.Lock()
+	if !as.closed {
+		as.closed = true
+		close(as.work)
+	}
+	as.mu.Unlock()
+	<-as.done
+	return as.dropped.Load()
+}
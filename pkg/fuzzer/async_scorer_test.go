@@ -0,0 +1,125 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/corpus"
+	"github.com/google/syzkaller/pkg/flatrpc"
+	"github.com/google/syzkaller/pkg/fuzzer/queue"
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys/targets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncScorerShutdown(t *testing.T) {
+	as := newAsyncScorer(1)
+	metrics := flatrpc.NewScoreMetrics()
+	record := func() {
+		metrics.UpdateMetrics(0.5, false, 0)
+	}
+
+	// 阻塞后台 goroutine，使队列被填满。
+	started, unblock := make(chan struct{}), make(chan struct{})
+	assert.True(t, as.submit(func() {
+		close(started)
+		<-unblock
+		record()
+	}))
+	<-started
+	for i := 0; i < asyncScoreQueueSize; i++ {
+		assert.True(t, as.submit(record))
+	}
+	// 队列已满，提交等待后台 goroutine 取走任务 (反压) 而不是丢弃任务。
+	const extra = 10
+	submitted := make(chan bool)
+	for i := 0; i < extra; i++ {
+		go func() {
+			submitted <- as.submit(record)
+		}()
+	}
+	select {
+	case <-submitted:
+		t.Fatal("队列已满时提交没有等待")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(unblock)
+	for i := 0; i < extra; i++ {
+		assert.True(t, <-submitted)
+	}
+
+	dropped := as.shutdown()
+	assert.Zero(t, dropped)
+	// 所有已入队的任务都已处理完毕。
+	assert.Equal(t, int64(asyncScoreQueueSize+extra+1), metrics.TotalRequests)
+
+	// 后台 goroutine 已经退出，关闭后提交的任务被丢弃。
+	select {
+	case <-as.done:
+	default:
+		t.Fatal("评分 goroutine 未退出")
+	}
+	assert.False(t, as.submit(record))
+	assert.Equal(t, int64(1), as.shutdown())
+	assert.Equal(t, int64(asyncScoreQueueSize+extra+1), metrics.TotalRequests)
+}
+
+func TestAsyncScoringWorkers(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	config := DefaultScoreConfig()
+	config.AsyncScoring = true
+	config.AsyncScoringWorkers = 4
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: config,
+	}, rnd, target)
+
+	const progs = 100
+	hashes := make([]string, progs)
+	for i := 0; i < progs; i++ {
+		req := &queue.Request{
+			Prog: target.Generate(rnd, 5, target.DefaultChoiceTable()),
+			Stat: fuzzer.statExecFuzz,
+		}
+		hashes[i] = req.Prog.Hash()
+		fuzzer.scoreResult(req, &queue.Result{
+			Info: &flatrpc.ProgInfo{
+				Elapsed: 1000000,
+				Calls:   []*flatrpc.CallInfo{{Signal: []uint64{uint64(i)}}},
+			},
+		}, 0, 0, false)
+	}
+	// 评分在后台完成，最终所有结果都被记录 (队列足够大，不会丢弃)。
+	assert.Eventually(t, func() bool {
+		return fuzzer.GetScoreMetrics().Snapshot().TotalRequests == progs
+	}, 10*time.Second, 10*time.Millisecond)
+	for _, hash := range hashes {
+		assert.NotNil(t, fuzzer.scoring.tracker.GetScoreByHash(hash))
+	}
+
+	// 取消 ctx 后后台评分被关闭，之后的结果不再评分。
+	cancel()
+	assert.Eventually(t, func() bool {
+		select {
+		case <-fuzzer.asyncScorer.done:
+			return true
+		default:
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.False(t, fuzzer.asyncScorer.submit(func() {}))
+	assert.Equal(t, int64(progs), fuzzer.GetScoreMetrics().Snapshot().TotalRequests)
+}
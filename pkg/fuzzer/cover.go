@@ -36,6 +36,14 @@ func (cover *Cover) addRawMaxSignal(signal []uint64, prio uint8) signal.Signal {
 	return diff
 }
 
+// diffRawMaxSignal returns the part of the signal that is not in the max signal
+// without adding it there.
+func (cover *Cover) diffRawMaxSignal(signal []uint64, prio uint8) signal.Signal {
+	cover.mu.RLock()
+	defer cover.mu.RUnlock()
+	return cover.maxSignal.DiffRaw(signal, prio)
+}
+
 func (cover *Cover) CopyMaxSignal() signal.Signal {
 	cover.mu.RLock()
 	defer cover.mu.RUnlock()
@@ -6,11 +6,15 @@ package fuzzer
 import (
 	"context"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/syzkaller/pkg/corpus"
@@ -36,14 +40,21 @@ type Fuzzer struct {
 	runningJobs  map[jobIntrospector]struct{}
 
 	ct           *prog.ChoiceTable
+	exploreCt    *prog.ChoiceTable // 偏向高分程序中少见的系统调用 (ScoreConfig.ExploreRate)
 	ctProgs      int
 	ctMu         sync.Mutex // TODO: use RWLock.
 	ctRegenerate chan struct{}
 
+	// 作业并发限制 (按类型公平调度)
+	jobLimiter *jobLimiter
+
 	// 评分系统组件
-	scoreTracker    *ScoreTracker
-	weightedSelector *WeightedSelector
-	scoreMetrics    *flatrpc.ScoreMetrics
+	scoreCfg    atomic.Pointer[ScoreConfig] // UpdateScoreConfig 设置的评分配置，见 scoreConfig
+	scoring     *scoring
+	smashStats  *smashStats
+	asyncScorer *asyncScorer
+	genWatchdog *genWatchdog
+	persisters  []*scorePersister // 定期保存评分、评分统计和评分指标
 
 	execQueues
 }
@@ -60,6 +71,11 @@ func NewFuzzer(ctx context.Context, cfg *Config, rnd *rand.Rand,
 	if cfg.ScoreConfig == nil {
 		cfg.ScoreConfig = DefaultScoreConfig()
 	}
+	cfg.ScoreConfig = validatedScoreConfig(cfg.ScoreConfig, func(level int, msg string, args ...interface{}) {
+		if cfg.Logf != nil {
+			cfg.Logf(level, msg, args...)
+		}
+	})
 	
 	f := &Fuzzer{
 		Stats:  newStats(target),
@@ -74,15 +90,77 @@ func NewFuzzer(ctx context.Context, cfg *Config, rnd *rand.Rand,
 		// We're okay to lose some of the messages -- if we are already
 		// regenerating the table, we don't want to repeat it right away.
 		ctRegenerate: make(chan struct{}),
+		jobLimiter:   newJobLimiter(cfg.MaxJobs, cfg.JobQuotas),
 		
 		// 初始化评分系统组件
-		scoreTracker:     NewScoreTracker(cfg.ScoreConfig),
-		weightedSelector: NewWeightedSelector(),
-		scoreMetrics:     flatrpc.NewScoreMetrics(),
+		scoring:     newScoring(cfg.ScoreConfig),
+		smashStats:  newSmashStats(),
+		asyncScorer: newAsyncScorer(cfg.ScoreConfig.AsyncScoringWorkers),
+		genWatchdog: newGenWatchdog(cfg.ScoreConfig.MinGenerateRatio,
+			genWatchdogWindow, genWatchdogPatience, genWatchdogBurst),
+	}
+	if cfg.ScoreConfig.Deterministic {
+		f.scoring.rnd = rand.New(rand.NewSource(rnd.Int63()))
+	}
+	f.scoring.scoreFunc = cfg.ScoreFunc
+	if cfg.Corpus != nil {
+		f.scoring.corpusWeight = cfg.Corpus.SetProgramWeight
+	}
+	f.statScorerPCs = stat.New("scorer pcs", "Distinct PCs observed by the program scorer",
+		f.scoring.tracker.DistinctPCs, stat.Graph("corpus"))
+	if cfg.ScoreConfig.Enabled {
+		f.registerScoreStats(f.scoring.Metrics())
+	}
+	if cfg.ScoreConfig.PersistPath != "" {
+		f.persisters = append(f.persisters, newScorePersister(f.scoring.tracker,
+			cfg.ScoreConfig.PersistPath, cfg.ScoreConfig.PersistInterval, f.Logf))
+	}
+	if cfg.ScoreConfig.StatePath != "" {
+		restoreScoreSnapshot(f.scoring.tracker, cfg.ScoreConfig.StatePath, f.Logf)
+		sp := newScorePersister(f.scoring.tracker, cfg.ScoreConfig.StatePath,
+			cfg.ScoreConfig.PersistInterval, f.Logf)
+		sp.dump = func(w io.Writer) error {
+			data, err := f.scoring.tracker.Snapshot()
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			return err
+		}
+		f.persisters = append(f.persisters, sp)
+	}
+	if cfg.ScoreConfig.MetricsPath != "" {
+		metrics := f.scoring.Metrics()
+		sp := newScorePersister(f.scoring.tracker, cfg.ScoreConfig.MetricsPath,
+			cfg.ScoreConfig.PersistInterval, f.Logf)
+		// 故障注入和 smash 的统计不改变评分的版本，因此按指标的更新时间判断是否有变化。
+		sp.version = func() uint64 {
+			return uint64(metrics.Snapshot().LastUpdated.UnixNano())
+		}
+		sp.dump = func(w io.Writer) error {
+			data, err := metrics.MarshalSnapshot()
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			return err
+		}
+		f.persisters = append(f.persisters, sp)
+	}
+	for _, sp := range f.persisters {
+		go sp.run(ctx)
+	}
+	if cfg.ScoreConfig.Enabled && cfg.ScoreConfig.AutoTune {
+		go f.tuneScoreWeights(ctx)
+	}
+	if cfg.ScoreConfig.Enabled {
+		go f.decayScores(ctx)
 	}
 	f.execQueues = newExecQueues(f)
 	f.updateChoiceTable(nil)
 	go f.choiceTableUpdater()
+	go func() {
+		<-ctx.Done()
+		f.ShutdownScoring()
+	}()
 	if cfg.Debug {
 		go f.logCurrentStats()
 	}
@@ -94,7 +172,9 @@ type execQueues struct {
 	candidateQueue       *queue.PlainQueue
 	triageQueue          *queue.DynamicOrderer
 	smashQueue           *queue.PlainQueue
-	source               queue.Source
+	// weightedQueue is nil unless ScoreConfig.WeightedQueue is set.
+	weightedQueue *queue.WeightedSource
+	source        queue.Source
 }
 
 func newExecQueues(fuzzer *Fuzzer) execQueues {
@@ -113,13 +193,22 @@ func newExecQueues(fuzzer *Fuzzer) execQueues {
 		skipQueue = 2
 	}
 	// Sources are listed in the order, in which they will be polled.
-	ret.source = queue.Order(
+	sources := []queue.Source{
 		ret.triageCandidateQueue,
 		ret.candidateQueue,
 		ret.triageQueue,
 		queue.Alternate(ret.smashQueue, skipQueue),
-		queue.Callback(fuzzer.genFuzz),
-	)
+	}
+	if scoreConfig := fuzzer.scoreConfig(); scoreConfig.Enabled && scoreConfig.WeightedQueue {
+		// The weighted queue also gets the polls skipped by Alternate,
+		// and falls through to genFuzz once it's empty.
+		// In the deterministic mode the queue's random stream is seeded from the scoring one.
+		fuzzer.scoring.withRand(fuzzer.rand(), func(rnd *rand.Rand) {
+			ret.weightedQueue = queue.Weighted(rand.New(rand.NewSource(rnd.Int63())))
+		})
+		sources = append(sources, ret.weightedQueue)
+	}
+	ret.source = queue.Order(append(sources, queue.Callback(fuzzer.genFuzz))...)
 	return ret
 }
 
@@ -152,31 +241,10 @@ func (fuzzer *Fuzzer) enqueue(executor queue.Executor, req *queue.Request, flags
 }
 
 func (fuzzer *Fuzzer) processResult(req *queue.Request, res *queue.Result, flags ProgFlags, attempt int) bool {
-	// 计算评分 (在处理结果的开始)
-	scoreCalculationStart := time.Now()
-	progScore := fuzzer.calculateProgScore(req, res)
-	scoreCalculationTime := time.Since(scoreCalculationStart).Nanoseconds()
-	
-	// 更新评分指标
-	fuzzer.scoreMetrics.UpdateMetrics(progScore.Total, false, scoreCalculationTime)
-	fuzzer.scoreMetrics.UpdateDimensionScores(
-		progScore.Coverage, progScore.Rarity, 
-		progScore.KernelLog, progScore.TimeAnomaly)
-	
-	// 更新加权选择器
-	if req.Prog != nil {
-		progHash := req.Prog.Hash()
-		fuzzer.weightedSelector.UpdateWeight(progHash, progScore.Total)
-	}
-	
-	// 记录评分信息
-	fuzzer.Logf(3, "程序评分: 总分=%.3f, 覆盖率=%.3f, 稀有性=%.3f, 内核日志=%.3f, 时间异常=%.3f", 
-		progScore.Total, progScore.Coverage, progScore.Rarity, 
-		progScore.KernelLog, progScore.TimeAnomaly)
-
 	// If we are already triaging this exact prog, this is flaky coverage.
 	// Hanged programs are harmful as they consume executor procs.
-	dontTriage := flags&progInTriage > 0 || res.Status == queue.Hanged
+	// Fault injection runs are only scored (see faultInjectionJob), they never get into the corpus.
+	dontTriage := flags&(progInTriage|progFaultInjection|progJobInternal) > 0 || res.Status == queue.Hanged
 	// Triage the program.
 	// We do it before unblocking the waiting threads because
 	// it may result it concurrent modification of req.Prog.
@@ -186,29 +254,40 @@ func (fuzzer *Fuzzer) processResult(req *queue.Request, res *queue.Result, flags
 			fuzzer.triageProgCall(req.Prog, info, call, &triage)
 		}
 		fuzzer.triageProgCall(req.Prog, res.Info.Extra, -1, &triage)
+	}
 
-		if len(triage) != 0 {
-			queue, stat := fuzzer.triageQueue, fuzzer.statJobsTriage
-			if flags&progCandidate > 0 {
-				queue, stat = fuzzer.triageCandidateQueue, fuzzer.statJobsTriageCandidate
-			}
-			job := &triageJob{
-				p:        req.Prog.Clone(),
-				executor: res.Executor,
-				flags:    flags,
-				queue:    queue.Append(),
-				calls:    triage,
-				info: &JobInfo{
-					Name: req.Prog.String(),
-					Type: "triage",
-				},
-			}
-			for id := range triage {
-				job.info.Calls = append(job.info.Calls, job.p.CallName(id))
+	// 计算评分 (在创建 triage 作业之前，作业的队列和信息取决于程序的评分)。
+	// 发现了新信号的 hints 变异体在评分提交之后标记，异步评分时也是如此。
+	fuzzer.scoreResult(req, res, flags, attempt, len(triage) != 0 && flags&progHint != 0)
+
+	if len(triage) != 0 {
+		// The stat follows the program origin even if a high-score program
+		// is triaged in the candidate queue: it tracks candidate processing.
+		queue, stat := fuzzer.triageQueueFor(req.Prog, flags), fuzzer.statJobsTriage
+		if flags&progCandidate > 0 {
+			stat = fuzzer.statJobsTriageCandidate
+		}
+		job := &triageJob{
+			p:        req.Prog.Clone(),
+			executor: res.Executor,
+			flags:    flags,
+			queue:    queue.Append(),
+			calls:    triage,
+			info: &JobInfo{
+				Name: req.Prog.String(),
+				Type: "triage",
+			},
+		}
+		for id := range triage {
+			job.info.Calls = append(job.info.Calls, job.p.CallName(id))
+		}
+		if fuzzer.scoreConfig().Enabled {
+			if score := fuzzer.scoring.tracker.scoreOf(req.Prog.Hash()); score != nil {
+				job.info.setScore(score.Total, score)
 			}
-			sort.Strings(job.info.Calls)
-			fuzzer.startJob(stat, job)
 		}
+		sort.Strings(job.info.Calls)
+		fuzzer.startJob(stat, job)
 	}
 
 	if res.Info != nil {
@@ -253,16 +332,51 @@ type Config struct {
 	FetchRawCover  bool
 	NewInputFilter func(call string) bool
 	PatchTest      bool
+
+	// 同时运行的作业数量上限 (0 表示不限制)
+	MaxJobs int
+	// 每种作业类型 ("triage", "smash", "hints", "fault") 同时运行的数量上限，
+	// 仅在设置了 MaxJobs 时生效。未列出的类型只受 MaxJobs 限制。
+	JobQuotas map[string]int
+	// SignalPrio computes priority of the signal of the call (call == -1 for extra signal).
+	// When signal with the same PC is observed with different priorities, the higher one wins.
+	// If nil, the default signalPrio is used.
+	SignalPrio func(p *prog.Prog, info *flatrpc.CallInfo, call int) uint8
+	// CandidatePrio computes priority of a candidate passed to AddCandidates.
+	// Candidates with higher priority are executed first, candidates with equal
+	// priority keep their original order. If nil, candidates are not reordered.
+	CandidatePrio func(p *prog.Prog) float64
+	// ExternalKernelLogs returns additional kernel log lines for the execution
+	// that are not part of res.Output (e.g. from a hypervisor watchdog or a dmesg scraper).
+	// The lines are merged into the scoring input. Optional.
+	ExternalKernelLogs func(req *queue.Request, res *queue.Result) []string
+	// MaxTriageCalls limits the number of calls of a single triage job that are
+	// minimized/smashed concurrently. Calls with more new stable signal are handled first.
+	// 0 means all calls are handled concurrently.
+	MaxTriageCalls int
+	// DeflakeStableRuns makes deflake consider signal stable if it was observed in at least
+	// that many of the deflake runs (k-of-n), instead of the default number of runs for the program
+	// (see deflakeNeedRuns). Lower values tolerate more flakiness. Deflake still runs until signal is seen
+	// in the default number of runs, and gives up early only when signal can't be seen in DeflakeStableRuns runs.
+	// Snapshot mode always does a fixed number of runs. Values larger than the default are clamped to it.
+	// 0 means the default.
+	DeflakeStableRuns int
 	
 	// 评分系统配置
 	ScoreConfig    *ScoreConfig
+	// 外部评分函数 (可选)。设置后代替 ScoreTracker 内置的评分计算，用于实现完全不同的评分策略
+	// (例如基于比较操作数的启发式，或通过 socket 请求的模型)。返回的评分仍然被记录到评分跟踪器、
+	// 加权选择器和评分指标中，总分超出 [0, 1] 时被截断；返回 nil 表示不评分该执行。
+	// 包含 ScoreConfig.ScoreExcludedCalls 中的调用的程序不调用该函数，直接得到中等分数。
+	// 内置的评分统计 (PC 命中次数、执行时间基线等) 不再更新。可能被并发调用。
+	ScoreFunc func(*prog.Prog, *ExecutionResult) *ProgScore
 }
 
 func (fuzzer *Fuzzer) triageProgCall(p *prog.Prog, info *flatrpc.CallInfo, call int, triage *map[int]*triageCall) {
 	if info == nil {
 		return
 	}
-	prio := signalPrio(p, info, call)
+	prio := fuzzer.signalPrio(p, info, call)
 	newMaxSignal := fuzzer.Cover.addRawMaxSignal(info.Signal, prio)
 	if newMaxSignal.Empty() {
 		return
@@ -297,6 +411,13 @@ func (fuzzer *Fuzzer) handleCallInfo(req *queue.Request, info *flatrpc.CallInfo,
 	}
 }
 
+func (fuzzer *Fuzzer) signalPrio(p *prog.Prog, info *flatrpc.CallInfo, call int) uint8 {
+	if fuzzer.Config.SignalPrio != nil {
+		return fuzzer.Config.SignalPrio(p, info, call)
+	}
+	return signalPrio(p, info, call)
+}
+
 func signalPrio(p *prog.Prog, info *flatrpc.CallInfo, call int) (prio uint8) {
 	if call == -1 {
 		return 0
@@ -320,9 +441,12 @@ func (fuzzer *Fuzzer) genFuzz() *queue.Request {
 	}
 	var req *queue.Request
 	rnd := fuzzer.rand()
+	scoringEnabled := fuzzer.scoreConfig().Enabled
+	// 生成比例长期过低时强制生成新程序
+	forceGenerate := scoringEnabled && fuzzer.genWatchdog.forceGenerate()
 	
 	// 基于评分的加权选择 (如果启用评分系统)
-	if fuzzer.Config.ScoreConfig.Enabled && rnd.Float64() < 0.3 { // 30% 概率使用评分选择
+	if !forceGenerate && scoringEnabled && rnd.Float64() < 0.3 { // 30% 概率使用评分选择
 		req = fuzzer.mutateProgRequestWeighted(rnd)
 		if req != nil {
 			fuzzer.Logf(3, "使用基于评分的加权选择生成程序")
@@ -330,14 +454,19 @@ func (fuzzer *Fuzzer) genFuzz() *queue.Request {
 	}
 	
 	// 如果加权选择失败或未启用，使用原有逻辑
+	generated := false
 	if req == nil {
-		if rnd.Float64() < mutateRate {
+		if !forceGenerate && rnd.Float64() < mutateRate {
 			req = mutateProgRequest(fuzzer, rnd)
 		}
 		if req == nil {
 			req = genProgRequest(fuzzer, rnd)
+			generated = true
 		}
 	}
+	if scoringEnabled {
+		fuzzer.genWatchdog.record(generated)
+	}
 	
 	if fuzzer.Config.Collide && rnd.Intn(3) == 0 {
 		req = &queue.Request{
@@ -351,26 +480,17 @@ func (fuzzer *Fuzzer) genFuzz() *queue.Request {
 
 // mutateProgRequestWeighted 基于评分的加权程序变异
 func (fuzzer *Fuzzer) mutateProgRequestWeighted(rnd *rand.Rand) *queue.Request {
-	// 获取评分最高的程序列表
-	topProgs := fuzzer.scoreTracker.GetTopScoredProgs(50) // 获取前50个高分程序
-	if len(topProgs) == 0 {
+	// 从高分程序中随机选择一个进行变异
+	selectedHash := fuzzer.scoring.Select(rnd)
+	if selectedHash == "" {
+		fuzzer.statWeightedEmptyTop.Add(1)
 		return nil
 	}
 	
-	// 从高分程序中随机选择一个进行变异
-	selectedHash := topProgs[rnd.Intn(len(topProgs))]
-	
 	// 从语料库中找到对应的程序
-	programs := fuzzer.Config.Corpus.Programs()
-	var selectedProg *prog.Prog
-	for _, p := range programs {
-		if p.Hash() == selectedHash {
-			selectedProg = p
-			break
-		}
-	}
-	
+	selectedProg := fuzzer.Config.Corpus.ProgramByHash(selectedHash)
 	if selectedProg == nil {
+		fuzzer.statWeightedResolveMiss.Add(1)
 		return nil
 	}
 	
@@ -384,12 +504,97 @@ func (fuzzer *Fuzzer) mutateProgRequestWeighted(rnd *rand.Rand) *queue.Request {
 	)
 	
 	return &queue.Request{
-		Prog:     newP,
-		ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
-		Stat:     fuzzer.statExecFuzz,
+		Prog:      newP,
+		ExecOpts:  setFlags(flatrpc.ExecFlagCollectSignal),
+		Stat:      fuzzer.statExecFuzz,
+		Important: fuzzer.importantByScore(selectedHash),
 	}
 }
 
+// chooseCorpusProgram 从语料库中选择一个程序进行变异。
+// 启用评分时使用语料库维护的选择器权重 (见 scoring.corpusWeight)，在语料库自身的优先级之上
+// 按评分提高程序被选中的概率；未启用时使用语料库原有的选择方式。确定性模式下使用评分系统的随机数流。
+func (fuzzer *Fuzzer) chooseCorpusProgram(rnd *rand.Rand) *prog.Prog {
+	if !fuzzer.scoreConfig().Enabled {
+		return fuzzer.Config.Corpus.ChooseProgram(rnd)
+	}
+	var p *prog.Prog
+	fuzzer.scoring.withRand(rnd, func(rnd *rand.Rand) {
+		p = fuzzer.Config.Corpus.ChooseProgramWeighted(rnd)
+	})
+	return p
+}
+
+// importantByScore 判断由该程序变异得到的请求是否应标记为 Important，
+// 使执行层在 VM 崩溃后仍重试这些来自高分程序的请求。
+func (fuzzer *Fuzzer) importantByScore(progHash string) bool {
+	threshold := fuzzer.scoreConfig().ImportantScoreThreshold
+	if threshold <= 0 {
+		return false
+	}
+	score := fuzzer.scoring.tracker.scoreOf(progHash)
+	return score != nil && score.Total >= threshold
+}
+
+// triageQueueFor 返回程序的 triage 作业使用的队列。
+// 候选程序总是使用优先级更高的候选 triage 队列；配置了 PriorityTriageThreshold 时，
+// 高分的 fuzz 程序也使用该队列。
+func (fuzzer *Fuzzer) triageQueueFor(p *prog.Prog, flags ProgFlags) *queue.DynamicOrderer {
+	if flags&progCandidate > 0 {
+		return fuzzer.triageCandidateQueue
+	}
+	scoreConfig := fuzzer.scoreConfig()
+	if scoreConfig.Enabled && scoreConfig.PriorityTriageThreshold > 0 {
+		score := fuzzer.scoring.tracker.scoreOf(p.Hash())
+		if score != nil && score.Total >= scoreConfig.PriorityTriageThreshold {
+			return fuzzer.triageCandidateQueue
+		}
+	}
+	return fuzzer.triageQueue
+}
+
+// deflakeOnOriginalExecutor 判断 deflake 时是否优先在最初执行程序的执行器上重复运行。
+// 高分程序往往是崩溃复现，确认它在同一配置上可复现比分散到不同 VM 更有价值。
+func (fuzzer *Fuzzer) deflakeOnOriginalExecutor(p *prog.Prog) bool {
+	scoreConfig := fuzzer.scoreConfig()
+	if scoreConfig == nil || !scoreConfig.Enabled || scoreConfig.DeflakeOriginalExecutorThreshold <= 0 {
+		return false
+	}
+	score := fuzzer.scoring.tracker.scoreOf(p.Hash())
+	return score != nil && score.Total >= scoreConfig.DeflakeOriginalExecutorThreshold
+}
+
+// scoredDeflakeMaxRuns 返回 fuzz 程序 deflake 的最大执行次数。评分启用时按程序的总分在
+// deflakeMaxRuns 上下最多调整 deflakeScoredRunsDelta 次: 高分程序 (可能的崩溃、稀有路径)
+// 有更多机会确认不稳定的新信号，低分程序更早放弃。需要的稳定次数 (deflakeNeedRuns) 不变，
+// 最大执行次数至少比它多一次，使偶尔不复现的信号仍有机会通过。
+func (fuzzer *Fuzzer) scoredDeflakeMaxRuns(p *prog.Prog) int {
+	scoreConfig := fuzzer.scoreConfig()
+	if scoreConfig == nil || !scoreConfig.Enabled {
+		return deflakeMaxRuns
+	}
+	score := fuzzer.scoring.tracker.scoreOf(p.Hash())
+	if score == nil {
+		return deflakeMaxRuns
+	}
+	delta := int(math.Round((score.Total - neutralScore) * 2 * deflakeScoredRunsDelta))
+	return max(deflakeNeedRuns+1, min(deflakeMaxRuns+deflakeScoredRunsDelta, deflakeMaxRuns+delta))
+}
+
+// wantRawCover 判断 triage 时是否为该程序收集原始覆盖。
+// 原始覆盖开销较大，评分启用并配置了阈值时只为高分程序收集。
+func (fuzzer *Fuzzer) wantRawCover(p *prog.Prog) bool {
+	if !fuzzer.Config.FetchRawCover {
+		return false
+	}
+	scoreConfig := fuzzer.scoreConfig()
+	if !scoreConfig.Enabled || scoreConfig.RawCoverScoreThreshold <= 0 {
+		return true
+	}
+	score := fuzzer.scoring.tracker.scoreOf(p.Hash())
+	return score != nil && score.Total >= scoreConfig.RawCoverScoreThreshold
+}
+
 func (fuzzer *Fuzzer) startJob(stat *stat.Val, newJob job) {
 	fuzzer.Logf(2, "started %T", newJob)
 	go func() {
@@ -399,6 +604,12 @@ func (fuzzer *Fuzzer) startJob(stat *stat.Val, newJob job) {
 		fuzzer.statJobs.Add(1)
 		defer fuzzer.statJobs.Add(-1)
 
+		typ := jobType(newJob)
+		if !fuzzer.jobLimiter.acquire(fuzzer.ctx, typ) {
+			return
+		}
+		defer fuzzer.jobLimiter.release(typ)
+
 		if obj, ok := newJob.(jobIntrospector); ok {
 			fuzzer.mu.Lock()
 			fuzzer.runningJobs[obj] = struct{}{}
@@ -441,6 +652,13 @@ const (
 
 	progCandidate
 	progInTriage
+	// The program is a mutant produced by hintsJob.
+	progHint
+	// The program is a fault-injected variant produced by a fault injection job.
+	progFaultInjection
+	// The execution only collects data for a job (e.g. the signal of each call),
+	// it is neither scored nor triaged.
+	progJobInternal
 )
 
 type Candidate struct {
@@ -450,7 +668,22 @@ type Candidate struct {
 
 func (fuzzer *Fuzzer) AddCandidates(candidates []Candidate) {
 	fuzzer.statCandidates.Add(len(candidates))
+	if prioFn := fuzzer.Config.CandidatePrio; prioFn != nil {
+		prio := make(map[*prog.Prog]float64, len(candidates))
+		for _, candidate := range candidates {
+			prio[candidate.Prog] = prioFn(candidate.Prog)
+		}
+		candidates = slices.Clone(candidates)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return prio[candidates[i].Prog] > prio[candidates[j].Prog]
+		})
+	}
 	for _, candidate := range candidates {
+		// 重新 triage 的语料库程序的缓存评分可能已经过时 (例如内核已经改变)，
+		// 删除后在这次执行时重新评分。
+		if candidate.Flags&ProgFromCorpus != 0 && fuzzer.scoreConfig().Enabled {
+			fuzzer.scoring.invalidate(candidate.Prog.Hash())
+		}
 		req := &queue.Request{
 			Prog:      candidate.Prog,
 			ExecOpts:  setFlags(flatrpc.ExecFlagCollectSignal),
@@ -461,6 +694,13 @@ func (fuzzer *Fuzzer) AddCandidates(candidates []Candidate) {
 	}
 }
 
+// ReloadCorpus 重新加载语料库: 清除所有程序评分和评分统计，然后把 candidates 作为候选程序重新 triage，
+// 之后的执行从头重新评分。评分指标继续累计；Config.Corpus 由调用方负责替换。
+func (fuzzer *Fuzzer) ReloadCorpus(candidates []Candidate) {
+	fuzzer.scoring.reset()
+	fuzzer.AddCandidates(candidates)
+}
+
 func (fuzzer *Fuzzer) rand() *rand.Rand {
 	fuzzer.mu.Lock()
 	defer fuzzer.mu.Unlock()
@@ -469,15 +709,87 @@ func (fuzzer *Fuzzer) rand() *rand.Rand {
 
 func (fuzzer *Fuzzer) updateChoiceTable(programs []*prog.Prog) {
 	newCt := fuzzer.target.BuildChoiceTable(programs, fuzzer.Config.EnabledCalls)
+	exploreCt := fuzzer.buildExploreChoiceTable(programs)
 
 	fuzzer.ctMu.Lock()
 	defer fuzzer.ctMu.Unlock()
 	if len(programs) >= fuzzer.ctProgs {
 		fuzzer.ctProgs = len(programs)
 		fuzzer.ct = newCt
+		fuzzer.exploreCt = exploreCt
 	}
 }
 
+// buildExploreChoiceTable 构建只包含高分程序中最少出现的系统调用的选择表，
+// 用于反坍缩探索: 防止评分系统把生成集中到一小部分 API 上。
+// 未启用或还没有高分程序时返回 nil。
+func (fuzzer *Fuzzer) buildExploreChoiceTable(programs []*prog.Prog) *prog.ChoiceTable {
+	scoreConfig := fuzzer.scoreConfig()
+	if !scoreConfig.Enabled || scoreConfig.ExploreRate <= 0 {
+		return nil
+	}
+	top := make(map[string]bool)
+	for _, sp := range fuzzer.scoring.tracker.GetTopScoredCorpusProgs(weightedSelectTop) {
+		top[sp.Hash] = true
+	}
+	usage := make(map[*prog.Syscall]int)
+	for _, p := range programs {
+		if !top[p.Hash()] {
+			continue
+		}
+		for _, c := range p.Calls {
+			usage[c.Meta]++
+		}
+	}
+	if len(usage) == 0 {
+		return nil
+	}
+	enabled := underrepresentedCalls(fuzzer.target, fuzzer.Config.EnabledCalls, usage)
+	if len(enabled) == 0 {
+		return nil
+	}
+	return fuzzer.target.BuildChoiceTable(nil, enabled)
+}
+
+// underrepresentedCalls 返回可生成的系统调用中使用次数最少的那些
+func underrepresentedCalls(target *prog.Target, enabled map[*prog.Syscall]bool,
+	usage map[*prog.Syscall]int) map[*prog.Syscall]bool {
+	minUsage := -1
+	var calls []*prog.Syscall
+	for _, c := range target.Syscalls {
+		if (enabled != nil && !enabled[c]) || c.Attrs.Disabled || c.Attrs.NoGenerate {
+			continue
+		}
+		switch n := usage[c]; {
+		case minUsage == -1 || n < minUsage:
+			minUsage = n
+			calls = []*prog.Syscall{c}
+		case n == minUsage:
+			calls = append(calls, c)
+		}
+	}
+	res := make(map[*prog.Syscall]bool, len(calls))
+	for _, c := range calls {
+		res[c] = true
+	}
+	return res
+}
+
+// generateChoiceTable 返回生成新程序时使用的选择表，
+// 以 ScoreConfig.ExploreRate 的概率使用反坍缩探索选择表。
+func (fuzzer *Fuzzer) generateChoiceTable(rnd *rand.Rand) *prog.ChoiceTable {
+	ct := fuzzer.ChoiceTable()
+	if rate := fuzzer.scoreConfig().ExploreRate; rate > 0 && rnd.Float64() < rate {
+		fuzzer.ctMu.Lock()
+		exploreCt := fuzzer.exploreCt
+		fuzzer.ctMu.Unlock()
+		if exploreCt != nil {
+			return exploreCt
+		}
+	}
+	return ct
+}
+
 func (fuzzer *Fuzzer) choiceTableUpdater() {
 	for {
 		select {
@@ -489,18 +801,40 @@ func (fuzzer *Fuzzer) choiceTableUpdater() {
 	}
 }
 
+// defaultDecayInterval 未配置 DecayInterval 时评分衰减的间隔
+const defaultDecayInterval = time.Minute
+
+// decayScores 按配置定期让评分衰减，直到 ctx 被取消。
+// 每次都重新读取当前的评分配置，因此 UpdateScoreConfig 可以在运行时开启、关闭或调整衰减。
+func (fuzzer *Fuzzer) decayScores(ctx context.Context) {
+	for {
+		interval := fuzzer.scoreConfig().DecayInterval
+		if interval <= 0 {
+			interval = defaultDecayInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		fuzzer.scoring.decay(fuzzer.scoreConfig().DecayHalfLife)
+	}
+}
+
 func (fuzzer *Fuzzer) ChoiceTable() *prog.ChoiceTable {
-	progs := fuzzer.Config.Corpus.Programs()
+	numProgs := fuzzer.Config.Corpus.NumPrograms()
 
 	fuzzer.ctMu.Lock()
 	defer fuzzer.ctMu.Unlock()
 
 	// There were no deep ideas nor any calculations behind these numbers.
 	regenerateEveryProgs := 333
-	if len(progs) < 100 {
+	if numProgs < 100 {
 		regenerateEveryProgs = 33
 	}
-	if fuzzer.ctProgs+regenerateEveryProgs < len(progs) {
+	if fuzzer.ctProgs+regenerateEveryProgs < numProgs {
 		select {
 		case fuzzer.ctRegenerate <- struct{}{}:
 		default:
@@ -540,11 +874,183 @@ func (fuzzer *Fuzzer) logCurrentStats() {
 }
 
 // calculateProgScore 计算程序评分
+// 如果执行器返回了错误 (res.Err != nil)，结果不参与评分，返回 nil。
 func (fuzzer *Fuzzer) calculateProgScore(req *queue.Request, res *queue.Result) *ProgScore {
-	if !fuzzer.Config.ScoreConfig.Enabled || req.Prog == nil {
+	return fuzzer.scoreExecution(req, res, false)
+}
+
+// scoreExecution 与 calculateProgScore 相同，retry 表示这是同一程序的重复执行，
+// 只计算评分而不再更新评分统计 (见 ExecutionResult.Retry)
+func (fuzzer *Fuzzer) scoreExecution(req *queue.Request, res *queue.Result, retry bool) *ProgScore {
+	if !fuzzer.scoreConfig().Enabled || req.Prog == nil {
 		return &ProgScore{Total: 0.5} // 默认中等分数
 	}
+	if res.Err != nil {
+		fuzzer.Logf(3, "执行出错，跳过评分: %v", res.Err)
+		return nil
+	}
 	
+	// 计算评分，同时更新加权选择器和评分指标
+	execResult := fuzzer.newExecutionResult(req, res)
+	execResult.Retry = retry
+	return fuzzer.scoring.Score(req.Prog, execResult)
+}
+
+// faultNewSignal 返回故障注入的执行是否带来了新信号: 既不在 fuzzer 的最大信号中，也不在同一作业之前的执行 seen 中。
+// 故障注入的执行不做 triage，因此新信号只合并到 seen 而不加入最大信号。
+func (fuzzer *Fuzzer) faultNewSignal(p *prog.Prog, info *flatrpc.ProgInfo, seen *signal.Signal) bool {
+	if info == nil {
+		return false
+	}
+	found := false
+	check := func(ci *flatrpc.CallInfo, call int) {
+		if ci == nil {
+			return
+		}
+		prio := fuzzer.signalPrio(p, ci, call)
+		diff := fuzzer.Cover.diffRawMaxSignal(ci.Signal, prio)
+		if diff = seen.DiffRaw(diff.ToRaw(), prio); !diff.Empty() {
+			seen.Merge(diff)
+			found = true
+		}
+	}
+	for call, ci := range info.Calls {
+		check(ci, call)
+	}
+	check(info.Extra, -1)
+	return found
+}
+
+// scoreResult 计算执行结果的评分并计入指标。
+// 执行出错的结果返回 nil，既不计入指标也不更新权重。
+// 评分系统关闭时立即返回，热路径上不产生任何与评分相关的分配。
+// 除非启用了 ScoreTriageExecutions，triage 和候选程序的执行 (由 flags 区分) 也不评分，
+// 但尚无评分的候选程序 (见 unscoredCandidate) 仍然评分。
+// attempt 大于 0 的执行是候选程序的重试，只评分而不重复更新评分统计。
+// hintNewSignal 表示执行的是发现了新信号的 hints 变异体，评分提交之后标记 (见 markHintNewSignal)。
+func (fuzzer *Fuzzer) scoreResult(req *queue.Request, res *queue.Result, flags ProgFlags, attempt int,
+	hintNewSignal bool) {
+	scoreConfig := fuzzer.scoreConfig()
+	if !scoreConfig.Enabled || flags&progJobInternal != 0 {
+		return
+	}
+	if !scoreConfig.ScoreTriageExecutions && flags&(progInTriage|progCandidate) != 0 &&
+		!fuzzer.unscoredCandidate(req, flags) {
+		return
+	}
+	spawn := fuzzer.weightedQueue != nil && req.Prog != nil &&
+		(req.Stat == fuzzer.statExecFuzz || req.Stat == fuzzer.statExecGenerate)
+	if scoreConfig.AsyncScoring && req.Prog != nil && res.Err == nil {
+		execResult := fuzzer.newExecutionResult(req, res)
+		execResult.Retry = attempt > 0
+		fuzzer.scoreAsync(req.Prog.Clone(), execResult, spawn, hintNewSignal)
+		return
+	}
+	progScore := fuzzer.scoreExecution(req, res, attempt > 0)
+	if hintNewSignal && progScore != nil {
+		fuzzer.scoring.markHintNewSignal(req.Prog.Hash())
+	}
+	fuzzer.logProgScore(progScore)
+	if spawn && progScore != nil {
+		fuzzer.queueWeightedMutant(req.Prog, progScore.Total)
+	}
+}
+
+// unscoredCandidate 判断执行是否为还没有评分的候选程序: 从未评分，或评分已经失效 (见 InvalidateProgram)。
+// 重启后重新 triage 的语料库程序都是这种候选程序，跳过它们会使语料库一直没有评分，
+// 失效的程序也不会像 InvalidateProgram 约定的那样在下一次执行时重新评分。
+func (fuzzer *Fuzzer) unscoredCandidate(req *queue.Request, flags ProgFlags) bool {
+	return flags&progCandidate != 0 && flags&progInTriage == 0 && req.Prog != nil &&
+		fuzzer.scoring.tracker.scoreOf(req.Prog.Hash()) == nil
+}
+
+// queueWeightedMutant 把程序的一个变异体以 score 为权重放入加权队列，队列已满时忽略。
+// 确定性模式下变异使用评分系统的随机数流。
+func (fuzzer *Fuzzer) queueWeightedMutant(p *prog.Prog, score float64) {
+	limit := fuzzer.scoreConfig().WeightedQueueSize
+	if limit <= 0 {
+		limit = defaultWeightedQueueSize
+	}
+	if fuzzer.weightedQueue.Len() >= limit {
+		return
+	}
+	newP := p.Clone()
+	ct, corpusProgs := fuzzer.ChoiceTable(), fuzzer.Config.Corpus.Programs()
+	fuzzer.scoring.withRand(fuzzer.rand(), func(rnd *rand.Rand) {
+		newP.Mutate(rnd, prog.RecommendedCalls, ct, fuzzer.Config.NoMutateCalls, corpusProgs)
+	})
+	req := &queue.Request{
+		Prog:     newP,
+		ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
+		Stat:     fuzzer.statExecWeighted,
+	}
+	fuzzer.prepare(req, 0, 0)
+	fuzzer.weightedQueue.SubmitScored(req, score)
+}
+
+// logProgScore 记录评分信息，progScore 为 nil 时忽略
+func (fuzzer *Fuzzer) logProgScore(progScore *ProgScore) {
+	if progScore == nil {
+		return
+	}
+	fuzzer.Logf(3, "程序评分: 总分=%.3f, 覆盖率=%.3f, 稀有性=%.3f, 内核日志=%.3f, 时间异常=%.3f",
+		progScore.Total, progScore.Coverage, progScore.Rarity,
+		progScore.KernelLog, progScore.TimeAnomaly)
+}
+
+// scoreAsync 在后台计算评分并更新指标，p 必须是调用方不再修改的程序副本。
+// spawn 为 true 时评分后把 p 的变异体放入加权队列。
+// hintNewSignal 为 true 时在同一个任务中评分提交之后标记 hints 新信号，
+// 否则标记可能在评分之前到达而被忽略。
+func (fuzzer *Fuzzer) scoreAsync(p *prog.Prog, execResult *ExecutionResult, spawn, hintNewSignal bool) {
+	fuzzer.asyncScorer.submit(func() {
+		progScore := fuzzer.scoring.Score(p, execResult)
+		if hintNewSignal && progScore != nil {
+			fuzzer.scoring.markHintNewSignal(p.Hash())
+		}
+		fuzzer.logProgScore(progScore)
+		if spawn && progScore != nil {
+			fuzzer.queueWeightedMutant(p, progScore.Total)
+		}
+	})
+}
+
+// ShutdownScoring 停止后台评分，等待已入队的评分任务处理完毕并返回被丢弃的任务数量。
+// 配置了 PersistPath、StatePath 或 MetricsPath 时随后保存最新的评分、评分统计和评分指标。
+// fuzzer 的 ctx 被取消时会自动调用 (嵌入方应在收到 SIGTERM 时取消 ctx)，可以重复调用。
+func (fuzzer *Fuzzer) ShutdownScoring() int64 {
+	dropped, _ := fuzzer.shutdownScoring()
+	return dropped
+}
+
+// Shutdown 与 ShutdownScoring 相同，但返回保存时遇到的第一个错误。
+// ctx 被取消后自动进行的关闭在后台完成；嵌入方在进程退出前调用 Shutdown，
+// 它返回时评分任务已经处理完毕，所有状态都已经写入文件。
+func (fuzzer *Fuzzer) Shutdown() error {
+	_, err := fuzzer.shutdownScoring()
+	return err
+}
+
+func (fuzzer *Fuzzer) shutdownScoring() (int64, error) {
+	dropped := fuzzer.asyncScorer.shutdown()
+	if dropped != 0 {
+		fuzzer.Logf(0, "评分系统关闭: 丢弃了 %d 个评分任务", dropped)
+	}
+	var firstErr error
+	for _, sp := range fuzzer.persisters {
+		if err := sp.flush(); err != nil {
+			fuzzer.Logf(0, "保存评分失败: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return dropped, firstErr
+}
+
+// newExecutionResult 从执行结果中提取评分所需的信息，
+// 并合并 Config.ExternalKernelLogs 提供的带外内核日志。
+func (fuzzer *Fuzzer) newExecutionResult(req *queue.Request, res *queue.Result) *ExecutionResult {
 	// 构建执行结果
 	execResult := &ExecutionResult{
 		ExecTime:   0,
@@ -552,55 +1058,100 @@ func (fuzzer *Fuzzer) calculateProgScore(req *queue.Request, res *queue.Result)
 		Crashed:    res.Status == queue.Crashed,
 		Error:      "",
 	}
+	if req.Prog != nil {
+		execResult.CallSequence = callSequence(req.Prog)
+	}
 	
 	if res.Info != nil {
 		execResult.ExecTime = res.Info.Elapsed
 		
-		// 收集信号
+		// 收集信号和原始覆盖 (如果执行器收集了)
+		for _, call := range res.Info.Calls {
+			if call != nil && len(call.Signal) > 0 {
+				execResult.CallSignal.Merge(signal.FromRaw(call.Signal, 0))
+			}
+			if call != nil && len(call.Cover) > 0 {
+				execResult.Cover.Merge(call.Cover)
+			}
+		}
+		if scoreConfig := fuzzer.scoreConfig(); scoreConfig != nil && scoreConfig.CallSignalScoring {
+			execResult.Signal = execResult.CallSignal.Copy()
+		}
 		if res.Info.Extra != nil && len(res.Info.Extra.Signal) > 0 {
-			execResult.Signal = signal.FromRaw(res.Info.Extra.Signal, 0)
+			execResult.Signal.Merge(signal.FromRaw(res.Info.Extra.Signal, 0))
 		}
-		
-		// 从输出中提取内核日志 (简化实现)
-		if len(res.Output) > 0 {
-			output := string(res.Output)
-			// 简单的日志行分割
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if line != "" && (strings.Contains(line, "KASAN") || 
-					strings.Contains(line, "WARNING") || 
-					strings.Contains(line, "ERROR") ||
-					strings.Contains(line, "Oops") ||
-					strings.Contains(line, "panic")) {
-					execResult.KernelLogs = append(execResult.KernelLogs, line)
-				}
+	}
+	// 组装执行结果时已经提取过内核日志的直接使用，否则从输出中提取
+	if res.Scoring != nil {
+		execResult.KernelLogs = append(execResult.KernelLogs, res.Scoring.KernelLogs...)
+	} else if res.Info != nil {
+		execResult.KernelLogs = append(execResult.KernelLogs, flatrpc.ExtractKernelLogs(res.Output)...)
+	}
+	// 带外来源的日志由嵌入方筛选过，不再按关键字过滤
+	if fuzzer.Config.ExternalKernelLogs != nil {
+		for _, line := range fuzzer.Config.ExternalKernelLogs(req, res) {
+			if line = strings.TrimSpace(line); line != "" {
+				execResult.KernelLogs = append(execResult.KernelLogs, line)
 			}
 		}
 	}
-	
-	if res.Err != nil {
-		execResult.Error = res.Err.Error()
-	}
-	
-	// 使用评分跟踪器计算评分
-	return fuzzer.scoreTracker.UpdateScore(req.Prog, execResult)
+	return execResult
 }
 
 // GetScoreMetrics 获取评分指标
 func (fuzzer *Fuzzer) GetScoreMetrics() *flatrpc.ScoreMetrics {
-	return fuzzer.scoreMetrics
+	return fuzzer.scoring.Metrics()
 }
 
-// GetTopScoredProgs 获取评分最高的程序
-func (fuzzer *Fuzzer) GetTopScoredProgs(limit int) []string {
-	return fuzzer.scoreTracker.GetTopScoredProgs(limit)
+// ScoringHealth 返回评分系统的健康报告
+func (fuzzer *Fuzzer) ScoringHealth() *ScoringHealth {
+	scoreConfig := fuzzer.scoreConfig()
+	samplingFraction := 0.0
+	if scoreConfig.Enabled {
+		samplingFraction = 1
+		if scoreConfig.AsyncScoring {
+			samplingFraction = fuzzer.asyncScorer.acceptedFraction()
+		}
+	}
+	return fuzzer.scoring.health(scoreConfig.Enabled, samplingFraction)
+}
+
+// GetTopScoredProgs 获取评分最高的程序及其评分
+func (fuzzer *Fuzzer) GetTopScoredProgs(limit int) []ScoredProg {
+	return fuzzer.scoring.tracker.GetTopScoredProgs(limit)
+}
+
+// ProgramScore 返回程序 (按哈希) 当前的各维度评分，程序尚未被评分或评分已被淘汰时返回 nil。
+// 返回的是副本，调用方 (例如 Web 界面) 可以随意读取而不与评分的更新竞争。
+func (fuzzer *Fuzzer) ProgramScore(hash string) *ProgScore {
+	return fuzzer.scoring.tracker.scoreOf(hash)
 }
 
-// UpdateScoreConfig 更新评分配置
+// SmashRemainingValue 估算继续 smash 程序的剩余价值 (0.0-1.0)。
+// 该值是最近若干次 smash 作业中使评分提升的变异比例，接近 0 说明收益已趋于平缓，
+// 可以考虑不再 smash 该程序。
+func (fuzzer *Fuzzer) SmashRemainingValue(progHash string) float64 {
+	return fuzzer.smashStats.remainingValue(progHash)
+}
+
+// UpdateScoreConfig 更新评分配置，配置无效时的处理与 NewFuzzer 相同。
+// 可以与 fuzzing 并发调用: 新配置只通过 scoreConfig 读取，Config.ScoreConfig 保持为初始配置。
 func (fuzzer *Fuzzer) UpdateScoreConfig(config *ScoreConfig) {
-	fuzzer.Config.ScoreConfig = config
-	fuzzer.scoreTracker.config = config
+	config = validatedScoreConfig(config, fuzzer.Logf)
+	if err := fuzzer.scoring.tracker.SetConfig(config); err != nil {
+		// validatedScoreConfig 总是返回有效的配置
+		panic(err)
+	}
+	fuzzer.scoreCfg.Store(config)
+}
+
+// scoreConfig 返回当前的评分配置。返回的配置不会被修改，UpdateScoreConfig 总是替换为新的配置。
+// 没有更新过时使用 Config.ScoreConfig，因此直接构造的 Fuzzer (例如测试中) 也能使用。
+func (fuzzer *Fuzzer) scoreConfig() *ScoreConfig {
+	if config := fuzzer.scoreCfg.Load(); config != nil {
+		return config
+	}
+	return fuzzer.Config.ScoreConfig
 }
 
 func setFlags(execFlags flatrpc.ExecFlag) flatrpc.ExecOpts {
@@ -22,6 +22,7 @@ import (
 	"github.com/google/syzkaller/pkg/flatrpc"
 	"github.com/google/syzkaller/pkg/fuzzer/queue"
 	"github.com/google/syzkaller/pkg/rpcserver"
+	"github.com/google/syzkaller/pkg/signal"
 	"github.com/google/syzkaller/pkg/testutil"
 	"github.com/google/syzkaller/pkg/vminfo"
 	"github.com/google/syzkaller/prog"
@@ -120,6 +121,242 @@ func BenchmarkFuzzer(b *testing.B) {
 	})
 }
 
+func TestCandidatePrio(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	ct := target.DefaultChoiceTable()
+	const count = 10
+	prio := map[*prog.Prog]float64{}
+	var candidates []Candidate
+	for i := 0; i < count; i++ {
+		p := target.Generate(rnd, 5, ct)
+		// Candidates are added in the order of increasing priority.
+		prio[p] = float64(i)
+		candidates = append(candidates, Candidate{Prog: p})
+	}
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+		CandidatePrio: func(p *prog.Prog) float64 {
+			return prio[p]
+		},
+	}, rnd, target)
+	fuzzer.AddCandidates(candidates)
+
+	// The custom priority must reverse the processing order.
+	for i := count - 1; i >= 0; i-- {
+		req := fuzzer.Next()
+		assert.Equal(t, candidates[i].Prog, req.Prog)
+	}
+}
+
+func TestWeightedRequestImportant(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	fuzzer.Config.Corpus.Save(corpus.NewInput{
+		Prog:   p,
+		Signal: signal.FromRaw([]uint64{1, 2, 3}, 0),
+		Cover:  []uint64{1, 2, 3},
+	})
+
+	setScore := func(total float64) {
+		st := fuzzer.scoring.tracker
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.scores[p.Hash()] = &ProgScore{Total: total}
+		st.touchLocked(p.Hash())
+	}
+	// By default no requests are marked as important.
+	setScore(1)
+	fuzzer.scoring.tracker.markInCorpus(p.Hash())
+	req := fuzzer.mutateProgRequestWeighted(rnd)
+	assert.NotNil(t, req)
+	assert.False(t, req.Important)
+
+	const threshold = 0.8
+	config := DefaultScoreConfig()
+	config.ImportantScoreThreshold = threshold
+	fuzzer.UpdateScoreConfig(config)
+
+	setScore(threshold)
+	req = fuzzer.mutateProgRequestWeighted(rnd)
+	assert.NotNil(t, req)
+	assert.True(t, req.Important)
+
+	setScore(threshold / 2)
+	req = fuzzer.mutateProgRequestWeighted(rnd)
+	assert.NotNil(t, req)
+	assert.False(t, req.Important)
+}
+
+func TestWeightedSelectionFailures(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+
+	// Nothing is scored yet.
+	assert.Nil(t, fuzzer.mutateProgRequestWeighted(rnd))
+	assert.Equal(t, 1, fuzzer.statWeightedEmptyTop.Val())
+	assert.Equal(t, 0, fuzzer.statWeightedResolveMiss.Val())
+
+	// The scored program was never saved to the corpus, so it is not selected.
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	fuzzer.scoring.tracker.UpdateScore(p, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	})
+	assert.Nil(t, fuzzer.mutateProgRequestWeighted(rnd))
+	assert.Equal(t, 2, fuzzer.statWeightedEmptyTop.Val())
+	assert.Equal(t, 0, fuzzer.statWeightedResolveMiss.Val())
+
+	// The program was saved, but the corpus no longer contains it (e.g. after a reload).
+	fuzzer.scoring.tracker.markInCorpus(p.Hash())
+	assert.Nil(t, fuzzer.mutateProgRequestWeighted(rnd))
+	assert.Equal(t, 2, fuzzer.statWeightedEmptyTop.Val())
+	assert.Equal(t, 1, fuzzer.statWeightedResolveMiss.Val())
+}
+
+// largeCorpusFuzzer returns a fuzzer with a corpus of n programs.
+func largeCorpusFuzzer(b *testing.B, n int) (*Fuzzer, []*prog.Prog) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	rnd := rand.New(rand.NewSource(0))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+	var progs []*prog.Prog
+	for i := 0; i < n; i++ {
+		p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+		fuzzer.Config.Corpus.Save(corpus.NewInput{
+			Prog:   p,
+			Signal: signal.FromRaw([]uint64{uint64(i)}, 0),
+		})
+		progs = append(progs, p)
+	}
+	return fuzzer, progs
+}
+
+func BenchmarkChoiceTableLargeCorpus(b *testing.B) {
+	fuzzer, _ := largeCorpusFuzzer(b, 5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fuzzer.ChoiceTable()
+	}
+}
+
+func BenchmarkWeightedLookupLargeCorpus(b *testing.B) {
+	fuzzer, progs := largeCorpusFuzzer(b, 5000)
+	hash := progs[len(progs)-1].Hash()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if fuzzer.Config.Corpus.ProgramByHash(hash) == nil {
+			b.Fatal("program not found")
+		}
+	}
+}
+
+func TestExternalKernelLogs(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	kernelLogScore := func(external func(*queue.Request, *queue.Result) []string) float64 {
+		fuzzer := &Fuzzer{
+			Config: &Config{
+				ScoreConfig:        DefaultScoreConfig(),
+				ExternalKernelLogs: external,
+			},
+			scoring: newScoring(DefaultScoreConfig()),
+		}
+		req := &queue.Request{Prog: p}
+		res := &queue.Result{
+			Info: &flatrpc.ProgInfo{
+				Elapsed: 1000000,
+				Calls:   []*flatrpc.CallInfo{{Signal: []uint64{1, 2, 3}}},
+			},
+		}
+		return fuzzer.calculateProgScore(req, res).KernelLog
+	}
+	// Nothing in res.Output.
+	assert.Equal(t, 0.0, kernelLogScore(nil))
+	// The crash is only visible to an out-of-band detector.
+	assert.Greater(t, kernelLogScore(func(*queue.Request, *queue.Result) []string {
+		return []string{"KASAN: use-after-free Read in foo"}
+	}), 0.0)
+}
+
+func TestStructuredKernelLogs(t *testing.T) {
+	fuzzer := &Fuzzer{Config: &Config{ScoreConfig: DefaultScoreConfig()}}
+	req := &queue.Request{}
+	res := &queue.Result{
+		Info:   &flatrpc.ProgInfo{Elapsed: 1000000},
+		Output: []byte("boot\nKASAN: use-after-free Read in foo\n"),
+	}
+	// Without structured data the output is scanned.
+	assert.Equal(t, []string{"KASAN: use-after-free Read in foo"},
+		fuzzer.newExecutionResult(req, res).KernelLogs)
+
+	// Pre-parsed kernel logs are used as is and the output is not re-scanned.
+	res.Scoring = flatrpc.NewScoringProgInfo(res.Info)
+	res.Scoring.SetKernelLogs([]string{"WARNING: in bar"})
+	assert.Equal(t, []string{"WARNING: in bar"}, fuzzer.newExecutionResult(req, res).KernelLogs)
+	res.Scoring.SetKernelLogs(nil)
+	assert.Empty(t, fuzzer.newExecutionResult(req, res).KernelLogs)
+}
+
+func TestExecutionResultCallSignal(t *testing.T) {
+	scoreConfig := DefaultScoreConfig()
+	fuzzer := &Fuzzer{Config: &Config{ScoreConfig: scoreConfig}}
+	req := &queue.Request{}
+	res := &queue.Result{
+		Info: &flatrpc.ProgInfo{
+			Elapsed: 1000000,
+			Calls:   []*flatrpc.CallInfo{{Signal: []uint64{1, 2}}, nil, {Signal: []uint64{3}}},
+			Extra:   &flatrpc.CallInfo{Signal: []uint64{100}},
+		},
+	}
+	// By default only the extra signal is scored, as before the per-call signal was collected.
+	execResult := fuzzer.newExecutionResult(req, res)
+	assert.ElementsMatch(t, []uint64{100}, execResult.Signal.ToRaw())
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, execResult.CallSignal.ToRaw())
+
+	scoreConfig.CallSignalScoring = true
+	execResult = fuzzer.newExecutionResult(req, res)
+	assert.ElementsMatch(t, []uint64{1, 2, 3, 100}, execResult.Signal.ToRaw())
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, execResult.CallSignal.ToRaw())
+}
+
 // Based on the example from Go documentation.
 var crc32q = crc32.MakeTable(0xD5828281)
 
@@ -255,3 +492,570 @@ func checkGoroutineLeaks() {
 		panic(err)
 	}
 }
+
+func TestRawCoverByScore(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	low := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	high := target.Generate(rnd, 5, target.DefaultChoiceTable())
+
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.RawCoverScoreThreshold = 0.5
+	fuzzer := &Fuzzer{
+		Config: &Config{
+			FetchRawCover: true,
+			ScoreConfig:   scoreConfig,
+		},
+		scoring: newScoring(scoreConfig),
+	}
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	st.scores[low.Hash()] = &ProgScore{Total: 0.2}
+	st.touchLocked(low.Hash())
+	st.scores[high.Hash()] = &ProgScore{Total: 0.9}
+	st.touchLocked(high.Hash())
+	st.mu.Unlock()
+
+	assert.False(t, fuzzer.wantRawCover(low))
+	assert.True(t, fuzzer.wantRawCover(high))
+
+	// Without a threshold raw cover is collected for every program.
+	scoreConfig.RawCoverScoreThreshold = 0
+	assert.True(t, fuzzer.wantRawCover(low))
+
+	// And never if it's not requested at all.
+	fuzzer.Config.FetchRawCover = false
+	assert.False(t, fuzzer.wantRawCover(high))
+}
+
+func TestExploreChoiceTable(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.ExploreRate = 1
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+
+	// High-score programs that use only a narrow subset of syscalls.
+	used := make(map[*prog.Syscall]bool)
+	for i := 0; i < 10; i++ {
+		p := target.Generate(rnd, 3, target.DefaultChoiceTable())
+		for _, c := range p.Calls {
+			used[c.Meta] = true
+		}
+		fuzzer.Config.Corpus.Save(corpus.NewInput{
+			Prog:   p,
+			Signal: signal.FromRaw([]uint64{uint64(i)}, 0),
+		})
+		fuzzer.scoring.Score(p, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i)}, 0),
+			ExecTime: 1000000,
+		})
+		fuzzer.scoring.tracker.markInCorpus(p.Hash())
+	}
+	fuzzer.updateChoiceTable(fuzzer.Config.Corpus.Programs())
+
+	unusedFraction := func(ct *prog.ChoiceTable) float64 {
+		total, unused := 0, 0
+		for i := 0; i < 100; i++ {
+			for _, c := range target.Generate(rnd, 5, ct).Calls {
+				total++
+				if !used[c.Meta] {
+					unused++
+				}
+			}
+		}
+		return float64(unused) / float64(total)
+	}
+	explore := unusedFraction(fuzzer.generateChoiceTable(rnd))
+	normal := unusedFraction(fuzzer.ChoiceTable())
+	assert.Greater(t, explore, normal)
+
+	// Without the option generation always uses the regular table.
+	scoreConfig.ExploreRate = 0
+	assert.Same(t, fuzzer.ChoiceTable(), fuzzer.generateChoiceTable(rnd))
+}
+
+func TestPriorityTriageQueue(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+	high := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	low := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	st.scores[high.Hash()] = &ProgScore{Total: 0.9}
+	st.touchLocked(high.Hash())
+	st.scores[low.Hash()] = &ProgScore{Total: 0.1}
+	st.touchLocked(low.Hash())
+	st.mu.Unlock()
+
+	// By default only the origin matters.
+	assert.Same(t, fuzzer.triageQueue, fuzzer.triageQueueFor(high, 0))
+	assert.Same(t, fuzzer.triageCandidateQueue, fuzzer.triageQueueFor(low, progCandidate))
+
+	scoreConfig.PriorityTriageThreshold = 0.5
+	assert.Same(t, fuzzer.triageCandidateQueue, fuzzer.triageQueueFor(high, 0))
+	assert.Same(t, fuzzer.triageQueue, fuzzer.triageQueueFor(low, 0))
+	assert.Same(t, fuzzer.triageCandidateQueue, fuzzer.triageQueueFor(low, progCandidate))
+}
+
+func TestInvalidateRetriagedProgram(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	result := &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	}
+	fuzzer.scoring.Score(p, result)
+	fuzzer.scoring.markInCorpus(p.Hash())
+	pcs := fuzzer.scoring.tracker.DistinctPCs()
+	assert.NotNil(t, fuzzer.scoring.tracker.GetScoreByHash(p.Hash()))
+	assert.Equal(t, 1, fuzzer.scoring.selector.Len())
+
+	// Re-triaging a corpus program drops its stale score, but not the global stats.
+	fuzzer.AddCandidates([]Candidate{{Prog: p, Flags: ProgFromCorpus}})
+	assert.Nil(t, fuzzer.scoring.tracker.GetScoreByHash(p.Hash()))
+	assert.Equal(t, 0, fuzzer.scoring.selector.Len())
+	assert.Equal(t, pcs, fuzzer.scoring.tracker.DistinctPCs())
+
+	// The program gets a selector weight again only once it is saved to the corpus.
+	fuzzer.scoring.Score(p, result)
+	assert.NotNil(t, fuzzer.scoring.tracker.GetScoreByHash(p.Hash()))
+	assert.Equal(t, 0, fuzzer.scoring.selector.Len())
+	fuzzer.scoring.markInCorpus(p.Hash())
+	assert.Equal(t, 1, fuzzer.scoring.selector.Len())
+}
+
+func TestProgramScore(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	assert.Nil(t, fuzzer.ProgramScore(p.Hash()))
+
+	stored := fuzzer.scoring.Score(p, &ExecutionResult{
+		Signal:     signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime:   1000000,
+		KernelLogs: []string{"KASAN: use-after-free Read in foo"},
+	})
+	score := fuzzer.ProgramScore(p.Hash())
+	if assert.NotNil(t, score) {
+		assert.Equal(t, *stored, *score)
+		assert.Positive(t, score.Coverage)
+		assert.Positive(t, score.KernelLog)
+	}
+	// The returned score is a copy, modifying it does not affect the tracker.
+	score.Total = -1
+	assert.Equal(t, stored.Total, fuzzer.ProgramScore(p.Hash()).Total)
+	assert.NotSame(t, fuzzer.ProgramScore(p.Hash()), fuzzer.ProgramScore(p.Hash()))
+}
+
+func TestScoreStats(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus: corpus.NewCorpus(ctx),
+	}, rnd, target)
+	for i := 0; i < 5; i++ {
+		p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+		fuzzer.scoring.Score(p, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i), uint64(i + 100)}, 0),
+			ExecTime: 1000000,
+		})
+	}
+	fuzzer.scoring.Metrics().UpdateSmashStats(1, 4, 0.5)
+
+	metrics := fuzzer.GetScoreMetrics().Snapshot()
+	assert.Positive(t, fuzzer.statScoreAverage.Val())
+	assert.InDelta(t, metrics.AverageScore*1000, fuzzer.statScoreAverage.Val(), 0.5)
+	assert.Equal(t, 0, fuzzer.statScoreSelected.Val())
+	assert.Equal(t, 250, fuzzer.statSmashSuccess.Val())
+
+	// Disabled scoring does not register the stats.
+	config := DefaultScoreConfig()
+	config.Enabled = false
+	disabled := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: config,
+	}, rnd, target)
+	assert.Nil(t, disabled.statScoreAverage)
+}
+
+func TestWeightedQueue(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	config := DefaultScoreConfig()
+	config.WeightedQueue = true
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: config,
+	}, rnd, target)
+
+	// Fuzzed programs spawn a weighted mutant, the mutants themselves don't.
+	res := &queue.Result{
+		Info: &flatrpc.ProgInfo{
+			Elapsed: 1000000,
+			Calls:   []*flatrpc.CallInfo{{Signal: []uint64{1, 2, 3}}},
+		},
+	}
+	req := &queue.Request{
+		Prog: target.Generate(rnd, 5, target.DefaultChoiceTable()),
+		Stat: fuzzer.statExecFuzz,
+	}
+	fuzzer.scoreResult(req, res, 0, 0, false)
+	assert.Equal(t, 1, fuzzer.weightedQueue.Len())
+	mutant := fuzzer.Next()
+	assert.Equal(t, fuzzer.statExecWeighted, mutant.Stat)
+	fuzzer.scoreResult(mutant, res, 0, 0, false)
+	assert.Equal(t, 0, fuzzer.weightedQueue.Len())
+
+	// Higher scored requests are dequeued earlier on average.
+	const n = 100
+	scores := make(map[*queue.Request]float64)
+	for i := 0; i < n; i++ {
+		req := &queue.Request{Prog: target.Generate(rnd, 5, target.DefaultChoiceTable())}
+		scores[req] = 0.1
+		if i%2 == 0 {
+			scores[req] = 0.9
+		}
+		fuzzer.weightedQueue.SubmitScored(req, scores[req])
+	}
+	var high, low float64
+	for pos := 0; pos < n; pos++ {
+		req := fuzzer.Next()
+		score, ok := scores[req]
+		if !assert.True(t, ok, "request did not come from the weighted queue") {
+			return
+		}
+		if score > 0.5 {
+			high += float64(pos)
+		} else {
+			low += float64(pos)
+		}
+	}
+	assert.Less(t, high, low)
+
+	// Once the weighted queue is empty, the fuzzer falls through to generation.
+	assert.NotContains(t, scores, fuzzer.Next())
+}
+
+func TestScoreTriageExecutions(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := DefaultScoreConfig()
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: config,
+	}, rand.New(testutil.RandSource(t)), target)
+	rnd := rand.New(testutil.RandSource(t))
+	res := &queue.Result{
+		Info: &flatrpc.ProgInfo{
+			Elapsed: 1000000,
+			Calls:   []*flatrpc.CallInfo{{Signal: []uint64{1, 2, 3}}},
+		},
+	}
+	scorings := func() int64 {
+		return fuzzer.GetScoreMetrics().Snapshot().TotalRequests
+	}
+	// scored reports whether processing the execution of p with flags scored it.
+	scored := func(p *prog.Prog, flags ProgFlags) bool {
+		before := scorings()
+		fuzzer.processResult(&queue.Request{Prog: p}, res, flags, 0)
+		return scorings() > before
+	}
+	generate := func() *prog.Prog {
+		return target.Generate(rnd, 5, target.DefaultChoiceTable())
+	}
+
+	// By default triage executions are not scored.
+	assert.False(t, scored(generate(), progInTriage))
+	assert.True(t, scored(generate(), 0))
+	// Candidates are scored only while they have no score, e.g. after a restart.
+	p := generate()
+	assert.True(t, scored(p, progCandidate|ProgFromCorpus))
+	assert.False(t, scored(p, progCandidate|ProgFromCorpus))
+	// An invalidated program is rescored on the next execution.
+	fuzzer.scoring.invalidate(p.Hash())
+	assert.True(t, scored(p, progCandidate|ProgFromCorpus))
+
+	config.ScoreTriageExecutions = true
+	assert.True(t, scored(generate(), progInTriage))
+	assert.True(t, scored(p, progCandidate|ProgFromCorpus))
+}
+
+func TestHintNewSignalWeight(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			testHintNewSignalWeight(t, async)
+		})
+	}
+}
+
+func testHintNewSignalWeight(t *testing.T, async bool) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.CallSignalScoring = true
+	scoreConfig.AsyncScoring = async
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+	weight := func(p *prog.Prog) float64 {
+		fuzzer.scoring.selector.mu.RLock()
+		defer fuzzer.scoring.selector.mu.RUnlock()
+		return fuzzer.scoring.selector.weights[p.Hash()]
+	}
+	execHint := func() *prog.Prog {
+		p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+		req := &queue.Request{
+			Prog:     p,
+			ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
+		}
+		fuzzer.processResult(req, &queue.Result{
+			Info: &flatrpc.ProgInfo{
+				Elapsed: 1000000,
+				Calls:   []*flatrpc.CallInfo{{Signal: []uint64{1, 2, 3}}},
+			},
+		}, progHint, 0)
+		return p
+	}
+
+	// The first mutant brings new signal, the second one repeats it.
+	// With async scoring the mark must survive the score that is committed later.
+	// Only corpus programs get selector weights.
+	productive, unproductive := execHint(), execHint()
+	fuzzer.asyncScorer.shutdown()
+	fuzzer.scoring.markInCorpus(productive.Hash())
+	fuzzer.scoring.markInCorpus(unproductive.Hash())
+	productiveScore := fuzzer.scoring.tracker.GetScoreByHash(productive.Hash())
+	unproductiveScore := fuzzer.scoring.tracker.GetScoreByHash(unproductive.Hash())
+	if !assert.NotNil(t, productiveScore) || !assert.NotNil(t, unproductiveScore) {
+		return
+	}
+	assert.True(t, productiveScore.HintNewSignal)
+	assert.False(t, unproductiveScore.HintNewSignal)
+	assert.Equal(t, productiveScore.Total*hintNewSignalBoost, weight(productive))
+	assert.Equal(t, unproductiveScore.Total, weight(unproductive))
+	assert.Greater(t, weight(productive), weight(unproductive))
+}
+
+func TestChooseCorpusProgramWeighted(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+
+	var progs []*prog.Prog
+	for i := 0; i < 10; i++ {
+		p := target.Generate(rnd, 3, target.DefaultChoiceTable())
+		fuzzer.Config.Corpus.Save(corpus.NewInput{
+			Prog:   p,
+			Signal: signal.FromRaw([]uint64{uint64(i)}, 0),
+		})
+		progs = append(progs, p)
+	}
+	// Corpus.ChooseProgram slightly favors the first program, so boost one in the middle.
+	boosted := progs[len(progs)/2]
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	st.scores[boosted.Hash()] = &ProgScore{Total: 9}
+	st.touchLocked(boosted.Hash())
+	st.mu.Unlock()
+	// The weight reaches the corpus once the program is marked as saved.
+	fuzzer.scoring.markInCorpus(boosted.Hash())
+
+	const total = 2000
+	chosen := func() int {
+		count := 0
+		for i := 0; i < total; i++ {
+			if fuzzer.chooseCorpusProgram(rnd) == boosted {
+				count++
+			}
+		}
+		return count
+	}
+	// The high-weight program has priority 1*(1+9) against 9 programs with priority 1.
+	assert.InDelta(t, total*10/19, chosen(), total/20)
+
+	// With scoring disabled the corpus priorities alone are used.
+	scoreConfig.Enabled = false
+	assert.InDelta(t, total/10, chosen(), total/20)
+
+	// Invalidated programs lose their boost in the corpus as well.
+	scoreConfig.Enabled = true
+	fuzzer.scoring.invalidate(boosted.Hash())
+	assert.InDelta(t, total/10, chosen(), total/20)
+}
+
+func TestDeterministicScoring(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := rand.New(testutil.RandSource(t)).Int63()
+	run := func() []string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		scoreConfig := DefaultScoreConfig()
+		scoreConfig.Deterministic = true
+		scoreConfig.WeightedQueue = true
+		fuzzer := NewFuzzer(ctx, &Config{
+			Corpus:      corpus.NewCorpus(ctx),
+			ScoreConfig: scoreConfig,
+		}, rand.New(rand.NewSource(seed)), target)
+
+		rnd := rand.New(rand.NewSource(seed))
+		st := fuzzer.scoring.tracker
+		var progs []*prog.Prog
+		for i := 0; i < 20; i++ {
+			p := target.Generate(rnd, 3, target.DefaultChoiceTable())
+			progs = append(progs, p)
+			fuzzer.Config.Corpus.Save(corpus.NewInput{
+				Prog:   p,
+				Signal: signal.FromRaw([]uint64{uint64(i)}, 0),
+			})
+			// Every other pair of programs has the same score.
+			score := &ProgScore{Total: float64(i/2) / 10}
+			st.mu.Lock()
+			st.scores[p.Hash()] = score
+			st.touchLocked(p.Hash())
+			st.mu.Unlock()
+			fuzzer.scoring.markInCorpus(p.Hash())
+		}
+
+		var selected []string
+		for i := 0; i < 200; i++ {
+			// The callers' random sources differ between the runs.
+			callerRnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+			selected = append(selected, fuzzer.scoring.Select(callerRnd),
+				fuzzer.chooseCorpusProgram(callerRnd).Hash())
+		}
+		// The weighted queue's random stream is derived from the scoring one.
+		for i, p := range progs {
+			fuzzer.weightedQueue.SubmitScored(&queue.Request{Prog: p}, float64(i%4+1))
+		}
+		for req := fuzzer.weightedQueue.Next(); req != nil; req = fuzzer.weightedQueue.Next() {
+			selected = append(selected, req.Prog.Hash())
+		}
+		return selected
+	}
+	first := run()
+	assert.Equal(t, first, run())
+	distinct := make(map[string]bool)
+	for _, hash := range first {
+		distinct[hash] = true
+	}
+	assert.Greater(t, len(distinct), 1)
+}
+
+func TestUpdateScoreConfigConcurrent(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	initial := DefaultScoreConfig()
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: initial,
+	}, rand.New(testutil.RandSource(t)), target)
+	rnd := rand.New(testutil.RandSource(t))
+	p := target.Generate(rnd, 3, target.DefaultChoiceTable())
+
+	// The config is replaced while fuzzing reads it, run with -race to check.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			config := DefaultScoreConfig()
+			config.ImportantScoreThreshold = float64(i%10) / 10
+			fuzzer.UpdateScoreConfig(config)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		fuzzer.importantByScore(p.Hash())
+		fuzzer.chooseCorpusProgram(rnd)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0.9, fuzzer.scoreConfig().ImportantScoreThreshold)
+	// The initial config is left untouched.
+	assert.Same(t, initial, fuzzer.Config.ScoreConfig)
+	assert.Equal(t, DefaultScoreConfig().ImportantScoreThreshold, initial.ImportantScoreThreshold)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"sync"
+)
+
+const (
+	// 每个评估窗口包含的 genFuzz 请求数
+	genWatchdogWindow = 1000
+	// 生成比例连续低于下限多少个窗口后触发强制生成
+	genWatchdogPatience = 3
+	// 每次触发时强制生成的程序数量
+	genWatchdogBurst = 100
+)
+
+// genWatchdog 监控 genFuzz 中新生成程序所占的比例。
+// 评分驱动的加权变异可能让 fuzzer 长期集中变异少数高分程序，几乎不再生成新程序。
+// 如果生成比例连续多个窗口低于下限，接下来的若干次请求强制生成新程序。
+type genWatchdog struct {
+	mu sync.Mutex
+
+	floor    float64
+	window   int
+	patience int
+	burst    int
+
+	generated  int
+	total      int
+	lowWindows int
+	burstLeft  int
+	bursts     int
+}
+
+// newGenWatchdog 创建监控器，floor <= 0 表示不监控
+func newGenWatchdog(floor float64, window, patience, burst int) *genWatchdog {
+	return &genWatchdog{
+		floor:    floor,
+		window:   window,
+		patience: patience,
+		burst:    burst,
+	}
+}
+
+// forceGenerate 返回本次请求是否必须生成新程序
+func (w *genWatchdog) forceGenerate() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.burstLeft == 0 {
+		return false
+	}
+	w.burstLeft--
+	return true
+}
+
+// record 记录一次请求是生成的还是变异的
+func (w *genWatchdog) record(generated bool) {
+	if w.floor <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.total++
+	if generated {
+		w.generated++
+	}
+	if w.total < w.window {
+		return
+	}
+	if float64(w.generated)/float64(w.total) < w.floor {
+		w.lowWindows++
+	} else {
+		w.lowWindows = 0
+	}
+	w.generated, w.total = 0, 0
+	if w.lowWindows >= w.patience && w.burstLeft == 0 {
+		w.lowWindows = 0
+		w.burstLeft = w.burst
+		w.bursts++
+	}
+}
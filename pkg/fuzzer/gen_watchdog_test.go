@@ -0,0 +1,45 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenWatchdogBurst(t *testing.T) {
+	w := newGenWatchdog(0.2, 10, 2, 5)
+	// 模拟过度利用：几乎只有变异。
+	for i := 0; i < 19; i++ {
+		assert.False(t, w.forceGenerate())
+		w.record(i == 0)
+	}
+	assert.False(t, w.forceGenerate())
+	w.record(false)
+
+	// 连续两个窗口低于下限，触发强制生成。
+	for i := 0; i < 5; i++ {
+		assert.True(t, w.forceGenerate())
+		w.record(true)
+	}
+	assert.False(t, w.forceGenerate())
+	assert.Equal(t, 1, w.bursts)
+}
+
+func TestGenWatchdogHealthy(t *testing.T) {
+	w := newGenWatchdog(0.2, 10, 2, 5)
+	for i := 0; i < 100; i++ {
+		assert.False(t, w.forceGenerate())
+		w.record(i%3 == 0)
+	}
+	assert.Equal(t, 0, w.bursts)
+
+	// floor 为 0 (默认配置) 时不监控。
+	w = newGenWatchdog(DefaultScoreConfig().MinGenerateRatio, 10, 2, 5)
+	for i := 0; i < 100; i++ {
+		w.record(false)
+	}
+	assert.False(t, w.forceGenerate())
+}
@@ -5,12 +5,14 @@ package fuzzer
 
 import (
 	"context"
+	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/google/syzkaller/pkg/corpus"
+	"github.com/google/syzkaller/pkg/flatrpc"
 	"github.com/google/syzkaller/pkg/fuzzer/queue"
-	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
 	"github.com/google/syzkaller/prog"
 )
 
@@ -18,37 +20,31 @@ import (
 func TestFuzzerWithScoringSystem(t *testing.T) {
 	// 创建测试配置
 	cfg := &Config{
-		Debug:        true,
-		Coverage:     true,
-		ScoreConfig:  DefaultScoreConfig(),
-		EnabledCalls: make(map[*prog.Syscall]bool),
+		Debug:       true,
+		Coverage:    true,
+		ScoreConfig: DefaultScoreConfig(),
 		Logf: func(level int, msg string, args ...interface{}) {
 			t.Logf("[Level %d] "+msg, append([]interface{}{level}, args...)...)
 		},
 	}
 	
-	// 创建模拟的 corpus
-	cfg.Corpus = &MockCorpus{
-		programs: make([]*prog.Prog, 0),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
 	// 创建 Fuzzer 实例
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
 	
 	// 验证评分系统组件已初始化
-	if fuzzer.scoreTracker == nil {
+	if fuzzer.scoring.tracker == nil {
 		t.Error("ScoreTracker 未初始化")
 	}
-	if fuzzer.weightedSelector == nil {
+	if fuzzer.scoring.selector == nil {
 		t.Error("WeightedSelector 未初始化")
 	}
-	if fuzzer.scoreMetrics == nil {
+	if fuzzer.scoring.metrics == nil {
 		t.Error("ScoreMetrics 未初始化")
 	}
 	
@@ -73,18 +69,18 @@ func TestProcessResultWithScoring(t *testing.T) {
 		},
 	}
 	
-	cfg.Corpus = &MockCorpus{programs: make([]*prog.Prog, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
+	// 生成测试程序使用单独的随机数，fuzzer 的后台 goroutine 会并发使用传给它的随机数
+	rnd := rand.New(testutil.RandSource(t))
 	
 	// 创建测试请求和结果
-	testProg := target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+	testProg := target.Generate(rnd, prog.RecommendedCalls, target.DefaultChoiceTable())
 	req := &queue.Request{
 		Prog:     testProg,
 		ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
@@ -109,7 +105,7 @@ func TestProcessResultWithScoring(t *testing.T) {
 	}
 	
 	// 验证评分已计算
-	score := fuzzer.scoreTracker.GetScore(testProg.Hash())
+	score := fuzzer.scoring.tracker.GetScoreByHash(testProg.Hash())
 	if score == nil {
 		t.Error("程序评分未计算")
 	} else {
@@ -149,19 +145,19 @@ func TestWeightedProgramGeneration(t *testing.T) {
 		},
 	}
 	
-	cfg.Corpus = &MockCorpus{programs: make([]*prog.Prog, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
+	// 生成测试程序使用单独的随机数，fuzzer 的后台 goroutine 会并发使用传给它的随机数
+	rnd := rand.New(testutil.RandSource(t))
 	
 	// 添加一些高分程序到评分跟踪器
 	for i := 0; i < 5; i++ {
-		prog := target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+		prog := target.Generate(rnd, prog.RecommendedCalls, target.DefaultChoiceTable())
 		score := &ProgScore{
 			Total:       0.8 + float64(i)*0.04, // 0.8-0.96
 			Coverage:    0.7,
@@ -169,14 +165,13 @@ func TestWeightedProgramGeneration(t *testing.T) {
 			KernelLog:   0.9,
 			TimeAnomaly: 0.6,
 		}
-		fuzzer.scoreTracker.scores[prog.Hash()] = score
-		fuzzer.weightedSelector.UpdateWeight(prog.Hash(), score.Total)
-		cfg.Corpus.(*MockCorpus).programs = append(cfg.Corpus.(*MockCorpus).programs, prog)
+		fuzzer.scoring.tracker.scores[prog.Hash()] = score
+		fuzzer.scoring.selector.UpdateWeight(prog.Hash(), score.Total)
+		cfg.Corpus.Save(corpus.NewInput{Prog: prog})
 	}
 	
 	// 测试加权程序生成
 	generatedCount := 0
-	weightedCount := 0
 	
 	for i := 0; i < 100; i++ {
 		req := fuzzer.genFuzz()
@@ -207,18 +202,18 @@ func TestSmashJobWithScoring(t *testing.T) {
 		},
 	}
 	
-	cfg.Corpus = &MockCorpus{programs: make([]*prog.Prog, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
+	// 生成测试程序使用单独的随机数，fuzzer 的后台 goroutine 会并发使用传给它的随机数
+	rnd := rand.New(testutil.RandSource(t))
 	
 	// 创建测试程序
-	testProg := target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+	testProg := target.Generate(rnd, prog.RecommendedCalls, target.DefaultChoiceTable())
 	
 	// 设置程序评分
 	highScore := &ProgScore{
@@ -228,7 +223,7 @@ func TestSmashJobWithScoring(t *testing.T) {
 		KernelLog:   0.8,
 		TimeAnomaly: 0.9,
 	}
-	fuzzer.scoreTracker.scores[testProg.Hash()] = highScore
+	fuzzer.scoring.tracker.scores[testProg.Hash()] = highScore
 	
 	// 创建 smash 作业
 	job := &smashJob{
@@ -265,15 +260,13 @@ func TestScoreSystemDisabled(t *testing.T) {
 		},
 	}
 	
-	cfg.Corpus = &MockCorpus{programs: make([]*prog.Prog, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
 	
 	// 验证评分系统已禁用
 	if fuzzer.Config.ScoreConfig.Enabled {
@@ -290,25 +283,6 @@ func TestScoreSystemDisabled(t *testing.T) {
 }
 
 // 模拟实现
-type MockCorpus struct {
-	programs []*prog.Prog
-}
-
-func (mc *MockCorpus) Programs() []*prog.Prog {
-	return mc.programs
-}
-
-func (mc *MockCorpus) ChooseProgram(rnd *rand.Rand) *prog.Prog {
-	if len(mc.programs) == 0 {
-		return nil
-	}
-	return mc.programs[rnd.Intn(len(mc.programs))]
-}
-
-func (mc *MockCorpus) Save(input corpus.NewInput) {
-	mc.programs = append(mc.programs, input.Prog)
-}
-
 type MockExecutor struct{}
 
 func (me *MockExecutor) Submit(req *queue.Request) {
@@ -344,15 +318,13 @@ func TestEndToEndScoring(t *testing.T) {
 		},
 	}
 	
-	cfg.Corpus = &MockCorpus{programs: make([]*prog.Prog, 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Corpus = corpus.NewCorpus(ctx)
 	
-	ctx := context.Background()
 	target := getTestTarget()
-	if target == nil {
-		t.Skip("测试目标不可用")
-	}
 	
-	fuzzer := NewFuzzer(ctx, cfg, nil, target)
+	fuzzer := NewFuzzer(ctx, cfg, rand.New(testutil.RandSource(t)), target)
 	
 	// 模拟完整的模糊测试流程
 	numIterations := 10
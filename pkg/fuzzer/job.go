@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,17 +35,47 @@ type JobInfo struct {
 	Type  string
 	Execs atomic.Int32
 
+	// The score the job used for its program, set once the job has looked it up.
+	score atomic.Pointer[jobScore]
+
 	syncBuffer
 }
 
+type jobScore struct {
+	total     float64
+	breakdown *ProgScore
+}
+
 func (ji *JobInfo) ID() string {
 	return fmt.Sprintf("%p", ji)
 }
 
+// Score returns the program score used by the job (0 if the job doesn't use scores).
+func (ji *JobInfo) Score() float64 {
+	if score := ji.score.Load(); score != nil {
+		return score.total
+	}
+	return 0
+}
+
+// ScoreBreakdown returns the per-dimension scores behind Score,
+// or nil if the program was not scored (e.g. the job used the neutral score).
+func (ji *JobInfo) ScoreBreakdown() *ProgScore {
+	if score := ji.score.Load(); score != nil && score.breakdown != nil {
+		breakdown := *score.breakdown
+		return &breakdown
+	}
+	return nil
+}
+
+func (ji *JobInfo) setScore(total float64, breakdown *ProgScore) {
+	ji.score.Store(&jobScore{total: total, breakdown: breakdown})
+}
+
 func genProgRequest(fuzzer *Fuzzer, rnd *rand.Rand) *queue.Request {
 	p := fuzzer.target.Generate(rnd,
 		prog.RecommendedCalls,
-		fuzzer.ChoiceTable())
+		fuzzer.generateChoiceTable(rnd))
 	return &queue.Request{
 		Prog:     p,
 		ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
@@ -53,7 +84,7 @@ func genProgRequest(fuzzer *Fuzzer, rnd *rand.Rand) *queue.Request {
 }
 
 func mutateProgRequest(fuzzer *Fuzzer, rnd *rand.Rand) *queue.Request {
-	p := fuzzer.Config.Corpus.ChooseProgram(rnd)
+	p := fuzzer.chooseCorpusProgram(rnd)
 	if p == nil {
 		return nil
 	}
@@ -128,6 +159,9 @@ const (
 	deflakeMaxCorpusRuns    = 6
 	deflakeTotalCorpusRuns  = 20
 	deflakeNeedSnapshotRuns = 2
+	// With scoring enabled, fuzzing programs get up to this many deflake runs more (high score)
+	// or fewer (low score) than deflakeMaxRuns, see Fuzzer.scoredDeflakeMaxRuns.
+	deflakeScoredRunsDelta = 2
 )
 
 func (job *triageJob) execute(req *queue.Request, flags ProgFlags) *queue.Result {
@@ -150,12 +184,43 @@ func (job *triageJob) run(fuzzer *Fuzzer) {
 	if stop {
 		return
 	}
+	runOrdered(job.callOrder(), fuzzer.Config.MaxTriageCalls, func(call int) {
+		job.handleCall(call, job.calls[call])
+	})
+}
+
+// callOrder returns triaged calls sorted by the amount of new stable signal,
+// so that the most promising calls are handled first.
+func (job *triageJob) callOrder() []int {
+	calls := make([]int, 0, len(job.calls))
+	for call := range job.calls {
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		ni, nj := job.calls[calls[i]].newStableSignal.Len(), job.calls[calls[j]].newStableSignal.Len()
+		if ni != nj {
+			return ni > nj
+		}
+		return calls[i] < calls[j]
+	})
+	return calls
+}
+
+// runOrdered runs fn for each call in the given order with at most limit calls
+// running concurrently (limit <= 0 means no limit) and waits for all of them.
+func runOrdered(calls []int, limit int, fn func(call int)) {
+	if limit <= 0 {
+		limit = len(calls)
+	}
+	sem := make(chan struct{}, max(limit, 1))
 	var wg sync.WaitGroup
-	for call, info := range job.calls {
+	for _, call := range calls {
+		sem <- struct{}{}
 		wg.Add(1)
 		go func() {
-			job.handleCall(call, info)
-			wg.Done()
+			defer wg.Done()
+			fn(call)
+			<-sem
 		}()
 	}
 	wg.Wait()
@@ -168,7 +233,7 @@ func (job *triageJob) handleCall(call int, info *triageCall) {
 
 	p := job.p
 	if job.flags&ProgMinimized == 0 {
-		p, call = job.minimize(call, info)
+		p, call = job.minimize(call, info, job.execute)
 		if p == nil {
 			return
 		}
@@ -178,15 +243,18 @@ func (job *triageJob) handleCall(call int, info *triageCall) {
 		return
 	}
 	if job.flags&ProgSmashed == 0 {
-		job.fuzzer.startJob(job.fuzzer.statJobsSmash, &smashJob{
-			exec: job.fuzzer.smashQueue,
-			p:    p.Clone(),
-			info: &JobInfo{
-				Name:  p.String(),
-				Type:  "smash",
-				Calls: []string{p.CallName(call)},
-			},
-		})
+		scoreConfig := job.fuzzer.scoreConfig()
+		if !scoreConfig.Enabled || job.trySmash(p, info) {
+			job.fuzzer.startJob(job.fuzzer.statJobsSmash, &smashJob{
+				exec: job.fuzzer.smashQueue,
+				p:    p.Clone(),
+				info: &JobInfo{
+					Name:  p.String(),
+					Type:  "smash",
+					Calls: []string{p.CallName(call)},
+				},
+			})
+		}
 		if job.fuzzer.Config.Comparisons && call >= 0 {
 			job.fuzzer.startJob(job.fuzzer.statJobsHints, &hintsJob{
 				exec: job.fuzzer.smashQueue,
@@ -200,11 +268,20 @@ func (job *triageJob) handleCall(call int, info *triageCall) {
 			})
 		}
 		if job.fuzzer.Config.FaultInjection && call >= 0 {
-			job.fuzzer.startJob(job.fuzzer.statJobsFaultInjection, &faultInjectionJob{
-				exec: job.fuzzer.smashQueue,
-				p:    p.Clone(),
-				call: call,
-			})
+			if scoreConfig.Enabled && scoreConfig.RareFaultInjection {
+				job.fuzzer.startJob(job.fuzzer.statJobsFaultInjection, &rareFaultInjectionJob{
+					exec:       job.fuzzer.smashQueue,
+					p:          p.Clone(),
+					call:       call,
+					novelLimit: scoreConfig.FaultInjectionNovelLimit,
+				})
+			} else {
+				job.fuzzer.startJob(job.fuzzer.statJobsFaultInjection, &faultInjectionJob{
+					exec: job.fuzzer.smashQueue,
+					p:    p.Clone(),
+					call: call,
+				})
+			}
 		}
 	}
 	job.fuzzer.Logf(2, "added new input for %v to the corpus: %s", callName, p)
@@ -214,21 +291,76 @@ func (job *triageJob) handleCall(call int, info *triageCall) {
 		Signal:   info.stableSignal,
 		Cover:    info.cover.Serialize(),
 		RawCover: info.rawCover,
+		Score:    job.corpusScore(p),
 	}
 	job.fuzzer.Config.Corpus.Save(input)
+	if job.fuzzer.scoreConfig().Enabled {
+		job.fuzzer.scoring.markInCorpus(p.Hash())
+	}
+}
+
+// trySmash 判断评分启用时是否 smash 程序: 最近已经 smash 过稳定信号相同、评分相近的程序
+// (见 SmashDedupScoreDelta 和 SmashDedupWindow) 或程序仍在 smash 冷却期内时跳过。只有确定 smash 时才记录稳定信号，
+// 因冷却而跳过的程序不会阻止之后同样信号的程序被 smash。
+// 程序的评分取 triage 前的原始程序的评分，未评分时使用中性分数。
+func (job *triageJob) trySmash(p *prog.Prog, info *triageCall) bool {
+	scoreConfig := job.fuzzer.scoreConfig()
+	dedup := scoreConfig.SmashDedupScoreDelta > 0 && scoreConfig.SmashDedupWindow > 0
+	score := neutralScore
+	if dedup {
+		if progScore := job.fuzzer.scoring.tracker.scoreOf(job.p.Hash()); progScore != nil {
+			score = progScore.Total
+		}
+		if job.fuzzer.scoring.tracker.smashedSignal(info.stableSignal, score,
+			scoreConfig.SmashDedupScoreDelta, scoreConfig.SmashDedupWindow) {
+			return false
+		}
+	}
+	if !job.fuzzer.smashStats.tryStart(p.Hash(), scoreConfig.SmashCooldown, scoreConfig.MaxTrackedProgs,
+		time.Now()) {
+		return false
+	}
+	if dedup {
+		job.fuzzer.scoring.tracker.recordSmashedSignal(info.stableSignal, score, scoreConfig.SmashDedupWindow)
+	}
+	return true
+}
+
+// corpusScore 返回保存到语料库时附带的程序评分 (0 表示未知)。
+// 最小化后的程序通常没有被单独评分，此时它以最终哈希继承原始程序的评分，
+// 否则保存的语料库程序在评分系统中没有对应的记录。
+func (job *triageJob) corpusScore(p *prog.Prog) float64 {
+	if !job.fuzzer.scoreConfig().Enabled {
+		return 0
+	}
+	score := job.fuzzer.scoring.inherit(job.p.Hash(), p.Hash())
+	if score == nil {
+		return 0
+	}
+	return score.Total
 }
 
 func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result) (stop bool) {
 	job.info.Logf("deflake started")
 
 	avoid := []queue.ExecutorID{job.executor}
-	needRuns := deflakeNeedCorpusRuns
+	var prefer []queue.ExecutorID
+	if job.fuzzer.deflakeOnOriginalExecutor(job.p) {
+		avoid, prefer = nil, []queue.ExecutorID{job.executor}
+	}
+	needRuns, maxRuns := deflakeNeedCorpusRuns, deflakeMaxRuns
 	if job.fuzzer.Config.Snapshot {
 		needRuns = deflakeNeedSnapshotRuns
 	} else if job.flags&ProgFromCorpus == 0 {
 		needRuns = deflakeNeedRuns
+		maxRuns = job.fuzzer.scoredDeflakeMaxRuns(job.p)
+	}
+	stableRuns := needRuns
+	if cfg := job.fuzzer.Config.DeflakeStableRuns; cfg > 0 {
+		stableRuns = min(cfg, needRuns)
 	}
 	prevTotalNewSignal := 0
+	fetchRawCover := job.fuzzer.wantRawCover(job.p)
 	for run := 1; ; run++ {
 		totalNewSignal := 0
 		indices := make([]int, 0, len(job.calls))
@@ -236,7 +368,7 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 			indices = append(indices, call)
 			totalNewSignal += len(info.newSignal)
 		}
-		if job.stopDeflake(run, needRuns, prevTotalNewSignal == totalNewSignal) {
+		if job.stopDeflake(run, needRuns, stableRuns, maxRuns, prevTotalNewSignal == totalNewSignal) {
 			break
 		}
 		prevTotalNewSignal = totalNewSignal
@@ -245,12 +377,15 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 			ExecOpts:        setFlags(flatrpc.ExecFlagCollectCover | flatrpc.ExecFlagCollectSignal),
 			ReturnAllSignal: indices,
 			Avoid:           avoid,
+			Prefer:          prefer,
 			Stat:            job.fuzzer.statExecTriage,
 		}, progInTriage)
 		if result.Stop() {
 			return true
 		}
-		avoid = append(avoid, result.Executor)
+		if prefer == nil {
+			avoid = append(avoid, result.Executor)
+		}
 		if result.Info == nil {
 			continue // the program has failed
 		}
@@ -263,7 +398,7 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 			if info == nil || res == nil {
 				return
 			}
-			if len(info.rawCover) == 0 && job.fuzzer.Config.FetchRawCover {
+			if len(info.rawCover) == 0 && fetchRawCover {
 				info.rawCover = res.Cover
 			}
 			// Since the signal is frequently flaky, we may get some new new max signal.
@@ -273,7 +408,7 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 			// it won't be stable. However, it's still possible if we do more than needRuns runs.
 			// But also we already observed it and we know it's flaky, so at least doing
 			// cover.addRawMaxSignal for it looks useful.
-			prio := signalPrio(job.p, res, call)
+			prio := job.fuzzer.signalPrio(job.p, res, call)
 			newMaxSignal := job.fuzzer.Cover.addRawMaxSignal(res.Signal, prio)
 			info.newSignal.Merge(newMaxSignal)
 			info.cover.Merge(res.Cover)
@@ -291,7 +426,7 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 	}
 	job.info.Logf("deflake complete")
 	for call, info := range job.calls {
-		info.stableSignal = info.signals[needRuns-1]
+		info.stableSignal = info.signals[stableRuns-1]
 		info.newStableSignal = info.newSignal.Intersection(info.stableSignal)
 		job.info.Logf("call #%d [%s]: |stable signal|=%d, |new stable signal|=%d%s",
 			call, job.p.CallName(call), info.stableSignal.Len(), info.newStableSignal.Len(),
@@ -300,7 +435,7 @@ func (job *triageJob) deflake(exec func(*queue.Request, ProgFlags) *queue.Result
 	return false
 }
 
-func (job *triageJob) stopDeflake(run, needRuns int, noNewSignal bool) bool {
+func (job *triageJob) stopDeflake(run, needRuns, stableRuns, maxRuns int, noNewSignal bool) bool {
 	if job.fuzzer.Config.Snapshot {
 		return run >= needRuns+1
 	}
@@ -312,14 +447,14 @@ func (job *triageJob) stopDeflake(run, needRuns int, noNewSignal bool) bool {
 	}
 	if job.flags&ProgFromCorpus == 0 {
 		// For fuzzing programs we stop if we already have the right deflaked signal for all calls,
-		// or there's no chance to get coverage common to needRuns for all calls.
-		if run >= deflakeMaxRuns {
+		// or there's no chance to get coverage common to stableRuns for all calls.
+		if run >= maxRuns {
 			return true
 		}
 		noChance := true
 		for _, call := range job.calls {
-			if left := deflakeMaxRuns - run; left >= needRuns ||
-				call.newSignal.IntersectsWith(call.signals[needRuns-left-1]) {
+			if left := maxRuns - run; left >= stableRuns ||
+				call.newSignal.IntersectsWith(call.signals[stableRuns-left-1]) {
 				noChance = false
 			}
 		}
@@ -340,7 +475,8 @@ func (job *triageJob) stopDeflake(run, needRuns int, noNewSignal bool) bool {
 	return false
 }
 
-func (job *triageJob) minimize(call int, info *triageCall) (*prog.Prog, int) {
+func (job *triageJob) minimize(call int, info *triageCall,
+	exec func(*queue.Request, ProgFlags) *queue.Result) (*prog.Prog, int) {
 	job.info.Logf("[call #%d] minimize started", call)
 	minimizeAttempts := 3
 	if job.fuzzer.Config.Snapshot {
@@ -351,16 +487,21 @@ func (job *triageJob) minimize(call int, info *triageCall) (*prog.Prog, int) {
 	if job.fuzzer.Config.PatchTest {
 		mode = prog.MinimizeCallsOnly
 	}
+	contextSignal := job.minimizeContextSignal()
 	p, call := prog.Minimize(job.p, call, mode, func(p1 *prog.Prog, call1 int) bool {
 		if stop {
 			return false
 		}
-		var mergedSignal signal.Signal
+		returnSignal := []int{call1}
+		if contextSignal != nil {
+			returnSignal = allSignalCalls(p1)
+		}
+		var mergedSignal, mergedContext signal.Signal
 		for i := 0; i < minimizeAttempts; i++ {
-			result := job.execute(&queue.Request{
+			result := exec(&queue.Request{
 				Prog:            p1,
 				ExecOpts:        setFlags(flatrpc.ExecFlagCollectSignal),
-				ReturnAllSignal: []int{call1},
+				ReturnAllSignal: returnSignal,
 				Stat:            job.fuzzer.statExecMinimize,
 			}, 0)
 			if result.Stop() {
@@ -371,13 +512,21 @@ func (job *triageJob) minimize(call int, info *triageCall) (*prog.Prog, int) {
 				// The call was not executed or failed.
 				continue
 			}
-			thisSignal := getSignalAndCover(p1, result.Info, call1)
+			thisSignal := job.fuzzer.getSignalAndCover(p1, result.Info, call1)
 			if mergedSignal.Len() == 0 {
 				mergedSignal = thisSignal
 			} else {
 				mergedSignal.Merge(thisSignal)
 			}
-			if info.newStableSignal.Intersection(mergedSignal).Len() == info.newStableSignal.Len() {
+			if contextSignal != nil {
+				for _, call2 := range returnSignal {
+					if call2 < len(result.Info.Calls) {
+						mergedContext.Merge(job.fuzzer.getSignalAndCover(p1, result.Info, call2))
+					}
+				}
+			}
+			if containsSignal(mergedSignal, info.newStableSignal) &&
+				(contextSignal == nil || containsSignal(mergedContext, contextSignal)) {
 				job.info.Logf("[call #%d] minimization step success (|calls| = %d)",
 					call, len(p1.Calls))
 				return true
@@ -392,6 +541,39 @@ func (job *triageJob) minimize(call int, info *triageCall) (*prog.Prog, int) {
 	return p, call
 }
 
+// minimizeContextSignal 返回最小化时除被最小化的调用自身的新信号之外还必须保留的信号。
+// 高分程序保守地最小化: 所有被 triage 的调用的新稳定信号都必须保留，
+// 避免把使程序有价值的其他调用当作无关上下文删除。返回 nil 表示只检查被最小化的调用。
+func (job *triageJob) minimizeContextSignal() signal.Signal {
+	scoreConfig := job.fuzzer.scoreConfig()
+	if !scoreConfig.Enabled || scoreConfig.ConservativeMinimizeThreshold <= 0 {
+		return nil
+	}
+	score := job.fuzzer.scoring.tracker.scoreOf(job.p.Hash())
+	if score == nil || score.Total < scoreConfig.ConservativeMinimizeThreshold {
+		return nil
+	}
+	var required signal.Signal
+	for _, info := range job.calls {
+		required.Merge(info.newStableSignal)
+	}
+	return required
+}
+
+// allSignalCalls 返回程序中所有调用以及 extra (-1) 的下标
+func allSignalCalls(p *prog.Prog) []int {
+	calls := []int{-1}
+	for i := range p.Calls {
+		calls = append(calls, i)
+	}
+	return calls
+}
+
+// containsSignal 判断 s 是否包含 required 中的全部信号
+func containsSignal(s, required signal.Signal) bool {
+	return required.Intersection(s).Len() == required.Len()
+}
+
 func reexecutionSuccess(info *flatrpc.ProgInfo, oldErrno int32, call int) bool {
 	if info == nil || len(info.Calls) == 0 {
 		return false
@@ -407,7 +589,7 @@ func reexecutionSuccess(info *flatrpc.ProgInfo, oldErrno int32, call int) bool {
 	return info.Extra != nil && len(info.Extra.Signal) != 0
 }
 
-func getSignalAndCover(p *prog.Prog, info *flatrpc.ProgInfo, call int) signal.Signal {
+func (fuzzer *Fuzzer) getSignalAndCover(p *prog.Prog, info *flatrpc.ProgInfo, call int) signal.Signal {
 	inf := info.Extra
 	if call != -1 {
 		inf = info.Calls[call]
@@ -415,7 +597,7 @@ func getSignalAndCover(p *prog.Prog, info *flatrpc.ProgInfo, call int) signal.Si
 	if inf == nil {
 		return nil
 	}
-	return signal.FromRaw(inf.Signal, signalPrio(p, inf, call))
+	return signal.FromRaw(inf.Signal, fuzzer.signalPrio(p, inf, call))
 }
 
 func signalPreview(s signal.Signal) string {
@@ -449,36 +631,50 @@ func (job *smashJob) run(fuzzer *Fuzzer) {
 	job.info.Logf("\n%s", job.p.Serialize())
 
 	// 获取原始程序的评分作为基准
-	baseScore := float64(0.5) // 默认基准分数
-	if fuzzer.Config.ScoreConfig.Enabled {
-		if score := fuzzer.scoreTracker.GetScore(job.p.Hash()); score != nil {
+	baseScore := float64(neutralScore) // 默认基准分数
+	if fuzzer.scoreConfig().Enabled {
+		score := fuzzer.scoring.tracker.GetScoreByHash(job.p.Hash())
+		if score != nil {
 			baseScore = score.Total
 		}
+		job.info.setScore(baseScore, score)
 	}
 
 	// 根据评分调整迭代次数 - 高分程序进行更多变异
 	iters := 25
-	if fuzzer.Config.ScoreConfig.Enabled {
-		// 评分越高，变异次数越多 (范围: 15-50)
-		iters = int(15 + baseScore*35)
+	if fuzzer.scoreConfig().Enabled {
+		// 评分越高，变异次数越多 (范围: MinSmashIters-MaxSmashIters)
+		iters = fuzzer.scoreConfig().smashIters(baseScore)
 		fuzzer.Logf(3, "基于评分 %.3f 调整 smash 迭代次数为 %d", baseScore, iters)
 	}
 
 	rnd := fuzzer.rand()
 	successfulMutations := 0
 	totalMutations := 0
+	// 每种变异策略的 (成功变异数, 总变异数)
+	strategyMutations := make(map[smashStrategy][2]int)
+	base := smashBase{
+		p:      job.p,
+		score:  baseScore,
+		evolve: fuzzer.scoreConfig().EvolutionarySmash,
+	}
 	
 	for i := 0; i < iters; i++ {
-		p := job.p.Clone()
+		p := base.p.Clone()
 		
 		// 基于评分的智能变异策略
-		if fuzzer.Config.ScoreConfig.Enabled && baseScore > 0.7 {
+		strategy := smashStandard
+		if fuzzer.scoreConfig().Enabled {
+			strategy = fuzzer.scoreConfig().smashStrategy(baseScore)
+		}
+		switch strategy {
+		case smashConservative:
 			// 高分程序使用更保守的变异策略
 			job.conservativeMutate(p, rnd, fuzzer)
-		} else if fuzzer.Config.ScoreConfig.Enabled && baseScore < 0.3 {
+		case smashAggressive:
 			// 低分程序使用更激进的变异策略
 			job.aggressiveMutate(p, rnd, fuzzer)
-		} else {
+		default:
 			// 标准变异
 			p.Mutate(rnd, prog.RecommendedCalls,
 				fuzzer.ChoiceTable(),
@@ -495,70 +691,151 @@ func (job *smashJob) run(fuzzer *Fuzzer) {
 			return
 		}
 		
-		totalMutations++
+		job.info.Execs.Add(1)
 		
-		// 评估变异结果
-		if fuzzer.Config.ScoreConfig.Enabled {
-			mutationScore := fuzzer.calculateProgScore(&queue.Request{Prog: p}, result)
-			if mutationScore.Total > baseScore {
-				successfulMutations++
-				fuzzer.Logf(3, "成功变异: 分数从 %.3f 提升到 %.3f", baseScore, mutationScore.Total)
-				
-				// 更新加权选择器
-				fuzzer.weightedSelector.UpdateWeight(p.Hash(), mutationScore.Total)
-			}
+		// 评估变异结果: 变异体在 processResult 中已经评分，这里直接使用记录的评分，
+		// 重新评分会把同一次执行重复计入评分统计。启用 AsyncScoring 时评分可能还没有记录，
+		// 这样的变异体不计入成功率。
+		if !fuzzer.scoreConfig().Enabled {
+			continue
 		}
-		
-		job.info.Execs.Add(1)
+		mutationScore := fuzzer.scoring.tracker.scoreOf(p.Hash())
+		if mutationScore == nil {
+			continue
+		}
+		totalMutations++
+		counts := strategyMutations[strategy]
+		counts[1]++
+		if base.offer(p, mutationScore.Total) {
+			successfulMutations++
+			counts[0]++
+			fuzzer.Logf(3, "成功变异: 分数从 %.3f 提升到 %.3f", baseScore, mutationScore.Total)
+		}
+		strategyMutations[strategy] = counts
 	}
 	
 	// 记录 smash 统计信息
-	if fuzzer.Config.ScoreConfig.Enabled && totalMutations > 0 {
+	if fuzzer.scoreConfig().Enabled && totalMutations > 0 {
 		successRate := float64(successfulMutations) / float64(totalMutations)
 		fuzzer.Logf(2, "smash 完成: 基准分数=%.3f, 成功变异=%d/%d (%.1f%%)", 
 			baseScore, successfulMutations, totalMutations, successRate*100)
 		
 		// 更新评分指标
-		fuzzer.scoreMetrics.UpdateSmashStats(successfulMutations, totalMutations, baseScore)
+		fuzzer.scoring.Metrics().UpdateSmashStats(successfulMutations, totalMutations, baseScore)
+		for strategy, counts := range strategyMutations {
+			fuzzer.scoring.Metrics().UpdateSmashStrategyStats(strategy.String(), counts[0], counts[1])
+		}
+		fuzzer.smashStats.record(job.p.Hash(), successfulMutations, totalMutations)
 	}
 }
 
+// smashBase 是 smash 作业中用于变异的基准程序。
+// 默认总是从原始程序变异；进化模式下评分更高的变异体会成为新的基准 (爬山)，
+// 因此基准分数在整个作业中单调不减。
+type smashBase struct {
+	p      *prog.Prog
+	score  float64
+	evolve bool
+}
+
+// offer 报告一个变异体的评分，返回它是否优于当前基准。
+func (b *smashBase) offer(p *prog.Prog, score float64) bool {
+	if score <= b.score {
+		return false
+	}
+	if b.evolve {
+		b.p, b.score = p, score
+	}
+	return true
+}
+
+// conservativeMutateOpts 保守变异的每次变异只做一个操作: 变异参数、插入或移除调用 (概率相同)，
+// 不拼接其他程序也不把参数压缩为 ANY，保持程序结构
+var conservativeMutateOpts = prog.MutateOpts{
+	ExpectedIterations: 1,
+	MutateArgCount:     prog.DefaultMutateOpts.MutateArgCount,
+	InsertWeight:       1,
+	MutateArgWeight:    1,
+	RemoveCallWeight:   1,
+}
+
 // conservativeMutate 保守变异策略 - 用于高分程序
 func (job *smashJob) conservativeMutate(p *prog.Prog, rnd *rand.Rand, fuzzer *Fuzzer) {
-	// 较小的变异强度，保持程序结构
-	mutateOps := []func(){
-		func() { p.MutateArg(rnd, fuzzer.ChoiceTable()) },           // 只变异参数
-		func() { p.RemoveCall(rnd) },                                // 移除调用
-		func() { p.InsertCall(rnd, fuzzer.ChoiceTable()) },          // 插入调用
-	}
-	
-	// 执行1-2个变异操作
+	// 执行1-2个变异操作，每次最多插入一个调用
 	numOps := 1 + rnd.Intn(2)
-	for i := 0; i < numOps && len(p.Calls) > 0; i++ {
-		op := mutateOps[rnd.Intn(len(mutateOps))]
-		op()
+	for i := 0; i < numOps; i++ {
+		p.MutateWithOpts(rnd, min(len(p.Calls)+1, prog.MaxCalls),
+			fuzzer.ChoiceTable(),
+			fuzzer.Config.NoMutateCalls,
+			fuzzer.Config.Corpus.Programs(),
+			conservativeMutateOpts)
 	}
 }
 
 // aggressiveMutate 激进变异策略 - 用于低分程序
 func (job *smashJob) aggressiveMutate(p *prog.Prog, rnd *rand.Rand, fuzzer *Fuzzer) {
-	// 更大的变异强度，尝试更多变化
-	for i := 0; i < 2+rnd.Intn(3); i++ { // 2-4个变异操作
-		p.Mutate(rnd, prog.RecommendedCalls,
-			fuzzer.ChoiceTable(),
-			fuzzer.Config.NoMutateCalls,
-			fuzzer.Config.Corpus.Programs())
+	aggressiveMutate(p, rnd, fuzzer.scoreConfig(), aggressiveMutateOps{
+		mutate: func(p *prog.Prog) {
+			p.Mutate(rnd, prog.RecommendedCalls,
+				fuzzer.ChoiceTable(),
+				fuzzer.Config.NoMutateCalls,
+				fuzzer.Config.Corpus.Programs())
+		},
+		// 拼接语料库中的另一个程序
+		shuffle: func(p *prog.Prog) {
+			spliceMutate(p, rnd, fuzzer.ChoiceTable(), fuzzer.Config.Corpus.Programs())
+		},
+		// 拼接程序自身的副本
+		duplicate: func(p *prog.Prog) {
+			spliceMutate(p, rnd, fuzzer.ChoiceTable(), []*prog.Prog{p})
+		},
+	})
+}
+
+// spliceMutateOpts 只做拼接的变异选项
+var spliceMutateOpts = prog.MutateOpts{ExpectedIterations: 1, SpliceWeight: 1}
+
+// spliceMutate 把 donors 中随机一个程序的副本插入到 p 的随机位置，超出 prog.MaxCalls 的调用被删除。
+// 副本的资源引用只指向副本自身的调用，因此结果总是有效的程序。
+// donors 为空或 p 已经达到调用数量上限时不变异 (否则拼接永远不会成功)。
+func spliceMutate(p *prog.Prog, rnd *rand.Rand, ct *prog.ChoiceTable, donors []*prog.Prog) {
+	if len(donors) == 0 || len(p.Calls) == 0 || len(p.Calls) >= prog.MaxCalls {
+		return
 	}
-	
-	// 额外的随机操作
-	if rnd.Intn(3) == 0 {
-		// 随机重排调用顺序
-		p.ShuffleCall(rnd)
+	p.MutateWithOpts(rnd, prog.MaxCalls, ct, nil, donors, spliceMutateOpts)
+}
+
+// aggressiveMutateOps 激进变异使用的各个操作
+type aggressiveMutateOps struct {
+	mutate    func(p *prog.Prog)
+	shuffle   func(p *prog.Prog)
+	duplicate func(p *prog.Prog)
+}
+
+// aggressiveMutate 执行 [AggressiveMinOps, AggressiveMaxOps] 次变异，
+// 然后按配置的概率打乱程序结构和复制调用。每个操作之后检查程序大小，超过 prog.MaxCalls 的操作被撤销。
+func aggressiveMutate(p *prog.Prog, rnd *rand.Rand, cfg *ScoreConfig, ops aggressiveMutateOps) {
+	lo, hi := cfg.aggressiveOps()
+	for i, n := 0, lo+rnd.Intn(hi-lo+1); i < n; i++ {
+		boundedMutate(p, ops.mutate)
 	}
-	if rnd.Intn(4) == 0 {
-		// 随机复制调用
-		p.DuplicateCall(rnd)
+	if rnd.Float64() < cfg.AggressiveShuffleProb {
+		boundedMutate(p, ops.shuffle)
 	}
+	if rnd.Float64() < cfg.AggressiveDuplicateProb {
+		boundedMutate(p, ops.duplicate)
+	}
+}
+
+// boundedMutate 对程序执行 op，如果结果超过 prog.MaxCalls 则恢复原程序并返回 false。
+func boundedMutate(p *prog.Prog, op func(p *prog.Prog)) bool {
+	backup := p.Clone()
+	op(p)
+	if len(p.Calls) <= prog.MaxCalls {
+		return true
+	}
+	*p = *backup
+	return false
 }
 
 func (job *smashJob) getInfo() *JobInfo {
@@ -594,19 +871,33 @@ type faultInjectionJob struct {
 }
 
 func (job *faultInjectionJob) run(fuzzer *Fuzzer) {
+	// With scoring enabled the executions also collect signal, so that they are scored
+	// with their coverage and fault runs that reach new signal boost the original program
+	// in the selector. The runs are not triaged, only their signal is checked.
+	scoreConfig := fuzzer.scoreConfig()
+	scoring := scoreConfig != nil && scoreConfig.Enabled
+	var execOpts flatrpc.ExecOpts
+	if scoring {
+		execOpts = setFlags(flatrpc.ExecFlagCollectSignal)
+	}
+	var seen signal.Signal
 	for nth := 1; nth <= 100; nth++ {
 		fuzzer.Logf(2, "injecting fault into call %v, step %v",
 			job.call, nth)
 		newProg := job.p.Clone()
 		newProg.Calls[job.call].Props.FailNth = nth
-		result := fuzzer.execute(job.exec, &queue.Request{
-			Prog: newProg,
-			Stat: fuzzer.statExecFaultInject,
-		})
+		result := fuzzer.executeWithFlags(job.exec, &queue.Request{
+			Prog:     newProg,
+			ExecOpts: execOpts,
+			Stat:     fuzzer.statExecFaultInject,
+		}, progFaultInjection)
 		if result.Stop() {
 			return
 		}
 		info := result.Info
+		if scoring {
+			fuzzer.scoring.recordFaultInjection(job.p.Hash(), fuzzer.faultNewSignal(newProg, info, &seen))
+		}
 		if info != nil && len(info.Calls) > job.call &&
 			info.Calls[job.call].Flags&flatrpc.CallFlagFaultInjected == 0 {
 			break
@@ -614,6 +905,74 @@ func (job *faultInjectionJob) run(fuzzer *Fuzzer) {
 	}
 }
 
+// rareFaultInjectionJob 是评分驱动的故障注入: 先执行一次程序得到各调用的信号 (这次执行不评分也不 triage)，
+// 首先向触发新信号的调用 call 注入故障 (与 faultInjectionJob 相同)，
+// 然后按调用覆盖在评分系统中的稀有程度 (见 ScoreTracker.rareCallOrder) 依次向其余各调用注入故障，
+// 覆盖很少被执行到的调用的故障路径更可能还没有被探索过。
+// 找到 novelLimit 个带来新信号 (见 Fuzzer.faultNewSignal) 的执行后提前结束 (0 表示不提前结束)。
+type rareFaultInjectionJob struct {
+	exec       queue.Executor
+	p          *prog.Prog
+	call       int
+	novelLimit int
+}
+
+func (job *rareFaultInjectionJob) run(fuzzer *Fuzzer) {
+	result := fuzzer.executeWithFlags(job.exec, &queue.Request{
+		Prog:     job.p.Clone(),
+		ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
+		Stat:     fuzzer.statExecFaultInject,
+	}, progJobInternal)
+	if result.Stop() || result.Info == nil {
+		return
+	}
+	callSignals := make([][]uint64, len(job.p.Calls))
+	for call, info := range result.Info.Calls {
+		if info != nil && call < len(callSignals) {
+			callSignals[call] = info.Signal
+		}
+	}
+	order := []int{job.call}
+	for _, call := range fuzzer.scoring.tracker.rareCallOrder(callSignals) {
+		if call != job.call {
+			order = append(order, call)
+		}
+	}
+	var seen signal.Signal
+	novel := 0
+	for _, call := range order {
+		for nth := 1; nth <= 100; nth++ {
+			fuzzer.Logf(2, "injecting fault into call %v, step %v",
+				call, nth)
+			newProg := job.p.Clone()
+			newProg.Calls[call].Props.FailNth = nth
+			result := fuzzer.executeWithFlags(job.exec, &queue.Request{
+				Prog:     newProg,
+				ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
+				Stat:     fuzzer.statExecFaultInject,
+			}, progFaultInjection)
+			if result.Stop() {
+				return
+			}
+			info := result.Info
+			newSignal := fuzzer.faultNewSignal(newProg, info, &seen)
+			fuzzer.scoring.recordFaultInjection(job.p.Hash(), newSignal)
+			if info == nil {
+				continue
+			}
+			if newSignal {
+				novel++
+				if job.novelLimit > 0 && novel >= job.novelLimit {
+					return
+				}
+			}
+			if len(info.Calls) > call && info.Calls[call].Flags&flatrpc.CallFlagFaultInjected == 0 {
+				break
+			}
+		}
+	}
+}
+
 type hintsJob struct {
 	exec queue.Executor
 	p    *prog.Prog
@@ -659,14 +1018,16 @@ func (job *hintsJob) run(fuzzer *Fuzzer) {
 	// Then mutate the initial program for every match between
 	// a syscall argument and a comparison operand.
 	// Execute each of such mutants to check if it gives new coverage.
+	// Mutants that do are marked in the score tracker (see progHint),
+	// so the hints limiter above also bounds how many of them get tracked.
 	p.MutateWithHints(job.call, comps,
 		func(p *prog.Prog) bool {
 			defer job.info.Execs.Add(1)
-			result := fuzzer.execute(job.exec, &queue.Request{
+			result := fuzzer.executeWithFlags(job.exec, &queue.Request{
 				Prog:     p,
 				ExecOpts: setFlags(flatrpc.ExecFlagCollectSignal),
 				Stat:     fuzzer.statExecHint,
-			})
+			}, progHint)
 			return !result.Stop()
 		})
 }
@@ -0,0 +1,147 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// jobLimiter 限制同时运行的作业数量，并在不同作业类型之间公平分配槽位。
+// 每种类型有独立的 FIFO 等待队列，槽位释放时按类型轮询调度，
+// 因此大量 triage 作业不会让 smash/hints 作业饿死 (反之亦然)。
+type jobLimiter struct {
+	mu sync.Mutex
+
+	// 同时运行的作业总数上限 (<= 0 表示不限制)
+	limit int
+	// 每种类型同时运行的作业上限 (0 或缺失表示仅受 limit 限制)
+	quotas map[string]int
+
+	total   int
+	running map[string]int
+	waiting map[string][]chan struct{}
+
+	// 轮询顺序 (按类型首次出现的顺序) 以及下一次从哪个类型开始
+	types []string
+	next  int
+}
+
+func newJobLimiter(limit int, quotas map[string]int) *jobLimiter {
+	return &jobLimiter{
+		limit:   limit,
+		quotas:  quotas,
+		running: make(map[string]int),
+		waiting: make(map[string][]chan struct{}),
+	}
+}
+
+// acquire 阻塞直到给定类型的作业获得运行槽位。
+// 如果在此之前 ctx 被取消，返回 false，调用方不应再运行该作业。
+func (l *jobLimiter) acquire(ctx context.Context, typ string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	l.addTypeLocked(typ)
+	if len(l.waiting[typ]) == 0 && l.canRunLocked(typ) {
+		l.running[typ]++
+		l.total++
+		l.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	l.waiting[typ] = append(l.waiting[typ], ch)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiting[typ] {
+		if w == ch {
+			l.waiting[typ] = append(l.waiting[typ][:i], l.waiting[typ][i+1:]...)
+			return false
+		}
+	}
+	// 取消的同时已经被调度，作业不会再运行，归还槽位。
+	l.releaseLocked(typ)
+	return false
+}
+
+// release 归还由 acquire 获得的槽位。
+func (l *jobLimiter) release(typ string) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(typ)
+}
+
+func (l *jobLimiter) releaseLocked(typ string) {
+	l.running[typ]--
+	l.total--
+	l.dispatchLocked()
+}
+
+func (l *jobLimiter) addTypeLocked(typ string) {
+	if _, ok := l.running[typ]; ok {
+		return
+	}
+	l.running[typ] = 0
+	l.types = append(l.types, typ)
+}
+
+func (l *jobLimiter) canRunLocked(typ string) bool {
+	if l.total >= l.limit {
+		return false
+	}
+	quota := l.quotas[typ]
+	return quota <= 0 || l.running[typ] < quota
+}
+
+// dispatchLocked 把空闲槽位按类型轮询分配给等待中的作业。
+func (l *jobLimiter) dispatchLocked() {
+	for l.total < l.limit {
+		granted := false
+		for i := range l.types {
+			idx := (l.next + i) % len(l.types)
+			typ := l.types[idx]
+			if len(l.waiting[typ]) == 0 || !l.canRunLocked(typ) {
+				continue
+			}
+			ch := l.waiting[typ][0]
+			l.waiting[typ] = l.waiting[typ][1:]
+			l.running[typ]++
+			l.total++
+			close(ch)
+			l.next = (idx + 1) % len(l.types)
+			granted = true
+			break
+		}
+		if !granted {
+			return
+		}
+	}
+}
+
+func jobType(j job) string {
+	switch j.(type) {
+	case *triageJob:
+		return "triage"
+	case *smashJob:
+		return "smash"
+	case *hintsJob:
+		return "hints"
+	case *faultInjectionJob, *rareFaultInjectionJob:
+		return "fault"
+	}
+	return fmt.Sprintf("%T", j)
+}
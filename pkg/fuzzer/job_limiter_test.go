@@ -0,0 +1,93 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobLimiterFairness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := newJobLimiter(2, nil)
+	assert.True(t, l.acquire(ctx, "triage"))
+	assert.True(t, l.acquire(ctx, "triage"))
+
+	// 大量 triage 作业在排队。
+	const flood = 100
+	for i := 0; i < flood; i++ {
+		go l.acquire(ctx, "triage")
+	}
+	waitLimiter(l, func() bool { return len(l.waiting["triage"]) == flood })
+
+	// 之后到达的 smash 作业不应排在所有 triage 作业之后。
+	go l.acquire(ctx, "smash")
+	waitLimiter(l, func() bool { return len(l.waiting["smash"]) == 1 })
+
+	const maxReleases = 2
+	scheduled := false
+	for i := 0; i < maxReleases && !scheduled; i++ {
+		l.release("triage")
+		l.mu.Lock()
+		scheduled = l.running["smash"] == 1
+		l.mu.Unlock()
+	}
+	assert.True(t, scheduled, "smash job was not scheduled after %v releases", maxReleases)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	assert.Equal(t, 2, l.total)
+}
+
+func TestJobLimiterQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := newJobLimiter(3, map[string]int{"triage": 1})
+	assert.True(t, l.acquire(ctx, "triage"))
+	go l.acquire(ctx, "triage")
+	waitLimiter(l, func() bool { return len(l.waiting["triage"]) == 1 })
+
+	// triage 的配额已用完，但其他类型仍可使用剩余的槽位。
+	assert.True(t, l.acquire(ctx, "smash"))
+	assert.True(t, l.acquire(ctx, "hints"))
+
+	l.release("triage")
+	l.mu.Lock()
+	assert.Equal(t, 1, l.running["triage"])
+	assert.Equal(t, 0, len(l.waiting["triage"]))
+	l.mu.Unlock()
+}
+
+func TestJobLimiterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := newJobLimiter(1, nil)
+	assert.True(t, l.acquire(ctx, "smash"))
+	done := make(chan bool)
+	go func() {
+		done <- l.acquire(ctx, "smash")
+	}()
+	waitLimiter(l, func() bool { return len(l.waiting["smash"]) == 1 })
+	cancel()
+	assert.False(t, <-done)
+	l.release("smash")
+	assert.Equal(t, 0, l.total)
+}
+
+func waitLimiter(l *jobLimiter, cond func() bool) {
+	for {
+		l.mu.Lock()
+		ok := cond()
+		l.mu.Unlock()
+		if ok {
+			return
+		}
+		runtime.Gosched()
+	}
+}
@@ -4,13 +4,21 @@
 package fuzzer
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/syzkaller/pkg/corpus"
 	"github.com/google/syzkaller/pkg/cover"
 	"github.com/google/syzkaller/pkg/flatrpc"
 	"github.com/google/syzkaller/pkg/fuzzer/queue"
 	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
 	"github.com/google/syzkaller/prog"
 	"github.com/google/syzkaller/sys/targets"
 	"github.com/stretchr/testify/assert"
@@ -121,3 +129,794 @@ func TestDeflake(t *testing.T) {
 		})
 	}
 }
+
+func TestDeflakeStableRuns(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	const anyTestProg = `syz_compare(&AUTO="00000000", 0x4, &AUTO=@conditional={0x0, @void, @void, @void}, AUTO)`
+	p, err := target.Deserialize([]byte(anyTestProg), prog.NonStrict)
+	assert.NoError(t, err)
+
+	deflake := func(stableRuns int) *triageCall {
+		// The initial run has the same priority as the deflake runs.
+		prio := signalPrio(p, &flatrpc.CallInfo{}, 0)
+		info := &triageCall{newSignal: signal.FromRaw([]uint64{1, 2}, prio)}
+		info.signals[0] = info.newSignal.Copy()
+		job := &triageJob{
+			p:     p,
+			calls: map[int]*triageCall{0: info},
+			fuzzer: &Fuzzer{
+				Cover:  newCover(),
+				Config: &Config{DeflakeStableRuns: stableRuns},
+			},
+			info: &JobInfo{},
+		}
+		var run int
+		stop := job.deflake(func(_ *queue.Request, _ ProgFlags) *queue.Result {
+			run++
+			// Signal 2 is flaky: it's seen only in the initial run and in the second run.
+			raw := []uint64{1}
+			if run == 2 {
+				raw = append(raw, 2)
+			}
+			return &queue.Result{
+				Info: &flatrpc.ProgInfo{
+					Calls: []*flatrpc.CallInfo{{Signal: raw}},
+				},
+			}
+		})
+		assert.False(t, stop)
+		assert.Equal(t, 2, run)
+		return info
+	}
+
+	// By default the signal must be seen in 3 runs.
+	info := deflake(0)
+	assert.ElementsMatch(t, []uint64{1}, info.stableSignal.ToRaw())
+	assert.ElementsMatch(t, []uint64{1}, info.newStableSignal.ToRaw())
+	// 2-of-n keeps the flaky signal.
+	info = deflake(2)
+	assert.ElementsMatch(t, []uint64{1, 2}, info.stableSignal.ToRaw())
+	assert.ElementsMatch(t, []uint64{1, 2}, info.newStableSignal.ToRaw())
+	// Values above the default are clamped.
+	info = deflake(10)
+	assert.ElementsMatch(t, []uint64{1}, info.stableSignal.ToRaw())
+}
+
+func TestDeflakeOriginalExecutor(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	const anyTestProg = `syz_compare(&AUTO="00000000", 0x4, &AUTO=@conditional={0x0, @void, @void, @void}, AUTO)`
+	p, err := target.Deserialize([]byte(anyTestProg), prog.NonStrict)
+	assert.NoError(t, err)
+
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.DeflakeOriginalExecutorThreshold = 0.5
+	fuzzer := &Fuzzer{
+		Cover:   newCover(),
+		Config:  &Config{ScoreConfig: scoreConfig},
+		scoring: newScoring(scoreConfig),
+	}
+	original := queue.ExecutorID{VM: 3, Proc: 1}
+	deflake := func(total float64) []*queue.Request {
+		st := fuzzer.scoring.tracker
+		st.mu.Lock()
+		st.scores[p.Hash()] = &ProgScore{Total: total}
+		st.touchLocked(p.Hash())
+		st.mu.Unlock()
+		// The initial run has the same priority as the deflake runs.
+		prio := signalPrio(p, &flatrpc.CallInfo{}, 0)
+		info := &triageCall{newSignal: signal.FromRaw([]uint64{1, 2}, prio)}
+		info.signals[0] = info.newSignal.Copy()
+		job := &triageJob{
+			p:        p,
+			executor: original,
+			calls:    map[int]*triageCall{0: info},
+			fuzzer:   fuzzer,
+			info:     &JobInfo{},
+		}
+		var reqs []*queue.Request
+		vm := 0
+		stop := job.deflake(func(req *queue.Request, _ ProgFlags) *queue.Result {
+			reqs = append(reqs, req)
+			vm++
+			return &queue.Result{
+				Executor: queue.ExecutorID{VM: vm},
+				Info: &flatrpc.ProgInfo{
+					Calls: []*flatrpc.CallInfo{{Signal: []uint64{1, 2}}},
+				},
+			}
+		})
+		assert.False(t, stop)
+		assert.NotEmpty(t, reqs)
+		return reqs
+	}
+
+	// By default deflake runs are spread across VMs.
+	for _, req := range deflake(0.1) {
+		assert.Contains(t, req.Avoid, original)
+		assert.Empty(t, req.Prefer)
+	}
+	// High-score programs are re-run on the original executor.
+	for _, req := range deflake(0.9) {
+		assert.Empty(t, req.Avoid)
+		assert.Equal(t, []queue.ExecutorID{original}, req.Prefer)
+	}
+}
+
+func TestDeflakeScoredRuns(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	const anyTestProg = `syz_compare(&AUTO="00000000", 0x4, &AUTO=@conditional={0x0, @void, @void, @void}, AUTO)`
+	p, err := target.Deserialize([]byte(anyTestProg), prog.NonStrict)
+	assert.NoError(t, err)
+
+	scoreConfig := DefaultScoreConfig()
+	fuzzer := &Fuzzer{
+		Cover:   newCover(),
+		Config:  &Config{ScoreConfig: scoreConfig},
+		scoring: newScoring(scoreConfig),
+	}
+	deflake := func(flags ProgFlags, total float64) int {
+		st := fuzzer.scoring.tracker
+		st.mu.Lock()
+		st.scores[p.Hash()] = &ProgScore{Total: total}
+		st.touchLocked(p.Hash())
+		st.mu.Unlock()
+		// The new signal was seen only in the initial run and never reproduces.
+		info := &triageCall{newSignal: signal.FromRaw([]uint64{2}, 0)}
+		info.signals[0] = info.newSignal.Copy()
+		job := &triageJob{
+			p:      p,
+			flags:  flags,
+			calls:  map[int]*triageCall{0: info},
+			fuzzer: fuzzer,
+			info:   &JobInfo{},
+		}
+		runs := 0
+		stop := job.deflake(func(_ *queue.Request, _ ProgFlags) *queue.Result {
+			runs++
+			return &queue.Result{
+				Info: &flatrpc.ProgInfo{
+					Calls: []*flatrpc.CallInfo{{}},
+				},
+			}
+		})
+		assert.False(t, stop)
+		assert.Empty(t, info.newStableSignal)
+		return runs
+	}
+
+	low, neutral, high := deflake(0, 0.05), deflake(0, neutralScore), deflake(0, 0.95)
+	assert.Less(t, low, neutral)
+	assert.Less(t, neutral, high)
+	// Programs without a score and with scoring disabled use the default number of runs.
+	fuzzer.scoring.tracker.InvalidateProgram(p.Hash())
+	assert.Equal(t, neutral, deflake(0, neutralScore))
+	scoreConfig.Enabled = false
+	assert.Equal(t, neutral, deflake(0, 0.95))
+	scoreConfig.Enabled = true
+	// Corpus triage is not affected by the score.
+	assert.Equal(t, deflake(ProgFromCorpus, 0.05), deflake(ProgFromCorpus, 0.95))
+}
+
+func TestCustomSignalPrio(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	const anyTestProg = `syz_compare(&AUTO="00000000", 0x4, &AUTO=@conditional={0x0, @void, @void, @void}, AUTO)`
+	p, err := target.Deserialize([]byte(anyTestProg), prog.NonStrict)
+	assert.NoError(t, err)
+
+	succeeded := &flatrpc.CallInfo{Signal: []uint64{1, 2}}
+	failed := &flatrpc.CallInfo{Error: 1, Signal: []uint64{1, 2}}
+	newSignal := func(prio func(*prog.Prog, *flatrpc.CallInfo, int) uint8) int {
+		fuzzer := &Fuzzer{
+			Cover: newCover(),
+			Config: &Config{
+				NewInputFilter: func(string) bool { return true },
+				SignalPrio:     prio,
+			},
+		}
+		var triage map[int]*triageCall
+		fuzzer.triageProgCall(p, succeeded, 0, &triage)
+		triage = nil
+		fuzzer.triageProgCall(p, failed, 0, &triage)
+		if triage == nil {
+			return 0
+		}
+		return triage[0].newSignal.Len()
+	}
+
+	// By default signal of successful calls has higher priority,
+	// so the same signal from a failed call is not new.
+	assert.Equal(t, 0, newSignal(nil))
+	// If failed calls are prioritized, their signal wins during merging.
+	assert.Equal(t, 2, newSignal(func(_ *prog.Prog, info *flatrpc.CallInfo, _ int) uint8 {
+		if info.Error != 0 {
+			return 1
+		}
+		return 0
+	}))
+}
+
+func TestEvolutionarySmashBase(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	original := &prog.Prog{}
+	evolving := &smashBase{p: original, score: 0.5, evolve: true}
+	fixed := &smashBase{p: original, score: 0.5}
+	for i := 0; i < 100; i++ {
+		mutant := &prog.Prog{}
+		score := rnd.Float64()
+		prevScore := evolving.score
+		improved := evolving.offer(mutant, score)
+		assert.Equal(t, score > prevScore, improved)
+		assert.GreaterOrEqual(t, evolving.score, prevScore)
+		if improved {
+			assert.Same(t, mutant, evolving.p)
+		}
+		// By default the job keeps mutating the original program.
+		fixed.offer(mutant, score)
+		assert.Same(t, original, fixed.p)
+		assert.Equal(t, 0.5, fixed.score)
+	}
+}
+
+func TestTriageCallOrder(t *testing.T) {
+	newCall := func(signalLen int) *triageCall {
+		var raw []uint64
+		for i := 0; i < signalLen; i++ {
+			raw = append(raw, uint64(i))
+		}
+		return &triageCall{newStableSignal: signal.FromRaw(raw, 0)}
+	}
+	job := &triageJob{
+		calls: map[int]*triageCall{
+			-1: newCall(1),
+			0:  newCall(2),
+			1:  newCall(10),
+			2:  newCall(5),
+			3:  newCall(2),
+		},
+	}
+	order := job.callOrder()
+	assert.Equal(t, []int{1, 2, 0, 3, -1}, order)
+
+	// With a concurrency cap of 1 the most promising call is handled first.
+	var handled []int
+	runOrdered(order, 1, func(call int) {
+		handled = append(handled, call)
+	})
+	assert.Equal(t, order, handled)
+
+	// The cap is respected.
+	const limit = 2
+	var running, maxRunning atomic.Int32
+	var mu sync.Mutex
+	runOrdered(order, limit, func(call int) {
+		cur := running.Add(1)
+		mu.Lock()
+		maxRunning.Store(max(maxRunning.Load(), cur))
+		mu.Unlock()
+		runtime.Gosched()
+		running.Add(-1)
+	})
+	assert.LessOrEqual(t, maxRunning.Load(), int32(limit))
+}
+
+func TestTriageCallPoolManyCalls(t *testing.T) {
+	// A wide program where every call has new signal.
+	const calls = 200
+	const limit = 4
+	order := make([]int, calls)
+	for i := range order {
+		order[i] = i
+	}
+	var running, maxRunning atomic.Int32
+	var mu sync.Mutex
+	handled := make(map[int]int)
+	// The first calls block until the pool is full, so the cap is reached deterministically.
+	full := make(chan struct{})
+	var fullOnce sync.Once
+	runOrdered(order, limit, func(call int) {
+		cur := running.Add(1)
+		mu.Lock()
+		maxRunning.Store(max(maxRunning.Load(), cur))
+		mu.Unlock()
+		if cur == limit {
+			fullOnce.Do(func() { close(full) })
+		}
+		<-full
+		runtime.Gosched()
+		running.Add(-1)
+		mu.Lock()
+		handled[call]++
+		mu.Unlock()
+	})
+	// runOrdered returns only after all calls are handled, each exactly once.
+	assert.Equal(t, int32(0), running.Load())
+	assert.Equal(t, int32(limit), maxRunning.Load())
+	assert.Len(t, handled, calls)
+	for call, n := range handled {
+		assert.Equal(t, 1, n, "call %v", call)
+	}
+}
+
+func TestAggressiveMutateBounds(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	rnd := rand.New(testutil.RandSource(t))
+	ct := target.DefaultChoiceTable()
+
+	cfg := DefaultScoreConfig()
+	cfg.AggressiveMinOps, cfg.AggressiveMaxOps = 3, 3
+	for _, prob := range []float64{0, 1} {
+		cfg.AggressiveShuffleProb, cfg.AggressiveDuplicateProb = prob, prob
+		for i := 0; i < 20; i++ {
+			p := target.Generate(rnd, prog.RecommendedCalls, ct)
+			donor := target.Generate(rnd, prog.RecommendedCalls, ct)
+			var mutates, shuffles, duplicates int
+			aggressiveMutate(p, rnd, cfg, aggressiveMutateOps{
+				mutate: func(p *prog.Prog) {
+					mutates++
+					p.Mutate(rnd, prog.RecommendedCalls, ct, nil, nil)
+				},
+				shuffle: func(p *prog.Prog) {
+					shuffles++
+					spliceMutate(p, rnd, ct, []*prog.Prog{donor})
+				},
+				// Splicing a copy of the program into itself is cut at prog.MaxCalls.
+				duplicate: func(p *prog.Prog) {
+					duplicates++
+					spliceMutate(p, rnd, ct, []*prog.Prog{p})
+				},
+			})
+			assert.Equal(t, 3, mutates)
+			assert.Equal(t, int(prob), shuffles)
+			assert.Equal(t, int(prob), duplicates)
+			assert.LessOrEqual(t, len(p.Calls), prog.MaxCalls)
+			// The result must still be a valid program.
+			p.Clone()
+		}
+	}
+}
+
+func TestMinimizedProgInheritsScore(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	rnd := rand.New(testutil.RandSource(t))
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	minimized := p.Clone()
+	minimized.RemoveCall(len(minimized.Calls) - 1)
+	assert.NotEqual(t, p.Hash(), minimized.Hash())
+
+	fuzzer := &Fuzzer{
+		Config:  &Config{ScoreConfig: DefaultScoreConfig()},
+		scoring: newScoring(DefaultScoreConfig()),
+	}
+	score := fuzzer.scoring.Score(p, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	})
+	assert.NotNil(t, score)
+	assert.Nil(t, fuzzer.scoring.tracker.scoreOf(minimized.Hash()))
+
+	job := &triageJob{fuzzer: fuzzer, p: p}
+	assert.Equal(t, score.Total, job.corpusScore(minimized))
+	inherited := fuzzer.scoring.tracker.scoreOf(minimized.Hash())
+	assert.NotNil(t, inherited)
+	assert.Equal(t, score.Total, inherited.Total)
+
+	// A program that was scored on its own keeps its own score.
+	own := fuzzer.scoring.Score(minimized, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{4}, 0),
+		ExecTime: 1000000,
+	})
+	assert.Equal(t, own.Total, job.corpusScore(minimized))
+}
+
+func TestConservativeMinimize(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	rnd := rand.New(testutil.RandSource(t))
+	// Each call produces a signal identifying its syscall.
+	callSignal := func(c *prog.Call) []uint64 {
+		return []uint64{uint64(c.Meta.ID) + 1}
+	}
+	var p *prog.Prog
+	var calls map[int]*triageCall
+	for p == nil {
+		p = target.Generate(rnd, 10, target.DefaultChoiceTable())
+		last := len(p.Calls) - 1
+		calls = map[int]*triageCall{
+			last: {newStableSignal: signal.FromRaw(callSignal(p.Calls[last]), 0)},
+		}
+		for i, c := range p.Calls[:last] {
+			if c.Meta != p.Calls[last].Meta {
+				calls[i] = &triageCall{newStableSignal: signal.FromRaw(callSignal(c), 0)}
+			}
+		}
+		if len(calls) == 1 {
+			p = nil
+		}
+	}
+	last := len(p.Calls) - 1
+
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.ConservativeMinimizeThreshold = 0.5
+	fuzzer := &Fuzzer{
+		Config:  &Config{ScoreConfig: scoreConfig},
+		scoring: newScoring(scoreConfig),
+	}
+	exec := func(req *queue.Request, _ ProgFlags) *queue.Result {
+		info := &flatrpc.ProgInfo{}
+		for _, c := range req.Prog.Calls {
+			info.Calls = append(info.Calls, &flatrpc.CallInfo{Signal: callSignal(c)})
+		}
+		return &queue.Result{Info: info}
+	}
+	minimizedCalls := func(total float64) int {
+		st := fuzzer.scoring.tracker
+		st.mu.Lock()
+		st.scores[p.Hash()] = &ProgScore{Total: total}
+		st.touchLocked(p.Hash())
+		st.mu.Unlock()
+		job := &triageJob{
+			p:      p,
+			calls:  calls,
+			fuzzer: fuzzer,
+			info:   &JobInfo{},
+		}
+		minimized, _ := job.minimize(last, calls[last], exec)
+		assert.NotNil(t, minimized)
+		return len(minimized.Calls)
+	}
+	low := minimizedCalls(0.1)
+	high := minimizedCalls(0.9)
+	assert.Greater(t, high, low)
+	assert.LessOrEqual(t, high, len(p.Calls))
+}
+
+// countingExecutor completes every request immediately with an empty result.
+type countingExecutor struct {
+	submitted int
+}
+
+func (ce *countingExecutor) Submit(req *queue.Request) {
+	ce.submitted++
+	req.Done(&queue.Result{Status: queue.Success, Info: &flatrpc.ProgInfo{}})
+}
+
+func TestSmashItersConfig(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.MinSmashIters, scoreConfig.MaxSmashIters = 10, 10
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	smash := func(total float64) int {
+		st := fuzzer.scoring.tracker
+		st.mu.Lock()
+		st.scores[p.Hash()] = &ProgScore{Total: total}
+		st.touchLocked(p.Hash())
+		st.mu.Unlock()
+		exec := &countingExecutor{}
+		job := &smashJob{exec: exec, p: p.Clone(), info: &JobInfo{}}
+		job.run(fuzzer)
+		return exec.submitted
+	}
+	// The number of executions doesn't depend on the base score (and the mutation strategy).
+	for _, total := range []float64{0, 0.1, 0.5, 0.9, 1} {
+		assert.Equal(t, 10, smash(total), "base score %v", total)
+	}
+	// Without scoring smash keeps the flat number of iterations.
+	scoreConfig.Enabled = false
+	assert.Equal(t, 25, smash(0.9))
+}
+
+// stubExecutor completes every request immediately with the result of the callback.
+type stubExecutor func(req *queue.Request) *queue.Result
+
+func (se stubExecutor) Submit(req *queue.Request) {
+	req.Done(se(req))
+}
+
+func TestRareFaultInjectionOrder(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:         corpus.NewCorpus(ctx),
+		FaultInjection: true,
+	}, rnd, target)
+	var p *prog.Prog
+	for p == nil || len(p.Calls) < 3 {
+		p = target.Generate(rnd, 3, target.DefaultChoiceTable())
+	}
+	p.Calls = p.Calls[:3]
+	// Call 1 covers a rarely seen PC, call 2 a less common one than call 0.
+	hits := []int64{1000, 0, 10}
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	for call, n := range hits {
+		st.pcHitCounts[uint64(call+1)] = n
+	}
+	st.mu.Unlock()
+
+	var faulted []int
+	exec := stubExecutor(func(req *queue.Request) *queue.Result {
+		info := &flatrpc.ProgInfo{}
+		for i, c := range req.Prog.Calls {
+			call := &flatrpc.CallInfo{Signal: []uint64{uint64(i + 1)}}
+			if c.Props.FailNth > 0 {
+				faulted = append(faulted, i)
+				// The first fault injection succeeds, the second call has no more faults.
+				if c.Props.FailNth == 1 {
+					call.Flags |= flatrpc.CallFlagFaultInjected
+				}
+			}
+			info.Calls = append(info.Calls, call)
+		}
+		return &queue.Result{Status: queue.Success, Info: info}
+	})
+	// The triggering call goes first, the rest follow in the rarity order.
+	job := &rareFaultInjectionJob{exec: exec, p: p, call: 2}
+	job.run(fuzzer)
+	assert.Equal(t, []int{2, 2, 1, 1, 0, 0}, faulted)
+	// The clean run that collects the call signals is neither scored nor triaged.
+	assert.Nil(t, st.scoreOf(p.Hash()))
+	assert.Zero(t, fuzzer.statJobsTriage.Val())
+
+	// Fault injected executions with new signal stop the job early.
+	// Signal that is already in the max signal (the program's own signal after triage,
+	// and the signal of the first fault) is not new.
+	fuzzer.Cover.addRawMaxSignal([]uint64{1, 2, 3, 1001}, 3)
+	novel := 0
+	faulted = nil
+	exec = stubExecutor(func(req *queue.Request) *queue.Result {
+		info := &flatrpc.ProgInfo{}
+		for i, c := range req.Prog.Calls {
+			call := &flatrpc.CallInfo{Signal: []uint64{uint64(i + 1)}}
+			if c.Props.FailNth > 0 {
+				faulted = append(faulted, i)
+				novel++
+				call.Signal = append(call.Signal, uint64(1000+novel))
+				call.Flags |= flatrpc.CallFlagFaultInjected
+			}
+			info.Calls = append(info.Calls, call)
+		}
+		return &queue.Result{Status: queue.Success, Info: info}
+	})
+	job = &rareFaultInjectionJob{exec: exec, p: p, call: 1, novelLimit: 3}
+	job.run(fuzzer)
+	assert.Equal(t, []int{1, 1, 1, 1}, faulted)
+}
+
+func TestFaultInjectionScoring(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:         corpus.NewCorpus(ctx),
+		FaultInjection: true,
+		ScoreConfig:    DefaultScoreConfig(),
+	}, rnd, target)
+	p := target.Generate(rnd, 3, target.DefaultChoiceTable())
+	variant := func(nth int) string {
+		faulted := p.Clone()
+		faulted.Calls[0].Props.FailNth = nth
+		return faulted.Hash()
+	}
+	fuzzer.scoring.Score(p, &ExecutionResult{
+		Signal: signal.FromRaw([]uint64{1, 2}, 0),
+	})
+	fuzzer.scoring.markInCorpus(p.Hash())
+	weight := func(hash string) float64 {
+		fuzzer.scoring.selector.mu.RLock()
+		defer fuzzer.scoring.selector.mu.RUnlock()
+		return fuzzer.scoring.selector.weights[hash]
+	}
+
+	// The first run brings new signal, the second one repeats it, the third one
+	// reaches a new error path and is the last one with an injected fault.
+	var runs []int
+	exec := stubExecutor(func(req *queue.Request) *queue.Result {
+		nth := req.Prog.Calls[0].Props.FailNth
+		runs = append(runs, nth)
+		call := &flatrpc.CallInfo{Signal: []uint64{1, 2}, Flags: flatrpc.CallFlagFaultInjected}
+		if nth == 3 {
+			call.Signal = append(call.Signal, 3)
+			call.Flags = 0
+		}
+		return &queue.Result{Status: queue.Success, Info: &flatrpc.ProgInfo{
+			Elapsed: 1000000,
+			Calls:   []*flatrpc.CallInfo{call},
+		}}
+	})
+	job := &faultInjectionJob{exec: exec, p: p.Clone(), call: 0}
+	job.run(fuzzer)
+	assert.Equal(t, []int{1, 2, 3}, runs)
+
+	metrics := fuzzer.GetScoreMetrics().Snapshot()
+	assert.Equal(t, int64(3), metrics.FaultInjectionExecs)
+	assert.Equal(t, int64(2), metrics.FaultInjectionNewSignal)
+	assert.Equal(t, int64(4), metrics.TotalRequests)
+	// The runs are scored, but neither triaged nor added to the max signal.
+	assert.Zero(t, fuzzer.statJobsTriage.Val())
+	assert.True(t, fuzzer.Cover.CopyMaxSignal().Empty())
+
+	// New signal boosts the original program, not the fault-injected variants.
+	st := fuzzer.scoring.tracker
+	orig, faulted := st.GetScoreByHash(p.Hash()), st.GetScoreByHash(variant(3))
+	if !assert.NotNil(t, orig) || !assert.NotNil(t, faulted) {
+		return
+	}
+	assert.True(t, orig.FaultNewSignal)
+	assert.False(t, faulted.FaultNewSignal)
+	assert.Equal(t, orig.Total*faultNewSignalBoost, weight(p.Hash()))
+	// The variants are not in the corpus and get no selector weight.
+	assert.Zero(t, weight(variant(3)))
+	// Fault-injected executions don't update the normal execution time baseline.
+	_, _, samples := st.execTimeStats.GetStats()
+	assert.Zero(t, samples)
+}
+
+func TestSmashDedup(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.SmashDedupScoreDelta = 0.05
+	scoreConfig.SmashDedupWindow = 10 * time.Minute
+	scoreConfig.SmashCooldown = time.Minute
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rand.New(testutil.RandSource(t)), target)
+	// The fuzzer uses its rand in background goroutines, generate programs with a separate one.
+	rnd := rand.New(testutil.RandSource(t))
+	smashed := func(p *prog.Prog, raw, stable []uint64) bool {
+		fuzzer.smashStats.mu.Lock()
+		var lastStart time.Time
+		if h := fuzzer.smashStats.progs[p.Hash()]; h != nil {
+			lastStart = h.lastStart
+		}
+		fuzzer.smashStats.mu.Unlock()
+		job := &triageJob{
+			p:      p,
+			fuzzer: fuzzer,
+			flags:  ProgMinimized,
+			info:   &JobInfo{},
+		}
+		job.handleCall(0, &triageCall{
+			newSignal:       signal.FromRaw(raw, 0),
+			stableSignal:    signal.FromRaw(stable, 0),
+			newStableSignal: signal.FromRaw(stable, 0),
+		})
+		// Smash jobs are started only after the cooldown check records the program.
+		fuzzer.smashStats.mu.Lock()
+		defer fuzzer.smashStats.mu.Unlock()
+		h := fuzzer.smashStats.progs[p.Hash()]
+		return h != nil && h.lastStart != lastStart
+	}
+	generate := func() *prog.Prog {
+		return target.Generate(rnd, 3, target.DefaultChoiceTable())
+	}
+	assert.True(t, smashed(generate(), []uint64{1, 2, 3, 100}, []uint64{1, 2, 3}))
+	// The same stable signal is not smashed again, even if the raw signal differs.
+	assert.False(t, smashed(generate(), []uint64{1, 2, 3, 200}, []uint64{1, 2, 3}))
+	// A different stable signal is a genuinely new input.
+	p := generate()
+	assert.True(t, smashed(p, []uint64{1, 2, 3, 4}, []uint64{1, 2, 3, 4}))
+	// A program skipped because of the cooldown doesn't record its stable signal.
+	assert.False(t, smashed(p, []uint64{5, 6}, []uint64{5, 6}))
+	assert.True(t, smashed(generate(), []uint64{5, 6}, []uint64{5, 6}))
+
+	// Neither the dedup nor the cooldown is enabled by default.
+	fuzzer.UpdateScoreConfig(DefaultScoreConfig())
+	assert.True(t, smashed(p, []uint64{1, 2, 3}, []uint64{1, 2, 3}))
+	assert.True(t, smashed(p, []uint64{1, 2, 3}, []uint64{1, 2, 3}))
+}
+
+func TestSmashJobInfoScore(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.MinSmashIters, scoreConfig.MaxSmashIters = 1, 1
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	var stored *ProgScore
+	smash := func() (float64, *ProgScore) {
+		job := &smashJob{p: p.Clone(), info: &JobInfo{}}
+		var score float64
+		var breakdown *ProgScore
+		job.exec = stubExecutor(func(req *queue.Request) *queue.Result {
+			// Look at the info while the job is still running.
+			score, breakdown = job.info.Score(), job.info.ScoreBreakdown()
+			stored = job.info.score.Load().breakdown
+			return &queue.Result{Status: queue.Success}
+		})
+		job.run(fuzzer)
+		return score, breakdown
+	}
+
+	// Unscored programs are smashed with the neutral score and have no breakdown.
+	score, breakdown := smash()
+	assert.Equal(t, float64(neutralScore), score)
+	assert.Nil(t, breakdown)
+
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	st.scores[p.Hash()] = &ProgScore{Total: 0.8, Coverage: 0.9}
+	st.touchLocked(p.Hash())
+	st.mu.Unlock()
+	score, breakdown = smash()
+	assert.Equal(t, 0.8, score)
+	if assert.NotNil(t, breakdown) {
+		assert.Equal(t, 0.9, breakdown.Coverage)
+	}
+	// The job keeps its own copy of the score, not the object owned by the tracker.
+	st.mu.RLock()
+	assert.NotSame(t, st.scores[p.Hash()], stored)
+	st.mu.RUnlock()
+}
+
+func TestSmashStrategyStats(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.MinSmashIters, scoreConfig.MaxSmashIters = 4, 4
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: scoreConfig,
+	}, rnd, target)
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	st := fuzzer.scoring.tracker
+	st.mu.Lock()
+	st.scores[p.Hash()] = &ProgScore{Total: 0.1}
+	st.touchLocked(p.Hash())
+	st.mu.Unlock()
+
+	// A low-score program is smashed with the aggressive strategy only.
+	job := &smashJob{
+		exec: stubExecutor(func(req *queue.Request) *queue.Result {
+			return &queue.Result{Status: queue.Success, Info: &flatrpc.ProgInfo{Elapsed: 1000000}}
+		}),
+		p:    p.Clone(),
+		info: &JobInfo{},
+	}
+	job.run(fuzzer)
+	stats := fuzzer.GetScoreMetrics().GetSmashStats()
+	assert.Equal(t, int64(4), stats["aggressive_mutations"])
+	assert.Contains(t, stats, "aggressive_successful_mutations")
+	assert.Contains(t, stats, "aggressive_success_rate")
+	assert.NotContains(t, stats, "standard_mutations")
+	assert.NotContains(t, stats, "conservative_mutations")
+	// Each mutant is scored once, when its result is processed.
+	assert.Equal(t, int64(4), fuzzer.GetScoreMetrics().Snapshot().TotalRequests)
+}
@@ -4,6 +4,14 @@
 package fuzzer
 
 import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
@@ -17,6 +25,8 @@ type LogPattern struct {
 	Score float64
 	// 模式描述
 	Description string
+	// 泛化模式: 同一行日志匹配了更具体的模式时不再计分
+	Generic bool
 }
 
 // KernelLogMatcher 内核日志匹配器
@@ -25,73 +35,89 @@ type KernelLogMatcher struct {
 	
 	// 预定义的日志模式
 	patterns []LogPattern
+
+	// 最近见过的崩溃标题 (nil 表示不去重)
+	knownTitles *titleSet
+
+	// 多模式加分最多计入的不同模式数量 (<= 0 时使用 defaultMaxBonusPatterns)
+	maxBonusPatterns int
 }
 
-// NewKernelLogMatcher 创建内核日志匹配器
+// defaultMaxBonusPatterns 默认最多计入 3 个不同模式的加分，
+// 否则匹配大量模式的噪声输出和真正严重的输出都会被截断到 1.0，失去区分度。
+const defaultMaxBonusPatterns = 3
+
+// NewKernelLogMatcher 创建使用内置日志模式的内核日志匹配器
 func NewKernelLogMatcher() *KernelLogMatcher {
-	matcher := &KernelLogMatcher{}
-	matcher.initializePatterns()
-	return matcher
+	return NewKernelLogMatcherFromPatterns(defaultLogPatterns())
+}
+
+// NewKernelLogMatcherFromPatterns 创建只使用给定日志模式的内核日志匹配器
+func NewKernelLogMatcherFromPatterns(patterns []LogPattern) *KernelLogMatcher {
+	return &KernelLogMatcher{
+		patterns: append([]LogPattern(nil), patterns...),
+	}
 }
 
-// initializePatterns 初始化日志模式
-func (klm *KernelLogMatcher) initializePatterns() {
+// defaultLogPatterns 返回内置的日志模式
+func defaultLogPatterns() []LogPattern {
 	// 定义各种内核日志模式及其分数权重
 	patterns := []struct {
 		regex       string
 		score       float64
 		description string
+		generic     bool
 	}{
 		// KASAN 错误 (最高优先级)
-		{`KASAN:.*`, 1.0, "KASAN memory error"},
-		{`AddressSanitizer:.*`, 1.0, "AddressSanitizer error"},
+		{`KASAN:.*`, 1.0, "KASAN memory error", false},
+		{`AddressSanitizer:.*`, 1.0, "AddressSanitizer error", false},
 		
 		// 内核崩溃和恐慌
-		{`kernel BUG at.*`, 0.9, "Kernel BUG"},
-		{`Kernel panic.*`, 0.9, "Kernel panic"},
-		{`Oops:.*`, 0.8, "Kernel Oops"},
+		{`kernel BUG at.*`, 0.9, "Kernel BUG", false},
+		{`Kernel panic.*`, 0.9, "Kernel panic", false},
+		{`Oops:.*`, 0.8, "Kernel Oops", false},
 		
 		// 内存相关错误
-		{`general protection fault.*`, 0.8, "General protection fault"},
-		{`page fault.*`, 0.7, "Page fault"},
-		{`double fault.*`, 0.9, "Double fault"},
-		{`stack segment.*`, 0.8, "Stack segment fault"},
+		{`general protection fault.*`, 0.8, "General protection fault", false},
+		{`page fault.*`, 0.7, "Page fault", false},
+		{`double fault.*`, 0.9, "Double fault", false},
+		{`stack segment.*`, 0.8, "Stack segment fault", false},
 		
 		// 锁相关问题
-		{`possible deadlock.*`, 0.7, "Possible deadlock"},
-		{`lockdep.*`, 0.6, "Lockdep warning"},
-		{`sleeping function called from invalid context.*`, 0.6, "Invalid sleep context"},
+		{`possible deadlock.*`, 0.7, "Possible deadlock", false},
+		{`lockdep.*`, 0.6, "Lockdep warning", false},
+		{`sleeping function called from invalid context.*`, 0.6, "Invalid sleep context", false},
 		
 		// RCU 相关
-		{`rcu_.*stall.*`, 0.6, "RCU stall"},
-		{`RCU.*`, 0.5, "RCU related"},
+		{`rcu_.*stall.*`, 0.6, "RCU stall", false},
+		{`RCU.*`, 0.5, "RCU related", true},
 		
 		// 警告信息
-		{`WARNING:.*`, 0.5, "Kernel warning"},
-		{`WARN_ON.*`, 0.5, "WARN_ON triggered"},
+		{`WARNING:.*`, 0.5, "Kernel warning", false},
+		{`WARN_ON.*`, 0.5, "WARN_ON triggered", false},
 		
 		// 内存泄漏和引用计数
-		{`memory leak.*`, 0.6, "Memory leak"},
-		{`refcount_t.*`, 0.6, "Reference count error"},
+		{`memory leak.*`, 0.6, "Memory leak", false},
+		{`refcount_t.*`, 0.6, "Reference count error", false},
 		
 		// 文件系统错误
-		{`EXT4-fs error.*`, 0.4, "EXT4 filesystem error"},
-		{`XFS.*error.*`, 0.4, "XFS filesystem error"},
+		{`EXT4-fs error.*`, 0.4, "EXT4 filesystem error", false},
+		{`XFS.*error.*`, 0.4, "XFS filesystem error", false},
 		
 		// 网络相关错误
-		{`net.*warning.*`, 0.3, "Network warning"},
-		{`TCP.*error.*`, 0.3, "TCP error"},
+		{`net.*warning.*`, 0.3, "Network warning", false},
+		{`TCP.*error.*`, 0.3, "TCP error", false},
 		
 		// 设备驱动错误
-		{`device.*error.*`, 0.3, "Device error"},
-		{`driver.*warning.*`, 0.2, "Driver warning"},
+		{`device.*error.*`, 0.3, "Device error", false},
+		{`driver.*warning.*`, 0.2, "Driver warning", false},
 		
 		// 一般错误信息
-		{`ERROR:.*`, 0.4, "General error"},
-		{`error.*`, 0.2, "Generic error"},
+		{`ERROR:.*`, 0.4, "General error", true},
+		{`error.*`, 0.2, "Generic error", true},
 	}
 	
-	klm.patterns = make([]LogPattern, 0, len(patterns))
+	result := make([]LogPattern, 0, len(patterns))
 	
 	for _, p := range patterns {
 		regex, err := regexp.Compile(p.regex)
@@ -99,25 +125,170 @@ func (klm *KernelLogMatcher) initializePatterns() {
 			continue // 跳过无效的正则表达式
 		}
 		
-		klm.patterns = append(klm.patterns, LogPattern{
+		result = append(result, LogPattern{
 			Pattern:     regex,
 			Score:       p.score,
 			Description: p.description,
+			Generic:     p.generic,
 		})
 	}
+	return result
+}
+
+// logPatternJSON 是日志模式文件中的一项，例如
+//
+//	[
+//		{"pattern": "MYDRV-FATAL:.*", "score": 0.9, "description": "MYDRV fatal error"},
+//		{"pattern": "WARNING:.*", "score": 0.3, "description": "Kernel warning"},
+//		{"pattern": "mydrv:.*", "score": 0.1, "description": "MYDRV message", "generic": true}
+//	]
+type logPatternJSON struct {
+	Pattern     string  `json:"pattern"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+	Generic     bool    `json:"generic"`
+}
+
+// LoadPatternsFromJSON 从 JSON 数组读取日志模式。
+// 正则表达式无效、分数不在 [0, 1] 内或 JSON 格式错误时返回带有行号的错误，不会跳过任何模式。
+// 没有描述的模式使用正则表达式作为描述。
+func LoadPatternsFromJSON(r io.Reader) ([]LogPattern, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// lineAt 返回偏移处的行号
+	lineAt := func(offset int64) int {
+		offset = min(max(offset, 0), int64(len(data)))
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	// 先检查整体的语法，json.Unmarshal 报告的错误偏移是相对于整个输入的。
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("line %v: %w", lineAt(syntaxErr.Offset-1), err)
+		}
+		return nil, fmt.Errorf("line %v: expected an array of patterns: %w", lineAt(0), err)
+	}
+	// 语法正确时逐个读取数组元素，只是为了得到每个元素的起始偏移。
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var patterns []LogPattern
+	for range entries {
+		// InputOffset 指向上一个元素之后，跳过元素之前的空白和逗号。
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) >= 0 {
+			offset++
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		line := lineAt(offset)
+		var entry logPatternJSON
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		if entry.Pattern == "" {
+			return nil, fmt.Errorf("line %v: empty pattern", line)
+		}
+		regex, err := regexp.Compile(entry.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid pattern %q: %w", line, entry.Pattern, err)
+		}
+		if entry.Score < 0 || entry.Score > 1 {
+			return nil, fmt.Errorf("line %v: pattern %q: score %v is not in [0, 1]", line, entry.Pattern, entry.Score)
+		}
+		if entry.Description == "" {
+			entry.Description = entry.Pattern
+		}
+		patterns = append(patterns, LogPattern{
+			Pattern:     regex,
+			Score:       entry.Score,
+			Description: entry.Description,
+			Generic:     entry.Generic,
+		})
+	}
+	return patterns, nil
+}
+
+// mergeLogPatterns 用 overrides 中描述相同的模式替换 base 中的模式，其余的追加在后面
+func mergeLogPatterns(base, overrides []LogPattern) []LogPattern {
+	result := append([]LogPattern(nil), base...)
+	index := make(map[string]int)
+	for i, p := range result {
+		index[p.Description] = i
+	}
+	for _, p := range overrides {
+		if i, ok := index[p.Description]; ok {
+			result[i] = p
+			continue
+		}
+		index[p.Description] = len(result)
+		result = append(result, p)
+	}
+	return result
+}
+
+// loadLogPatternsFile 读取模式文件并与内置模式合并
+func loadLogPatternsFile(path string) ([]LogPattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	patterns, err := LoadPatternsFromJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return mergeLogPatterns(defaultLogPatterns(), patterns), nil
+}
+
+// EnableTitleDedup 启用已知标题去重: 最近 size 个见过的崩溃标题不再计分，
+// 避免同一个反复出现的崩溃持续抬高内核日志分数。size <= 0 表示关闭去重。
+// 去重集合的内存上限由 size 决定，代价见 titleSet。
+func (klm *KernelLogMatcher) EnableTitleDedup(size int) {
+	klm.mu.Lock()
+	defer klm.mu.Unlock()
+	klm.knownTitles = nil
+	if size > 0 {
+		klm.knownTitles = newTitleSet(size)
+	}
+}
+
+// SetMaxBonusPatterns 设置多模式加分最多计入的不同模式数量，n <= 0 表示使用默认值
+func (klm *KernelLogMatcher) SetMaxBonusPatterns(n int) {
+	klm.mu.Lock()
+	defer klm.mu.Unlock()
+	klm.maxBonusPatterns = n
 }
 
 // CalculateScore 计算内核日志分数
+// 每行日志只计入其中分数最高的模式，多模式加分只统计来自不同日志行的不同模式，
+// 避免同一个事件因为被多个模式匹配而抬高分数。
+// 计算不修改匹配器的状态，同样的日志总是得到同样的分数:
+// 崩溃标题只在调用 rememberTitles 后才被当作已知标题。
 func (klm *KernelLogMatcher) CalculateScore(logs []string) float64 {
+	score, _ := klm.score(logs)
+	return score
+}
+
+// score 与 CalculateScore 相同，同时返回日志匹配的崩溃标题 (未启用标题去重时为 nil)，
+// 调用方在记录评分时把它们传给 rememberTitles。
+func (klm *KernelLogMatcher) score(logs []string) (float64, []string) {
 	klm.mu.RLock()
 	defer klm.mu.RUnlock()
 	
 	if len(logs) == 0 {
-		return 0.0
+		return 0.0, nil
 	}
 	
 	maxScore := 0.0
 	matchedPatterns := make(map[string]bool)
+	var titles []string
 	
 	// 遍历所有日志行
 	for _, log := range logs {
@@ -126,25 +297,37 @@ func (klm *KernelLogMatcher) CalculateScore(logs []string) float64 {
 			continue
 		}
 		
-		// 检查每个模式
-		for _, pattern := range klm.patterns {
-			if pattern.Pattern.MatchString(log) {
-				// 避免重复计分同一类型的模式
-				key := pattern.Description
-				if !matchedPatterns[key] {
-					matchedPatterns[key] = true
-					if pattern.Score > maxScore {
-						maxScore = pattern.Score
-					}
+		var best *LogPattern
+		for _, match := range klm.matchLine(log) {
+			// 最近已经见过的崩溃不再计分
+			if klm.knownTitles != nil {
+				titles = append(titles, match.title)
+				if klm.knownTitles.contains(match.title) {
+					continue
 				}
 			}
+			if best == nil || match.pattern.Score > best.Score {
+				best = match.pattern
+			}
+		}
+		if best == nil {
+			continue
+		}
+		// 避免重复计分同一类型的模式
+		matchedPatterns[best.Description] = true
+		if best.Score > maxScore {
+			maxScore = best.Score
 		}
 	}
 	
-	// 如果匹配了多个不同类型的模式，给予额外加分
+	// 如果不同的日志行匹配了不同类型的模式，给予额外加分
 	bonusScore := 0.0
-	if len(matchedPatterns) > 1 {
-		bonusScore = float64(len(matchedPatterns)-1) * 0.1
+	bonusPatterns := klm.maxBonusPatterns
+	if bonusPatterns <= 0 {
+		bonusPatterns = defaultMaxBonusPatterns
+	}
+	if distinct := min(len(matchedPatterns), bonusPatterns); distinct > 1 {
+		bonusScore = float64(distinct-1) * 0.1
 	}
 	
 	totalScore := maxScore + bonusScore
@@ -154,7 +337,50 @@ func (klm *KernelLogMatcher) CalculateScore(logs []string) float64 {
 		totalScore = 1.0
 	}
 	
-	return totalScore
+	return totalScore, titles
+}
+
+// rememberTitles 把崩溃标题记为已知，之后的日志中再出现这些标题时不再计分。
+// 未启用标题去重时忽略。
+func (klm *KernelLogMatcher) rememberTitles(titles []string) {
+	klm.mu.RLock()
+	defer klm.mu.RUnlock()
+	if klm.knownTitles == nil {
+		return
+	}
+	for _, title := range titles {
+		klm.knownTitles.add(title)
+	}
+}
+
+// lineMatch 是一行日志匹配到的模式及匹配的文本
+type lineMatch struct {
+	pattern *LogPattern
+	title   string
+}
+
+// matchLine 返回一行日志匹配的模式。
+// 只要有非泛化的模式匹配，同一行上的泛化模式就被忽略。调用者需要持有读锁。
+func (klm *KernelLogMatcher) matchLine(log string) []lineMatch {
+	var matches []lineMatch
+	specific := false
+	for i := range klm.patterns {
+		pattern := &klm.patterns[i]
+		if title := pattern.Pattern.FindString(log); title != "" {
+			matches = append(matches, lineMatch{pattern, title})
+			specific = specific || !pattern.Generic
+		}
+	}
+	if !specific {
+		return matches
+	}
+	result := matches[:0]
+	for _, match := range matches {
+		if !match.pattern.Generic {
+			result = append(result, match)
+		}
+	}
+	return result
 }
 
 // AddCustomPattern 添加自定义日志模式
@@ -190,15 +416,68 @@ func (klm *KernelLogMatcher) GetMatchedPatterns(logs []string) []string {
 			continue
 		}
 		
-		for _, pattern := range klm.patterns {
-			if pattern.Pattern.MatchString(log) {
-				if !matchedSet[pattern.Description] {
-					matchedSet[pattern.Description] = true
-					matched = append(matched, pattern.Description)
-				}
+		for _, match := range klm.matchLine(log) {
+			if !matchedSet[match.pattern.Description] {
+				matchedSet[match.pattern.Description] = true
+				matched = append(matched, match.pattern.Description)
 			}
 		}
 	}
 	
 	return matched
-}
\ No newline at end of file
+}
+// titleSet 是有界的最近崩溃标题集合，按 LRU 淘汰最久未出现的标题。
+// 为了让内存只取决于容量而与标题长度无关，集合中只保存标题的 64 位哈希。
+// 代价是两类误判:
+//   - 哈希碰撞时新的崩溃会被误认为已知 (概率约为 size/2^64，可以忽略);
+//   - 被淘汰的旧标题再次出现时会被当作新的崩溃重新计分。
+//
+// 容量越大，第二类误判越少，但占用的内存越多。
+type titleSet struct {
+	mu    sync.Mutex
+	size  int
+	lru   *list.List
+	index map[uint64]*list.Element
+}
+
+func newTitleSet(size int) *titleSet {
+	return &titleSet{
+		size:  size,
+		lru:   list.New(),
+		index: make(map[uint64]*list.Element),
+	}
+}
+
+func titleKey(title string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	return h.Sum64()
+}
+
+// contains 返回标题是否在集合中，不改变标题的淘汰顺序。
+func (ts *titleSet) contains(title string) bool {
+	key := titleKey(title)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	_, ok := ts.index[key]
+	return ok
+}
+
+// add 记录标题，返回该标题此前是否已在集合中。
+func (ts *titleSet) add(title string) bool {
+	key := titleKey(title)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if elem, ok := ts.index[key]; ok {
+		ts.lru.MoveToFront(elem)
+		return true
+	}
+	ts.index[key] = ts.lru.PushFront(key)
+	for ts.lru.Len() > ts.size {
+		oldest := ts.lru.Back()
+		ts.lru.Remove(oldest)
+		delete(ts.index, oldest.Value.(uint64))
+	}
+	return false
+}
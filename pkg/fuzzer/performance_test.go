@@ -4,7 +4,6 @@
 package fuzzer
 
 import (
-	"context"
 	"fmt"
 	"math/rand"
 	"runtime"
@@ -13,6 +12,7 @@ import (
 	"time"
 
 	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
 )
 
 // TestScoreSystemPerformance 测试评分系统性能影响
@@ -22,19 +22,14 @@ func TestScoreSystemPerformance(t *testing.T) {
 	numWorkers := runtime.NumCPU()
 	
 	// 创建评分系统
-	config := DefaultScoreConfig()
-	tracker := NewScoreTracker(config)
-	selector := NewWeightedSelector()
+	scoring := newScoring(DefaultScoreConfig())
 	
 	t.Logf("开始性能测试: %d 个程序, %d 个工作线程", numPrograms, numWorkers)
 	
 	// 准备测试数据
-	programs := make([]*TestProgram, numPrograms)
-	for i := 0; i < numPrograms; i++ {
-		programs[i] = &TestProgram{
-			Hash:    fmt.Sprintf("prog_%d", i),
-			Content: fmt.Sprintf("test_program_%d", i),
-		}
+	hashes := make([]string, numPrograms)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("prog_%d", i)
 	}
 	
 	// 测试并发评分计算
@@ -47,7 +42,6 @@ func TestScoreSystemPerformance(t *testing.T) {
 			defer wg.Done()
 			
 			for j := workerID; j < numPrograms; j += numWorkers {
-				prog := programs[j]
 				execResult := &ExecutionResult{
 					Signal:     signal.Signal{},
 					ExecTime:   uint64(1000000 + rand.Intn(500000)),
@@ -56,8 +50,7 @@ func TestScoreSystemPerformance(t *testing.T) {
 					Error:      "",
 				}
 				
-				score := tracker.UpdateScore(prog, execResult)
-				selector.UpdateWeight(prog.Hash, score.Total)
+				scoreHash(scoring, hashes[j], execResult)
 			}
 		}(i)
 	}
@@ -80,16 +73,12 @@ func TestScoreSystemPerformance(t *testing.T) {
 	}
 	
 	// 测试加权选择性能
-	hashes := make([]string, numPrograms)
-	for i, prog := range programs {
-		hashes[i] = prog.Hash
-	}
-	
+	rnd := rand.New(rand.NewSource(0))
 	selectionStart := time.Now()
 	numSelections := 10000
 	
 	for i := 0; i < numSelections; i++ {
-		selector.WeightedSelect(hashes)
+		scoring.selector.WeightedSelect(rnd, hashes)
 	}
 	
 	selectionDuration := time.Since(selectionStart)
@@ -110,17 +99,10 @@ func TestMemoryUsage(t *testing.T) {
 	runtime.ReadMemStats(&m1)
 	
 	// 创建大量评分数据
-	config := DefaultScoreConfig()
-	tracker := NewScoreTracker(config)
-	selector := NewWeightedSelector()
+	scoring := newScoring(DefaultScoreConfig())
 	
 	numPrograms := 10000
 	for i := 0; i < numPrograms; i++ {
-		prog := &TestProgram{
-			Hash:    fmt.Sprintf("prog_%d", i),
-			Content: fmt.Sprintf("test_program_%d", i),
-		}
-		
 		execResult := &ExecutionResult{
 			Signal:     signal.Signal{},
 			ExecTime:   uint64(1000000 + i*1000),
@@ -129,12 +111,12 @@ func TestMemoryUsage(t *testing.T) {
 			Error:      "",
 		}
 		
-		score := tracker.UpdateScore(prog, execResult)
-		selector.UpdateWeight(prog.Hash, score.Total)
+		scoreHash(scoring, fmt.Sprintf("prog_%d", i), execResult)
 	}
 	
 	runtime.GC()
 	runtime.ReadMemStats(&m2)
+	runtime.KeepAlive(scoring)
 	
 	memoryUsed := m2.Alloc - m1.Alloc
 	memoryPerProgram := memoryUsed / uint64(numPrograms)
@@ -151,9 +133,7 @@ func TestMemoryUsage(t *testing.T) {
 
 // TestConcurrentAccess 测试并发访问安全性
 func TestConcurrentAccess(t *testing.T) {
-	config := DefaultScoreConfig()
-	tracker := NewScoreTracker(config)
-	selector := NewWeightedSelector()
+	scoring := newScoring(DefaultScoreConfig())
 	
 	numWorkers := 10
 	numOperations := 1000
@@ -166,12 +146,10 @@ func TestConcurrentAccess(t *testing.T) {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(workerID)))
 			
 			for j := 0; j < numOperations; j++ {
-				prog := &TestProgram{
-					Hash:    fmt.Sprintf("worker_%d_prog_%d", workerID, j),
-					Content: fmt.Sprintf("content_%d_%d", workerID, j),
-				}
+				hash := fmt.Sprintf("worker_%d_prog_%d", workerID, j)
 				
 				execResult := &ExecutionResult{
 					Signal:     signal.Signal{},
@@ -182,19 +160,17 @@ func TestConcurrentAccess(t *testing.T) {
 				}
 				
 				// 写操作
-				score := tracker.UpdateScore(prog, execResult)
-				selector.UpdateWeight(prog.Hash, score.Total)
+				scoreHash(scoring, hash, execResult)
 				
 				// 读操作
-				cachedScore := tracker.GetScore(prog.Hash)
+				cachedScore := scoring.tracker.GetScoreByHash(hash)
 				if cachedScore == nil {
 					errors <- fmt.Errorf("worker %d: 无法获取评分", workerID)
 					continue
 				}
 				
 				// 选择操作
-				hashes := []string{prog.Hash}
-				selected := selector.WeightedSelect(hashes)
+				selected := scoring.selector.WeightedSelect(rnd, []string{hash})
 				if selected == "" {
 					errors <- fmt.Errorf("worker %d: 选择失败", workerID)
 				}
@@ -221,19 +197,16 @@ func TestConcurrentAccess(t *testing.T) {
 
 // TestScoreSystemOverhead 测试评分系统开销
 func TestScoreSystemOverhead(t *testing.T) {
+	if testutil.RaceEnabled {
+		t.Skip("竞态检测下的计时没有意义")
+	}
 	numPrograms := 1000
 	
 	// 测试不启用评分系统的性能
-	disabledConfig := &ScoreConfig{Enabled: false}
-	disabledTracker := NewScoreTracker(disabledConfig)
+	disabledScoring := newScoring(&ScoreConfig{Enabled: false})
 	
 	start := time.Now()
 	for i := 0; i < numPrograms; i++ {
-		prog := &TestProgram{
-			Hash:    fmt.Sprintf("prog_%d", i),
-			Content: fmt.Sprintf("content_%d", i),
-		}
-		
 		execResult := &ExecutionResult{
 			Signal:     signal.Signal{},
 			ExecTime:   uint64(1000000 + i*1000),
@@ -242,21 +215,15 @@ func TestScoreSystemOverhead(t *testing.T) {
 			Error:      "",
 		}
 		
-		disabledTracker.UpdateScore(prog, execResult)
+		scoreHash(disabledScoring, fmt.Sprintf("prog_%d", i), execResult)
 	}
 	disabledDuration := time.Since(start)
 	
 	// 测试启用评分系统的性能
-	enabledConfig := DefaultScoreConfig()
-	enabledTracker := NewScoreTracker(enabledConfig)
+	enabledScoring := newScoring(DefaultScoreConfig())
 	
 	start = time.Now()
 	for i := 0; i < numPrograms; i++ {
-		prog := &TestProgram{
-			Hash:    fmt.Sprintf("prog_%d", i),
-			Content: fmt.Sprintf("content_%d", i),
-		}
-		
 		execResult := &ExecutionResult{
 			Signal:     signal.Signal{},
 			ExecTime:   uint64(1000000 + i*1000),
@@ -265,33 +232,44 @@ func TestScoreSystemOverhead(t *testing.T) {
 			Error:      "",
 		}
 		
-		enabledTracker.UpdateScore(prog, execResult)
+		scoreHash(enabledScoring, fmt.Sprintf("prog_%d", i), execResult)
 	}
 	enabledDuration := time.Since(start)
 	
-	// 计算开销
+	// 计算开销。关闭评分时评分几乎立即返回，与其比较的百分比没有意义，
+	// 因此与一次程序执行的耗时 (毫秒级) 比较平均每个程序的额外开销。
 	overhead := enabledDuration - disabledDuration
-	overheadPercent := float64(overhead) / float64(disabledDuration) * 100
+	overheadPerProgram := overhead / time.Duration(numPrograms)
 	
 	t.Logf("评分系统开销分析:")
 	t.Logf("  禁用评分: %v", disabledDuration)
 	t.Logf("  启用评分: %v", enabledDuration)
-	t.Logf("  额外开销: %v (%.2f%%)", overhead, overheadPercent)
+	t.Logf("  额外开销: %v (每程序 %v)", overhead, overheadPerProgram)
 	
-	// 开销阈值检查 (不应超过 50%)
-	if overheadPercent > 50 {
-		t.Errorf("评分系统开销过高: %.2f%% (期望 < 50%%)", overheadPercent)
+	// 开销阈值检查 (每个程序不应超过 100 微秒)
+	if overheadPerProgram > 100*time.Microsecond {
+		t.Errorf("评分系统开销过高: 每程序 %v (期望 < 100µs)", overheadPerProgram)
 	}
 }
 
-// 辅助结构和函数
-type TestProgram struct {
-	Hash    string
-	Content string
-}
+// 辅助函数
 
-func (tp *TestProgram) Hash() string {
-	return tp.Hash
+// scoreHash 按程序哈希评分并更新选择器权重，与 scoring.Score 的顺序相同，但不需要构造程序。
+// 评分系统关闭时与 ScoreTracker.UpdateScore 一样返回中性评分。
+func scoreHash(s *scoring, progHash string, execResult *ExecutionResult) *ProgScore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracker.mu.RLock()
+	enabled := s.tracker.config.Enabled
+	s.tracker.mu.RUnlock()
+	if !enabled {
+		return &ProgScore{Total: neutralScore}
+	}
+	progScore := s.tracker.updateScore(progHash, false, execResult)
+	if progScore != nil {
+		s.selector.UpdateWeight(progHash, selectorWeight(progScore))
+	}
+	return progScore
 }
 
 func generateRandomKernelLogs() []string {
@@ -318,19 +296,28 @@ func generateRandomKernelLogs() []string {
 
 // BenchmarkScoreCalculationComponents 基准测试各个评分组件
 func BenchmarkScoreCalculationComponents(b *testing.B) {
-	config := DefaultScoreConfig()
+	tracker := NewScoreTracker(DefaultScoreConfig())
 	
 	b.Run("CoverageScore", func(b *testing.B) {
+		execResult := &ExecutionResult{Signal: signal.Signal{}}
 		for i := 0; i < b.N; i++ {
-			calculateCoverageScore(signal.Signal{}, config)
+			tracker.calculateCoverageScore(execResult)
 		}
 	})
 	
 	b.Run("RarityScore", func(b *testing.B) {
-		stats := make(map[string]int64)
-		stats["test"] = 100
+		// 稀有性在热路径上每次执行计算一次，不应分配内存
+		raw := make([]uint64, 5000)
+		stats := make(map[uint64]int64)
+		for i := range raw {
+			raw[i] = uint64(i) * 4099
+			stats[raw[i]] = int64(i % 100)
+		}
+		sig := signal.FromRaw(raw, 0)
+		b.ReportAllocs()
+		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			calculateRarityScore("test", stats, config)
+			signalRarity(sig, stats)
 		}
 	})
 	
@@ -11,7 +11,7 @@ import (
 )
 
 // Distributor distributes requests to different VMs during input triage
-// (allows to avoid already used VMs).
+// (allows to avoid already used VMs, or to prefer particular VMs).
 type Distributor struct {
 	source        Source
 	seq           atomic.Uint64
@@ -44,7 +44,7 @@ func (dist *Distributor) Next(vm int) *Request {
 	}
 	for {
 		req := dist.source.Next()
-		if req == nil || !contains(req.Avoid, vm) || !dist.hasOtherActive(req.Avoid) {
+		if req == nil || dist.suitable(req, vm) {
 			return req
 		}
 		dist.delay(req)
@@ -68,7 +68,7 @@ func (dist *Distributor) delayed(vm int) *Request {
 	defer dist.mu.Unlock()
 	seq := dist.seq.Load()
 	for i, req := range dist.queue {
-		violation := contains(req.Avoid, vm)
+		violation := contains(req.Avoid, vm) || len(req.Prefer) != 0 && !contains(req.Prefer, vm)
 		// The delayedSince check protects from a situation when we had another VM available,
 		// and delayed a request, but then the VM was taken for reproduction and does not
 		// serve requests any more. If we could not dispatch a request in 1000 attempts,
@@ -105,12 +105,25 @@ func (dist *Distributor) noteActive(vm int) {
 	(*active)[vm].Store(dist.seq.Add(1))
 }
 
-// hasOtherActive says if we recently seen activity from VMs not in the set.
-func (dist *Distributor) hasOtherActive(set []ExecutorID) bool {
+// suitable says if the request can be executed on the vm right now
+// taking into account its Avoid and Prefer sets.
+func (dist *Distributor) suitable(req *Request, vm int) bool {
+	if contains(req.Avoid, vm) && dist.hasActive(req.Avoid, false) {
+		return false
+	}
+	if len(req.Prefer) != 0 && !contains(req.Prefer, vm) && dist.hasActive(req.Prefer, true) {
+		return false
+	}
+	return true
+}
+
+// hasActive says if we recently seen activity from VMs in the set (if inSet),
+// or from VMs not in the set (if !inSet).
+func (dist *Distributor) hasActive(set []ExecutorID, inSet bool) bool {
 	seq := dist.seq.Load()
 	active := *dist.active.Load()
 	for vm := range active {
-		if contains(set, vm) {
+		if contains(set, vm) != inSet {
 			continue
 		}
 		// 1000 is semi-random notion of recency.
@@ -46,3 +46,33 @@ func TestDistributor(t *testing.T) {
 	q.Submit(req)
 	assert.Equal(t, req, dist.Next(1))
 }
+
+func TestDistributorPrefer(t *testing.T) {
+	q := Plain()
+	dist := Distribute(q)
+	var noReq *Request
+	assert.Equal(t, noReq, dist.Next(0))
+	assert.Equal(t, noReq, dist.Next(1))
+
+	// Prefer VM 1.
+	req := &Request{Prefer: []ExecutorID{{VM: 1}}}
+	q.Submit(req)
+	assert.Equal(t, noReq, dist.Next(0))
+	assert.Equal(t, noReq, dist.Next(2))
+	assert.Equal(t, req, dist.Next(1))
+
+	// If the preferred VM does not query requests, others should eventually get it.
+	q.Submit(req)
+	for {
+		got := dist.Next(0)
+		if got == req {
+			break
+		}
+		assert.Equal(t, noReq, got)
+	}
+
+	// If the preferred VM was not active recently, any VM gets the request immediately.
+	req.Prefer = []ExecutorID{{VM: 5}}
+	q.Submit(req)
+	assert.Equal(t, req, dist.Next(0))
+}
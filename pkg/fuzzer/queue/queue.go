@@ -46,6 +46,11 @@ type Request struct {
 	// The restriction is soft since there can be only one executor at all or available right now.
 	Avoid []ExecutorID
 
+	// Prefer specifies set of executors that are preferable to execute this request on.
+	// The restriction is soft in the same way as Avoid: if none of the preferred executors
+	// is active, the request is executed elsewhere.
+	Prefer []ExecutorID
+
 	// The callback will be called on request completion in the LIFO order.
 	// If it returns false, all further processing will be stopped.
 	// It allows wrappers to intercept Done() requests.
@@ -183,11 +188,18 @@ type Result struct {
 	Output   []byte
 	Status   Status
 	Err      error // More details in case of ExecFailure.
+	// Scoring holds data for program scoring that was already extracted from Info/Output
+	// when the result was assembled (e.g. kernel log lines). May be nil.
+	Scoring *flatrpc.ScoringProgInfo
 }
 
 func (r *Result) clone() *Result {
 	ret := *r
 	ret.Info = ret.Info.Clone()
+	if ret.Scoring != nil {
+		ret.Scoring = ret.Scoring.Clone()
+		ret.Scoring.ProgInfo = ret.Info
+	}
 	return &ret
 }
 
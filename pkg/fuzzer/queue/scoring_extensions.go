@@ -4,7 +4,13 @@
 package queue
 
 import (
+	"cmp"
+	"math/rand"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/google/syzkaller/pkg/log"
 )
 
 // ScoringRequest 扩展 Request 结构，添加评分相关字段
@@ -24,6 +30,28 @@ type ScoringRequest struct {
 	ScoreDetails *ScoreDetails
 }
 
+// compare 比较两个请求的评分，返回 -1、0 或 1。
+// 与 fuzzer.ProgScore.Compare 的顺序一致: 先比较总分，然后依次比较内核日志、覆盖率、
+// 稀有性和时间异常分数，最后比较评分时间。
+func (r *ScoringRequest) compare(other *ScoringRequest) int {
+	if c := cmp.Compare(r.Score, other.Score); c != 0 {
+		return c
+	}
+	if r.ScoreDetails != nil && other.ScoreDetails != nil {
+		for _, pair := range [][2]float64{
+			{r.ScoreDetails.KernelLog, other.ScoreDetails.KernelLog},
+			{r.ScoreDetails.Coverage, other.ScoreDetails.Coverage},
+			{r.ScoreDetails.Rarity, other.ScoreDetails.Rarity},
+			{r.ScoreDetails.TimeAnomaly, other.ScoreDetails.TimeAnomaly},
+		} {
+			if c := cmp.Compare(pair[0], pair[1]); c != 0 {
+				return c
+			}
+		}
+	}
+	return r.ScoreTimestamp.Compare(other.ScoreTimestamp)
+}
+
 // ScoreDetails 评分详细信息
 type ScoreDetails struct {
 	// 覆盖率分数
@@ -115,7 +143,14 @@ func (sr *ScoringResult) UpdateScore(score float64) {
 type WeightedQueue struct {
 	requests []*ScoringRequest
 	weights  []float64
-	totalWeight float64
+	// cumulative[i] 是前 i+1 个请求的权重之和，最后一个元素就是总权重。
+	// 总权重不单独记录，因此按随机数选择时总能在累积权重中找到对应的请求。
+	cumulative []float64
+
+	// 严格模式: 选择未命中任何请求时记录日志并返回 nil，而不是回退到最后一个请求。
+	// 正常情况下不会未命中，严格模式用于在测试和校验中暴露这类错误 (例如累积权重被破坏)，
+	// 而不是悄悄得到有偏差的结果。
+	Strict bool
 }
 
 // NewWeightedQueue 创建加权队列
@@ -130,42 +165,46 @@ func NewWeightedQueue() *WeightedQueue {
 func (wq *WeightedQueue) SubmitScored(req *ScoringRequest) {
 	wq.requests = append(wq.requests, req)
 	weight := req.Score
-	if weight <= 0 {
+	if !(weight > 0) {
 		weight = 0.01 // 最小权重，避免完全忽略
 	}
 	wq.weights = append(wq.weights, weight)
-	wq.totalWeight += weight
+	wq.cumulative = append(wq.cumulative, wq.totalWeight()+weight)
 }
 
-// NextWeighted 基于权重随机选择请求
+// totalWeight 返回队列中所有请求的权重之和
+func (wq *WeightedQueue) totalWeight() float64 {
+	if len(wq.cumulative) == 0 {
+		return 0
+	}
+	return wq.cumulative[len(wq.cumulative)-1]
+}
+
+// NextWeighted 基于权重随机选择请求并将其移出队列，
+// 每个请求被选中的概率与其权重成正比。rnd 应在 [0, 1] 内，超出范围时截断。
 func (wq *WeightedQueue) NextWeighted(rnd float64) *ScoringRequest {
-	if len(wq.requests) == 0 || wq.totalWeight <= 0 {
+	total := wq.totalWeight()
+	if len(wq.requests) == 0 || total <= 0 {
 		return nil
 	}
-	
-	target := rnd * wq.totalWeight
-	cumulative := 0.0
-	
-	for i, weight := range wq.weights {
-		cumulative += weight
-		if cumulative >= target {
-			// 移除选中的请求
-			req := wq.requests[i]
-			wq.removeAt(i)
-			req.ScoreSelected = true
-			return req
+	// 在累积权重中二分查找第一个不小于 target 的位置。target 不超过总权重 (即最后一个累积权重)，
+	// 因此总能找到请求，rnd == 1 时选中最后一个请求。
+	target := min(max(rnd, 0), 1) * total
+	i := sort.Search(len(wq.cumulative), func(i int) bool {
+		return wq.cumulative[i] >= target
+	})
+	if i == len(wq.cumulative) {
+		if wq.Strict {
+			log.Logf(0, "weighted queue: selection miss (target %v, total weight %v)", target, total)
+			return nil
 		}
+		// 如果没有选中任何请求，返回最后一个
+		i = len(wq.requests) - 1
 	}
-	
-	// 如果没有选中任何请求，返回最后一个
-	if len(wq.requests) > 0 {
-		req := wq.requests[len(wq.requests)-1]
-		wq.removeAt(len(wq.requests) - 1)
-		req.ScoreSelected = true
-		return req
-	}
-	
-	return nil
+	req := wq.requests[i]
+	wq.removeAt(i)
+	req.ScoreSelected = true
+	return req
 }
 
 // removeAt 移除指定位置的请求
@@ -174,9 +213,6 @@ func (wq *WeightedQueue) removeAt(index int) {
 		return
 	}
 	
-	// 更新总权重
-	wq.totalWeight -= wq.weights[index]
-	
 	// 移除请求和权重
 	copy(wq.requests[index:], wq.requests[index+1:])
 	wq.requests[len(wq.requests)-1] = nil
@@ -184,6 +220,17 @@ func (wq *WeightedQueue) removeAt(index int) {
 	
 	copy(wq.weights[index:], wq.weights[index+1:])
 	wq.weights = wq.weights[:len(wq.weights)-1]
+
+	// 从被移除的位置起重新累加权重而不是减去被移除的权重，避免反复相减积累舍入误差
+	wq.cumulative = wq.cumulative[:len(wq.weights)]
+	cumulative := 0.0
+	if index > 0 {
+		cumulative = wq.cumulative[index-1]
+	}
+	for i := index; i < len(wq.weights); i++ {
+		cumulative += wq.weights[i]
+		wq.cumulative[i] = cumulative
+	}
 }
 
 // Len 返回队列长度
@@ -195,7 +242,47 @@ func (wq *WeightedQueue) Len() int {
 func (wq *WeightedQueue) Clear() {
 	wq.requests = wq.requests[:0]
 	wq.weights = wq.weights[:0]
-	wq.totalWeight = 0
+	wq.cumulative = wq.cumulative[:0]
+}
+
+// WeightedSource 是按评分加权随机顺序出队的线程安全请求源，
+// 评分越高的请求越可能先出队。队列为空时 Next 返回 nil，Order 会继续询问后面的请求源。
+type WeightedSource struct {
+	mu    sync.Mutex
+	queue *WeightedQueue
+	rnd   *rand.Rand
+}
+
+// Weighted 创建加权请求源，rnd 只在持有锁时使用
+func Weighted(rnd *rand.Rand) *WeightedSource {
+	return &WeightedSource{
+		queue: NewWeightedQueue(),
+		rnd:   rnd,
+	}
+}
+
+// SubmitScored 以 score 作为权重加入请求
+func (ws *WeightedSource) SubmitScored(req *Request, score float64) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.queue.SubmitScored(NewScoringRequest(req, score, nil))
+}
+
+// Len 返回队列中的请求数量
+func (ws *WeightedSource) Len() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.queue.Len()
+}
+
+func (ws *WeightedSource) Next() *Request {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	req := ws.queue.NextWeighted(ws.rnd.Float64())
+	if req == nil {
+		return nil
+	}
+	return req.Request
 }
 
 // GetTopScored 获取评分最高的N个请求
@@ -208,14 +295,10 @@ func (wq *WeightedQueue) GetTopScored(n int) []*ScoringRequest {
 	requests := make([]*ScoringRequest, len(wq.requests))
 	copy(requests, wq.requests)
 	
-	// 简单的冒泡排序 (按评分降序)
-	for i := 0; i < len(requests)-1; i++ {
-		for j := i + 1; j < len(requests); j++ {
-			if requests[i].Score < requests[j].Score {
-				requests[i], requests[j] = requests[j], requests[i]
-			}
-		}
-	}
+	// 按评分降序排序
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].compare(requests[j]) > 0
+	})
 	
 	// 返回前N个
 	if n > len(requests) {
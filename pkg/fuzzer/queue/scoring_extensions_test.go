@@ -0,0 +1,133 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedQueueBoundaries(t *testing.T) {
+	newQueue := func() *WeightedQueue {
+		wq := NewWeightedQueue()
+		for _, score := range []float64{0.1, 0.7, 0.3} {
+			wq.SubmitScored(NewScoringRequest(&Request{}, score, nil))
+		}
+		return wq
+	}
+
+	// Selection never misses, even at the upper boundary and after removals.
+	wq := newQueue()
+	for wq.Len() != 0 {
+		assert.NotNil(t, wq.NextWeighted(1))
+	}
+	assert.Nil(t, wq.NextWeighted(0.5))
+
+	// The boundaries select the first and the last requests.
+	assert.Equal(t, 0.1, newQueue().NextWeighted(0).Score)
+	assert.Equal(t, 0.3, newQueue().NextWeighted(1).Score)
+	assert.Equal(t, 0.7, newQueue().NextWeighted(0.5).Score)
+}
+
+func TestWeightedQueueStrict(t *testing.T) {
+	newQueue := func(strict bool) *WeightedQueue {
+		wq := NewWeightedQueue()
+		wq.Strict = strict
+		for _, score := range []float64{0.1, 0.7, 0.3} {
+			wq.SubmitScored(NewScoringRequest(&Request{}, score, nil))
+		}
+		// Force a miss by corrupting the cumulative weights.
+		wq.cumulative[len(wq.cumulative)-1] = math.NaN()
+		return wq
+	}
+
+	wq := newQueue(true)
+	assert.Nil(t, wq.NextWeighted(0.99))
+	assert.Equal(t, 3, wq.Len())
+
+	// The non-strict mode falls back to the last request.
+	wq = newQueue(false)
+	req := wq.NextWeighted(0.99)
+	assert.NotNil(t, req)
+	assert.Equal(t, 0.3, req.Score)
+	assert.Equal(t, 2, wq.Len())
+}
+
+func TestWeightedQueueDistribution(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	scores := []float64{0.2, 0.5, 0.3}
+	const samples = 30000
+	counts := make(map[float64]int)
+	for i := 0; i < samples; i++ {
+		wq := NewWeightedQueue()
+		for _, score := range scores {
+			wq.SubmitScored(NewScoringRequest(&Request{}, score, nil))
+		}
+		counts[wq.NextWeighted(rnd.Float64()).Score]++
+	}
+	for _, score := range scores {
+		assert.InDelta(t, score, float64(counts[score])/samples, 0.02, "score %v", score)
+	}
+}
+
+func TestWeightedQueueStress(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	wq := NewWeightedQueue()
+	iters := 10000
+	if testing.Short() {
+		iters = 1000
+	}
+	for i := 0; i < iters; i++ {
+		if wq.Len() == 0 || rnd.Intn(2) == 0 {
+			// Weights of very different magnitudes maximize the rounding errors.
+			score := rnd.Float64() * []float64{1e-6, 1, 1e6}[rnd.Intn(3)]
+			wq.SubmitScored(NewScoringRequest(&Request{}, score, nil))
+		} else if wq.NextWeighted(rnd.Float64()) == nil {
+			t.Fatalf("iter %v: no request selected from a queue of %v", i, wq.Len()+1)
+		}
+		if total := wq.totalWeight(); total < 0 || wq.Len() != 0 && total <= 0 {
+			t.Fatalf("iter %v: total weight %v with %v requests", i, total, wq.Len())
+		}
+	}
+	for wq.Len() != 0 {
+		if wq.NextWeighted(rnd.Float64()) == nil {
+			t.Fatalf("no request selected from a queue of %v", wq.Len())
+		}
+	}
+	assert.Equal(t, 0.0, wq.totalWeight())
+}
+
+func TestWeightedSource(t *testing.T) {
+	ws := Weighted(rand.New(testutil.RandSource(t)))
+	assert.Nil(t, ws.Next())
+
+	// Higher scored requests are dequeued earlier on average.
+	const n = 100
+	scores := make(map[*Request]float64)
+	for i := 0; i < n; i++ {
+		req := &Request{}
+		scores[req] = 0.1
+		if i%2 == 0 {
+			scores[req] = 0.9
+		}
+		ws.SubmitScored(req, scores[req])
+	}
+	assert.Equal(t, n, ws.Len())
+	var high, low float64
+	for pos := 0; pos < n; pos++ {
+		req := ws.Next()
+		if scores[req] > 0.5 {
+			high += float64(pos)
+		} else {
+			low += float64(pos)
+		}
+	}
+	assert.Less(t, high, low)
+	assert.Nil(t, ws.Next())
+	assert.Equal(t, 0, ws.Len())
+}
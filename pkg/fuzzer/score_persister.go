@@ -0,0 +1,83 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// defaultPersistInterval 未配置 PersistInterval 时定期保存评分的间隔
+const defaultPersistInterval = 10 * time.Minute
+
+// scorePersister 定期把评分 (默认为 DumpScores 的格式) 原子地写入文件，
+// 并在评分系统关闭时再写一次，使正常退出总能保存最新的状态。
+// 写入是串行的，并且状态 (默认为评分的版本) 自上次写入以来没有变化时跳过，
+// 因此关闭恰好发生在定期写入期间时不会重复写入相同的状态。
+type scorePersister struct {
+	mu          sync.Mutex
+	path        string
+	interval    time.Duration
+	lastVersion uint64
+	flushed     bool
+	version     func() uint64
+	dump        func(w io.Writer) error
+	write       func(filename string, data []byte) error
+	logf        func(level int, msg string, args ...interface{})
+}
+
+func newScorePersister(tracker *ScoreTracker, path string, interval time.Duration,
+	logf func(level int, msg string, args ...interface{})) *scorePersister {
+	if interval <= 0 {
+		interval = defaultPersistInterval
+	}
+	return &scorePersister{
+		path:     path,
+		interval: interval,
+		version:  tracker.Version,
+		dump:     tracker.DumpScores,
+		write:    osutil.WriteFileAtomically,
+		logf:     logf,
+	}
+}
+
+// run 定期保存评分，直到 ctx 被取消。最后一次保存由 flush 在关闭时完成。
+func (sp *scorePersister) run(ctx context.Context) {
+	ticker := time.NewTicker(sp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sp.flush(); err != nil {
+				sp.logf(0, "保存评分失败: %v", err)
+			}
+		}
+	}
+}
+
+// flush 在评分有变化时把评分写入文件
+func (sp *scorePersister) flush() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	version := sp.version()
+	if sp.flushed && version == sp.lastVersion {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := sp.dump(buf); err != nil {
+		return err
+	}
+	if err := sp.write(sp.path, buf.Bytes()); err != nil {
+		return err
+	}
+	sp.lastVersion, sp.flushed = version, true
+	return nil
+}
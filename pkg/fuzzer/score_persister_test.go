@@ -0,0 +1,115 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/corpus"
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys/targets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScorePersisterShutdownFlush(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	sp := newScorePersister(tracker, "scores.json", time.Hour, func(level int, msg string, args ...interface{}) {
+		t.Logf(msg, args...)
+	})
+	var writes []string
+	sp.write = func(filename string, data []byte) error {
+		assert.Equal(t, "scores.json", filename)
+		writes = append(writes, string(data))
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sp.run(ctx)
+		close(done)
+	}()
+
+	score := func(hash string) {
+		tracker.updateScore(hash, false, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime: 1000000,
+		})
+	}
+	score("first")
+	score("latest")
+
+	// 模拟关闭: 取消 ctx 后 (例如收到 SIGTERM) 进行最后一次保存，重复关闭不会重复写入。
+	cancel()
+	<-done
+	assert.NoError(t, sp.flush())
+	assert.NoError(t, sp.flush())
+	assert.Len(t, writes, 1)
+	assert.True(t, strings.Contains(writes[0], "latest"))
+
+	// 之后有新的评分时才再次写入。
+	score("more")
+	assert.NoError(t, sp.flush())
+	assert.Len(t, writes, 2)
+}
+
+func TestShutdownFlushesScoring(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	rnd := rand.New(testutil.RandSource(t))
+	config := DefaultScoreConfig()
+	config.AsyncScoring = true
+	config.StatePath = filepath.Join(dir, "state")
+	config.MetricsPath = filepath.Join(dir, "metrics.json")
+	config.PersistInterval = time.Hour
+	fuzzer := NewFuzzer(ctx, &Config{
+		Corpus:      corpus.NewCorpus(ctx),
+		ScoreConfig: config,
+	}, rnd, target)
+
+	const progs = 20
+	for i := 0; i < progs; i++ {
+		fuzzer.scoreAsync(target.Generate(rnd, 5, target.DefaultChoiceTable()), &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i), uint64(i + 100)}, 0),
+			ExecTime: 1000000,
+		}, false, false)
+	}
+	// 取消 ctx 后所有已入队的评分都处理完毕，评分统计和指标在 Shutdown 返回前写入文件。
+	cancel()
+	assert.NoError(t, fuzzer.Shutdown())
+
+	data, err := os.ReadFile(config.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewScoreTracker(DefaultScoreConfig())
+	assert.NoError(t, restored.Restore(data))
+	assert.Len(t, restored.pcHitCounts, 2*progs)
+
+	data, err = os.ReadFile(config.MetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics struct {
+		Metrics struct {
+			TotalRequests int64 `json:"total_requests"`
+		} `json:"metrics"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &metrics))
+	assert.Equal(t, int64(progs), metrics.Metrics.TotalRequests)
+}
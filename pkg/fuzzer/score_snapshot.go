@@ -0,0 +1,110 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// scoreSnapshotVersion 在快照格式或统计的含义变化时递增，旧版本的快照会被丢弃
+const scoreSnapshotVersion = 3
+
+var errSnapshotVersion = errors.New("incompatible score snapshot version")
+
+// trackerSnapshot 是评分跟踪器的聚合统计，不包括各程序的评分。
+// 程序的评分在重新执行时很快就会重新计算，而稀有性和执行时间基线需要大量执行才能重新积累。
+type trackerSnapshot struct {
+	Version           int
+	PCHitCounts       map[uint64]int64
+	FaultHitCounts    map[uint64]int64
+	RarityExecs       int64
+	SequenceFrequency map[string]int64
+	ExecTimes         timeStatsSnapshot
+	FaultExecTimes    timeStatsSnapshot
+}
+
+type timeStatsSnapshot struct {
+	Samples []uint64
+	Count   int64
+}
+
+// Snapshot 把评分跟踪器的聚合统计序列化为紧凑的二进制格式
+func (st *ScoreTracker) Snapshot() ([]byte, error) {
+	st.mu.RLock()
+	snapshot := &trackerSnapshot{
+		Version:           scoreSnapshotVersion,
+		PCHitCounts:       st.pcHitCounts,
+		FaultHitCounts:    st.faultHitCounts,
+		RarityExecs:       st.rarityExecs,
+		SequenceFrequency: st.sequenceFrequency,
+		ExecTimes:         st.execTimeStats.snapshot(),
+		FaultExecTimes:    st.faultExecTimeStats.snapshot(),
+	}
+	// 编码期间需要持有读锁，否则可能与统计的更新并发访问 map。
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(snapshot)
+	st.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore 用 Snapshot 生成的数据替换评分跟踪器的聚合统计。
+// 数据无法解析或版本不兼容时返回错误，跟踪器保持不变。
+func (st *ScoreTracker) Restore(data []byte) error {
+	snapshot := new(trackerSnapshot)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(snapshot); err != nil {
+		return fmt.Errorf("failed to decode score snapshot: %w", err)
+	}
+	if snapshot.Version != scoreSnapshotVersion {
+		return fmt.Errorf("%w: %v, want %v", errSnapshotVersion, snapshot.Version, scoreSnapshotVersion)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	// 快照可能是在上限更小的配置下保存的，恢复时同样遵守当前的上限。
+	st.pcHitCounts = restoredCounts(snapshot.PCHitCounts, st.config.MaxTrackedPCs)
+	st.faultHitCounts = restoredCounts(snapshot.FaultHitCounts, st.config.MaxTrackedPCs)
+	st.rarityExecs = snapshot.RarityExecs
+	st.sequenceFrequency = restoredCounts(snapshot.SequenceFrequency, st.config.MaxTrackedSequences)
+	st.execTimeStats.restore(snapshot.ExecTimes)
+	st.faultExecTimeStats.restore(snapshot.FaultExecTimes)
+	return nil
+}
+
+// restoredCounts 返回非 nil 的计数，超过 limit 的部分随机丢弃 (limit <= 0 表示不限制)
+func restoredCounts[K comparable](counts map[K]int64, limit int) map[K]int64 {
+	if counts == nil {
+		return make(map[K]int64)
+	}
+	for key := range counts {
+		if limit <= 0 || len(counts) <= limit {
+			break
+		}
+		delete(counts, key)
+	}
+	return counts
+}
+
+// restoreScoreSnapshot 从文件恢复评分统计。文件不存在时什么也不做，
+// 其他错误 (包括版本不兼容) 只记录日志并丢弃快照，评分系统从头开始积累统计。
+func restoreScoreSnapshot(tracker *ScoreTracker, path string,
+	logf func(level int, msg string, args ...interface{})) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = tracker.Restore(data)
+	}
+	if err != nil {
+		logf(0, "丢弃评分统计快照 %v: %v", path, err)
+		return
+	}
+	logf(0, "从 %v 恢复了评分统计 (%v 个 PC)", path, tracker.DistinctPCs())
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreSnapshotRoundTrip(t *testing.T) {
+	config := DefaultScoreConfig()
+	config.FaultInjectionLane = true
+	tracker := NewScoreTracker(config)
+	for i := 0; i < 20; i++ {
+		tracker.updateScore(fmt.Sprintf("prog%v", i), i%5 == 0, &ExecutionResult{
+			Signal:       signal.FromRaw([]uint64{uint64(i % 7), uint64(i%7 + 100)}, 0),
+			ExecTime:     uint64(1000000 + i*1000),
+			CallSequence: []string{"open", fmt.Sprintf("read%v", i%3)},
+		})
+	}
+	data, err := tracker.Snapshot()
+	assert.NoError(t, err)
+
+	restored := NewScoreTracker(config)
+	assert.NoError(t, restored.Restore(data))
+	assert.Equal(t, tracker.pcHitCounts, restored.pcHitCounts)
+	assert.Equal(t, tracker.faultHitCounts, restored.faultHitCounts)
+	assert.Equal(t, tracker.sequenceFrequency, restored.sequenceFrequency)
+	assert.Equal(t, tracker.execTimeStats.snapshot(), restored.execTimeStats.snapshot())
+	assert.Equal(t, tracker.faultExecTimeStats.snapshot(), restored.faultExecTimeStats.snapshot())
+	mean, stdDev, count := tracker.execTimeStats.GetStats()
+	restoredMean, restoredStdDev, restoredCount := restored.execTimeStats.GetStats()
+	assert.Equal(t, mean, restoredMean)
+	assert.Equal(t, stdDev, restoredStdDev)
+	assert.Equal(t, count, restoredCount)
+	// 程序的评分不在快照中。
+	assert.Equal(t, 0, restored.TrackedProgs())
+
+	// 恢复后继续积累统计，而不是从头开始。
+	result := &ExecutionResult{Signal: signal.FromRaw([]uint64{1, 101}, 0), ExecTime: 1000000}
+	assert.Equal(t, tracker.updateScore("next", false, result).Rarity,
+		restored.updateScore("next", false, result).Rarity)
+}
+
+func TestScoreSnapshotIncompatible(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	tracker.updateScore("prog", false, &ExecutionResult{Signal: signal.FromRaw([]uint64{1, 2, 3}, 0)})
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, gob.NewEncoder(buf).Encode(&trackerSnapshot{
+		Version:     scoreSnapshotVersion + 1,
+		PCHitCounts: map[uint64]int64{10: 1},
+	}))
+	assert.ErrorIs(t, tracker.Restore(buf.Bytes()), errSnapshotVersion)
+	assert.Error(t, tracker.Restore([]byte("garbage")))
+	assert.Equal(t, 3, tracker.DistinctPCs())
+
+	// NewFuzzer 丢弃无法使用的快照，评分系统照常工作。
+	path := filepath.Join(t.TempDir(), "state")
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	var logs []string
+	logf := func(level int, msg string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(msg, args...))
+	}
+	fresh := NewScoreTracker(DefaultScoreConfig())
+	restoreScoreSnapshot(fresh, path, logf)
+	assert.Equal(t, 0, fresh.DistinctPCs())
+	assert.Len(t, logs, 1)
+
+	// 文件不存在时什么也不做。
+	restoreScoreSnapshot(fresh, filepath.Join(t.TempDir(), "missing"), logf)
+	assert.Len(t, logs, 1)
+}
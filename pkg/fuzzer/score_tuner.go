@@ -0,0 +1,131 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultAutoTuneInterval = 10 * time.Minute
+	defaultAutoTuneStep     = 0.02
+	defaultAutoTuneMaxShift = 0.2
+)
+
+// scoreTuner 根据语料库增长的反馈在线调整各维度的权重 (不需要梯度)。
+// 观察窗口交替进行: 基准窗口使用当前权重，试探窗口把一个随机维度的权重增加或减少 step。
+// 试探窗口的增长超过之前基准窗口的增长时接受试探的权重，否则恢复当前权重。
+// 归一化后每个权重与初始值的偏差都不超过 maxShift (超出时放弃这次试探)，
+// 因此调整总是保守的，不会让评分偏离配置太远。
+type scoreTuner struct {
+	initial    []float64
+	current    []float64
+	trial      []float64
+	tunable    []bool
+	step       float64
+	maxShift   float64
+	baseGrowth float64
+	rnd        *rand.Rand
+}
+
+func newScoreTuner(weights []float64, tunable []bool, step, maxShift float64, rnd *rand.Rand) *scoreTuner {
+	if step <= 0 {
+		step = defaultAutoTuneStep
+	}
+	if maxShift <= 0 {
+		maxShift = defaultAutoTuneMaxShift
+	}
+	return &scoreTuner{
+		initial:  append([]float64(nil), weights...),
+		current:  append([]float64(nil), weights...),
+		tunable:  tunable,
+		step:     step,
+		maxShift: maxShift,
+		rnd:      rnd,
+	}
+}
+
+// next 接收刚结束的窗口中的增长，返回下一个窗口使用的权重
+func (tuner *scoreTuner) next(growth float64) []float64 {
+	if tuner.trial != nil {
+		if growth > tuner.baseGrowth {
+			tuner.current = tuner.trial
+		}
+		tuner.trial = nil
+		return tuner.current
+	}
+	tuner.baseGrowth = growth
+	var dims []int
+	for i, tunable := range tuner.tunable {
+		if tunable {
+			dims = append(dims, i)
+		}
+	}
+	if len(dims) < 2 {
+		// 只有一个维度时归一化后权重不会变化。
+		return tuner.current
+	}
+	dim := dims[tuner.rnd.Intn(len(dims))]
+	delta := tuner.step
+	if tuner.rnd.Intn(2) == 0 {
+		delta = -delta
+	}
+	trial := append([]float64(nil), tuner.current...)
+	trial[dim] = max(trial[dim]+delta, 0)
+	sum := 0.0
+	for _, w := range trial {
+		sum += w
+	}
+	if sum <= 0 {
+		return tuner.current
+	}
+	for i := range trial {
+		trial[i] /= sum
+		if math.Abs(trial[i]-tuner.initial[i]) > tuner.maxShift {
+			return tuner.current
+		}
+	}
+	tuner.trial = trial
+	return trial
+}
+
+// tuneScoreWeights 定期根据每次执行带来的语料库信号增长调整评分权重，直到 ctx 被取消
+func (fuzzer *Fuzzer) tuneScoreWeights(ctx context.Context) {
+	config := fuzzer.scoreConfig()
+	interval := config.AutoTuneInterval
+	if interval <= 0 {
+		interval = defaultAutoTuneInterval
+	}
+	disabled := config.disabled()
+	tunable := make([]bool, len(disabled))
+	for i := range disabled {
+		tunable[i] = !disabled[i]
+	}
+	tuner := newScoreTuner(config.weights(), tunable, config.AutoTuneStep, config.AutoTuneMaxShift,
+		rand.New(rand.NewSource(time.Now().UnixNano())))
+	execs := func() int {
+		return fuzzer.statExecFuzz.Val() + fuzzer.statExecGenerate.Val()
+	}
+	lastSignal, lastExecs := fuzzer.Config.Corpus.StatSignal.Val(), execs()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		curSignal, curExecs := fuzzer.Config.Corpus.StatSignal.Val(), execs()
+		growth := float64(curSignal-lastSignal) / float64(max(curExecs-lastExecs, 1))
+		lastSignal, lastExecs = curSignal, curExecs
+		// 复制配置而不是就地修改，正在使用旧配置的读者不会看到修改了一半的权重。
+		updated := *fuzzer.scoreConfig()
+		updated.setWeights(tuner.next(growth))
+		fuzzer.UpdateScoreConfig(&updated)
+		fuzzer.Logf(1, "评分权重自动调整: %v (增长 %.6f)", updated.weights(), growth)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreTunerFollowsGrowth(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	initial := []float64{0.25, 0.25, 0.25, 0.25, 0}
+	tunable := []bool{true, true, true, true, false}
+	tuner := newScoreTuner(initial, tunable, 0.02, 0.2, rnd)
+
+	// 合成环境: 语料库的增长只取决于稀有性维度的权重，另有少量噪声。
+	weights := initial
+	for window := 0; window < 400; window++ {
+		growth := weights[1] + rnd.Float64()*0.001
+		weights = tuner.next(growth)
+
+		sum := 0.0
+		for i, w := range weights {
+			sum += w
+			assert.GreaterOrEqual(t, w, 0.0)
+			assert.LessOrEqual(t, w, 1.0)
+			if !tunable[i] {
+				assert.Zero(t, w)
+			}
+		}
+		assert.InDelta(t, 1.0, sum, 1e-9)
+	}
+	assert.Greater(t, weights[1], initial[1]+0.1)
+	// 权重的调整是有界的。
+	for i := range weights {
+		assert.LessOrEqual(t, math.Abs(weights[i]-initial[i]), 0.2, "dimension %v", i)
+	}
+}
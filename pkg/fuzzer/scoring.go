@@ -4,10 +4,25 @@
 package fuzzer
 
 import (
+	"cmp"
+	"container/heap"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/log"
 	"github.com/google/syzkaller/pkg/signal"
 	"github.com/google/syzkaller/prog"
 )
@@ -22,21 +37,423 @@ type ScoreConfig struct {
 	KernelLogWeight float64 `json:"kernel_log_weight"`
 	// 执行时间异常权重 (0.0-1.0)
 	TimeAnomalyWeight float64 `json:"time_anomaly_weight"`
+	// 系统调用序列新颖性权重 (0.0-1.0)，默认为 0，即只记录该维度的分数而不计入总分
+	SequenceWeight float64 `json:"sequence_weight"`
+	// 关闭的维度完全不参与评分: 不计算分数、不更新其统计基线，也不参与权重归一化。
+	// 与之不同，权重为 0 的维度仍然计算并记录在 ProgScore 中，只是不计入总分。
+	// 使用关闭而不是启用标志，使没有这些字段的旧配置保持所有维度启用。
+	DisableCoverage    bool `json:"disable_coverage"`
+	DisableRarity      bool `json:"disable_rarity"`
+	DisableKernelLog   bool `json:"disable_kernel_log"`
+	DisableTimeAnomaly bool `json:"disable_time_anomaly"`
+	DisableSequence    bool `json:"disable_sequence"`
+	// 权重总和不为 1 (例如 0.5/0.5/0.5/0.5 这样只表示相对重要性的权重) 时，Validate 不再返回错误，
+	// 而是在使用前按比例归一化使总和为 1，并记录一条警告。归一化前配置的权重可通过 RawWeights 获取。
+	// 不启用时 (默认) Validate 对这样的配置返回错误，创建 fuzzer 时仍会归一化并记录警告。
+	AutoNormalize bool `json:"auto_normalize"`
+	// 执行时间异常分数的计分方向 (空表示快慢两个方向同等计分)，见 TimeAnomalyMode
+	TimeAnomalyMode TimeAnomalyMode `json:"time_anomaly_mode"`
 	// 是否启用评分系统
 	Enabled bool `json:"enabled"`
+	// 也为 triage (deflake) 和候选程序 (包括语料库重新 triage) 的执行评分。
+	// 这些执行重复运行已知的程序，评分的收益远小于新的 fuzz 执行，默认跳过以节省开销。
+	// 尚无评分 (从未评分或已失效) 的候选程序总是评分。
+	ScoreTriageExecutions bool `json:"score_triage_executions"`
+	// 最多跟踪的程序评分数量，超过后淘汰最久未更新的评分 (0 表示不限制)
+	MaxTrackedProgs int `json:"max_tracked_progs"`
+	// 最多记录的不同系统调用序列数量，超过后随机淘汰已记录的序列 (0 表示不限制)
+	MaxTrackedSequences int `json:"max_tracked_sequences"`
+	// 最多记录命中次数的不同 PC 数量，超过后随机淘汰已记录的 PC (0 表示不限制)。
+	// 被淘汰的 PC 再次出现时会重新被当作新覆盖，覆盖率分数因此略微偏高，但不会失效。
+	MaxTrackedPCs int `json:"max_tracked_pcs"`
+	// Deprecated: 稀有性改为按信号元素统计后不再记录路径频率，该字段被忽略，
+	// 只为兼容已有的配置文件而保留。信号元素的数量由 MaxTrackedPCs 限制。
+	MaxTrackedPaths int `json:"max_tracked_paths"`
+	// 全新的 PC 同时让覆盖率和稀有性得高分，两个维度会重复奖励同一份新颖性。
+	// 启用后新颖性只记入覆盖率维度，稀有性分数按信号中已见过的 PC 占比折算，
+	// 因此完全由新 PC 组成的路径稀有性为 0。
+	DecorrelateNovelty bool `json:"decorrelate_novelty"`
+	// 在后台 goroutine 中计算评分，避免阻塞结果处理。默认在处理结果时同步评分。
+	// 后台评分队列满时结果处理等待队列中有空位，评分系统关闭后到达的执行结果不再评分
+	// (见 ScoringHealth 的 SamplingFraction)。
+	AsyncScoring bool `json:"async_scoring"`
+	// 后台评分的 goroutine 数量 (0 表示 1 个)，只在启用 AsyncScoring 时使用
+	AsyncScoringWorkers int `json:"async_scoring_workers"`
+	// genFuzz 中新生成程序的最低比例，长期低于该值时强制生成一批新程序 (0 表示不限制，默认不限制)
+	MinGenerateRatio float64 `json:"min_generate_ratio"`
+	// 内核日志已知崩溃去重记住的最近标题数量，已知标题不再计入内核日志分数 (0 表示不去重)
+	KnownTitleCacheSize int `json:"known_title_cache_size"`
+	// 内核日志模式文件 (格式见 LoadPatternsFromJSON)，只在创建评分跟踪器时读取。
+	// 与内置模式描述相同的模式替换内置模式 (可用于调整分数)，其余的追加在内置模式之后。
+	// 文件无法读取或包含无效的模式时记录错误并只使用内置模式 (空表示只使用内置模式)。
+	KernelLogPatternsFile string `json:"kernel_log_patterns_file"`
+	// 内核日志多模式加分最多计入的不同模式数量 (0 表示使用默认值 3)
+	MaxBonusPatterns int `json:"max_bonus_patterns"`
+	// 总分不低于该值的程序经加权选择变异后的请求标记为 Important (0 表示不标记，默认不标记)
+	ImportantScoreThreshold float64 `json:"important_score_threshold"`
+	// 同一程序两次 smash 之间的最短间隔，用于分散 smash 的注意力 (0 表示不限制，默认不限制)。
+	// 记录 smash 历史的程序数量受 MaxTrackedProgs 限制，超出时优先淘汰冷却期已过的程序。
+	SmashCooldown time.Duration `json:"smash_cooldown"`
+	// smash 去重: 最近 SmashDedupWindow 内已经 smash 过稳定信号相同、总分相差不超过该值的程序时，
+	// 不再 smash 新的程序，避免对只有细微差别的程序重复 smash (0 表示不去重，默认关闭)
+	SmashDedupScoreDelta float64 `json:"smash_dedup_score_delta"`
+	// smash 去重记住已 smash 的稳定信号的时间，数量仍受 MaxTrackedProgs 限制 (0 表示不去重，默认关闭)
+	SmashDedupWindow time.Duration `json:"smash_dedup_window"`
+	// 故障注入 (设置了 FailNth) 的执行行为被人为改变，默认不更新任何基线:
+	// PC 命中次数 (覆盖率和稀有性共用)、执行时间和调用序列频率。
+	// 启用后这些执行在独立的基线 (信号元素的命中次数和执行时间) 中评分并更新该基线。
+	FaultInjectionLane bool `json:"fault_injection_lane"`
+	// 进化式 smash: 评分最高的变异体成为后续变异的基准，而不是总从原始程序变异
+	EvolutionarySmash bool `json:"evolutionary_smash"`
+	// extra (-1) 信号桶可能包含与程序无关的后台/中断覆盖。
+	// 启用后稀有性 (以及可选的覆盖率) 只根据各调用自身的信号计算。
+	ExcludeExtraRarity   bool `json:"exclude_extra_rarity"`
+	ExcludeExtraCoverage bool `json:"exclude_extra_coverage"`
+	// 评分信号 (ExecutionResult.Signal) 是否同时包含各调用的信号。默认只包含 extra 信号，
+	// 与引入该选项之前的行为一致。ExcludeExtraRarity/ExcludeExtraCoverage 总是使用各调用的信号，不受该选项影响。
+	CallSignalScoring bool `json:"call_signal_scoring"`
+	// 不参与评分的系统调用 (完整名称如 "ioctl$FOO"，或不带变体的名称如 "ioctl" 以排除所有变体)。
+	// 某些调用 (例如 nanosleep 一类或已知有噪声的调用) 产生虚假的时间异常和不稳定的信号，抬高了评分，
+	// 使 smash 队列在它们上面浪费时间。包含这些调用的程序总是得到中等分数 (0.5)，不会被优先 smash，
+	// 它们的执行也不更新评分统计。与 NoMutateCalls 不同，它只影响评分而不影响变异。
+	ScoreExcludedCalls map[string]bool `json:"score_excluded_calls"`
+	// 稀有性维度的预热执行数: 刚启动时 PC 命中次数还是空的，几乎每个执行的信号都是"从未见过"的而得到满分。
+	// 计入稀有性基线的执行数达到该值之前，稀有性分数按已计入的比例从中等分数 (0.5) 逐渐过渡到实际分数，
+	// 第一个执行得到中等分数，达到该值之后不再衰减 (0 表示不预热)。与执行时间基线不足 10 个样本时
+	// 不计算异常分数类似，但逐渐过渡而不是突然生效。从 StatePath 恢复的统计包括已计入的执行数。
+	RarityWarmupExecs int64 `json:"rarity_warmup_execs"`
+	// smash 作业的迭代次数范围，由基准程序的评分映射到 [MinSmashIters, MaxSmashIters]
+	MinSmashIters int `json:"min_smash_iters"`
+	MaxSmashIters int `json:"max_smash_iters"`
+	// 评分到迭代次数的映射方式: "linear" (默认) 按评分线性插值;
+	// "step" 评分低于 0.5 时取最小值，否则取最大值
+	SmashItersMapping string `json:"smash_iters_mapping"`
+	// smash 时基准评分高于 ConservativeThreshold 的程序保守变异，低于 AggressiveThreshold 的程序激进变异，
+	// 其余的使用标准变异 (两者都为 0 时使用默认的 0.7 和 0.3)
+	ConservativeThreshold float64 `json:"conservative_threshold"`
+	AggressiveThreshold   float64 `json:"aggressive_threshold"`
+	// 低分程序激进变异时的基础变异次数范围 (未配置时为 2-4)
+	AggressiveMinOps int `json:"aggressive_min_ops"`
+	AggressiveMaxOps int `json:"aggressive_max_ops"`
+	// 激进变异后额外打乱程序结构/复制调用的概率 (0 表示不执行)。
+	// prog 不能在保持资源引用有效的前提下重排调用，因此"打乱"是把语料库中另一个程序拼接进来，
+	// "复制"是把程序自身的副本拼接进来 (同时复制被依赖的资源创建调用)。
+	AggressiveShuffleProb   float64 `json:"aggressive_shuffle_prob"`
+	AggressiveDuplicateProb float64 `json:"aggressive_duplicate_prob"`
+	// 启用 FetchRawCover 时只为总分不低于该值的程序收集原始覆盖，节省低分程序的带宽 (0 表示全部收集)
+	RawCoverScoreThreshold float64 `json:"raw_cover_score_threshold"`
+	// 总分不低于该值的程序保守地最小化: 所有被 triage 的调用的新信号都必须保留 (0 表示不启用)
+	ConservativeMinimizeThreshold float64 `json:"conservative_minimize_threshold"`
+	// 生成新程序时使用反坍缩探索选择表的概率，该选择表只包含高分程序中最少出现的系统调用 (0 表示不启用)
+	ExploreRate float64 `json:"explore_rate"`
+	// 总分不低于该值的 fuzz 程序也在优先级更高的候选 triage 队列中 triage (0 表示只按来源区分)
+	PriorityTriageThreshold float64 `json:"priority_triage_threshold"`
+	// 总分不低于该值的程序 deflake 时优先在最初发现新信号的执行器上重复运行以确认在该配置上可复现，
+	// 而不是分散到其他 VM (0 表示总是分散)
+	DeflakeOriginalExecutorThreshold float64 `json:"deflake_original_executor_threshold"`
+	// 启用 fuzzer 的故障注入时，先向触发新信号的调用注入故障，再按调用覆盖的稀有程度依次向新语料库程序的
+	// 其余调用注入故障，而不是只向触发新信号的调用逐个注入 (默认关闭，评分系统关闭时总是使用后者)
+	RareFaultInjection bool `json:"rare_fault_injection"`
+	// 稀有优先的故障注入找到这么多带来新信号的执行后提前结束 (0 表示不提前结束)
+	FaultInjectionNovelLimit int `json:"fault_injection_novel_limit"`
+	// 定期保存评分的文件 (空表示不保存)，评分系统关闭时 (fuzzer 的 ctx 被取消) 也会保存一次
+	PersistPath string `json:"persist_path"`
+	// 保存评分统计 (PC 命中次数、序列频率、执行时间基线，不包括各程序的评分) 的文件 (空表示不保存)。
+	// 启动时从该文件恢复统计，版本不兼容的快照被丢弃；之后与评分一样定期以及在关闭时保存。
+	StatePath string `json:"state_path"`
+	// 保存评分指标 (flatrpc.ScoreMetrics.MarshalSnapshot 的 JSON 文档) 的文件 (空表示不保存)，
+	// 与评分一样定期以及在关闭时保存
+	MetricsPath string `json:"metrics_path"`
+	// 定期保存评分、评分统计和评分指标的间隔 (0 表示默认的 10 分钟)
+	PersistInterval time.Duration `json:"persist_interval"`
+	// 记录各维度的计算耗时并累加到评分指标中 (调试用，每个维度有额外的计时开销)
+	ProfileDimensions bool `json:"profile_dimensions"`
+	// 根据语料库信号增长在线微调各维度的权重
+	AutoTune bool `json:"auto_tune"`
+	// 每个观察窗口的长度 (0 表示默认的 10 分钟)
+	AutoTuneInterval time.Duration `json:"auto_tune_interval"`
+	// 每次试探调整的权重步长 (0 表示默认的 0.02)
+	AutoTuneStep float64 `json:"auto_tune_step"`
+	// 权重与初始配置的最大偏差 (0 表示默认的 0.2)
+	AutoTuneMaxShift float64 `json:"auto_tune_max_shift"`
+	// 每个新生成或变异的程序评分后产生一个变异体，以该程序的评分为权重放入加权队列。
+	// 加权队列在 smash 队列之后、生成新程序之前被轮询，评分高的变异体更早执行；
+	// 加权队列为空时照常生成新程序。加权队列产生的变异体不再产生后代。
+	WeightedQueue bool `json:"weighted_queue"`
+	// 加权队列的最大长度，队列满时不再加入变异体 (0 表示默认的 1000)
+	WeightedQueueSize int `json:"weighted_queue_size"`
+	// 评分向中性分数衰减的半衰期: 早期因覆盖新颖而得到的高分随时间降低，
+	// 避免加权选择器长期偏向覆盖早已变得常见的程序 (0 表示不衰减，默认不衰减)
+	DecayHalfLife time.Duration `json:"decay_half_life"`
+	// 评分衰减的执行间隔 (0 表示默认的 1 分钟)
+	DecayInterval time.Duration `json:"decay_interval"`
+	// 确定性模式，用于需要逐位复现的对比实验: 所有基于评分的选择按排序后的顺序遍历，
+	// 并从同一个带种子 (由 fuzzer 的随机数源派生) 的随机数流取随机数，而不是各 goroutine 各自的随机数。
+	// 使用该随机数流的有: 语料库程序的加权选择、高分程序的选择、加权队列变异体的变异，
+	// 以及加权队列本身 (它的随机数流由评分系统的随机数流派生)。稀有优先的故障注入
+	// (见 ScoreTracker.rareCallOrder) 不使用随机数，相同的统计总是得到相同的顺序。
+	// 这些选择因此必须串行执行，会损失部分吞吐量。执行结果本身 (以及 AsyncScoring 的评分顺序) 仍可能不同。
+	Deterministic bool `json:"deterministic"`
+
+	// 最近一次归一化前配置的各维度权重 (顺序与 configuredWeights 相同)，从未缩放过时为 nil
+	rawWeights []float64
 }
 
 // DefaultScoreConfig 返回默认的评分配置
+// 权重总是经过归一化，即使以后修改了这里的数值，总和也保持为 1。
 func DefaultScoreConfig() *ScoreConfig {
-	return &ScoreConfig{
-		CoverageWeight:    0.4,
-		RarityWeight:      0.3,
-		KernelLogWeight:   0.2,
-		TimeAnomalyWeight: 0.1,
-		Enabled:           true,
+	config := &ScoreConfig{
+		CoverageWeight:           0.4,
+		RarityWeight:             0.3,
+		KernelLogWeight:          0.2,
+		TimeAnomalyWeight:        0.1,
+		Enabled:                  true,
+		MaxTrackedProgs:          100000,
+		MaxTrackedSequences:      100000,
+		MaxTrackedPCs:            1000000,
+		MaxBonusPatterns:         defaultMaxBonusPatterns,
+		MinSmashIters:            defaultMinSmashIters,
+		MaxSmashIters:            defaultMaxSmashIters,
+		SmashItersMapping:        "linear",
+		ConservativeThreshold:    defaultConservativeThreshold,
+		AggressiveThreshold:      defaultAggressiveThreshold,
+		AggressiveMinOps:         defaultAggressiveMinOps,
+		AggressiveMaxOps:         defaultAggressiveMaxOps,
+		AggressiveShuffleProb:    1.0 / 3,
+		AggressiveDuplicateProb:  1.0 / 4,
+		FaultInjectionNovelLimit: 5,
+	}
+	config.Normalize()
+	return config
+}
+
+// Normalize 按比例缩放启用的维度的权重，使其总和为 1。
+// 关闭的维度的权重保持不变，权重总和不为正时不做任何修改。
+// 归一化前的权重被保存下来，见 RawWeights。
+func (sc *ScoreConfig) Normalize() {
+	sum := sc.weightSum()
+	if sum <= 0 {
+		return
+	}
+	if math.Abs(sum-1) > weightSumEpsilon {
+		sc.rawWeights = sc.configuredWeights()
+	}
+	disabled := sc.disabled()
+	for i, w := range []*float64{&sc.CoverageWeight, &sc.RarityWeight, &sc.KernelLogWeight, &sc.TimeAnomalyWeight,
+		&sc.SequenceWeight} {
+		if !disabled[i] {
+			*w /= sum
+		}
+	}
+}
+
+// RawWeights 返回最近一次归一化前配置的各维度权重 (覆盖率、稀有性、内核日志、时间异常、序列新颖性)。
+// 权重从未被缩放过时返回当前的权重。
+func (sc *ScoreConfig) RawWeights() []float64 {
+	if sc.rawWeights != nil {
+		return slices.Clone(sc.rawWeights)
+	}
+	return sc.configuredWeights()
+}
+
+// configuredWeights 返回各维度配置的权重，包括关闭的维度
+func (sc *ScoreConfig) configuredWeights() []float64 {
+	return []float64{sc.CoverageWeight, sc.RarityWeight, sc.KernelLogWeight, sc.TimeAnomalyWeight,
+		sc.SequenceWeight}
+}
+
+// weightSum 返回启用的维度的权重总和
+func (sc *ScoreConfig) weightSum() float64 {
+	sum := 0.0
+	for _, w := range sc.weights() {
+		sum += w
+	}
+	return sum
+}
+
+// weightSumEpsilon 检查权重总和是否为 1 时允许的误差
+const weightSumEpsilon = 1e-6
+
+// errWeightsNotNormalized 表示权重本身有效，只是总和不为 1
+var errWeightsNotNormalized = errors.New("评分权重总和不为 1")
+
+// Validate 检查评分配置: 每个维度的权重必须在 [0, 1] 范围内；
+// 启用评分时启用的维度的权重不能全为 0，且总和应为 1 (否则返回包装了 errWeightsNotNormalized 的错误)。
+// 启用 AutoNormalize 时总和不为 1 不是错误，配置在使用前被归一化 (并记录警告)。
+func (sc *ScoreConfig) Validate() error {
+	err := sc.validate()
+	if sc.AutoNormalize && errors.Is(err, errWeightsNotNormalized) {
+		return nil
+	}
+	return err
+}
+
+// validate 与 Validate 相同，但总是对总和不为 1 的权重返回错误
+func (sc *ScoreConfig) validate() error {
+	for i, w := range sc.configuredWeights() {
+		if !(w >= 0 && w <= 1) {
+			return fmt.Errorf("维度 %v 的权重 %v 超出 [0, 1] 范围", scoreDimensionNames[i], w)
+		}
+	}
+	if sc.AsyncScoringWorkers < 0 {
+		return fmt.Errorf("后台评分的 goroutine 数量 %v 不能为负数", sc.AsyncScoringWorkers)
+	}
+	if sc.RarityWarmupExecs < 0 {
+		return fmt.Errorf("稀有性预热执行数 %v 不能为负数", sc.RarityWarmupExecs)
+	}
+	if sc.FaultInjectionNovelLimit < 0 {
+		return fmt.Errorf("故障注入的新信号执行数量上限 %v 不能为负数", sc.FaultInjectionNovelLimit)
+	}
+	if !(sc.SmashDedupScoreDelta >= 0 && sc.SmashDedupScoreDelta <= 1) || sc.SmashDedupWindow < 0 {
+		return fmt.Errorf("smash 去重的分数差 %v 超出 [0, 1] 范围或时间 %v 为负数",
+			sc.SmashDedupScoreDelta, sc.SmashDedupWindow)
+	}
+	if sc.DecayHalfLife < 0 || sc.DecayInterval < 0 {
+		return fmt.Errorf("评分衰减的半衰期 %v 和间隔 %v 不能为负数", sc.DecayHalfLife, sc.DecayInterval)
+	}
+	if !sc.TimeAnomalyMode.valid() {
+		return fmt.Errorf("未知的执行时间异常模式 %q", sc.TimeAnomalyMode)
+	}
+	if sc.MinSmashIters < 0 || sc.MaxSmashIters < 0 {
+		return fmt.Errorf("smash 迭代次数范围 %v-%v 不能为负数", sc.MinSmashIters, sc.MaxSmashIters)
+	}
+	if sc.MaxSmashIters > 0 && sc.MinSmashIters > sc.MaxSmashIters {
+		return fmt.Errorf("smash 最小迭代次数 %v 大于最大迭代次数 %v", sc.MinSmashIters, sc.MaxSmashIters)
+	}
+	for _, threshold := range []float64{sc.ConservativeThreshold, sc.AggressiveThreshold} {
+		if !(threshold >= 0 && threshold <= 1) {
+			return fmt.Errorf("smash 变异策略阈值 %v 超出 [0, 1] 范围", threshold)
+		}
+	}
+	if sc.AggressiveThreshold > sc.ConservativeThreshold {
+		return fmt.Errorf("激进变异阈值 %v 大于保守变异阈值 %v", sc.AggressiveThreshold, sc.ConservativeThreshold)
+	}
+	if !sc.Enabled {
+		return nil
+	}
+	sum := sc.weightSum()
+	if sum == 0 {
+		return errors.New("评分已启用，但启用的维度的权重全为 0")
+	}
+	if math.Abs(sum-1) > weightSumEpsilon {
+		return fmt.Errorf("%w: %v", errWeightsNotNormalized, sum)
+	}
+	return nil
+}
+
+// validatedScoreConfig 检查评分配置并记录警告: 只是权重总和不为 1 时就地归一化，
+// 其他错误时使用默认配置，避免用无效的配置计算出无意义的总分。
+func validatedScoreConfig(config *ScoreConfig, logf func(level int, msg string, args ...interface{})) *ScoreConfig {
+	config, err := checkedScoreConfig(config, logf)
+	if err != nil {
+		logf(0, "评分配置无效: %v，使用默认配置", err)
+		return DefaultScoreConfig()
+	}
+	return config
+}
+
+// checkedScoreConfig 与 validatedScoreConfig 相同，但对无效的配置返回错误而不是使用默认配置
+func checkedScoreConfig(config *ScoreConfig, logf func(level int, msg string, args ...interface{})) (
+	*ScoreConfig, error) {
+	err := config.validate()
+	switch {
+	case err == nil:
+		return config, nil
+	case errors.Is(err, errWeightsNotNormalized):
+		logf(0, "%v，按比例归一化权重", err)
+		config.Normalize()
+		return config, nil
+	default:
+		return nil, err
+	}
+}
+
+const (
+	defaultMinSmashIters    = 15
+	defaultMaxSmashIters    = 50
+	defaultAggressiveMinOps = 2
+	defaultAggressiveMaxOps = 4
+
+	defaultConservativeThreshold = 0.7
+	defaultAggressiveThreshold   = 0.3
+)
+
+// smashStrategy 是 smash 时使用的变异策略
+type smashStrategy int
+
+const (
+	smashStandard smashStrategy = iota
+	smashConservative
+	smashAggressive
+)
+
+func (s smashStrategy) String() string {
+	switch s {
+	case smashConservative:
+		return "conservative"
+	case smashAggressive:
+		return "aggressive"
+	default:
+		return "standard"
+	}
+}
+
+// smashStrategy 根据基准程序的评分选择变异策略，没有配置阈值时使用默认阈值
+func (sc *ScoreConfig) smashStrategy(score float64) smashStrategy {
+	conservative, aggressive := sc.ConservativeThreshold, sc.AggressiveThreshold
+	if conservative == 0 && aggressive == 0 {
+		conservative, aggressive = defaultConservativeThreshold, defaultAggressiveThreshold
+	}
+	switch {
+	case score > conservative:
+		return smashConservative
+	case score < aggressive:
+		return smashAggressive
+	default:
+		return smashStandard
 	}
 }
 
+// aggressiveOps 返回激进变异的基础变异次数范围，未配置时使用默认范围
+func (sc *ScoreConfig) aggressiveOps() (int, int) {
+	lo, hi := max(sc.AggressiveMinOps, 0), max(sc.AggressiveMaxOps, sc.AggressiveMinOps)
+	if hi <= 0 {
+		return defaultAggressiveMinOps, defaultAggressiveMaxOps
+	}
+	return lo, hi
+}
+
+// smashIters 根据基准程序的评分计算 smash 迭代次数，评分先被限制在 0-1 范围内。
+// 没有配置迭代次数范围 (例如旧的配置文件) 时使用默认范围。
+func (sc *ScoreConfig) smashIters(score float64) int {
+	score = min(max(score, 0), 1)
+	lo, hi := sc.MinSmashIters, max(sc.MaxSmashIters, sc.MinSmashIters)
+	if hi <= 0 {
+		lo, hi = defaultMinSmashIters, defaultMaxSmashIters
+	}
+	if sc.SmashItersMapping == "step" {
+		if score < 0.5 {
+			return lo
+		}
+		return hi
+	}
+	return lo + int(score*float64(hi-lo))
+}
+
+// weightedTotal 计算各维度分数的加权平均值。
+// 按权重总和归一化，因此未归一化的配置也能得到 0-1 范围内的总分。
+func (sc *ScoreConfig) weightedTotal(dimensions []float64) float64 {
+	total, sum := 0.0, 0.0
+	for i, w := range sc.weights() {
+		total += w * dimensions[i]
+		sum += w
+	}
+	if sum <= 0 {
+		return 0
+	}
+	return total / sum
+}
+
 // ProgScore 表示程序的综合评分
 type ProgScore struct {
 	// 总分 (0.0-1.0)
@@ -49,8 +466,45 @@ type ProgScore struct {
 	KernelLog float64 `json:"kernel_log"`
 	// 执行时间异常分数 (0.0-1.0)
 	TimeAnomaly float64 `json:"time_anomaly"`
+	// 系统调用序列新颖性分数 (0.0-1.0)
+	Sequence float64 `json:"sequence"`
 	// 评分时间戳
 	Timestamp time.Time `json:"timestamp"`
+	// 程序是 hints 变异产生的，并且执行时发现了新信号。
+	// 重新评分时保留该标记，加权选择器据此提高程序的权重。
+	HintNewSignal bool `json:"hint_new_signal,omitempty"`
+	// 程序是故障注入作业产生的，并且执行时发现了新信号。与 HintNewSignal 一样在重新评分时保留。
+	FaultNewSignal bool `json:"fault_new_signal,omitempty"`
+	// 覆盖率、稀有性、内核日志、时间异常和调用序列维度的计算耗时，只在启用 ProfileDimensions 时记录
+	dimensionTimes [5]time.Duration
+	// 上次衰减的时间，下次衰减从该时间 (没有衰减过时从 Timestamp) 开始计算
+	decayedAt time.Time
+}
+
+// Compare 比较两个评分，返回 -1、0 或 1。
+// 先比较总分；总分相同时依次比较内核日志、覆盖率、稀有性、时间异常和序列新颖性分数，
+// 最后比较评分时间 (较早的评分更小)。所有需要对评分排序的地方都应使用该顺序。
+func (ps *ProgScore) Compare(other *ProgScore) int {
+	if c := cmp.Compare(ps.Total, other.Total); c != 0 {
+		return c
+	}
+	for _, pair := range [][2]float64{
+		{ps.KernelLog, other.KernelLog},
+		{ps.Coverage, other.Coverage},
+		{ps.Rarity, other.Rarity},
+		{ps.TimeAnomaly, other.TimeAnomaly},
+		{ps.Sequence, other.Sequence},
+	} {
+		if c := cmp.Compare(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+	return ps.Timestamp.Compare(other.Timestamp)
+}
+
+// Less 返回 ps 是否排在 other 之前 (按 Compare 的顺序更小)
+func (ps *ProgScore) Less(other *ProgScore) bool {
+	return ps.Compare(other) < 0
 }
 
 // ScoreTracker 跟踪和管理程序评分
@@ -59,21 +513,54 @@ type ScoreTracker struct {
 	
 	// 程序评分缓存 (prog hash -> score)
 	scores map[string]*ProgScore
+	// 评分的 LRU 顺序 (队首为最近更新)，用于限制 scores 的大小
+	scoresLRU   *list.List
+	scoresIndex map[string]*list.Element
 	
-	// PC 命中计数统计
+	// PC 命中计数统计 (覆盖率维度和稀有性维度共用)
 	pcHitCounts map[uint64]int64
-	
-	// 路径频率统计 (signal -> frequency)
-	pathFrequency map[string]int64
+	// 计入稀有性基线 (包括故障注入的独立基线) 的执行数，用于稀有性维度的预热
+	rarityExecs int64
 	
 	// 执行时间统计
 	execTimeStats *TimeStats
+
+	// 故障注入执行的独立基线 (仅在启用 FaultInjectionLane 时使用)
+	faultHitCounts     map[uint64]int64
+	faultExecTimeStats *TimeStats
+
+	// 系统调用序列频率统计 (序列哈希 -> frequency)
+	sequenceFrequency map[string]int64
+
+	// 已评分程序包含的系统调用 (prog hash -> 不同的 CallName) 及各系统调用的累计贡献，
+	// 与 scores 同步更新，用于 TopSyscallsByScore
+	progSyscalls  map[string][]string
+	syscallScores map[string]*syscallScore
 	
+	// 最近 smash 过的程序的稳定信号指纹 (pathHash -> 评分和时间)，用于 smash 去重
+	smashedSignals map[uint64]smashedSignal
+
+	// 已保存到语料库的程序哈希。大部分评分属于从未保存的生成/变异程序，
+	// 加权选择只能变异语料库中的程序，因此需要区分两者。
+	// 评分被淘汰或失效时一起删除，因此其大小也受 MaxTrackedProgs 限制
+	// (语料库中被替换的程序不会一直留在这里)；程序再次保存到语料库时重新记录。
+	corpusProgs map[string]bool
+
+	// 语料库程序的评分被 LRU 淘汰时调用 (持有跟踪器的锁)，为 nil 时不调用。
+	// 评分系统用它删除被淘汰的程序的选择器权重。
+	onEvictCorpus func(progHash string)
+
 	// 内核日志模式匹配器
 	logMatcher *KernelLogMatcher
 	
 	// 配置
 	config *ScoreConfig
+
+	// 评分时间戳使用的时钟 (测试中可以替换)
+	now func() time.Time
+
+	// 每次评分变化时递增，用于判断自上次保存以来是否有变化
+	version uint64
 }
 
 // NewScoreTracker 创建新的评分跟踪器
@@ -81,90 +568,661 @@ func NewScoreTracker(config *ScoreConfig) *ScoreTracker {
 	if config == nil {
 		config = DefaultScoreConfig()
 	}
+	config = validatedScoreConfig(config, log.Logf)
 	
-	return &ScoreTracker{
-		scores:        make(map[string]*ProgScore),
-		pcHitCounts:   make(map[uint64]int64),
-		pathFrequency: make(map[string]int64),
-		execTimeStats: NewTimeStats(),
-		logMatcher:    NewKernelLogMatcher(),
-		config:        config,
+	logMatcher := NewKernelLogMatcher()
+	if config.KernelLogPatternsFile != "" {
+		if patterns, err := loadLogPatternsFile(config.KernelLogPatternsFile); err != nil {
+			log.Logf(0, "无法加载内核日志模式，使用内置模式: %v", err)
+		} else {
+			logMatcher = NewKernelLogMatcherFromPatterns(patterns)
+		}
+	}
+	logMatcher.EnableTitleDedup(config.KnownTitleCacheSize)
+	logMatcher.SetMaxBonusPatterns(config.MaxBonusPatterns)
+	st := &ScoreTracker{
+		logMatcher: logMatcher,
+		config:     config,
+		now:        time.Now,
 	}
+	st.resetLocked()
+	return st
+}
+
+// Reset 清除所有程序评分、评分统计 (PC 命中次数、序列频率、执行时间基线) 和语料库程序记录，
+// 用于重新加载语料库时从头推导评分。配置和内核日志模式保持不变。
+func (st *ScoreTracker) Reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.resetLocked()
+	st.version++
+}
+
+func (st *ScoreTracker) resetLocked() {
+	st.scores = make(map[string]*ProgScore)
+	st.scoresLRU = list.New()
+	st.scoresIndex = make(map[string]*list.Element)
+	st.pcHitCounts = make(map[uint64]int64)
+	st.rarityExecs = 0
+	st.execTimeStats = NewTimeStats()
+	st.faultHitCounts = make(map[uint64]int64)
+	st.faultExecTimeStats = NewTimeStats()
+	st.sequenceFrequency = make(map[string]int64)
+	st.progSyscalls = make(map[string][]string)
+	st.syscallScores = make(map[string]*syscallScore)
+	st.smashedSignals = make(map[uint64]smashedSignal)
+	st.corpusProgs = make(map[string]bool)
 }
 
 // UpdateScore 更新程序评分
+// 如果执行本身出错 (执行器/传输错误，而非内核崩溃)，其信号和耗时都不可靠，
+// 此时不计算评分也不更新统计信息，返回 nil。
 func (st *ScoreTracker) UpdateScore(prog *prog.Prog, execResult *ExecutionResult) *ProgScore {
-	if !st.config.Enabled {
-		return &ProgScore{Total: 0.5} // 默认中等分数
+	return st.commitScore(st.prepareScore(prog, execResult))
+}
+
+// prepareScore 是 UpdateScore 的计算部分，只持有读锁 (见 computeScore)。
+// 评分关闭时返回的结果只带有中性分数，commitScore 不记录它。
+func (st *ScoreTracker) prepareScore(prog *prog.Prog, execResult *ExecutionResult) *pendingScore {
+	st.mu.RLock()
+	enabled := st.config.Enabled
+	st.mu.RUnlock()
+	if !enabled {
+		return &pendingScore{score: &ProgScore{Total: neutralScore}, disabled: true}
+	}
+	return st.computeScore(prog.Hash(), hasFailNth(prog), execResult, progSyscalls(prog))
+}
+
+// SetConfig 替换评分配置，可以与评分并发调用。
+// 配置的检查和归一化与 NewScoreTracker 相同，但配置无效时返回错误并保留当前的配置。
+func (st *ScoreTracker) SetConfig(config *ScoreConfig) error {
+	config, err := checkedScoreConfig(config, log.Logf)
+	if err != nil {
+		return err
 	}
-	
 	st.mu.Lock()
 	defer st.mu.Unlock()
+	st.config = config
+	return nil
+}
+
+// updateScore 按程序哈希更新评分，faultInjected 表示程序包含故障注入的调用
+func (st *ScoreTracker) updateScore(progHash string, faultInjected bool, execResult *ExecutionResult) *ProgScore {
+	return st.updateScoreSyscalls(progHash, faultInjected, execResult, nil)
+}
+
+// updateScoreSyscalls 与 updateScore 相同，同时把评分计入程序包含的系统调用。
+// syscalls 为 nil 时沿用程序之前记录的系统调用。
+func (st *ScoreTracker) updateScoreSyscalls(progHash string, faultInjected bool, execResult *ExecutionResult,
+	syscalls []string) *ProgScore {
+	return st.commitScore(st.computeScore(progHash, faultInjected, execResult, syscalls))
+}
+
+// pendingScore 是已经计算、尚未记录的评分
+type pendingScore struct {
+	progHash      string
+	faultInjected bool
+	faultLane     bool
+	execResult    *ExecutionResult
+	syscalls      []string
+	score         *ProgScore
+	// 稀有性使用的信号，稀有性分数和统计更新共用
+	raritySignal signal.Signal
+	// 日志匹配的崩溃标题，记录评分时才记为已知标题 (见 KernelLogMatcher.rememberTitles)
+	logTitles []string
+	// 评分关闭，不记录评分
+	disabled bool
+	// 重试沿用已记录的评分
+	reuse bool
+	// 只记录评分，不更新统计 (重试和被排除的程序)
+	scoreOnly bool
+}
+
+// computeScore 在读锁下按当前的统计计算评分，不修改跟踪器的状态。
+// 执行出错时返回 nil。计算最耗时的部分 (扫描信号和日志) 因此可以并发进行，
+// 只有 commitScore 需要写锁。两步之间其他评分可能已经更新了统计，
+// 这与这些评分先于本次评分完成的结果相同。
+func (st *ScoreTracker) computeScore(progHash string, faultInjected bool, execResult *ExecutionResult,
+	syscalls []string) *pendingScore {
+	if execResult.Error != "" {
+		return nil
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	pending := &pendingScore{
+		progHash:      progHash,
+		faultInjected: faultInjected,
+		execResult:    execResult,
+		syscalls:      syscalls,
+		scoreOnly:     execResult.Retry,
+	}
+	// 重试沿用首次执行的评分: 首次执行已经把覆盖计入统计，按更新后的统计重新评分
+	// 会把首次执行自己的覆盖当作已见过的覆盖。首次评分已被淘汰或失效时才重新计算。
+	if execResult.Retry && st.scores[progHash] != nil {
+		pending.reuse = true
+		return pending
+	}
+
+	if st.excludedLocked(execResult, syscalls) {
+		pending.score = &ProgScore{Total: neutralScore, Timestamp: st.now()}
+		pending.scoreOnly = true
+		return pending
+	}
+
+	// 故障注入的执行使用独立的基线或不更新基线
+	pending.faultLane = faultInjected && st.config.FaultInjectionLane
+	rarityCounts, execTimeStats := st.pcHitCounts, st.execTimeStats
+	if pending.faultLane {
+		rarityCounts, execTimeStats = st.faultHitCounts, st.faultExecTimeStats
+	}
+
+	// 计算各个维度的分数 (关闭的维度为 0)
+	var dimensionTimes [5]time.Duration
+	measure := func(dim int, calculate func()) {
+		if !st.config.ProfileDimensions {
+			calculate()
+			return
+		}
+		start := time.Now()
+		calculate()
+		dimensionTimes[dim] = time.Since(start)
+	}
+	var coverageScore, newCoverageRatio, rarityScore, kernelLogScore, timeAnomalyScore, sequenceScore float64
+	if !st.config.DisableCoverage {
+		measure(0, func() {
+			coverageScore, newCoverageRatio = st.calculateCoverageScore(execResult)
+		})
+	}
+	if !st.config.DisableRarity {
+		measure(1, func() {
+			pending.raritySignal = execResult.scoringSignal(st.config.ExcludeExtraRarity)
+			var hasSignal bool
+			rarityScore, hasSignal = signalRarity(pending.raritySignal, rarityCounts)
+			if edgeRarity, ok := edgeRarity(execResult, rarityCounts); ok {
+				rarityScore = (rarityScore + edgeRarity) / 2
+			}
+			if hasSignal {
+				rarityScore = st.warmedUpRarity(rarityScore)
+			}
+		})
+		if st.config.DecorrelateNovelty {
+			rarityScore *= 1 - newCoverageRatio
+		}
+	}
+	if !st.config.DisableKernelLog {
+		measure(2, func() {
+			kernelLogScore, pending.logTitles = st.calculateKernelLogScore(execResult)
+		})
+	}
+	if !st.config.DisableTimeAnomaly {
+		measure(3, func() {
+			timeAnomalyScore = st.calculateTimeAnomalyScore(execResult, execTimeStats)
+		})
+	}
+	if !st.config.DisableSequence {
+		measure(4, func() {
+			sequenceScore = st.calculateSequenceScore(execResult)
+		})
+	}
 	
-	progHash := prog.Hash()
-	
-	// 计算各个维度的分数
-	coverageScore := st.calculateCoverageScore(execResult)
-	rarityScore := st.calculateRarityScore(execResult)
-	kernelLogScore := st.calculateKernelLogScore(execResult)
-	timeAnomalyScore := st.calculateTimeAnomalyScore(execResult)
-	
-	// 计算加权总分
-	totalScore := st.config.CoverageWeight*coverageScore +
-		st.config.RarityWeight*rarityScore +
-		st.config.KernelLogWeight*kernelLogScore +
-		st.config.TimeAnomalyWeight*timeAnomalyScore
-	
-	score := &ProgScore{
-		Total:       totalScore,
+	pending.score = &ProgScore{
 		Coverage:    coverageScore,
 		Rarity:      rarityScore,
 		KernelLog:   kernelLogScore,
 		TimeAnomaly: timeAnomalyScore,
-		Timestamp:   time.Now(),
+		Sequence:    sequenceScore,
+		Timestamp:   st.now(),
+
+		dimensionTimes: dimensionTimes,
 	}
+	// 计算加权总分
+	pending.score.Total = st.config.weightedTotal(pending.score.dimensions())
+	return pending
+}
+
+// commitScore 在写锁下记录 computeScore 计算的评分并更新统计信息，返回记录的评分。
+// pending 为 nil (执行出错) 时返回 nil。
+func (st *ScoreTracker) commitScore(pending *pendingScore) *ProgScore {
+	if pending == nil {
+		return nil
+	}
+	if pending.disabled {
+		return pending.score
+	}
+
+	if pending.reuse {
+		if score := st.touchScore(pending.progHash); score != nil {
+			return score
+		}
+		// 计算之后评分已被淘汰，重新计算
+		return st.commitScore(st.computeScore(pending.progHash, pending.faultInjected,
+			pending.execResult, pending.syscalls))
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	score := pending.score
+	st.storeScoreLocked(pending.progHash, score, pending.syscalls)
+	st.logMatcher.rememberTitles(pending.logTitles)
 	
-	st.scores[progHash] = score
-	
-	// 更新统计信息
-	st.updateStatistics(execResult)
+	// 更新统计信息，同一程序的重复执行只评分，不重复计入
+	if pending.scoreOnly {
+		return score
+	}
+	execResult := pending.execResult
+	execTimeStats := st.execTimeStats
+	if pending.faultLane {
+		execTimeStats = st.faultExecTimeStats
+	}
+	// 故障注入的执行不把覆盖计入 PC 命中次数: 它们同时是稀有性的基线，
+	// 人为改变的执行路径会使正常执行的稀有性分数偏低
+	if !st.config.DisableCoverage && !pending.faultInjected {
+		st.recordCoverage(execResult)
+	}
+	if !pending.faultInjected || pending.faultLane {
+		st.updateStatistics(execResult, pending.raritySignal, pending.faultLane, execTimeStats)
+	}
 	
 	return score
 }
 
-// GetScore 获取程序评分
-func (st *ScoreTracker) GetScore(prog *prog.Prog) *ProgScore {
+// touchScore 把已记录的评分标记为最近使用并返回它，程序没有评分时返回 nil
+func (st *ScoreTracker) touchScore(progHash string) *ProgScore {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	score := st.scores[progHash]
+	if score != nil {
+		st.touchLocked(progHash)
+	}
+	return score
+}
+
+// recordScore 记录由外部评分函数 (见 Config.ScoreFunc) 计算的评分，代替内置的评分计算。
+// 总分被限制在 [0, 1] 内，评分统计不更新。执行出错或外部评分为 nil 时不记录，返回 nil。
+// 包含 ScoreExcludedCalls 中的调用的程序与内置的评分计算一样记录中等分数，
+// 调用方不必为它们调用外部评分函数 (见 excluded)。
+func (st *ScoreTracker) recordScore(p *prog.Prog, execResult *ExecutionResult, score *ProgScore) *ProgScore {
+	if execResult.Error != "" {
+		return nil
+	}
+	syscalls := progSyscalls(p)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.excludedLocked(execResult, syscalls) {
+		recorded := &ProgScore{Total: neutralScore, Timestamp: st.now()}
+		st.storeScoreLocked(p.Hash(), recorded, syscalls)
+		return recorded
+	}
+	if score == nil {
+		return nil
+	}
+	// 复制一份，外部评分函数可能复用返回的评分
+	recorded := &ProgScore{
+		Total:          clampScore(score.Total),
+		Coverage:       score.Coverage,
+		Rarity:         score.Rarity,
+		KernelLog:      score.KernelLog,
+		TimeAnomaly:    score.TimeAnomaly,
+		Sequence:       score.Sequence,
+		Timestamp:      score.Timestamp,
+		HintNewSignal:  score.HintNewSignal,
+		FaultNewSignal: score.FaultNewSignal,
+	}
+	if recorded.Timestamp.IsZero() {
+		recorded.Timestamp = st.now()
+	}
+	st.storeScoreLocked(p.Hash(), recorded, syscalls)
+	return recorded
+}
+
+// excluded 判断程序是否包含 ScoreExcludedCalls 中的系统调用，此时不需要计算评分
+func (st *ScoreTracker) excluded(p *prog.Prog, execResult *ExecutionResult) bool {
 	st.mu.RLock()
 	defer st.mu.RUnlock()
-	
-	progHash := prog.Hash()
-	if score, exists := st.scores[progHash]; exists {
+	return st.excludedLocked(execResult, progSyscalls(p))
+}
+
+// clampScore 把分数限制在 [0, 1] 内，NaN 被当作 0
+func clampScore(score float64) float64 {
+	if math.IsNaN(score) {
+		return 0
+	}
+	return math.Max(0, math.Min(score, 1))
+}
+
+// storeScoreLocked 记录程序的新评分。syscalls 为 nil 时沿用程序之前记录的系统调用。
+func (st *ScoreTracker) storeScoreLocked(progHash string, score *ProgScore, syscalls []string) {
+	// 撤销旧评分的贡献后按新评分重新计入，重复评分的程序只计入一次
+	if old := st.removeSyscallScoresLocked(progHash); syscalls == nil {
+		syscalls = old
+	}
+	// 新信号的标记一旦设置就保留，重新评分不清除它们
+	if old := st.scores[progHash]; old != nil {
+		score.HintNewSignal = score.HintNewSignal || old.HintNewSignal
+		score.FaultNewSignal = score.FaultNewSignal || old.FaultNewSignal
+	}
+	st.scores[progHash] = score
+	st.addSyscallScoresLocked(progHash, syscalls, score.Total)
+	st.touchLocked(progHash)
+	st.version++
+}
+
+// excludedLocked 判断执行的程序是否包含 ScoreExcludedCalls 中的系统调用
+func (st *ScoreTracker) excludedLocked(execResult *ExecutionResult, syscalls []string) bool {
+	excluded := st.config.ScoreExcludedCalls
+	if len(excluded) == 0 {
+		return false
+	}
+	for _, names := range [][]string{execResult.CallSequence, syscalls} {
+		for _, name := range names {
+			if excluded[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// neutralScore 尚未评分的程序使用的默认中等分数
+const neutralScore = 0.5
+
+// GetScore 获取程序评分，程序尚未被评分时返回默认的中等分数 (总分 0.5)。
+// 它适用于只需要一个可用分数的调用方；需要区分"从未评分"和"评分为中等"的调用方
+// 应使用 GetScoreByHash。
+func (st *ScoreTracker) GetScore(p *prog.Prog) *ProgScore {
+	if score := st.GetScoreByHash(p.Hash()); score != nil {
 		return score
 	}
-	
-	// 返回默认分数
-	return &ProgScore{Total: 0.5}
+	return &ProgScore{Total: neutralScore}
 }
 
-// calculateCoverageScore 计算覆盖率分数
-func (st *ScoreTracker) calculateCoverageScore(result *ExecutionResult) float64 {
-	if result.Signal == nil || result.Signal.Empty() {
-		return 0.0
+// touchLocked 把程序标记为最近更新，并淘汰超出 MaxTrackedProgs 的旧评分
+func (st *ScoreTracker) touchLocked(progHash string) {
+	if elem, ok := st.scoresIndex[progHash]; ok {
+		st.scoresLRU.MoveToFront(elem)
+	} else {
+		st.scoresIndex[progHash] = st.scoresLRU.PushFront(progHash)
+	}
+	for st.config.MaxTrackedProgs > 0 && st.scoresLRU.Len() > st.config.MaxTrackedProgs {
+		oldest := st.scoresLRU.Back()
+		hash := st.scoresLRU.Remove(oldest).(string)
+		delete(st.scoresIndex, hash)
+		st.removeSyscallScoresLocked(hash)
+		delete(st.scores, hash)
+		if st.corpusProgs[hash] {
+			delete(st.corpusProgs, hash)
+			if st.onEvictCorpus != nil {
+				st.onEvictCorpus(hash)
+			}
+		}
+	}
+}
+
+// scoreOf 返回已记录的程序评分的副本，如果程序尚未被评分则返回 nil。
+// 跟踪器之外的代码 (作业、Web 界面等) 只通过副本读取评分，
+// 调用方持有的评分不会与跟踪器内部的更新 (重新评分、衰减、新信号标记) 共享同一对象。
+func (st *ScoreTracker) scoreOf(progHash string) *ProgScore {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	score := st.scores[progHash]
+	if score == nil {
+		return nil
+	}
+	copied := *score
+	return &copied
+}
+
+// GetScoreByHash 按程序哈希返回已记录的评分的副本，程序尚未被评分时返回 nil。
+// 已经缓存了哈希的调用方应使用该方法，避免重新计算哈希。
+func (st *ScoreTracker) GetScoreByHash(progHash string) *ProgScore {
+	return st.scoreOf(progHash)
+}
+
+// InvalidateProgram 删除程序的缓存评分和语料库标记，使其在下一次执行时重新评分。
+// 全局的 PC 命中、序列频率和执行时间统计不受影响。
+func (st *ScoreTracker) InvalidateProgram(progHash string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.corpusProgs, progHash)
+	if _, ok := st.scores[progHash]; !ok {
+		return
+	}
+	st.removeSyscallScoresLocked(progHash)
+	delete(st.scores, progHash)
+	if elem, ok := st.scoresIndex[progHash]; ok {
+		st.scoresLRU.Remove(elem)
+		delete(st.scoresIndex, progHash)
+	}
+	st.version++
+}
+
+// inheritScore 在 to 尚未被评分时把 from 的评分复制到 to 下，
+// 用于最小化等改变了程序哈希的变换。返回 to 的评分，两者都未评分时返回 nil。
+func (st *ScoreTracker) inheritScore(from, to string) *ProgScore {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if score := st.scores[to]; score != nil {
+		return score
+	}
+	score := st.scores[from]
+	if score == nil {
+		return nil
+	}
+	inherited := *score
+	st.scores[to] = &inherited
+	st.addSyscallScoresLocked(to, st.progSyscalls[from], inherited.Total)
+	st.touchLocked(to)
+	st.version++
+	return &inherited
+}
+
+// markHintNewSignal 标记 hints 变异产生的程序发现了新信号，返回标记后的评分。
+// 程序尚未评分 (例如异步评分还在排队) 或已被淘汰时返回 nil，此时不做任何记录，
+// 因此标记只存在于已跟踪的评分中，跟踪的程序数量仍由 MaxTrackedProgs 限制。
+// hints 作业的变异体数量由 hintsLimiter 限制的比较操作数决定，
+// 所以 hintsLimiter 只限制了标记的次数，而不影响评分的跟踪和淘汰。
+func (st *ScoreTracker) markHintNewSignal(progHash string) *ProgScore {
+	return st.markScore(progHash, func(score *ProgScore) *bool { return &score.HintNewSignal })
+}
+
+// markFaultNewSignal 与 markHintNewSignal 相同，用于故障注入作业产生的程序
+func (st *ScoreTracker) markFaultNewSignal(progHash string) *ProgScore {
+	return st.markScore(progHash, func(score *ProgScore) *bool { return &score.FaultNewSignal })
+}
+
+// markScore 把程序评分中由 flag 返回的标记置位，返回标记后的评分，程序尚未评分时返回 nil
+func (st *ScoreTracker) markScore(progHash string, flag func(*ProgScore) *bool) *ProgScore {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	score := st.scores[progHash]
+	if score == nil || *flag(score) {
+		return score
+	}
+	// 写时复制: scoreOf 等返回的指针在释放锁之后仍被调用方 (选择器权重、作业信息、持久化)
+	// 读取，就地修改会与这些读取产生数据竞争，因此替换为标记后的副本。
+	marked := *score
+	*flag(&marked) = true
+	st.scores[progHash] = &marked
+	st.version++
+	return &marked
+}
+
+// Decay 让每个评分的总分按距上次评分 (或上次衰减) 经过的时间向中性分数衰减，
+// 每经过 halfLife 与中性分数的差距减半。halfLife 不为正时不做任何修改。
+// 各维度的分数保持不变，重新评分的程序得到不衰减的新总分。
+func (st *ScoreTracker) Decay(halfLife time.Duration) {
+	st.decay(halfLife)
+}
+
+// decay 实现 Decay，返回总分被衰减的程序的新评分
+func (st *ScoreTracker) decay(halfLife time.Duration) map[string]*ProgScore {
+	if halfLife <= 0 {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := st.now()
+	decayed := make(map[string]*ProgScore)
+	for progHash, score := range st.scores {
+		since := score.Timestamp
+		if score.decayedAt.After(since) {
+			since = score.decayedAt
+		}
+		elapsed := now.Sub(since)
+		if elapsed <= 0 {
+			continue
+		}
+		updated := *score
+		updated.Total = neutralScore + (score.Total-neutralScore)*math.Exp2(-float64(elapsed)/float64(halfLife))
+		updated.decayedAt = now
+		syscalls := st.removeSyscallScoresLocked(progHash)
+		st.scores[progHash] = &updated
+		st.addSyscallScoresLocked(progHash, syscalls, updated.Total)
+		decayed[progHash] = &updated
+	}
+	if len(decayed) != 0 {
+		st.version++
+	}
+	return decayed
+}
+
+// smashedSignal 记录 smash 过的稳定信号对应程序的总分和 smash 的时间
+type smashedSignal struct {
+	score float64
+	at    time.Time
+}
+
+// smashedSignal 检查最近 window 内是否已经 smash 过
+// 稳定信号与 stableSignal 相同、总分与 score 相差不超过 scoreDelta 的程序，是则调用方应跳过 smash。
+// 只比较稳定信号的指纹，原始信号中不稳定的部分不影响判断。
+func (st *ScoreTracker) smashedSignal(stableSignal signal.Signal, score, scoreDelta float64,
+	window time.Duration) bool {
+	fingerprint := pathHash(stableSignal)
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	prev, ok := st.smashedSignals[fingerprint]
+	return ok && !smashedSignalExpired(prev, st.now(), window) && math.Abs(prev.score-score) <= scoreDelta
+}
+
+// recordSmashedSignal 记录以 stableSignal 为稳定信号、总分为 score 的程序开始了 smash。
+// 记录数超过 MaxTrackedProgs 时先淘汰过期的记录，仍然超出时随机淘汰。
+func (st *ScoreTracker) recordSmashedSignal(stableSignal signal.Signal, score float64, window time.Duration) {
+	fingerprint := pathHash(stableSignal)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := st.now()
+	st.smashedSignals[fingerprint] = smashedSignal{score: score, at: now}
+	if limit := st.config.MaxTrackedProgs; limit > 0 && len(st.smashedSignals) > limit {
+		for fp, prev := range st.smashedSignals {
+			if smashedSignalExpired(prev, now, window) {
+				delete(st.smashedSignals, fp)
+			}
+		}
+		// 仍然超出上限时随机淘汰 (map 的遍历顺序是随机的)
+		for fp := range st.smashedSignals {
+			if len(st.smashedSignals) <= limit {
+				break
+			}
+			if fp != fingerprint {
+				delete(st.smashedSignals, fp)
+			}
+		}
+	}
+}
+
+func smashedSignalExpired(prev smashedSignal, now time.Time, window time.Duration) bool {
+	return now.Sub(prev.at) >= window
+}
+
+// markInCorpus 记录程序已保存到语料库，返回是否记录了。
+// 只记录已有评分的程序: 标记随评分一起被淘汰，没有评分的程序的标记不受 MaxTrackedProgs 限制。
+// 这样的程序在之后评分时不会成为语料库程序，直到它再次保存到语料库。
+func (st *ScoreTracker) markInCorpus(progHash string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.scores[progHash] == nil {
+		return false
+	}
+	st.corpusProgs[progHash] = true
+	return true
+}
+
+// inCorpus 判断程序是否已保存到语料库 (且标记尚未随评分一起被淘汰或失效)
+func (st *ScoreTracker) inCorpus(progHash string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.corpusProgs[progHash]
+}
+
+// TrackedProgs 返回当前记录了评分的程序数量
+func (st *ScoreTracker) TrackedProgs() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.scores)
+}
+
+// Version 返回评分的版本号，每次评分变化时递增
+func (st *ScoreTracker) Version() uint64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.version
+}
+
+// DistinctPCs 返回评分系统观察到的不同 PC 数量
+func (st *ScoreTracker) DistinctPCs() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.pcHitCounts)
+}
+
+// rareCallOrder 返回按信号稀有程度从高到低排列的调用下标，相同时保持原来的顺序。
+// 顺序只由统计决定，不使用随机数 (因此也不需要确定性模式的随机数流)。
+// 调用的稀有程度是其信号中各 PC 的 1/(1+命中次数) 的平均值，没有信号的调用排在最后。
+func (st *ScoreTracker) rareCallOrder(callSignals [][]uint64) []int {
+	st.mu.RLock()
+	rarity := make([]float64, len(callSignals))
+	for call, pcs := range callSignals {
+		for _, pc := range pcs {
+			rarity[call] += 1 / (1 + float64(st.pcHitCounts[pc]))
+		}
+		if len(pcs) != 0 {
+			rarity[call] /= float64(len(pcs))
+		}
+	}
+	st.mu.RUnlock()
+	order := make([]int, len(callSignals))
+	for call := range order {
+		order[call] = call
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rarity[order[i]] > rarity[order[j]]
+	})
+	return order
+}
+
+// calculateCoverageScore 计算覆盖率分数，同时返回新 PC 在信号中的占比。
+// 它不修改 PC 命中次数，同一执行结果重复计算得到相同的分数。
+func (st *ScoreTracker) calculateCoverageScore(result *ExecutionResult) (float64, float64) {
+	sig := result.scoringSignal(st.config.ExcludeExtraCoverage)
+	if sig == nil || sig.Empty() {
+		return 0.0, 0.0
 	}
 	
 	newCoverage := 0
-	totalCoverage := result.Signal.Len()
+	totalCoverage := sig.Len()
 	
-	// 计算新覆盖的PC数量
-	for _, pc := range result.Signal.ToRaw() {
-		if st.pcHitCounts[pc] == 0 {
+	// 计算新覆盖的PC数量 (只读取命中次数，由 recordCoverage 记录)
+	for pc := range sig {
+		if st.pcHitCounts[uint64(pc)] == 0 {
 			newCoverage++
 		}
-		st.pcHitCounts[pc]++
 	}
 	
 	if totalCoverage == 0 {
-		return 0.0
+		return 0.0, 0.0
 	}
 	
 	// 新覆盖率占比越高，分数越高
@@ -173,106 +1231,316 @@ func (st *ScoreTracker) calculateCoverageScore(result *ExecutionResult) float64
 	// 使用对数函数平滑分数分布
 	score := math.Log(1 + newCoverageRatio*math.E) / math.Log(1 + math.E)
 	
-	return math.Min(score, 1.0)
+	return math.Min(score, 1.0), newCoverageRatio
 }
 
-// calculateRarityScore 计算路径稀有性分数
-func (st *ScoreTracker) calculateRarityScore(result *ExecutionResult) float64 {
-	if result.Signal == nil || result.Signal.Empty() {
-		return 0.0
+// recordCoverage 把覆盖率维度使用的信号中的 PC 记为已命中
+func (st *ScoreTracker) recordCoverage(result *ExecutionResult) {
+	for pc := range result.scoringSignal(st.config.ExcludeExtraCoverage) {
+		incrementBounded(st.pcHitCounts, uint64(pc), st.config.MaxTrackedPCs)
 	}
-	
-	signalKey := result.Signal.String()
-	frequency := st.pathFrequency[signalKey]
-	
-	// 频率越低，稀有性分数越高
-	if frequency == 0 {
-		return 1.0 // 全新路径获得最高分
+}
+
+// pathHash 计算信号中 PC 集合的哈希。
+// 各个 PC 先单独混合再相加，结果与 map 的遍历顺序无关，因此不需要排序，也不分配内存。
+func pathHash(sig signal.Signal) uint64 {
+	hash := uint64(len(sig))
+	for pc := range sig {
+		hash += mix64(uint64(pc))
 	}
-	
-	// 使用反比例函数计算稀有性分数
-	score := 1.0 / (1.0 + math.Log(float64(frequency)))
-	
-	return math.Min(score, 1.0)
+	return mix64(hash)
 }
 
-// calculateKernelLogScore 计算内核日志分数
-func (st *ScoreTracker) calculateKernelLogScore(result *ExecutionResult) float64 {
-	if len(result.KernelLogs) == 0 {
-		return 0.0
+// mix64 是 splitmix64 的终结函数，使相近的输入得到差别很大的输出
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// signalRarity 返回信号中各元素的平均稀有程度 1/(1+命中次数)，信号为空时 ok 为 false。
+// 按单个元素而不是整个信号集合统计: 只差几个不稳定信号位的执行得到相近的分数，
+// 而不会因为集合不同都被当作从未见过的路径。
+func signalRarity(sig signal.Signal, hitCounts map[uint64]int64) (rarity float64, ok bool) {
+	if sig.Empty() {
+		return 0, false
 	}
-	
-	return st.logMatcher.CalculateScore(result.KernelLogs)
+	for pc := range sig {
+		rarity += 1 / (1 + float64(hitCounts[uint64(pc)]))
+	}
+	return rarity / float64(sig.Len()), true
+}
+
+// warmedUpRarity 在预热期间 (见 RarityWarmupExecs) 把稀有性分数向中等分数收缩
+func (st *ScoreTracker) warmedUpRarity(rarity float64) float64 {
+	warmup := st.config.RarityWarmupExecs
+	if st.rarityExecs >= warmup {
+		return rarity
+	}
+	return neutralScore + (rarity-neutralScore)*float64(st.rarityExecs)/float64(warmup)
+}
+
+// edgeRarity 返回原始覆盖中各 PC 的平均稀有程度 1/(1+命中次数)，没有原始覆盖时 ok 为 false
+func edgeRarity(result *ExecutionResult, hitCounts map[uint64]int64) (rarity float64, ok bool) {
+	if len(result.Cover) == 0 {
+		return 0, false
+	}
+	for pc := range result.Cover {
+		rarity += 1 / (1 + float64(hitCounts[pc]))
+	}
+	return rarity / float64(len(result.Cover)), true
+}
+
+// crashedKernelLogScore 崩溃的执行在内核日志维度上的最低分数。
+// 崩溃可能截断了日志，因此即使日志没有匹配任何模式，也认为它接近最严重的情况。
+const crashedKernelLogScore = 0.9
+
+// calculateKernelLogScore 计算内核日志分数，同时返回日志匹配的崩溃标题。
+// 崩溃的执行至少得到 crashedKernelLogScore，日志匹配的模式越严重，分数越接近 1。
+func (st *ScoreTracker) calculateKernelLogScore(result *ExecutionResult) (float64, []string) {
+	score := 0.0
+	var titles []string
+	if len(result.KernelLogs) != 0 {
+		score, titles = st.logMatcher.score(result.KernelLogs)
+	}
+	if result.Crashed {
+		score = crashedKernelLogScore + (1-crashedKernelLogScore)*score
+	}
+	return score, titles
 }
 
 // calculateTimeAnomalyScore 计算执行时间异常分数
-func (st *ScoreTracker) calculateTimeAnomalyScore(result *ExecutionResult) float64 {
+func (st *ScoreTracker) calculateTimeAnomalyScore(result *ExecutionResult, execTimeStats *TimeStats) float64 {
 	if result.ExecTime == 0 {
 		return 0.0
 	}
 	
-	return st.execTimeStats.CalculateAnomalyScore(result.ExecTime)
+	return execTimeStats.AnomalyScore(result.ExecTime, st.config.TimeAnomalyMode)
 }
 
-// updateStatistics 更新统计信息
-func (st *ScoreTracker) updateStatistics(result *ExecutionResult) {
-	// 更新路径频率
-	if result.Signal != nil && !result.Signal.Empty() {
-		signalKey := result.Signal.String()
-		st.pathFrequency[signalKey]++
+// calculateSequenceScore 计算系统调用序列新颖性分数。
+// 从未出现过的调用序列得 1 分，之后随出现次数递减，
+// 因此相同的调用以不同的顺序出现时被视为不同的序列。
+func (st *ScoreTracker) calculateSequenceScore(result *ExecutionResult) float64 {
+	if len(result.CallSequence) == 0 {
+		return 0.0
+	}
+	return 1.0 / (1.0 + float64(st.sequenceFrequency[sequenceKey(result.CallSequence)]))
+}
+
+// sequenceKey 返回调用序列的哈希
+func sequenceKey(calls []string) string {
+	return hash.String([]byte(strings.Join(calls, "\n")))
+}
+
+// callSequence 返回程序中按顺序排列的系统调用名
+func callSequence(p *prog.Prog) []string {
+	calls := make([]string, 0, len(p.Calls))
+	for _, call := range p.Calls {
+		calls = append(calls, call.Meta.Name)
+	}
+	return calls
+}
+
+// updateStatistics 更新统计信息，raritySignal 是稀有性使用的信号 (关闭稀有性维度时为 nil)，
+// faultLane 表示在故障注入的独立基线中更新
+func (st *ScoreTracker) updateStatistics(result *ExecutionResult, raritySignal signal.Signal, faultLane bool,
+	execTimeStats *TimeStats) {
+	// 更新信号元素的命中次数
+	if !raritySignal.Empty() {
+		st.recordRaritySignal(result, raritySignal, faultLane)
+		st.rarityExecs++
+	}
+
+	// 记录原始覆盖的 PC，故障注入的执行记录在独立基线中
+	if !st.config.DisableRarity {
+		hitCounts := st.pcHitCounts
+		if faultLane {
+			hitCounts = st.faultHitCounts
+		}
+		for pc := range result.Cover {
+			incrementBounded(hitCounts, pc, st.config.MaxTrackedPCs)
+		}
 	}
 	
 	// 更新执行时间统计
-	if result.ExecTime > 0 {
-		st.execTimeStats.AddSample(result.ExecTime)
+	if !st.config.DisableTimeAnomaly && result.ExecTime > 0 {
+		execTimeStats.AddSample(result.ExecTime)
+	}
+
+	// 更新调用序列频率
+	if !st.config.DisableSequence && len(result.CallSequence) != 0 {
+		incrementBounded(st.sequenceFrequency, sequenceKey(result.CallSequence), st.config.MaxTrackedSequences)
 	}
 }
 
-// GetTopScoredProgs 获取评分最高的程序列表
-func (st *ScoreTracker) GetTopScoredProgs(limit int) []string {
-	st.mu.RLock()
-	defer st.mu.RUnlock()
-	
-	type progScore struct {
-		hash  string
-		score float64
+// recordRaritySignal 把稀有性使用的信号中的元素记为已命中。
+// 正常执行中覆盖率维度已经由 recordCoverage 计入的元素不再重复计入。
+func (st *ScoreTracker) recordRaritySignal(result *ExecutionResult, raritySignal signal.Signal, faultLane bool) {
+	if faultLane {
+		for pc := range raritySignal {
+			incrementBounded(st.faultHitCounts, uint64(pc), st.config.MaxTrackedPCs)
+		}
+		return
 	}
-	
-	var progs []progScore
-	for hash, score := range st.scores {
-		progs = append(progs, progScore{hash: hash, score: score.Total})
+	var recorded signal.Signal
+	if !st.config.DisableCoverage {
+		recorded = result.scoringSignal(st.config.ExcludeExtraCoverage)
 	}
-	
-	// 按分数降序排序
-	for i := 0; i < len(progs)-1; i++ {
-		for j := i + 1; j < len(progs); j++ {
-			if progs[i].score < progs[j].score {
-				progs[i], progs[j] = progs[j], progs[i]
-			}
+	for pc := range raritySignal {
+		if _, ok := recorded[pc]; !ok {
+			incrementBounded(st.pcHitCounts, uint64(pc), st.config.MaxTrackedPCs)
 		}
 	}
-	
-	// 返回前 limit 个
-	result := make([]string, 0, limit)
-	for i := 0; i < len(progs) && i < limit; i++ {
-		result = append(result, progs[i].hash)
+}
+
+// incrementBounded 增加 key 的计数。key 尚未记录且记录数已达到 limit 时先随机淘汰一个
+// (map 的遍历顺序是随机的)，因此 map 的大小不会超过 limit (limit <= 0 表示不限制)。
+func incrementBounded[K comparable](counts map[K]int64, key K, limit int) {
+	if _, ok := counts[key]; !ok && limit > 0 && len(counts) >= limit {
+		for evict := range counts {
+			delete(counts, evict)
+			break
+		}
+	}
+	counts[key]++
+}
+
+// hasFailNth 判断程序是否包含故障注入的调用
+func hasFailNth(p *prog.Prog) bool {
+	for _, call := range p.Calls {
+		if call.Props.FailNth > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ScoredProg 是程序哈希及其评分的快照
+type ScoredProg struct {
+	Hash  string
+	Score ProgScore
+}
+
+// rankedBelow 判断 sp 的排名是否低于 other: 评分更低，评分完全相同时哈希更大
+func (sp *ScoredProg) rankedBelow(other *ScoredProg) bool {
+	if c := sp.Score.Compare(&other.Score); c != 0 {
+		return c < 0
+	}
+	return sp.Hash > other.Hash
+}
+
+// topScoresHeap 是按排名组织的最小堆，堆顶是当前保留的程序中排名最低的
+type topScoresHeap []ScoredProg
+
+func (h topScoresHeap) Len() int           { return len(h) }
+func (h topScoresHeap) Less(i, j int) bool { return h[i].rankedBelow(&h[j]) }
+func (h topScoresHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topScoresHeap) Push(x any)        { *h = append(*h, x.(ScoredProg)) }
+func (h *topScoresHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// GetTopScoredProgs 按评分降序返回评分最高的 limit 个程序及其评分
+// (评分完全相同时按哈希排序，保证结果稳定)。
+// 使用大小为 limit 的堆，复杂度为 O(n log limit)，只复制进入前 limit 名的评分。
+// 遍历在读锁下进行，因此不会与淘汰并发修改 scores 冲突。
+func (st *ScoreTracker) GetTopScoredProgs(limit int) []ScoredProg {
+	return st.topScoredProgs(limit, false)
+}
+
+// GetTopScoredCorpusProgs 与 GetTopScoredProgs 相同，但只返回已保存到语料库的程序，
+// 因此返回的哈希总能在语料库中找到对应的程序。
+func (st *ScoreTracker) GetTopScoredCorpusProgs(limit int) []ScoredProg {
+	return st.topScoredProgs(limit, true)
+}
+
+func (st *ScoreTracker) topScoredProgs(limit int, corpusOnly bool) []ScoredProg {
+	if limit <= 0 {
+		return nil
+	}
+	h := make(topScoresHeap, 0, limit)
+	st.mu.RLock()
+	for hash, score := range st.scores {
+		if corpusOnly && !st.corpusProgs[hash] {
+			continue
+		}
+		candidate := ScoredProg{Hash: hash, Score: *score}
+		if len(h) < limit {
+			heap.Push(&h, candidate)
+		} else if h[0].rankedBelow(&candidate) {
+			h[0] = candidate
+			heap.Fix(&h, 0)
+		}
+	}
+	st.mu.RUnlock()
+
+	result := make([]ScoredProg, len(h))
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(&h).(ScoredProg)
 	}
-	
 	return result
 }
 
+// DumpScores 以 JSON 格式输出所有程序评分。
+// 条目按程序哈希排序，因此相同的状态总是得到逐字节相同的输出，便于比较和存档。
+func (st *ScoreTracker) DumpScores(w io.Writer) error {
+	type entry struct {
+		Hash  string    `json:"hash"`
+		Score ProgScore `json:"score"`
+	}
+	st.mu.RLock()
+	entries := make([]entry, 0, len(st.scores))
+	for hash, score := range st.scores {
+		entries = append(entries, entry{Hash: hash, Score: *score})
+	}
+	st.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hash < entries[j].Hash
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(entries)
+}
+
 // ExecutionResult 执行结果结构体
 type ExecutionResult struct {
-	// 覆盖率信号
+	// 覆盖率信号 (extra 信号，启用 CallSignalScoring 时还包括各调用的信号)
 	Signal signal.Signal
+	// 仅来自各调用的信号 (不含 extra 信号)
+	CallSignal signal.Signal
 	// 执行时间 (微秒)
 	ExecTime uint64
 	// 内核日志
 	KernelLogs []string
-	// 是否发生崩溃
+	// 是否发生崩溃，崩溃的执行在内核日志维度上得到接近最高的分数
 	Crashed bool
-	// 错误信息
+	// 执行错误信息 (非空表示结果不可靠，不参与评分)
 	Error string
+	// 按顺序排列的系统调用名
+	CallSequence []string
+	// 各调用的原始覆盖 (可选，只有执行器收集了原始覆盖时非空)。
+	// 非空时稀有性分数还考虑其中各 PC 的稀有程度，PC 的命中次数与信号共用 pcHitCounts。
+	Cover cover.Cover
+	// 同一程序的重复执行 (如候选程序的重试): 沿用首次执行的评分，不再把覆盖、路径、执行时间和调用序列计入统计，
+	// 否则同一份覆盖会被计入两次，第二次评分时覆盖全部被当作已见过的覆盖。
+	// 没有首次执行的评分时只计算评分，同样不更新统计。
+	Retry bool
+}
+
+// scoringSignal 返回用于评分的信号，excludeExtra 时不包含 extra 信号
+func (result *ExecutionResult) scoringSignal(excludeExtra bool) signal.Signal {
+	if excludeExtra {
+		return result.CallSignal
+	}
+	return result.Signal
 }
 
 // WeightedSelector 基于评分的加权选择器
@@ -298,6 +1566,13 @@ func NewWeightedSelector() *WeightedSelector {
 	}
 }
 
+// Len 返回选择器中有权重的程序数量
+func (ws *WeightedSelector) Len() int {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return len(ws.weights)
+}
+
 // UpdateWeight 更新程序权重
 func (ws *WeightedSelector) UpdateWeight(progHash string, weight float64) {
 	ws.mu.Lock()
@@ -307,6 +1582,35 @@ func (ws *WeightedSelector) UpdateWeight(progHash string, weight float64) {
 	ws.needRebuild = true
 }
 
+// RemoveWeight 移除程序的权重，使其不再被选择
+func (ws *WeightedSelector) RemoveWeight(progHash string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.weights[progHash]; ok {
+		delete(ws.weights, progHash)
+		ws.needRebuild = true
+	}
+}
+
+// Reset 移除所有程序的权重
+func (ws *WeightedSelector) Reset() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.weights = make(map[string]float64)
+	ws.cumulativeWeights = nil
+	ws.progHashes = nil
+	ws.needRebuild = true
+}
+
+// hashes 返回选择器中有权重的程序哈希
+func (ws *WeightedSelector) hashes() []string {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return slices.Collect(maps.Keys(ws.weights))
+}
+
 // SelectWeighted 基于权重随机选择程序
 func (ws *WeightedSelector) SelectWeighted(rnd float64) string {
 	ws.mu.Lock()
@@ -336,19 +1640,50 @@ func (ws *WeightedSelector) SelectWeighted(rnd float64) string {
 	return ws.progHashes[left]
 }
 
-// rebuildWeightTable 重建权重表
+// WeightedSelect 用 rnd 在给定的候选程序中按权重随机选择一个。
+// 没有记录权重 (或权重不大于 0) 的候选不会被选中；所有候选都没有权重时均匀选择。
+// 只有候选为空时返回空字符串。选择器不持有随机数源，相同的 rnd 状态总是选出相同的程序；
+// 确定性模式下调用方应通过 scoring.withRand 传入评分系统的随机数流。
+func (ws *WeightedSelector) WeightedSelect(rnd *rand.Rand, hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	cumulative := make([]float64, len(hashes))
+	total := 0.0
+	ws.mu.RLock()
+	for i, hash := range hashes {
+		if weight := ws.weights[hash]; weight > 0 {
+			total += weight
+		}
+		cumulative[i] = total
+	}
+	ws.mu.RUnlock()
+	if total == 0 {
+		return hashes[rnd.Intn(len(hashes))]
+	}
+	target := rnd.Float64() * total
+	return hashes[sort.Search(len(cumulative), func(i int) bool {
+		return cumulative[i] > target
+	})]
+}
+
+// rebuildWeightTable 重建权重表。
+// 程序按哈希排序后再累积权重，因此相同的权重和相同的随机数总是选出相同的程序。
 func (ws *WeightedSelector) rebuildWeightTable() {
 	ws.cumulativeWeights = ws.cumulativeWeights[:0]
 	ws.progHashes = ws.progHashes[:0]
 	
-	cumulative := 0.0
 	for hash, weight := range ws.weights {
 		if weight > 0 {
-			cumulative += weight
-			ws.cumulativeWeights = append(ws.cumulativeWeights, cumulative)
 			ws.progHashes = append(ws.progHashes, hash)
 		}
 	}
+	sort.Strings(ws.progHashes)
+	cumulative := 0.0
+	for _, hash := range ws.progHashes {
+		cumulative += ws.weights[hash]
+		ws.cumulativeWeights = append(ws.cumulativeWeights, cumulative)
+	}
 	
 	ws.needRebuild = false
 }
\ No newline at end of file
@@ -0,0 +1,152 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/flatrpc"
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+var flagUpdate = flag.Bool("update", false, "update the scoring replay golden file")
+
+const scoringReplayGolden = "scoring_replay.json"
+
+// replayInput 是回放序列中的一次评分
+type replayInput struct {
+	hash          string
+	faultInjected bool
+	result        ExecutionResult
+}
+
+// scoringReplayInputs 返回覆盖所有评分维度和边界情况的固定输入序列
+func scoringReplayInputs() []replayInput {
+	sig := func(pcs ...uint64) signal.Signal {
+		return signal.FromRaw(pcs, 0)
+	}
+	var inputs []replayInput
+	add := func(hash string, result ExecutionResult) {
+		if result.CallSignal == nil {
+			result.CallSignal = result.Signal.Copy()
+		}
+		inputs = append(inputs, replayInput{hash: hash, result: result})
+	}
+	// 空信号、没有执行时间。
+	add("empty", ExecutionResult{})
+	// 全新覆盖。
+	add("new", ExecutionResult{Signal: sig(1, 2, 3), ExecTime: 1000})
+	// 相同路径重复出现，稀有性逐渐降低。
+	for i := 0; i < 3; i++ {
+		add("repeat", ExecutionResult{Signal: sig(1, 2, 3), ExecTime: 1000})
+	}
+	// 部分新覆盖。
+	add("partial", ExecutionResult{Signal: sig(3, 4), ExecTime: 1100})
+	// extra 信号只出现在 Signal 中。
+	add("extra", ExecutionResult{Signal: sig(5, 100), CallSignal: sig(5), ExecTime: 900})
+	// 崩溃日志以及匹配多个模式的日志。
+	add("crash", ExecutionResult{
+		Signal:     sig(6),
+		ExecTime:   1000,
+		Crashed:    true,
+		KernelLogs: []string{"KASAN: use-after-free Read in foo"},
+	})
+	add("multi", ExecutionResult{
+		Signal:   sig(7),
+		ExecTime: 1000,
+		KernelLogs: []string{
+			"WARNING: CPU: 0 PID: 1 at foo",
+			"BUG: sleeping function called from invalid context",
+			"possible deadlock in bar",
+		},
+	})
+	// 稳定的执行时间之后出现异常值。
+	for i := 0; i < 10; i++ {
+		add("steady", ExecutionResult{Signal: sig(8), ExecTime: 1000 + uint64(i%3)})
+	}
+	add("slow", ExecutionResult{Signal: sig(9), ExecTime: 100000})
+	add("fast", ExecutionResult{Signal: sig(10), ExecTime: 1})
+	// 执行器错误不参与评分。
+	add("error", ExecutionResult{Signal: sig(11), ExecTime: 1000, Error: "executor failed"})
+	// 故障注入的执行不更新正常基线。
+	add("fault", ExecutionResult{Signal: sig(1, 2, 3), ExecTime: 50000})
+	inputs[len(inputs)-1].faultInjected = true
+	// 重新评分已有的程序。
+	add("new", ExecutionResult{Signal: sig(1, 2, 3, 12), ExecTime: 1000})
+	return inputs
+}
+
+// scoringReplaySnapshot 是回放结束后评分系统的完整状态
+type scoringReplaySnapshot struct {
+	Results       []*ProgScore          `json:"results"`
+	Scores        map[string]*ProgScore `json:"scores"`
+	PCHitCounts   map[uint64]int64      `json:"pc_hit_counts"`
+	ExecTimeMean  float64               `json:"exec_time_mean"`
+	ExecTimeStd   float64               `json:"exec_time_std"`
+	ExecTimeCount int64                 `json:"exec_time_count"`
+	Metrics       *flatrpc.ScoreMetrics `json:"metrics"`
+}
+
+// replayScoring 使用固定时钟按顺序回放输入，返回序列化后的状态快照
+func replayScoring(t *testing.T) []byte {
+	st := NewScoreTracker(DefaultScoreConfig())
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	st.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	metrics := flatrpc.NewScoreMetrics()
+	snapshot := &scoringReplaySnapshot{}
+	for _, input := range scoringReplayInputs() {
+		result := input.result
+		score := st.updateScore(input.hash, input.faultInjected, &result)
+		snapshot.Results = append(snapshot.Results, score)
+		if score != nil {
+			metrics.UpdateMetrics(score.Total, false, 0)
+			metrics.UpdateDimensionScores(score.Coverage, score.Rarity, score.KernelLog, score.TimeAnomaly, score.Sequence)
+		}
+	}
+	snapshot.Scores = st.scores
+	snapshot.PCHitCounts = st.pcHitCounts
+	snapshot.ExecTimeMean, snapshot.ExecTimeStd, snapshot.ExecTimeCount = st.execTimeStats.GetStats()
+	snapshot.Metrics = metrics.Snapshot()
+	snapshot.Metrics.LastUpdated = time.Time{}
+	data, err := json.MarshalIndent(snapshot, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// TestScoringReplay 回放固定的输入序列并与保存的快照比较，防止评分流程的行为在不知不觉中改变。
+// 有意修改评分行为后，使用 -update 重新生成快照并检查差异。
+func TestScoringReplay(t *testing.T) {
+	got := replayScoring(t)
+	assert.Equal(t, string(got), string(replayScoring(t)), "回放结果不确定")
+
+	golden := filepath.Join("testdata", scoringReplayGolden)
+	if *flagUpdate {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Skipf("快照 %v 不存在，使用 -update 生成", golden)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(want), string(got))
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"sort"
+)
+
+// WeightSensitivity 描述单个权重扰动对 top-N 排名的影响
+type WeightSensitivity struct {
+	// 维度名称 (coverage, rarity, kernel_log, time_anomaly)
+	Dimension string `json:"dimension"`
+	// 权重增加 delta 后 top-N 中位置发生变化的数量
+	Increase int `json:"increase"`
+	// 权重减少 delta 后 top-N 中位置发生变化的数量
+	Decrease int `json:"decrease"`
+}
+
+// WeightSensitivity 对每个维度的权重分别做 ±delta 扰动，
+// 使用已保存的各维度分数重新计算总分，报告 top-N 排名的变化程度。
+// 变化越大说明排序对该权重越敏感。扰动后的权重不会小于 0。
+func (st *ScoreTracker) WeightSensitivity(topN int, delta float64) []WeightSensitivity {
+	st.mu.RLock()
+	hashes := make([]string, 0, len(st.scores))
+	dims := make([][]float64, 0, len(st.scores))
+	for hash, score := range st.scores {
+		hashes = append(hashes, hash)
+		dims = append(dims, score.dimensions())
+	}
+	weights := st.config.weights()
+	st.mu.RUnlock()
+
+	base := rankByWeights(hashes, dims, weights, topN)
+	var report []WeightSensitivity
+	for i, name := range scoreDimensionNames {
+		perturbed := func(d float64) int {
+			w := append([]float64(nil), weights...)
+			w[i] = max(w[i]+d, 0)
+			return rankingDistance(base, rankByWeights(hashes, dims, w, topN))
+		}
+		report = append(report, WeightSensitivity{
+			Dimension: name,
+			Increase:  perturbed(delta),
+			Decrease:  perturbed(-delta),
+		})
+	}
+	return report
+}
+
+// scoreDimensionNames 各评分维度的名称，顺序与 ProgScore.dimensions 和 ScoreConfig.weights 一致
+var scoreDimensionNames = []string{"coverage", "rarity", "kernel_log", "time_anomaly", "sequence"}
+
+func (ps *ProgScore) dimensions() []float64 {
+	return []float64{ps.Coverage, ps.Rarity, ps.KernelLog, ps.TimeAnomaly, ps.Sequence}
+}
+
+// weights 返回各维度的有效权重，关闭的维度权重为 0
+func (sc *ScoreConfig) weights() []float64 {
+	weights := []float64{sc.CoverageWeight, sc.RarityWeight, sc.KernelLogWeight, sc.TimeAnomalyWeight, sc.SequenceWeight}
+	for i, disabled := range sc.disabled() {
+		if disabled {
+			weights[i] = 0
+		}
+	}
+	return weights
+}
+
+// setWeights 设置各维度的权重 (顺序与 weights 相同)，关闭的维度保持配置的权重不变
+func (sc *ScoreConfig) setWeights(weights []float64) {
+	disabled := sc.disabled()
+	for i, w := range []*float64{&sc.CoverageWeight, &sc.RarityWeight, &sc.KernelLogWeight, &sc.TimeAnomalyWeight,
+		&sc.SequenceWeight} {
+		if !disabled[i] {
+			*w = weights[i]
+		}
+	}
+}
+
+// disabled 返回各维度是否被关闭，顺序与 weights 相同
+func (sc *ScoreConfig) disabled() []bool {
+	return []bool{sc.DisableCoverage, sc.DisableRarity, sc.DisableKernelLog, sc.DisableTimeAnomaly, sc.DisableSequence}
+}
+
+// rankByWeights 按给定权重计算总分并返回 top-N 的程序哈希 (同分时按哈希排序以保证确定性)
+func rankByWeights(hashes []string, dims [][]float64, weights []float64, topN int) []string {
+	totals := make(map[string]float64, len(hashes))
+	for i, hash := range hashes {
+		total := 0.0
+		for j, w := range weights {
+			total += w * dims[i][j]
+		}
+		totals[hash] = total
+	}
+	ranked := append([]string(nil), hashes...)
+	sort.Slice(ranked, func(i, j int) bool {
+		if totals[ranked[i]] != totals[ranked[j]] {
+			return totals[ranked[i]] > totals[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	if len(ranked) > topN {
+		ranked = ranked[:topN]
+	}
+	return ranked
+}
+
+// rankingDistance 返回两个排名中位置不同的数量
+func rankingDistance(a, b []string) int {
+	diff := 0
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			diff++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScoringConcurrentStress 并发调用评分系统的所有公开操作。
+// 该测试本身只检查不变量，主要作用是在 -race 下运行，记录评分系统哪些操作可以并发调用。
+func TestScoringConcurrentStress(t *testing.T) {
+	iters := 2000
+	if testing.Short() || testutil.RaceEnabled {
+		iters = 200
+	}
+	s := newScoring(DefaultScoreConfig())
+	sp := newScorePersister(s.tracker, "scores.json", 0, func(level int, msg string, args ...interface{}) {
+		t.Logf(msg, args...)
+	})
+	sp.write = func(filename string, data []byte) error {
+		return nil
+	}
+	hashOf := func(rnd *rand.Rand) string {
+		return fmt.Sprintf("prog%v", rnd.Intn(100))
+	}
+	ops := []func(rnd *rand.Rand){
+		// 评分，同时更新跟踪器、选择器和指标。
+		func(rnd *rand.Rand) {
+			hash := hashOf(rnd)
+			result := &ExecutionResult{
+				Signal:   signal.FromRaw([]uint64{uint64(rnd.Intn(50)), uint64(rnd.Intn(50))}, 0),
+				ExecTime: uint64(rnd.Intn(1000000) + 1),
+			}
+			if rnd.Intn(10) == 0 {
+				result.KernelLogs = []string{"KASAN: use-after-free Read in foo"}
+			}
+			if score := s.tracker.updateScore(hash, rnd.Intn(5) == 0, result); score != nil {
+				s.selector.UpdateWeight(hash, score.Total)
+				s.metrics.UpdateMetrics(score.Total, false, 1)
+				s.metrics.UpdateDimensionScores(score.Coverage, score.Rarity, score.KernelLog, score.TimeAnomaly, score.Sequence)
+			}
+		},
+		func(rnd *rand.Rand) { s.Select(rnd) },
+		func(rnd *rand.Rand) { s.selector.SelectWeighted(rnd.Float64()) },
+		func(rnd *rand.Rand) { s.inherit(hashOf(rnd), hashOf(rnd)) },
+		func(rnd *rand.Rand) { s.invalidate(hashOf(rnd)) },
+		func(rnd *rand.Rand) { s.tracker.GetScoreByHash(hashOf(rnd)) },
+		func(rnd *rand.Rand) { s.tracker.GetTopScoredProgs(10) },
+		func(rnd *rand.Rand) { s.tracker.WeightSensitivity(10, 0.1) },
+		func(rnd *rand.Rand) { s.tracker.DumpScores(io.Discard) },
+		func(rnd *rand.Rand) { s.tracker.DistinctPCs() },
+		func(rnd *rand.Rand) { s.health(true, 1) },
+		func(rnd *rand.Rand) { json.Marshal(s.metrics.Snapshot()) },
+		func(rnd *rand.Rand) { s.tracker.execTimeStats.GetStats() },
+		func(rnd *rand.Rand) {
+			s.tracker.logMatcher.GetMatchedPatterns([]string{"WARNING: CPU: 0 PID: 1 at foo"})
+		},
+		func(rnd *rand.Rand) {
+			s.tracker.logMatcher.AddCustomPattern(fmt.Sprintf("custom%v", rnd.Intn(10)), 0.5, "custom")
+		},
+		func(rnd *rand.Rand) { sp.flush() },
+		// 运行时替换配置。
+		func(rnd *rand.Rand) {
+			config := DefaultScoreConfig()
+			config.DecorrelateNovelty = rnd.Intn(2) == 0
+			if err := s.tracker.SetConfig(config); err != nil {
+				t.Error(err)
+			}
+		},
+	}
+
+	seed := testutil.RandSource(t).Int63()
+	var wg sync.WaitGroup
+	for g := 0; g < 2*len(ops); g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed + int64(g)))
+			for i := 0; i < iters; i++ {
+				// 一半的 goroutine 专门执行某个操作，其余随机执行各种操作。
+				op := g % len(ops)
+				if g >= len(ops) {
+					op = rnd.Intn(len(ops))
+				}
+				ops[op](rnd)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 跟踪器和选择器中的程序都来自同一组哈希，指标统计了所有成功的评分。
+	assert.LessOrEqual(t, s.tracker.TrackedProgs(), 100)
+	assert.LessOrEqual(t, s.selector.Len(), 100)
+	assert.Positive(t, s.metrics.Snapshot().TotalRequests)
+	assert.NoError(t, sp.flush())
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"sort"
+
+	"github.com/google/syzkaller/prog"
+)
+
+// SyscallScore 汇总包含某个系统调用的已评分程序的评分
+type SyscallScore struct {
+	// 系统调用名 (prog.Syscall.CallName，不区分变体)
+	Name string `json:"name"`
+	// 包含该系统调用的已评分程序数量
+	Programs int `json:"programs"`
+	// 这些程序的总分之和
+	TotalScore float64 `json:"total_score"`
+	// 这些程序的平均总分
+	AverageScore float64 `json:"average_score"`
+}
+
+// syscallScore 是一个系统调用的累计贡献
+type syscallScore struct {
+	programs int
+	total    float64
+}
+
+// progSyscalls 返回程序中不同的系统调用名
+func progSyscalls(p *prog.Prog) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, c := range p.Calls {
+		if !seen[c.Meta.CallName] {
+			seen[c.Meta.CallName] = true
+			names = append(names, c.Meta.CallName)
+		}
+	}
+	return names
+}
+
+// addSyscallScoresLocked 把程序的总分计入它包含的每个系统调用
+func (st *ScoreTracker) addSyscallScoresLocked(progHash string, syscalls []string, total float64) {
+	if len(syscalls) == 0 {
+		return
+	}
+	st.progSyscalls[progHash] = syscalls
+	for _, name := range syscalls {
+		sc := st.syscallScores[name]
+		if sc == nil {
+			sc = new(syscallScore)
+			st.syscallScores[name] = sc
+		}
+		sc.programs++
+		sc.total += total
+	}
+}
+
+// removeSyscallScoresLocked 撤销程序当前评分的贡献，返回程序的系统调用 (未记录时为 nil)。
+// 必须在程序的评分被替换或删除之前调用，因此每个程序最多只被计入一次。
+func (st *ScoreTracker) removeSyscallScoresLocked(progHash string) []string {
+	syscalls := st.progSyscalls[progHash]
+	score := st.scores[progHash]
+	if syscalls == nil || score == nil {
+		return nil
+	}
+	delete(st.progSyscalls, progHash)
+	for _, name := range syscalls {
+		sc := st.syscallScores[name]
+		sc.programs--
+		sc.total -= score.Total
+		if sc.programs == 0 {
+			delete(st.syscallScores, name)
+		}
+	}
+	return syscalls
+}
+
+// TopSyscallsByScore 按已评分程序的总分之和降序返回贡献最大的 n 个系统调用，
+// 用于查看哪些系统调用的程序占据了高分区间。每个程序只按其最新的评分计入一次，
+// 被淘汰或失效的程序不再计入。
+func (st *ScoreTracker) TopSyscallsByScore(n int) []SyscallScore {
+	st.mu.RLock()
+	result := make([]SyscallScore, 0, len(st.syscallScores))
+	for name, sc := range st.syscallScores {
+		result = append(result, SyscallScore{
+			Name:         name,
+			Programs:     sc.programs,
+			TotalScore:   sc.total,
+			AverageScore: sc.total / float64(sc.programs),
+		})
+	}
+	st.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalScore != result[j].TotalScore {
+			return result[i].TotalScore > result[j].TotalScore
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result[:min(max(n, 0), len(result))]
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/flatrpc"
+	"github.com/google/syzkaller/prog"
+)
+
+// weightedSelectTop 加权选择时参与随机挑选的高分程序数量
+const weightedSelectTop = 50
+
+// defaultWeightedQueueSize 加权队列的默认最大长度
+const defaultWeightedQueueSize = 1000
+
+// hintNewSignalBoost 发现了新信号的 hints 变异程序在加权选择器中的权重倍数
+const hintNewSignalBoost = 2.0
+
+// faultNewSignalBoost 发现了新信号的故障注入程序在加权选择器中的权重倍数
+const faultNewSignalBoost = 2.0
+
+// scoring 把评分跟踪器、加权选择器和评分指标组合在一起。
+// 一次评分总是按 跟踪器 -> 选择器 -> 指标 的顺序更新三者，
+// 并且在同一把锁下完成，因此并发评分时三者看到的更新顺序一致，也不会遗漏其中之一。
+// 评分的计算不在这把锁下进行，见 Score。
+type scoring struct {
+	mu       sync.Mutex
+	tracker  *ScoreTracker
+	selector *WeightedSelector
+	metrics  *flatrpc.ScoreMetrics
+
+	// 确定性模式下所有基于评分的选择共用的随机数流 (见 ScoreConfig.Deterministic)，否则为 nil
+	rndMu sync.Mutex
+	rnd   *rand.Rand
+
+	// 外部评分函数 (见 Config.ScoreFunc)，为 nil 时使用跟踪器内置的评分计算
+	scoreFunc func(*prog.Prog, *ExecutionResult) *ProgScore
+
+	// 把选择器权重同步给语料库 (Corpus.SetProgramWeight)，为 nil 时不同步。
+	// 语料库增量地维护自己程序的权重，选择语料库程序时不需要复制或遍历选择器的所有权重。
+	corpusWeight func(progHash string, weight float64)
+}
+
+func newScoring(config *ScoreConfig) *scoring {
+	s := &scoring{
+		tracker:  NewScoreTracker(config),
+		selector: NewWeightedSelector(),
+		metrics:  flatrpc.NewScoreMetrics(),
+	}
+	// 淘汰总是发生在 s.mu 下的跟踪器更新中，这里不需要再加锁
+	s.tracker.onEvictCorpus = s.removeWeight
+	return s
+}
+
+// Score 计算程序评分并同时更新加权选择器和评分指标。
+// 设置了外部评分函数时由它计算评分，跟踪器只记录结果。评分在锁外计算
+// (内置的计算只持有跟踪器的读锁，见 ScoreTracker.computeScore)，锁内只记录结果，
+// 因此并发的评分可以同时计算，较慢的外部评分函数 (例如通过网络请求模型) 也不会阻塞其他评分的记录。
+func (s *scoring) Score(p *prog.Prog, execResult *ExecutionResult) *ProgScore {
+	start := time.Now()
+	var external *ProgScore
+	var pending *pendingScore
+	if s.scoreFunc != nil {
+		if execResult.Error == "" && !s.tracker.excluded(p, execResult) {
+			external = s.scoreFunc(p, execResult)
+		}
+	} else {
+		pending = s.tracker.prepareScore(p, execResult)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var progScore *ProgScore
+	if s.scoreFunc != nil {
+		progScore = s.tracker.recordScore(p, execResult, external)
+	} else {
+		progScore = s.tracker.commitScore(pending)
+	}
+	if progScore == nil {
+		return nil
+	}
+	if p != nil {
+		s.setWeight(p.Hash(), progScore)
+	}
+	s.metrics.UpdateMetrics(progScore.Total, false, time.Since(start).Nanoseconds())
+	s.metrics.UpdateDimensionScores(
+		progScore.Coverage, progScore.Rarity,
+		progScore.KernelLog, progScore.TimeAnomaly, progScore.Sequence)
+	if times := progScore.dimensionTimes; times != ([5]time.Duration{}) {
+		s.metrics.UpdateDimensionTimes(times[0], times[1], times[2], times[3], times[4])
+	}
+	return progScore
+}
+
+// inherit 让哈希已改变的程序 (例如最小化后的程序) 继承原始程序的评分，
+// 同时更新加权选择器，使以最终哈希保存到语料库的程序也有对应的评分。
+func (s *scoring) inherit(from, to string) *ProgScore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progScore := s.tracker.inheritScore(from, to)
+	if progScore != nil {
+		s.setWeight(to, progScore)
+	}
+	return progScore
+}
+
+// markHintNewSignal 提高发现了新信号的 hints 变异程序的选择器权重，
+// 使加权生成路径更多地回到这些程序上。程序尚未评分时忽略。
+func (s *scoring) markHintNewSignal(progHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if progScore := s.tracker.markHintNewSignal(progHash); progScore != nil {
+		s.setWeight(progHash, progScore)
+	}
+}
+
+// recordFaultInjection 把向程序 progHash 注入故障的一次执行计入评分指标。
+// 带来了新信号时同时提高该 (未注入故障的) 程序的选择器权重，
+// 使加权选择更多地回到找到了新错误处理路径的程序上。程序尚未评分时只计入指标。
+func (s *scoring) recordFaultInjection(progHash string, newSignal bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.UpdateFaultInjectionStats(newSignal)
+	if !newSignal {
+		return
+	}
+	if progScore := s.tracker.markFaultNewSignal(progHash); progScore != nil {
+		s.setWeight(progHash, progScore)
+	}
+}
+
+// decay 让评分随时间衰减 (见 ScoreTracker.Decay)，同时更新衰减了的程序的选择器权重
+func (s *scoring) decay(halfLife time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for progHash, progScore := range s.tracker.decay(halfLife) {
+		s.setWeight(progHash, progScore)
+	}
+}
+
+// setWeight 更新程序在选择器中的权重，并同步给语料库。
+// 只有语料库中的程序有权重: 加权选择只会选择语料库程序，
+// 而大部分评分属于从未保存的变异程序，它们的权重只会让选择器无限增长。
+// 程序保存到语料库时由 markInCorpus 补上权重。
+func (s *scoring) setWeight(progHash string, progScore *ProgScore) {
+	if !s.tracker.inCorpus(progHash) {
+		return
+	}
+	weight := selectorWeight(progScore)
+	s.selector.UpdateWeight(progHash, weight)
+	if s.corpusWeight != nil {
+		s.corpusWeight(progHash, weight)
+	}
+}
+
+// removeWeight 删除程序在选择器中的权重，并清除语料库中的权重
+func (s *scoring) removeWeight(progHash string) {
+	s.selector.RemoveWeight(progHash)
+	if s.corpusWeight != nil {
+		s.corpusWeight(progHash, 0)
+	}
+}
+
+// markInCorpus 记录程序已保存到语料库，并按程序已有的评分设置选择器和语料库中的权重
+// (程序评分时还不在语料库中，当时没有设置权重)。尚未评分的程序不记录 (见 ScoreTracker.markInCorpus)。
+func (s *scoring) markInCorpus(progHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tracker.markInCorpus(progHash) {
+		return
+	}
+	if progScore := s.tracker.scoreOf(progHash); progScore != nil {
+		s.setWeight(progHash, progScore)
+	}
+}
+
+// selectorWeight 返回评分在加权选择器中对应的权重
+func selectorWeight(progScore *ProgScore) float64 {
+	weight := progScore.Total
+	if progScore.HintNewSignal {
+		weight *= hintNewSignalBoost
+	}
+	if progScore.FaultNewSignal {
+		weight *= faultNewSignalBoost
+	}
+	return weight
+}
+
+// invalidate 删除程序的缓存评分和选择器权重，用于重新 triage 的程序
+func (s *scoring) invalidate(progHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracker.InvalidateProgram(progHash)
+	s.removeWeight(progHash)
+}
+
+// reset 清除所有评分和选择器权重，评分指标继续累计
+func (s *scoring) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracker.Reset()
+	if s.corpusWeight != nil {
+		for _, progHash := range s.selector.hashes() {
+			s.corpusWeight(progHash, 0)
+		}
+	}
+	s.selector.Reset()
+}
+
+// Select 从语料库中评分最高的程序中随机选择一个，没有已评分的语料库程序时返回空字符串
+func (s *scoring) Select(rnd *rand.Rand) string {
+	topProgs := s.tracker.GetTopScoredCorpusProgs(weightedSelectTop)
+	if len(topProgs) == 0 {
+		return ""
+	}
+	var idx int
+	s.withRand(rnd, func(rnd *rand.Rand) {
+		idx = rnd.Intn(len(topProgs))
+	})
+	return topProgs[idx].Hash
+}
+
+// withRand 用基于评分的选择应当使用的随机数调用 fn。
+// 确定性模式下 fn 串行地使用评分系统自己的随机数流，否则直接使用调用方的 rnd。
+func (s *scoring) withRand(rnd *rand.Rand, fn func(rnd *rand.Rand)) {
+	if s.rnd == nil {
+		fn(rnd)
+		return
+	}
+	s.rndMu.Lock()
+	defer s.rndMu.Unlock()
+	fn(s.rnd)
+}
+
+// Metrics 返回评分指标
+func (s *scoring) Metrics() *flatrpc.ScoreMetrics {
+	return s.metrics
+}
+
+// ScoringHealth 汇总评分系统的运行状态，供管理器的状态页面确认评分系统正常工作
+type ScoringHealth struct {
+	Enabled bool `json:"enabled"`
+	// 记录了评分的程序数量
+	TrackedProgs int `json:"tracked_progs"`
+	// 加权选择器中的程序数量
+	SelectorSize int `json:"selector_size"`
+	// 评分指标的一致性快照
+	Metrics *flatrpc.ScoreMetrics `json:"metrics"`
+	// 从未产生过非零分数的维度
+	DeadDimensions []string `json:"dead_dimensions"`
+	// 实际参与评分的执行结果比例 (异步评分关闭后到达的结果不再评分)
+	SamplingFraction float64 `json:"sampling_fraction"`
+}
+
+// health 返回评分系统的状态，samplingFraction 由调用方根据评分方式提供
+func (s *scoring) health(enabled bool, samplingFraction float64) *ScoringHealth {
+	return &ScoringHealth{
+		Enabled:          enabled,
+		TrackedProgs:     s.tracker.TrackedProgs(),
+		SelectorSize:     s.selector.Len(),
+		Metrics:          s.metrics.Snapshot(),
+		DeadDimensions:   s.metrics.DeadDimensions(),
+		SamplingFraction: samplingFraction,
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys/targets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoringConsistent(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	s := newScoring(DefaultScoreConfig())
+
+	// 不在语料库中的程序没有选择器权重，也不会被选择。
+	s.Score(p, &ExecutionResult{Signal: signal.FromRaw([]uint64{1000}, 0), ExecTime: 1000000})
+	assert.Zero(t, s.selector.Len())
+	assert.Equal(t, "", s.Select(rnd))
+	s.markInCorpus(p.Hash())
+
+	// 同一程序被并发评分，评分结果各不相同。
+	const scorers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < scorers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Score(p, &ExecutionResult{
+				Signal:   signal.FromRaw([]uint64{uint64(i), uint64(i + 100)}, 0),
+				ExecTime: uint64(i+1) * 1000000,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	// 选择器中的权重必须与跟踪器中最后一次的评分一致，每次评分都计入指标。
+	score := s.tracker.scoreOf(p.Hash())
+	assert.NotNil(t, score)
+	s.selector.mu.RLock()
+	weight := s.selector.weights[p.Hash()]
+	s.selector.mu.RUnlock()
+	assert.Equal(t, score.Total, weight)
+	assert.Equal(t, int64(scorers+1), s.Metrics().TotalRequests)
+	assert.Equal(t, p.Hash(), s.Select(rnd))
+}
+
+func TestScoringHealth(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	scoreConfig := DefaultScoreConfig()
+	fuzzer := &Fuzzer{
+		Config:      &Config{ScoreConfig: scoreConfig},
+		scoring:     newScoring(scoreConfig),
+		asyncScorer: newAsyncScorer(1),
+	}
+	defer fuzzer.asyncScorer.shutdown()
+
+	health := fuzzer.ScoringHealth()
+	assert.True(t, health.Enabled)
+	assert.Equal(t, 0, health.TrackedProgs)
+	assert.Empty(t, health.DeadDimensions)
+
+	const progs = 5
+	for i := 0; i < progs; i++ {
+		p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+		fuzzer.scoring.Score(p, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i)}, 0),
+			ExecTime: 1000000,
+		})
+		// 只有语料库中的程序进入选择器。
+		if i%2 == 0 {
+			fuzzer.scoring.markInCorpus(p.Hash())
+		}
+	}
+	health = fuzzer.ScoringHealth()
+	assert.Equal(t, progs, health.TrackedProgs)
+	assert.Equal(t, (progs+1)/2, health.SelectorSize)
+	assert.Equal(t, int64(progs), health.Metrics.TotalRequests)
+	// 没有内核日志，该维度从未生效。
+	assert.Contains(t, health.DeadDimensions, "kernel_log")
+	assert.NotContains(t, health.DeadDimensions, "coverage")
+	assert.Equal(t, 1.0, health.SamplingFraction)
+
+	scoreConfig.Enabled = false
+	assert.Equal(t, 0.0, fuzzer.ScoringHealth().SamplingFraction)
+}
+
+func TestScoringDimensionTimes(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	score := func(s *scoring) {
+		for i := 0; i < 10; i++ {
+			s.Score(target.Generate(rnd, 5, target.DefaultChoiceTable()), &ExecutionResult{
+				Signal:     signal.FromRaw([]uint64{uint64(i), uint64(i + 100)}, 0),
+				ExecTime:   uint64(i+1) * 1000000,
+				KernelLogs: []string{"KASAN: use-after-free Read in foo", "possible deadlock in bar"},
+			})
+		}
+	}
+
+	// 默认不计时。
+	s := newScoring(DefaultScoreConfig())
+	score(s)
+	metrics := s.Metrics().Snapshot()
+	assert.Zero(t, metrics.CoverageCalculationTime+metrics.RarityCalculationTime+
+		metrics.KernelLogCalculationTime+metrics.TimeAnomalyCalculationTime+metrics.SequenceCalculationTime)
+
+	scoreConfig := DefaultScoreConfig()
+	scoreConfig.ProfileDimensions = true
+	s = newScoring(scoreConfig)
+	score(s)
+	first := s.Metrics().Snapshot()
+	assert.Positive(t, first.KernelLogCalculationTime)
+	assert.Positive(t, first.CoverageCalculationTime+first.RarityCalculationTime+first.TimeAnomalyCalculationTime)
+	// 计时在多次评分之间累加。
+	score(s)
+	second := s.Metrics().Snapshot()
+	assert.Greater(t, second.KernelLogCalculationTime, first.KernelLogCalculationTime)
+	assert.GreaterOrEqual(t, second.CoverageCalculationTime, first.CoverageCalculationTime)
+	assert.GreaterOrEqual(t, second.RarityCalculationTime, first.RarityCalculationTime)
+	assert.GreaterOrEqual(t, second.TimeAnomalyCalculationTime, first.TimeAnomalyCalculationTime)
+	assert.GreaterOrEqual(t, second.SequenceCalculationTime, first.SequenceCalculationTime)
+}
+
+func TestScoringScoreFunc(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	s := newScoring(DefaultScoreConfig())
+	var external *ProgScore
+	calls := 0
+	s.scoreFunc = func(p *prog.Prog, execResult *ExecutionResult) *ProgScore {
+		calls++
+		return external
+	}
+	execResult := &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	}
+
+	// 内置的评分计算会给全新的信号满分的覆盖率，外部评分函数的结果原样记录。
+	for _, test := range []struct {
+		total, want float64
+	}{
+		{0.3, 0.3},
+		{1.7, 1.0},
+		{-0.2, 0.0},
+	} {
+		p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+		external = &ProgScore{Total: test.total, KernelLog: 0.7}
+		score := s.Score(p, execResult)
+		s.markInCorpus(p.Hash())
+		assert.Equal(t, test.want, score.Total)
+		assert.Equal(t, 0.7, score.KernelLog)
+		assert.Zero(t, score.Coverage)
+		assert.Equal(t, score, s.tracker.scoreOf(p.Hash()))
+		s.selector.mu.RLock()
+		assert.Equal(t, test.want, s.selector.weights[p.Hash()])
+		s.selector.mu.RUnlock()
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, int64(3), s.Metrics().TotalRequests)
+	assert.Empty(t, s.tracker.pcHitCounts)
+
+	// 外部评分函数返回 nil 时不记录评分，执行出错时不调用外部评分函数。
+	external = nil
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	assert.Nil(t, s.Score(p, execResult))
+	assert.Nil(t, s.tracker.scoreOf(p.Hash()))
+	assert.Nil(t, s.Score(p, &ExecutionResult{Error: "executor failed"}))
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, int64(3), s.Metrics().TotalRequests)
+
+	// 外部评分设置的新信号标记被保留，重新评分也不清除已有的标记。
+	external = &ProgScore{Total: 0.6, HintNewSignal: true}
+	assert.True(t, s.Score(p, execResult).HintNewSignal)
+	external = &ProgScore{Total: 0.6}
+	assert.True(t, s.Score(p, execResult).HintNewSignal)
+	assert.Equal(t, 6, calls)
+
+	// 包含被排除的调用的程序不调用外部评分函数，直接得到中等分数。
+	config := DefaultScoreConfig()
+	config.ScoreExcludedCalls = map[string]bool{p.Calls[0].Meta.CallName: true}
+	assert.NoError(t, s.tracker.SetConfig(config))
+	external = &ProgScore{Total: 0.9}
+	score := s.Score(p, execResult)
+	assert.Equal(t, neutralScore, score.Total)
+	assert.Equal(t, 6, calls)
+}
+
+func TestScoringEvictionRemovesWeight(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	config := DefaultScoreConfig()
+	config.MaxTrackedProgs = 3
+	s := newScoring(config)
+	corpusWeights := map[string]float64{}
+	s.corpusWeight = func(progHash string, weight float64) {
+		corpusWeights[progHash] = weight
+	}
+	execResult := &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	}
+	p := target.Generate(rnd, 5, target.DefaultChoiceTable())
+	s.Score(p, execResult)
+	s.markInCorpus(p.Hash())
+	assert.Equal(t, 1, s.selector.Len())
+	assert.Positive(t, corpusWeights[p.Hash()])
+
+	// 评分被 LRU 淘汰的语料库程序的权重也被删除，选择器的大小受 MaxTrackedProgs 限制。
+	for i := 0; i < 2*config.MaxTrackedProgs; i++ {
+		s.Score(target.Generate(rnd, 5, target.DefaultChoiceTable()), execResult)
+	}
+	assert.Nil(t, s.tracker.scoreOf(p.Hash()))
+	assert.Zero(t, s.selector.Len())
+	assert.Zero(t, corpusWeights[p.Hash()])
+}
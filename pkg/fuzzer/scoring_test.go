@@ -4,11 +4,28 @@
 package fuzzer
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/flatrpc"
+	"github.com/google/syzkaller/pkg/fuzzer/queue"
 	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/pkg/testutil"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys/targets"
 )
 
 func TestScoreTracker(t *testing.T) {
@@ -17,7 +34,7 @@ func TestScoreTracker(t *testing.T) {
 	
 	// 创建测试程序
 	target := getTestTarget()
-	p := target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+	p := target.Generate(rand.New(testutil.RandSource(t)), prog.RecommendedCalls, target.DefaultChoiceTable())
 	
 	// 创建测试执行结果
 	execResult := &ExecutionResult{
@@ -53,16 +70,46 @@ func TestScoreTracker(t *testing.T) {
 	}
 	
 	// 测试评分缓存
-	cachedScore := tracker.GetScore(p.Hash())
+	cachedScore := tracker.GetScoreByHash(p.Hash())
 	if cachedScore == nil {
-		t.Error("评分缓存失败")
+		t.Fatal("评分缓存失败")
 	}
 	if cachedScore.Total != score.Total {
 		t.Errorf("缓存评分不匹配: 期望 %f, 实际 %f", score.Total, cachedScore.Total)
 	}
+	// 两者返回各自的副本，按值比较
+	if byProg := tracker.GetScore(p); !reflect.DeepEqual(byProg, cachedScore) {
+		t.Errorf("GetScore 与 GetScoreByHash 的结果不一致: %+v != %+v", byProg, cachedScore)
+	}
+}
+
+func TestGetScoreUnscored(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := target.Generate(rand.New(testutil.RandSource(t)), 5, target.DefaultChoiceTable())
+	tracker := NewScoreTracker(DefaultScoreConfig())
+
+	// 从未评分的程序: 按哈希查询返回 nil，GetScore 返回默认的中等分数。
+	if score := tracker.GetScoreByHash(p.Hash()); score != nil {
+		t.Errorf("未评分的程序按哈希查询应返回 nil, 实际为 %+v", score)
+	}
+	if score := tracker.GetScore(p); score == nil || score.Total != neutralScore {
+		t.Errorf("未评分的程序应返回默认分数, 实际为 %+v", score)
+	}
+	// 评分后两者都返回记录的评分 (的副本)。
+	scored := tracker.UpdateScore(p, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	})
+	if *tracker.GetScoreByHash(p.Hash()) != *scored || *tracker.GetScore(p) != *scored {
+		t.Errorf("评分后查询结果与记录的评分不一致")
+	}
 }
 
 func TestWeightedSelector(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
 	selector := NewWeightedSelector()
 	
 	// 添加测试权重
@@ -78,7 +125,7 @@ func TestWeightedSelector(t *testing.T) {
 	totalSelections := 1000
 	
 	for i := 0; i < totalSelections; i++ {
-		selected := selector.WeightedSelect(hashes)
+		selected := selector.WeightedSelect(rnd, hashes)
 		if selected == "" {
 			t.Error("加权选择返回空值")
 			continue
@@ -94,6 +141,73 @@ func TestWeightedSelector(t *testing.T) {
 	t.Logf("选择分布: %v", selections)
 }
 
+func TestWeightedSelectCandidates(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	selector := NewWeightedSelector()
+	selector.UpdateWeight("hash1", 0.5)
+	selector.UpdateWeight("hash2", 0)
+	selector.UpdateWeight("other", 0.9)
+
+	if selected := selector.WeightedSelect(rnd, nil); selected != "" {
+		t.Errorf("候选为空时应返回空字符串，实际 %q", selected)
+	}
+	for _, hashes := range [][]string{
+		{"hash1", "hash2", "unknown"},
+		{"hash2", "unknown"},
+		{"unknown1", "unknown2", "unknown3"},
+	} {
+		selections := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			selected := selector.WeightedSelect(rnd, hashes)
+			found := false
+			for _, hash := range hashes {
+				found = found || hash == selected
+			}
+			if !found {
+				t.Fatalf("选择的程序 %q 不在候选 %v 中", selected, hashes)
+			}
+			selections[selected]++
+		}
+		// 有权重的候选存在时，其他候选不会被选中；否则均匀选择。
+		if hashes[0] == "hash1" && selections["hash1"] != 300 {
+			t.Errorf("未按权重选择: %v", selections)
+		}
+		if hashes[0] != "hash1" && len(selections) < 2 {
+			t.Errorf("没有权重时未均匀选择: %v", selections)
+		}
+	}
+}
+
+// 相同的权重和相同的随机数序列在独立的选择器中得到相同的选择序列，
+// 与权重的插入顺序和权重表重建的次数无关。
+func TestSelectWeightedDeterministic(t *testing.T) {
+	weights := make(map[string]float64)
+	for i := 0; i < 100; i++ {
+		weights[fmt.Sprintf("prog%v", i)] = float64(i%10+1) / 10
+	}
+	selections := func(rebuilds int) []string {
+		selector := NewWeightedSelector()
+		// 遍历 map 的顺序每次都不同，因此两个选择器的插入顺序不同。
+		for hash, weight := range weights {
+			selector.UpdateWeight(hash, weight)
+		}
+		for i := 0; i < rebuilds; i++ {
+			selector.UpdateWeight("extra", 1)
+			selector.SelectWeighted(0.5)
+			selector.RemoveWeight("extra")
+		}
+		rnd := rand.New(rand.NewSource(0))
+		var result []string
+		for i := 0; i < 1000; i++ {
+			result = append(result, selector.SelectWeighted(rnd.Float64()))
+		}
+		return result
+	}
+	if first, second := selections(0), selections(3); !reflect.DeepEqual(first, second) {
+		t.Errorf("相同的权重和随机数得到了不同的选择序列")
+	}
+}
+
 func TestKernelLogMatcher(t *testing.T) {
 	matcher := NewKernelLogMatcher()
 	
@@ -101,10 +215,13 @@ func TestKernelLogMatcher(t *testing.T) {
 		log      string
 		expected float64
 	}{
-		{"KASAN: use-after-free", 0.9},
-		{"WARNING: suspicious RCU usage", 0.7},
-		{"ERROR: AddressSanitizer", 0.8},
-		{"kernel BUG at", 0.95},
+		// 期望值是内置模式 (见 defaultLogPatterns) 中匹配的最高分
+		{"KASAN: use-after-free", 1.0},
+		{"WARNING: suspicious RCU usage", 0.5},
+		// 没有冒号时只匹配通用的 ERROR 模式
+		{"ERROR: AddressSanitizer", 0.4},
+		{"ERROR: AddressSanitizer: heap-buffer-overflow", 1.0},
+		{"kernel BUG at", 0.9},
 		{"normal log message", 0.0},
 		{"", 0.0},
 	}
@@ -127,11 +244,207 @@ func TestKernelLogMatcher(t *testing.T) {
 	}
 }
 
+func TestKernelLogTitleDedup(t *testing.T) {
+	const size = 100
+	matcher := NewKernelLogMatcher()
+	matcher.EnableTitleDedup(size)
+
+	// 计算分数不修改去重集合，同样的日志重复计算得到同样的分数。
+	logs := []string{"KASAN: use-after-free"}
+	first, titles := matcher.score(logs)
+	if first == 0 {
+		t.Errorf("新的崩溃没有计分")
+	}
+	if score := matcher.CalculateScore(logs); score != first {
+		t.Errorf("重复计算的分数不同: %f != %f", score, first)
+	}
+	// 记录标题之后崩溃成为已知崩溃。
+	matcher.rememberTitles(titles)
+	if score := matcher.CalculateScore(logs); score != 0 {
+		t.Errorf("已知崩溃仍然计分: %f", score)
+	}
+
+	// 跟踪器在记录评分时才记录标题。
+	config := DefaultScoreConfig()
+	config.KnownTitleCacheSize = size
+	tracker := NewScoreTracker(config)
+	result := &ExecutionResult{KernelLogs: []string{"WARNING: in foo"}}
+	pending := tracker.computeScore("prog", false, result, nil)
+	if again := tracker.computeScore("prog", false, result, nil); again.score.KernelLog != pending.score.KernelLog {
+		t.Errorf("计算评分修改了去重集合: %f != %f", again.score.KernelLog, pending.score.KernelLog)
+	}
+	tracker.commitScore(pending)
+	if score := tracker.computeScore("prog", false, result, nil).score.KernelLog; score != 0 {
+		t.Errorf("记录评分后崩溃仍然计分: %f", score)
+	}
+
+	// 插入大量不同标题后集合大小仍受容量限制，最近的标题仍能被识别。
+	const total = 100 * size
+	for i := 0; i < total; i++ {
+		matcher.knownTitles.add(fmt.Sprintf("WARNING: title %v", i))
+	}
+	if n := matcher.knownTitles.lru.Len(); n != size || len(matcher.knownTitles.index) != size {
+		t.Errorf("标题集合大小超出容量: %v/%v, 容量 %v", n, len(matcher.knownTitles.index), size)
+	}
+	for i := total - size; i < total; i++ {
+		if !matcher.knownTitles.add(fmt.Sprintf("WARNING: title %v", i)) {
+			t.Fatalf("最近的标题 %v 未被识别", i)
+		}
+	}
+	if matcher.knownTitles.add("WARNING: title 0") {
+		t.Errorf("最早的标题没有被淘汰")
+	}
+}
+
+func TestKernelLogBonusCap(t *testing.T) {
+	matcher := &KernelLogMatcher{}
+	for i := 0; i < 25; i++ {
+		if err := matcher.AddCustomPattern(fmt.Sprintf("noise%02d", i), 0.3, fmt.Sprintf("noise %v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := matcher.AddCustomPattern("severe", 0.7, "severe"); err != nil {
+		t.Fatal(err)
+	}
+	var noisy, severe []string
+	for i := 0; i < 10; i++ {
+		noisy = append(noisy, fmt.Sprintf("noise%02d", i))
+	}
+	severe = append(severe, "severe")
+	for i := 0; i < 24; i++ {
+		severe = append(severe, fmt.Sprintf("noise%02d", i))
+	}
+
+	// 加分被限制后，匹配 10 个噪声模式和匹配 25 个含严重模式的输出都不再被截断到 1.0。
+	noisyScore := matcher.CalculateScore(noisy)
+	severeScore := matcher.CalculateScore(severe)
+	if noisyScore >= 1.0 || severeScore >= 1.0 {
+		t.Errorf("多模式加分饱和: %f, %f", noisyScore, severeScore)
+	}
+	if noisyScore >= severeScore {
+		t.Errorf("严重输出的分数 %f 不高于噪声输出 %f", severeScore, noisyScore)
+	}
+
+	// 放宽上限后恢复原来的饱和行为。
+	matcher.SetMaxBonusPatterns(25)
+	if score := matcher.CalculateScore(noisy); score != 1.0 {
+		t.Errorf("放宽上限后分数应为 1.0: %f", score)
+	}
+}
+
+func TestKernelLogPatternSpecificity(t *testing.T) {
+	matcher := NewKernelLogMatcher()
+
+	// 同一行匹配了更具体的模式时泛化模式不计分，同一行的多个模式也不触发多模式加分。
+	line := "ERROR: rcu_sched detected stall"
+	if score := matcher.CalculateScore([]string{line}); score != 0.6 {
+		t.Errorf("同一行的多个模式抬高了分数: %f", score)
+	}
+	if matched := matcher.GetMatchedPatterns([]string{line}); !reflect.DeepEqual(matched, []string{"RCU stall"}) {
+		t.Errorf("泛化模式没有被忽略: %v", matched)
+	}
+	// 只有泛化模式匹配时仍然计分。
+	if score := matcher.CalculateScore([]string{"ERROR: something"}); score != 0.4 {
+		t.Errorf("泛化模式评分错误: %f", score)
+	}
+	// 来自不同日志行的不同模式仍然获得加分。
+	if score := matcher.CalculateScore([]string{line, "WARNING: foo"}); math.Abs(score-0.7) > 1e-9 {
+		t.Errorf("不同日志行的模式没有加分: %f", score)
+	}
+}
+
+func TestLoadPatternsFromJSON(t *testing.T) {
+	const valid = `[
+	{"pattern": "MYDRV-FATAL:.*", "score": 0.9, "description": "MYDRV fatal error"},
+	{"pattern": "WARNING:.*", "score": 0.1, "description": "Kernel warning"},
+	{"pattern": "mydrv: retry"}
+]`
+	patterns, err := LoadPatternsFromJSON(strings.NewReader(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 3 || patterns[2].Description != "mydrv: retry" || patterns[2].Score != 0 {
+		t.Fatalf("解析的模式错误: %+v", patterns)
+	}
+	matcher := NewKernelLogMatcherFromPatterns(patterns)
+	if score := matcher.CalculateScore([]string{"MYDRV-FATAL: device lost"}); score != 0.9 {
+		t.Errorf("自定义模式评分错误: %f", score)
+	}
+	if score := matcher.CalculateScore([]string{"KASAN: use-after-free"}); score != 0 {
+		t.Errorf("只使用给定模式时仍然匹配了内置模式: %f", score)
+	}
+
+	// 与内置模式描述相同的模式替换内置模式，其余的追加在后面。
+	merged := mergeLogPatterns(defaultLogPatterns(), patterns)
+	if len(merged) != len(defaultLogPatterns())+2 {
+		t.Errorf("合并后的模式数量错误: %v", len(merged))
+	}
+	matcher = NewKernelLogMatcherFromPatterns(merged)
+	if score := matcher.CalculateScore([]string{"WARNING: foo"}); score != 0.1 {
+		t.Errorf("没有替换内置模式的分数: %f", score)
+	}
+
+	// 跟踪器从配置的文件加载模式。
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	if err := os.WriteFile(path, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultScoreConfig()
+	config.KernelLogPatternsFile = path
+	if score := NewScoreTracker(config).logMatcher.CalculateScore([]string{"MYDRV-FATAL: x"}); score != 0.9 {
+		t.Errorf("跟踪器没有使用模式文件: %f", score)
+	}
+}
+
+func TestLoadPatternsFromJSONMalformed(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "[\n\t{\"pattern\": \"ok\", \"score\": 0.5},\n\t{\"pattern\": \"bad(\", \"score\": 0.5}\n]",
+			err:  `line 3: invalid pattern "bad("`,
+		},
+		{
+			data: "[\n\t{\"pattern\": \"ok\", \"score\": 2}\n]",
+			err:  "line 2: pattern \"ok\": score 2 is not in [0, 1]",
+		},
+		{
+			data: "[\n\t{\"pattern\": \"ok\"},\n\t{\"pattern\": }\n]",
+			err:  "line 3: invalid character",
+		},
+		{
+			data: "[\n\t{\"pattern\": \"\"}\n]",
+			err:  "line 2: empty pattern",
+		},
+		{
+			data: "{}",
+			err:  "line 1: expected an array",
+		},
+	} {
+		_, err := LoadPatternsFromJSON(strings.NewReader(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: 期望错误 %q, 实际 %v", test.data, test.err, err)
+		}
+	}
+
+	// 模式文件无效时跟踪器只使用内置模式。
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	if err := os.WriteFile(path, []byte(`[{"pattern": "bad("}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultScoreConfig()
+	config.KernelLogPatternsFile = path
+	if score := NewScoreTracker(config).logMatcher.CalculateScore([]string{"KASAN: x"}); score == 0 {
+		t.Errorf("模式文件无效时没有使用内置模式")
+	}
+}
+
 func TestTimeStats(t *testing.T) {
 	stats := NewTimeStats()
 	
-	// 添加测试数据
-	times := []uint64{1000, 1100, 900, 1200, 800, 1300, 950}
+	// 添加测试数据 (至少 10 个样本才会计算异常分数)
+	times := []uint64{1000, 1100, 900, 1200, 800, 1300, 950, 1050, 1150, 850}
 	for _, time := range times {
 		stats.AddTime(time)
 	}
@@ -154,10 +467,111 @@ func TestTimeStats(t *testing.T) {
 	if mean <= 0 || stddev < 0 {
 		t.Errorf("统计信息错误: 均值=%f, 标准差=%f", mean, stddev)
 	}
+	if m, s, count := stats.GetStats(); m != mean || s != stddev || count != int64(len(times)) {
+		t.Errorf("GetMean/GetStdDev 与 GetStats 不一致: %f/%f, %f/%f, %v", mean, m, stddev, s, count)
+	}
+	// 新样本使统计信息重新计算。
+	stats.AddSample(100000)
+	if stats.GetMean() <= mean || stats.GetStdDev() <= stddev {
+		t.Errorf("添加样本后统计信息没有重新计算")
+	}
 	
 	t.Logf("时间统计: 均值=%f, 标准差=%f", mean, stddev)
 }
 
+func TestTimeAnomalyMode(t *testing.T) {
+	stats := NewTimeStats()
+	for _, time := range []uint64{1000, 1100, 900, 1200, 800, 1300, 950, 1050, 1150, 850} {
+		stats.AddTime(time)
+	}
+	const fast, slow = 100, 1900
+	if z := stats.ZScore(fast); z >= 0 {
+		t.Errorf("快执行的 Z-score 应为负: %f", z)
+	}
+	if z := stats.ZScore(slow); z <= 0 {
+		t.Errorf("慢执行的 Z-score 应为正: %f", z)
+	}
+
+	tests := []struct {
+		mode TimeAnomalyMode
+		fast float64
+		slow float64
+	}{
+		{"", 1, 1},
+		{TimeAnomalySymmetric, 1, 1},
+		{TimeAnomalySlow, 0, 1},
+		{TimeAnomalySlowWeighted, math.Min(-stats.ZScore(fast)*fastAnomalyFactor/2, 1), 1},
+	}
+	for _, test := range tests {
+		if score := stats.AnomalyScore(fast, test.mode); math.Abs(score-test.fast) > 1e-9 {
+			t.Errorf("模式 %q: 快执行的异常分数 %f, 期望 %f", test.mode, score, test.fast)
+		}
+		if score := stats.AnomalyScore(slow, test.mode); math.Abs(score-test.slow) > 1e-9 {
+			t.Errorf("模式 %q: 慢执行的异常分数 %f, 期望 %f", test.mode, score, test.slow)
+		}
+	}
+	if stats.CalculateAnomalyScore(fast) != stats.AnomalyScore(fast, TimeAnomalySymmetric) {
+		t.Errorf("CalculateAnomalyScore 应保持对称计分")
+	}
+
+	config := DefaultScoreConfig()
+	config.TimeAnomalyMode = "fast"
+	if err := config.Validate(); err == nil {
+		t.Errorf("未知的执行时间异常模式没有被拒绝")
+	}
+}
+
+func TestTimeStatsEviction(t *testing.T) {
+	const maxSamples = 100
+	stats := NewTimeStats()
+	stats.maxSamples = maxSamples
+	rnd := rand.New(testutil.RandSource(t))
+
+	// 平稳分布的样本超过容量后，均值不再在淘汰时突变，并且与窗口内样本的精确统计一致。
+	var window []uint64
+	prevMean := 0.0
+	for i := 0; i < 10*maxSamples; i++ {
+		sample := uint64(900 + rnd.Intn(201))
+		stats.AddSample(sample)
+		window = append(window, sample)
+		if len(window) > maxSamples {
+			window = window[1:]
+		}
+		mean, stdDev, count := stats.GetStats()
+		if count != int64(i+1) {
+			t.Fatalf("样本计数错误: %v, 期望 %v", count, i+1)
+		}
+		if i >= maxSamples {
+			if diff := math.Abs(mean - prevMean); diff > 200.0/maxSamples+1e-6 {
+				t.Fatalf("第 %v 个样本后均值突变: %f -> %f", i, prevMean, mean)
+			}
+			if score := stats.CalculateAnomalyScore(1000); score > 0.5 {
+				t.Fatalf("第 %v 个样本后正常执行时间的异常分数过高: %f", i, score)
+			}
+		}
+		prevMean = mean
+
+		exactMean, exactVariance := 0.0, 0.0
+		for _, x := range window {
+			exactMean += float64(x)
+		}
+		exactMean /= float64(len(window))
+		for _, x := range window {
+			exactVariance += (float64(x) - exactMean) * (float64(x) - exactMean)
+		}
+		exactVariance /= float64(len(window))
+		if math.Abs(mean-exactMean) > 1e-6 || math.Abs(stdDev-math.Sqrt(exactVariance)) > 1e-6 {
+			t.Fatalf("第 %v 个样本后统计错误: %f/%f, 期望 %f/%f",
+				i, mean, stdDev, exactMean, math.Sqrt(exactVariance))
+		}
+	}
+
+	// 快照按加入顺序保存窗口内的样本。
+	if snapshot := stats.snapshot(); !reflect.DeepEqual(snapshot.Samples, window) {
+		t.Errorf("快照中的样本顺序错误")
+	}
+}
+
 func TestScoreConfig(t *testing.T) {
 	config := DefaultScoreConfig()
 	
@@ -166,11 +580,11 @@ func TestScoreConfig(t *testing.T) {
 		t.Error("默认配置应该启用评分系统")
 	}
 	
-	// 验证权重总和
+	// 验证权重总和 (浮点加法存在舍入误差)
 	totalWeight := config.CoverageWeight + config.RarityWeight + 
 		config.KernelLogWeight + config.TimeAnomalyWeight
 	
-	if totalWeight != 1.0 {
+	if math.Abs(totalWeight-1.0) > 1e-9 {
 		t.Errorf("权重总和应为1.0, 实际为 %f", totalWeight)
 	}
 	
@@ -188,12 +602,251 @@ func TestScoreConfig(t *testing.T) {
 	}
 }
 
+func TestScoreConfigValidate(t *testing.T) {
+	weights := func(coverage, rarity, kernelLog, timeAnomaly float64) *ScoreConfig {
+		return &ScoreConfig{
+			Enabled:           true,
+			CoverageWeight:    coverage,
+			RarityWeight:      rarity,
+			KernelLogWeight:   kernelLog,
+			TimeAnomalyWeight: timeAnomaly,
+		}
+	}
+	disabledScoring := weights(0, 0, 0, 0)
+	disabledScoring.Enabled = false
+	disabledDimension := weights(0.5, 0.5, 0.7, 0)
+	disabledDimension.DisableKernelLog = true
+	smashIters := func(lo, hi int) *ScoreConfig {
+		config := DefaultScoreConfig()
+		config.MinSmashIters, config.MaxSmashIters = lo, hi
+		return config
+	}
+	smashThresholds := func(conservative, aggressive float64) *ScoreConfig {
+		config := DefaultScoreConfig()
+		config.ConservativeThreshold, config.AggressiveThreshold = conservative, aggressive
+		return config
+	}
+	negativeDecay := DefaultScoreConfig()
+	negativeDecay.DecayHalfLife = -time.Hour
+	// 默认配置不启用自动归一化，修改后总和不为 1 的权重被报告。
+	defaultModified := DefaultScoreConfig()
+	defaultModified.CoverageWeight = 0.9
+	autoNormalize := func(config *ScoreConfig) *ScoreConfig {
+		config.AutoNormalize = true
+		return config
+	}
+	tests := []struct {
+		name          string
+		config        *ScoreConfig
+		valid         bool
+		notNormalized bool
+	}{
+		{"default", DefaultScoreConfig(), true, false},
+		{"default_modified_weights", defaultModified, false, true},
+		{"negative", weights(-0.1, 0.3, 0.4, 0.4), false, false},
+		{"above_one", weights(1.1, 0, 0, 0), false, false},
+		{"nan", weights(math.NaN(), 0.5, 0.5, 0), false, false},
+		{"zero_one_bounds", weights(1, 0, 0, 0), true, false},
+		{"all_zero", weights(0, 0, 0, 0), false, false},
+		{"all_zero_disabled_scoring", disabledScoring, true, false},
+		{"sum_below_one", weights(0.1, 0.1, 0.1, 0.1), false, true},
+		{"sum_above_one", weights(0.5, 0.5, 0.5, 0), false, true},
+		{"sum_within_epsilon", weights(0.4, 0.3, 0.2, 0.1+1e-9), true, false},
+		{"disabled_dimension_not_summed", disabledDimension, true, false},
+		{"smash_iters_equal", smashIters(10, 10), true, false},
+		{"smash_iters_min_above_max", smashIters(20, 10), false, false},
+		{"smash_iters_negative", smashIters(-1, 10), false, false},
+		{"smash_thresholds_above_one", smashThresholds(1.5, 0.3), false, false},
+		{"smash_thresholds_negative", smashThresholds(0.7, -0.1), false, false},
+		{"smash_thresholds_inverted", smashThresholds(0.3, 0.7), false, false},
+		{"decay_negative", negativeDecay, false, false},
+		{"auto_normalize_sum_above_one", autoNormalize(weights(0.5, 0.5, 0.5, 0.5)), true, false},
+		{"auto_normalize_all_zero", autoNormalize(weights(0, 0, 0, 0)), false, false},
+		{"auto_normalize_above_one", autoNormalize(weights(1.1, 0, 0, 0)), false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.Validate()
+			if (err == nil) != test.valid {
+				t.Fatalf("期望有效=%v, 实际错误: %v", test.valid, err)
+			}
+			if errors.Is(err, errWeightsNotNormalized) != test.notNormalized {
+				t.Errorf("权重总和错误的判断不正确: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewScoreTrackerInvalidConfig(t *testing.T) {
+	// 无效的配置被替换为默认配置。
+	invalid := &ScoreConfig{Enabled: true, CoverageWeight: -1}
+	if tracker := NewScoreTracker(invalid); tracker.config == invalid ||
+		!reflect.DeepEqual(tracker.config, DefaultScoreConfig()) {
+		t.Errorf("无效配置没有被替换为默认配置: %+v", tracker.config)
+	}
+	// 只是总和不为 1 的配置被归一化。
+	unnormalized := &ScoreConfig{Enabled: true, CoverageWeight: 0.5, RarityWeight: 0.5, KernelLogWeight: 0.5}
+	tracker := NewScoreTracker(unnormalized)
+	if tracker.config != unnormalized || unnormalized.Validate() != nil {
+		t.Errorf("权重总和不为 1 的配置没有被归一化: %+v", tracker.config)
+	}
+}
+
+func TestScoreTrackerSetConfig(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	old := tracker.config
+	// 无效的配置返回错误，当前的配置保持不变。
+	if err := tracker.SetConfig(&ScoreConfig{Enabled: true, CoverageWeight: -1}); err == nil {
+		t.Errorf("无效配置应该返回错误")
+	}
+	if tracker.config != old {
+		t.Errorf("无效配置替换了当前的配置: %+v", tracker.config)
+	}
+	// 只是总和不为 1 的配置被归一化后使用。
+	unnormalized := &ScoreConfig{Enabled: true, CoverageWeight: 0.5, RarityWeight: 0.5, KernelLogWeight: 0.5}
+	if err := tracker.SetConfig(unnormalized); err != nil {
+		t.Fatalf("权重总和不为 1 的配置不应是错误: %v", err)
+	}
+	if tracker.config != unnormalized || unnormalized.validate() != nil {
+		t.Errorf("权重总和不为 1 的配置没有被归一化: %+v", tracker.config)
+	}
+}
+
+func TestScoreConfigNormalize(t *testing.T) {
+	// 故意不平衡的权重 (总和为 2) 在使用前被归一化。
+	unbalanced := DefaultScoreConfig()
+	unbalanced.CoverageWeight *= 2
+	unbalanced.RarityWeight *= 2
+	unbalanced.KernelLogWeight *= 2
+	unbalanced.TimeAnomalyWeight *= 2
+
+	dims := []float64{1, 1, 1, 1, 1}
+	if total := unbalanced.weightedTotal(dims); math.Abs(total-1.0) > 1e-9 {
+		t.Errorf("未归一化配置的总分超出范围: %f", total)
+	}
+
+	unbalanced.Normalize()
+	expected := DefaultScoreConfig()
+	for i, w := range unbalanced.weights() {
+		if math.Abs(w-expected.weights()[i]) > 1e-9 {
+			t.Errorf("维度 %v 归一化后的权重错误: 期望 %f, 实际 %f",
+				scoreDimensionNames[i], expected.weights()[i], w)
+		}
+	}
+
+	zero := &ScoreConfig{}
+	zero.Normalize()
+	if total := zero.weightedTotal(dims); total != 0 {
+		t.Errorf("权重全为 0 时总分应为 0, 实际为 %f", total)
+	}
+}
+
+func TestScoreConfigAutoNormalize(t *testing.T) {
+	// 只表示相对重要性的权重在使用前被归一化，归一化前的权重仍然可以获取。
+	config := &ScoreConfig{
+		Enabled:           true,
+		AutoNormalize:     true,
+		CoverageWeight:    0.5,
+		RarityWeight:      0.5,
+		KernelLogWeight:   0.5,
+		TimeAnomalyWeight: 0.5,
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("启用自动归一化时总和不为 1 的权重不应是错误: %v", err)
+	}
+	var warnings []string
+	logf := func(level int, msg string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(msg, args...))
+	}
+	if validatedScoreConfig(config, logf) != config {
+		t.Fatalf("权重总和不为 1 的配置被替换")
+	}
+	if len(warnings) == 0 {
+		t.Errorf("归一化时没有记录警告")
+	}
+	// 没有配置权重的序列新颖性维度保持为 0。
+	for i, expected := range []float64{0.25, 0.25, 0.25, 0.25, 0} {
+		if w := config.weights()[i]; math.Abs(w-expected) > 1e-9 {
+			t.Errorf("维度 %v 归一化后的权重应为 %f, 实际为 %f", scoreDimensionNames[i], expected, w)
+		}
+	}
+	if raw := config.RawWeights(); !reflect.DeepEqual(raw, []float64{0.5, 0.5, 0.5, 0.5, 0}) {
+		t.Errorf("归一化前的权重错误: %v", raw)
+	}
+	if err := config.validate(); err != nil {
+		t.Errorf("归一化后的配置无效: %v", err)
+	}
+
+	tracker := NewScoreTracker(config)
+	for i := 0; i < 10; i++ {
+		score := tracker.updateScore(fmt.Sprintf("prog%v", i), i%3 == 0, &ExecutionResult{
+			Signal:     signal.FromRaw([]uint64{uint64(i), uint64(i + 1)}, 0),
+			ExecTime:   uint64(1000000 * (i + 1)),
+			KernelLogs: []string{"KASAN: use-after-free Read in foo"},
+		})
+		if score.Total < 0 || score.Total > 1 {
+			t.Errorf("总分超出 [0, 1] 范围: %f", score.Total)
+		}
+	}
+}
+
+func TestDisabledDimension(t *testing.T) {
+	result := func() *ExecutionResult {
+		return &ExecutionResult{
+			Signal:     signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime:   1000000,
+			KernelLogs: []string{"KASAN: use-after-free Read in foo"},
+		}
+	}
+
+	// 权重为 0 的维度仍然计算，只是不计入总分。
+	zeroWeight := DefaultScoreConfig()
+	zeroWeight.KernelLogWeight = 0
+	zeroWeight.Normalize()
+	zeroScore := NewScoreTracker(zeroWeight).updateScore("prog", false, result())
+
+	// 关闭的维度完全不计算，也不参与归一化，配置的权重保持不变。
+	disabled := DefaultScoreConfig()
+	configured := disabled.KernelLogWeight
+	disabled.DisableKernelLog = true
+	disabled.DisableTimeAnomaly = true
+	disabled.Normalize()
+	if disabled.KernelLogWeight != configured {
+		t.Errorf("关闭的维度的权重被修改: %f", disabled.KernelLogWeight)
+	}
+	sum := 0.0
+	for _, w := range disabled.weights() {
+		sum += w
+	}
+	if math.Abs(sum-1.0) > 1e-9 {
+		t.Errorf("启用的维度的权重总和应为 1, 实际为 %f", sum)
+	}
+	tracker := NewScoreTracker(disabled)
+	disabledScore := tracker.updateScore("prog", false, result())
+
+	if zeroScore.KernelLog == 0 {
+		t.Errorf("权重为 0 的维度没有计算分数")
+	}
+	if disabledScore.KernelLog != 0 || disabledScore.TimeAnomaly != 0 {
+		t.Errorf("关闭的维度仍然计算了分数: %+v", disabledScore)
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 0 {
+		t.Errorf("关闭的时间异常维度仍然更新了执行时间基线: %v", count)
+	}
+	// 两种方式下内核日志都不影响总分。
+	clean := result()
+	clean.KernelLogs = nil
+	if total := NewScoreTracker(zeroWeight).updateScore("prog", false, clean).Total; total != zeroScore.Total {
+		t.Errorf("权重为 0 的维度影响了总分: %f != %f", zeroScore.Total, total)
+	}
+}
+
 func BenchmarkScoreCalculation(b *testing.B) {
 	config := DefaultScoreConfig()
 	tracker := NewScoreTracker(config)
 	
 	target := getTestTarget()
-	p := target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+	p := target.Generate(rand.New(rand.NewSource(0)), prog.RecommendedCalls, target.DefaultChoiceTable())
 	
 	execResult := &ExecutionResult{
 		Signal:     signal.Signal{},
@@ -209,7 +862,28 @@ func BenchmarkScoreCalculation(b *testing.B) {
 	}
 }
 
+func BenchmarkScoringDisabled(b *testing.B) {
+	config := DefaultScoreConfig()
+	config.Enabled = false
+	fuzzer := &Fuzzer{Config: &Config{ScoreConfig: config}}
+	req := &queue.Request{Prog: &prog.Prog{}}
+	res := &queue.Result{
+		Info:   &flatrpc.ProgInfo{Elapsed: 1000000},
+		Output: []byte("KASAN: use-after-free\n"),
+	}
+	// 评分系统关闭时结果处理路径上不应有任何与评分相关的分配。
+	if allocs := testing.AllocsPerRun(100, func() { fuzzer.scoreResult(req, res, 0, 0, false) }); allocs != 0 {
+		b.Fatalf("评分系统关闭时仍有 %v 次分配", allocs)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fuzzer.scoreResult(req, res, 0, 0, false)
+	}
+}
+
 func BenchmarkWeightedSelection(b *testing.B) {
+	rnd := rand.New(rand.NewSource(0))
 	selector := NewWeightedSelector()
 	
 	// 准备测试数据
@@ -222,7 +896,7 @@ func BenchmarkWeightedSelection(b *testing.B) {
 	
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		selector.WeightedSelect(hashes)
+		selector.WeightedSelect(rnd, hashes)
 	}
 }
 
@@ -231,13 +905,14 @@ func TestScoreSystemIntegration(t *testing.T) {
 	config := DefaultScoreConfig()
 	tracker := NewScoreTracker(config)
 	selector := NewWeightedSelector()
+	rnd := rand.New(testutil.RandSource(t))
 	
 	target := getTestTarget()
 	
 	// 生成多个测试程序
 	programs := make([]*prog.Prog, 10)
 	for i := 0; i < 10; i++ {
-		programs[i] = target.Generate(nil, prog.RecommendedCalls, target.DefaultChoiceTable())
+		programs[i] = target.Generate(rnd, prog.RecommendedCalls, target.DefaultChoiceTable())
 	}
 	
 	// 为每个程序计算评分
@@ -277,7 +952,7 @@ func TestScoreSystemIntegration(t *testing.T) {
 		hashes[i] = p.Hash()
 	}
 	
-	selected := selector.WeightedSelect(hashes)
+	selected := selector.WeightedSelect(rnd, hashes)
 	if selected == "" {
 		t.Error("加权选择失败")
 	}
@@ -287,7 +962,951 @@ func TestScoreSystemIntegration(t *testing.T) {
 
 // 辅助函数
 func getTestTarget() *prog.Target {
-	// 这里应该返回一个测试用的 target
-	// 实际实现中需要根据 syzkaller 的测试框架来获取
-	return nil // 占位符
-}
\ No newline at end of file
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64Fuzz)
+	if err != nil {
+		panic(err)
+	}
+	return target
+}
+
+func TestErroredResultNotScored(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	p := generateScoringTestProgs(t, 1)[0]
+
+	execResult := &ExecutionResult{
+		Signal:     signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime:   1000000,
+		KernelLogs: []string{"KASAN: use-after-free"},
+		Error:      "executor failed",
+	}
+	if score := tracker.UpdateScore(p, execResult); score != nil {
+		t.Fatalf("执行出错的结果不应被评分: %+v", score)
+	}
+	if _, ok := tracker.scores[p.Hash()]; ok {
+		t.Error("执行出错的结果不应写入评分缓存")
+	}
+	if len(tracker.pcHitCounts) != 0 || tracker.rarityExecs != 0 {
+		t.Error("执行出错的结果不应更新统计信息")
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 0 {
+		t.Errorf("执行出错的结果不应计入时间统计: %d", count)
+	}
+}
+
+func TestFailNthNotInBaselines(t *testing.T) {
+	p := generateScoringTestProgs(t, 1)[0]
+	faulty := p.Clone()
+	faulty.Calls[0].Props.FailNth = 1
+	execResult := func() *ExecutionResult {
+		return &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime: 1000000,
+		}
+	}
+
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	if score := tracker.UpdateScore(faulty, execResult()); score == nil {
+		t.Fatal("故障注入的执行仍应被评分")
+	}
+	if tracker.rarityExecs != 0 || len(tracker.pcHitCounts) != 0 {
+		t.Error("故障注入的执行不应计入稀有性基线")
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 0 {
+		t.Errorf("故障注入的执行不应计入时间统计: %d", count)
+	}
+	tracker.UpdateScore(p, execResult())
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 1 {
+		t.Errorf("正常执行应计入时间统计: %d", count)
+	}
+
+	// 独立基线模式下，故障注入的执行只更新自己的基线。
+	config := DefaultScoreConfig()
+	config.FaultInjectionLane = true
+	tracker = NewScoreTracker(config)
+	tracker.UpdateScore(faulty, execResult())
+	if len(tracker.faultHitCounts) != 3 || len(tracker.pcHitCounts) != 0 {
+		t.Error("故障注入的信号元素应只记录在独立基线中")
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 0 {
+		t.Errorf("故障注入的执行不应计入正常的时间统计: %d", count)
+	}
+	if _, _, count := tracker.faultExecTimeStats.GetStats(); count != 1 {
+		t.Errorf("故障注入的执行应计入独立的时间统计: %d", count)
+	}
+}
+
+func TestRetryNotRecorded(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	p := generateScoringTestProgs(t, 1)[0]
+	execResult := &ExecutionResult{
+		Signal:       signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime:     1000000,
+		CallSequence: []string{"open", "read"},
+	}
+
+	// 计算覆盖率分数本身不记录 PC。
+	coverage, _ := tracker.calculateCoverageScore(execResult)
+	if again, _ := tracker.calculateCoverageScore(execResult); again != coverage {
+		t.Errorf("重复计算的覆盖率分数不同: %v != %v", again, coverage)
+	}
+	if len(tracker.pcHitCounts) != 0 {
+		t.Error("计算覆盖率分数不应修改 PC 命中次数")
+	}
+
+	first := tracker.UpdateScore(p, execResult)
+	if first.Coverage != 1.0 {
+		t.Errorf("全新覆盖的分数应为 1: %v", first.Coverage)
+	}
+	retry := *execResult
+	retry.Retry = true
+	second := tracker.UpdateScore(p, &retry)
+	if second.Coverage != first.Coverage || second.Rarity != first.Rarity || second.Sequence != first.Sequence {
+		t.Errorf("重试的评分与首次执行不同: %+v != %+v", second, first)
+	}
+	for pc, count := range tracker.pcHitCounts {
+		if count != 1 {
+			t.Errorf("PC %v 的命中次数应为 1: %v", pc, count)
+		}
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 1 {
+		t.Errorf("重试不应计入时间统计: %d", count)
+	}
+
+	// 不是重试的重复执行照常记录，覆盖不再是新的。
+	if third := tracker.UpdateScore(p, execResult); third.Coverage != 0 {
+		t.Errorf("已见过的覆盖分数应为 0: %v", third.Coverage)
+	}
+}
+
+func TestDistinctPCs(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	progs := generateScoringTestProgs(t, 3)
+	signals := [][]uint64{{1, 2, 3}, {2, 3, 4}, {4, 5}}
+	for i, p := range progs {
+		tracker.UpdateScore(p, &ExecutionResult{
+			Signal:   signal.FromRaw(signals[i], 0),
+			ExecTime: 1000000,
+		})
+	}
+	if pcs := tracker.DistinctPCs(); pcs != 5 {
+		t.Errorf("不同 PC 数量错误: 期望 5, 实际 %d", pcs)
+	}
+}
+
+func TestExcludeExtraRarity(t *testing.T) {
+	progs := generateScoringTestProgs(t, 2)
+	// 两次执行的调用信号相同，只有 extra 信号 (后台噪声) 不同。
+	execResult := func(extra uint64) *ExecutionResult {
+		return &ExecutionResult{
+			Signal:     signal.FromRaw([]uint64{1, 2, 3, extra}, 0),
+			CallSignal: signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime:   1000000,
+		}
+	}
+	secondRarity := func(excludeExtra bool) float64 {
+		config := DefaultScoreConfig()
+		config.ExcludeExtraRarity = excludeExtra
+		tracker := NewScoreTracker(config)
+		tracker.UpdateScore(progs[0], execResult(100))
+		return tracker.UpdateScore(progs[1], execResult(200)).Rarity
+	}
+	withExtra, withoutExtra := secondRarity(false), secondRarity(true)
+	if withExtra >= 1.0 {
+		t.Errorf("只有噪声信号不同的路径不应被当作全新的路径, 实际 %f", withExtra)
+	}
+	if withoutExtra >= withExtra {
+		t.Errorf("排除 extra 信号后重复路径的稀有性应降低: %f >= %f", withoutExtra, withExtra)
+	}
+}
+
+// 两个程序 90% 的信号相同，剩下的部分各不相同且每次执行都有一个不稳定的信号位。
+// 信号集合每次都不同，但稀有性按单个元素统计，两个程序的稀有性应相近且远低于满分。
+func TestSignalElementRarity(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	execResult := func(prog, run int) *ExecutionResult {
+		raw := make([]uint64, 0, 20)
+		for pc := uint64(0); pc < 18; pc++ {
+			raw = append(raw, pc)
+		}
+		raw = append(raw, uint64(100+prog), uint64(1000+2*run+prog))
+		return &ExecutionResult{
+			Signal:   signal.FromRaw(raw, 0),
+			ExecTime: 1000000,
+		}
+	}
+	var rarity [2]float64
+	for run := 0; run < 10; run++ {
+		for prog := range rarity {
+			rarity[prog] = tracker.updateScore(fmt.Sprintf("prog%v", prog), false, execResult(prog, run)).Rarity
+		}
+	}
+	for prog, got := range rarity {
+		if got >= 0.5 {
+			t.Errorf("程序 %v 的信号只有一个不稳定的信号位是新的, 稀有性应远低于满分, 实际 %f", prog, got)
+		}
+	}
+	if diff := math.Abs(rarity[0] - rarity[1]); diff > 0.05 {
+		t.Errorf("信号大部分相同的程序的稀有性应相近: %f, %f", rarity[0], rarity[1])
+	}
+}
+
+func TestEdgeRarity(t *testing.T) {
+	progs := generateScoringTestProgs(t, 4)
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	trodden := []uint64{0x1000, 0x1004, 0x1008}
+	for i := 0; i < 10; i++ {
+		tracker.UpdateScore(progs[0], &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i)}, 0),
+			ExecTime: 1000000,
+			Cover:    cover.FromRaw(trodden),
+		})
+	}
+	// 以下执行的信号路径都是全新的，只有原始覆盖不同；作为重试评分，不改变统计。
+	rarity := func(p *prog.Prog, pcs []uint64) float64 {
+		return tracker.UpdateScore(p, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{100}, 0),
+			ExecTime: 1000000,
+			Cover:    cover.FromRaw(pcs),
+			Retry:    true,
+		}).Rarity
+	}
+	newEdge := rarity(progs[1], append([]uint64{0x2000}, trodden...))
+	oldEdges := rarity(progs[2], trodden)
+	if newEdge <= oldEdges {
+		t.Errorf("覆盖全新边的程序稀有性应更高: %f <= %f", newEdge, oldEdges)
+	}
+	// 没有原始覆盖时只使用信号路径的稀有性。
+	// 重试复用已有的评分，因此使用尚未评分的程序。
+	if noCover := rarity(progs[3], nil); noCover != 1.0 {
+		t.Errorf("没有原始覆盖时全新路径的稀有性应为 1.0, 实际 %f", noCover)
+	}
+}
+
+func TestScoreTrackerReset(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	progs := generateScoringTestProgs(t, 3)
+	for i, p := range progs {
+		tracker.UpdateScore(p, &ExecutionResult{
+			Signal:       signal.FromRaw([]uint64{uint64(i), 100}, 0),
+			ExecTime:     1000000,
+			CallSequence: []string{"open"},
+		})
+	}
+	if len(tracker.GetTopScoredProgs(10)) != len(progs) {
+		t.Fatal("评分没有被记录")
+	}
+	tracker.Reset()
+	if top := tracker.GetTopScoredProgs(10); len(top) != 0 {
+		t.Errorf("重置后不应有评分: %v", top)
+	}
+	if len(tracker.pcHitCounts) != 0 || tracker.rarityExecs != 0 || len(tracker.sequenceFrequency) != 0 {
+		t.Error("重置后统计信息应被清除")
+	}
+	if _, _, count := tracker.execTimeStats.GetStats(); count != 0 {
+		t.Errorf("重置后时间统计应被清除: %d", count)
+	}
+	// 重置后的评分与新的跟踪器相同。
+	score := tracker.UpdateScore(progs[0], &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{0, 100}, 0),
+		ExecTime: 1000000,
+	})
+	if score.Coverage != 1.0 || score.Rarity != 1.0 {
+		t.Errorf("重置后的覆盖应是全新的: %+v", score)
+	}
+
+	ws := NewWeightedSelector()
+	ws.UpdateWeight("a", 1)
+	ws.SelectWeighted(0.5)
+	ws.Reset()
+	if ws.Len() != 0 || ws.SelectWeighted(0.5) != "" || len(ws.hashes()) != 0 {
+		t.Error("重置后选择器不应有权重")
+	}
+}
+
+func TestDumpScoresStable(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	for i, p := range generateScoringTestProgs(t, 20) {
+		// 部分程序的评分相同，检查同分时输出顺序仍然稳定。
+		tracker.UpdateScore(p, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i % 3)}, 0),
+			ExecTime: 1000000,
+		})
+	}
+	dump := func() []byte {
+		buf := new(bytes.Buffer)
+		if err := tracker.DumpScores(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := dump()
+	for i := 0; i < 10; i++ {
+		if second := dump(); !bytes.Equal(first, second) {
+			t.Fatalf("相同状态的评分输出不一致:\n%s\n%s", first, second)
+		}
+	}
+	top := tracker.GetTopScoredProgs(20)
+	for i := 0; i < 10; i++ {
+		if again := tracker.GetTopScoredProgs(20); !reflect.DeepEqual(top, again) {
+			t.Fatalf("同分程序的排序不稳定:\n%v\n%v", top, again)
+		}
+	}
+}
+
+// trackerWithScores 返回直接填充了 n 个随机评分的跟踪器，部分评分相同
+func trackerWithScores(n int, rnd *rand.Rand) *ScoreTracker {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	for i := 0; i < n; i++ {
+		tracker.scores[fmt.Sprintf("prog%v", i)] = &ProgScore{Total: float64(rnd.Intn(n/2+1)) / float64(n)}
+	}
+	return tracker
+}
+
+// topScoredProgsSorted 对所有评分完整排序后取前 limit 个，作为 GetTopScoredProgs 的参照
+func topScoredProgsSorted(st *ScoreTracker, limit int) []ScoredProg {
+	progs := make([]ScoredProg, 0, len(st.scores))
+	for hash, score := range st.scores {
+		progs = append(progs, ScoredProg{Hash: hash, Score: *score})
+	}
+	sort.Slice(progs, func(i, j int) bool {
+		return progs[j].rankedBelow(&progs[i])
+	})
+	return progs[:min(limit, len(progs))]
+}
+
+func TestGetTopScoredProgs(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	tracker := trackerWithScores(1000, rnd)
+	for _, limit := range []int{0, 1, 7, 50, 1000, 2000} {
+		got := tracker.GetTopScoredProgs(limit)
+		want := topScoredProgsSorted(tracker, limit)
+		if len(got) != len(want) {
+			t.Fatalf("limit %v: 期望 %v 个程序, 实际 %v 个", limit, len(want), len(got))
+		}
+		for i := range want {
+			if got[i].Hash != want[i].Hash || got[i].Score.Total != want[i].Score.Total {
+				t.Fatalf("limit %v: 第 %v 名期望 %+v, 实际 %+v", limit, i, want[i], got[i])
+			}
+		}
+	}
+}
+
+func BenchmarkTopScoredProgs(b *testing.B) {
+	tracker := trackerWithScores(50000, rand.New(rand.NewSource(0)))
+	b.Run("sort", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			topScoredProgsSorted(tracker, weightedSelectTop)
+		}
+	})
+	b.Run("heap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tracker.GetTopScoredProgs(weightedSelectTop)
+		}
+	})
+}
+
+func TestPathHash(t *testing.T) {
+	sig := signal.FromRaw([]uint64{1, 2, 3}, 0)
+	if pathHash(sig) != pathHash(signal.FromRaw([]uint64{3, 1, 2}, 1)) {
+		t.Errorf("相同 PC 集合的路径哈希不同")
+	}
+	for _, other := range [][]uint64{{1, 2}, {1, 2, 4}, {1, 2, 3, 4}, {2, 2, 3}} {
+		if pathHash(sig) == pathHash(signal.FromRaw(other, 0)) {
+			t.Errorf("不同路径 %v 的哈希相同", other)
+		}
+	}
+}
+
+func TestSmashIters(t *testing.T) {
+	config := DefaultScoreConfig()
+	if lo, hi := config.smashIters(0), config.smashIters(1); lo != 15 || hi != 50 {
+		t.Errorf("默认迭代次数范围错误: %v-%v", lo, hi)
+	}
+	if iters := config.smashIters(2); iters != 50 {
+		t.Errorf("超出范围的评分应被限制: %v", iters)
+	}
+
+	config.MinSmashIters, config.MaxSmashIters = 30, 30
+	for _, score := range []float64{-1, 0, 0.3, 0.5, 0.9, 1, 2} {
+		if iters := config.smashIters(score); iters != 30 {
+			t.Errorf("min=max 时迭代次数应为常数: 评分 %v, 迭代 %v", score, iters)
+		}
+	}
+
+	unset := &ScoreConfig{Enabled: true}
+	if iters := unset.smashIters(1); iters != 50 {
+		t.Errorf("未配置范围时应使用默认范围: %v", iters)
+	}
+
+	config.MinSmashIters, config.MaxSmashIters = 10, 40
+	config.SmashItersMapping = "step"
+	if lo, hi := config.smashIters(0.49), config.smashIters(0.5); lo != 10 || hi != 40 {
+		t.Errorf("阶梯映射错误: %v/%v", lo, hi)
+	}
+}
+
+func TestProgScoreCompare(t *testing.T) {
+	now := time.Now()
+	base := ProgScore{Total: 0.5, Coverage: 0.5, Rarity: 0.5, KernelLog: 0.5, TimeAnomaly: 0.5, Timestamp: now}
+	testCases := []struct {
+		name   string
+		modify func(ps *ProgScore)
+	}{
+		{"总分", func(ps *ProgScore) { ps.Total = 0.6; ps.KernelLog = 0 }},
+		{"内核日志", func(ps *ProgScore) { ps.KernelLog = 0.6; ps.Coverage = 0 }},
+		{"覆盖率", func(ps *ProgScore) { ps.Coverage = 0.6; ps.Rarity = 0 }},
+		{"稀有性", func(ps *ProgScore) { ps.Rarity = 0.6; ps.TimeAnomaly = 0 }},
+		{"时间异常", func(ps *ProgScore) { ps.TimeAnomaly = 0.6 }},
+		{"评分时间", func(ps *ProgScore) { ps.Timestamp = now.Add(time.Second) }},
+	}
+	for _, tc := range testCases {
+		greater := base
+		tc.modify(&greater)
+		if c := greater.Compare(&base); c != 1 {
+			t.Errorf("%v: 期望较大, Compare 返回 %v", tc.name, c)
+		}
+		if !base.Less(&greater) || greater.Less(&base) {
+			t.Errorf("%v: Less 与 Compare 不一致", tc.name)
+		}
+	}
+	same := base
+	if c := same.Compare(&base); c != 0 || same.Less(&base) {
+		t.Errorf("相同的评分应相等, Compare 返回 %v", c)
+	}
+}
+
+func TestWeightSensitivity(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	// 权重为 0.4/0.3/0.2/0.1 时: a=0.38, b=0.375, c=0。
+	// 覆盖率权重 +0.1 会让 b 超过 a，而时间异常维度对排名没有影响。
+	tracker.scores["a"] = &ProgScore{Coverage: 0.5, Rarity: 0.6}
+	tracker.scores["b"] = &ProgScore{Coverage: 0.6, Rarity: 0.45}
+	tracker.scores["c"] = &ProgScore{}
+
+	report := tracker.WeightSensitivity(2, 0.1)
+	byDim := make(map[string]WeightSensitivity)
+	for _, s := range report {
+		byDim[s.Dimension] = s
+	}
+	if s := byDim["coverage"]; s.Increase != 2 || s.Decrease != 0 {
+		t.Errorf("覆盖率权重敏感性错误: %+v", s)
+	}
+	if s := byDim["rarity"]; s.Increase != 0 || s.Decrease != 2 {
+		t.Errorf("稀有性权重敏感性错误: %+v", s)
+	}
+	if s := byDim["time_anomaly"]; s.Increase != 0 || s.Decrease != 0 {
+		t.Errorf("时间异常权重不应影响排名: %+v", s)
+	}
+}
+
+// 在评分被不断淘汰的同时并发获取 top 程序，需要配合 -race 运行。
+func TestTopScoredProgsDuringEviction(t *testing.T) {
+	config := DefaultScoreConfig()
+	config.MaxTrackedProgs = 10
+	tracker := NewScoreTracker(config)
+	progs := generateScoringTestProgs(t, 100)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if top := tracker.GetTopScoredProgs(5); len(top) > 5 {
+					t.Errorf("返回的程序过多: %d", len(top))
+				}
+			}
+		}()
+	}
+	for iter := 0; iter < 10; iter++ {
+		for i, p := range progs {
+			tracker.UpdateScore(p, &ExecutionResult{
+				Signal:   signal.FromRaw([]uint64{uint64(iter*len(progs) + i)}, 0),
+				ExecTime: uint64(1000 + i),
+			})
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+	if len(tracker.scores) > config.MaxTrackedProgs || len(tracker.scoresIndex) != len(tracker.scores) {
+		t.Errorf("评分淘汰失败: scores=%d, index=%d", len(tracker.scores), len(tracker.scoresIndex))
+	}
+}
+
+// 插入远超上限的程序、PC 和序列后，所有跟踪结构的大小都保持在上限以内。
+func TestScoreTrackerBounded(t *testing.T) {
+	config := DefaultScoreConfig()
+	config.MaxTrackedProgs = 10
+	config.MaxTrackedPCs = 50
+	config.MaxTrackedSequences = 5
+	tracker := NewScoreTracker(config)
+	progs := generateScoringTestProgs(t, 100)
+	for iter := 0; iter < 10; iter++ {
+		for i, p := range progs {
+			pc := uint64(iter*len(progs) + i)
+			tracker.UpdateScore(p, &ExecutionResult{
+				Signal:   signal.FromRaw([]uint64{2 * pc, 2*pc + 1}, 0),
+				ExecTime: uint64(1000 + i),
+			})
+		}
+	}
+	// 淘汰之后新覆盖仍然能被识别。
+	score := tracker.updateScore("new", false, &ExecutionResult{
+		Signal: signal.FromRaw([]uint64{1 << 40}, 0),
+	})
+	if score == nil || score.Coverage != 1.0 {
+		t.Errorf("淘汰后新覆盖没有得到满分: %+v", score)
+	}
+
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+	if len(tracker.scores) > config.MaxTrackedProgs {
+		t.Errorf("评分数量超出上限: %d", len(tracker.scores))
+	}
+	if len(tracker.pcHitCounts) > config.MaxTrackedPCs {
+		t.Errorf("PC 数量超出上限: %d", len(tracker.pcHitCounts))
+	}
+	if len(tracker.sequenceFrequency) > config.MaxTrackedSequences {
+		t.Errorf("序列数量超出上限: %d", len(tracker.sequenceFrequency))
+	}
+}
+
+func TestMarkHintNewSignalCopyOnWrite(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	p := generateScoringTestProgs(t, 1)[0]
+	before := tracker.UpdateScore(p, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	})
+	held := tracker.GetScoreByHash(p.Hash())
+	marked := tracker.markHintNewSignal(p.Hash())
+	if marked == nil || !marked.HintNewSignal {
+		t.Fatalf("评分没有被标记: %+v", marked)
+	}
+	// 标记之前取得的评分保持不变，标记后的评分只在标记位上不同。
+	if held.HintNewSignal || before.HintNewSignal {
+		t.Errorf("标记修改了调用方持有的评分")
+	}
+	if marked.Total != held.Total {
+		t.Errorf("标记改变了总分: %v != %v", marked.Total, held.Total)
+	}
+	if again := tracker.markHintNewSignal(p.Hash()); again == nil || !again.HintNewSignal {
+		t.Errorf("重复标记应返回已标记的评分")
+	}
+	if tracker.markHintNewSignal("unknown") != nil {
+		t.Errorf("未评分的程序不应被记录")
+	}
+}
+
+func TestGetScoreByHashCopy(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	p := generateScoringTestProgs(t, 1)[0]
+	tracker.UpdateScore(p, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1, 2, 3}, 0),
+		ExecTime: 1000000,
+	})
+	score := tracker.GetScoreByHash(p.Hash())
+	if score == nil {
+		t.Fatalf("程序没有被评分")
+	}
+	total := score.Total
+	// 修改返回的评分不影响跟踪器记录的评分。
+	score.Total = -1
+	score.HintNewSignal = true
+	again := tracker.GetScoreByHash(p.Hash())
+	if again.Total != total || again.HintNewSignal {
+		t.Errorf("修改返回的评分改变了跟踪器中的评分: %+v", again)
+	}
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+	if tracker.scores[p.Hash()] == again {
+		t.Errorf("GetScoreByHash 返回了跟踪器内部的评分")
+	}
+}
+
+func TestScoreDecay(t *testing.T) {
+	s := newScoring(DefaultScoreConfig())
+	st := s.tracker
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	st.now = func() time.Time {
+		return clock
+	}
+	add := func(hash string, total float64) {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.scores[hash] = &ProgScore{Total: total, Timestamp: clock}
+		st.touchLocked(hash)
+	}
+	add("old", 0.9)
+	add("low", 0.1)
+	clock = clock.Add(time.Hour)
+	add("recent", 0.9)
+	// 只有语料库中的程序有选择器权重
+	st.markInCorpus("old")
+
+	// 经过一个半衰期后与中性分数的差距减半，低分也向中性分数回升。
+	s.decay(time.Hour)
+	old, recent := st.GetScoreByHash("old"), st.GetScoreByHash("recent")
+	if math.Abs(old.Total-0.7) > 1e-9 || recent.Total != 0.9 || old.Total >= recent.Total {
+		t.Errorf("衰减后的分数不正确: old=%v recent=%v", old.Total, recent.Total)
+	}
+	if low := st.GetScoreByHash("low"); math.Abs(low.Total-0.3) > 1e-9 {
+		t.Errorf("低分没有向中性分数衰减: %v", low.Total)
+	}
+	s.selector.mu.RLock()
+	weight := s.selector.weights["old"]
+	_, lowWeighted := s.selector.weights["low"]
+	s.selector.mu.RUnlock()
+	if weight != old.Total {
+		t.Errorf("选择器权重 %v 与衰减后的分数 %v 不一致", weight, old.Total)
+	}
+	if lowWeighted {
+		t.Errorf("不在语料库中的程序得到了选择器权重")
+	}
+
+	// 再次衰减从上次衰减的时间开始计算，而不是从评分时间重复计算。
+	clock = clock.Add(time.Hour)
+	s.decay(time.Hour)
+	old, recent = st.GetScoreByHash("old"), st.GetScoreByHash("recent")
+	if math.Abs(old.Total-0.6) > 1e-9 || math.Abs(recent.Total-0.7) > 1e-9 {
+		t.Errorf("重复衰减的分数不正确: old=%v recent=%v", old.Total, recent.Total)
+	}
+}
+
+func TestDecorrelateNovelty(t *testing.T) {
+	p := generateScoringTestProgs(t, 1)[0]
+	newResult := func() *ExecutionResult {
+		return &ExecutionResult{Signal: signal.FromRaw([]uint64{1, 2, 3}, 0)}
+	}
+
+	// 默认情况下全新的信号同时获得覆盖率和稀有性分数。
+	score := NewScoreTracker(DefaultScoreConfig()).UpdateScore(p, newResult())
+	if score.Coverage != 1.0 || score.Rarity != 1.0 {
+		t.Fatalf("默认评分错误: %+v", score)
+	}
+
+	config := DefaultScoreConfig()
+	config.DecorrelateNovelty = true
+	score = NewScoreTracker(config).UpdateScore(p, newResult())
+	if score.Coverage != 1.0 || score.Rarity != 0.0 {
+		t.Errorf("全新覆盖不应被重复计分: %+v", score)
+	}
+	if math.Abs(score.Total-config.CoverageWeight) > 1e-9 {
+		t.Errorf("总分错误: 期望 %f, 实际 %f", config.CoverageWeight, score.Total)
+	}
+}
+
+// generateScoringTestProgs 在测试 target 上生成 n 个随机程序
+func generateScoringTestProgs(t *testing.T, n int) []*prog.Prog {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(testutil.RandSource(t))
+	ct := target.DefaultChoiceTable()
+	// 评分按哈希记录，程序必须互不相同
+	var progs []*prog.Prog
+	seen := make(map[string]bool)
+	for len(progs) < n {
+		p := target.Generate(rnd, 5, ct)
+		if hash := p.Hash(); !seen[hash] {
+			seen[hash] = true
+			progs = append(progs, p)
+		}
+	}
+	return progs
+}
+
+func TestTopSyscallsByScore(t *testing.T) {
+	target, err := prog.GetTarget(targets.TestOS, targets.TestArch64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(text string) *prog.Prog {
+		p, err := target.Deserialize([]byte(text), prog.NonStrict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	high := &ExecutionResult{
+		Signal:     signal.FromRaw([]uint64{1, 2, 3}, 0),
+		KernelLogs: []string{"KASAN: use-after-free Read in foo"},
+	}
+	low := &ExecutionResult{}
+
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	var progs []*prog.Prog
+	for i := 1; i <= 3; i++ {
+		p := parse(strings.Repeat("mutate0()\n", i))
+		progs = append(progs, p)
+		tracker.UpdateScore(p, high)
+		tracker.UpdateScore(parse(strings.Repeat("mutate1()\n", i)), low)
+	}
+	// 重复评分的程序只按最新的评分计入一次。
+	for i := 0; i < 3; i++ {
+		tracker.UpdateScore(progs[0], high)
+	}
+	mixed := parse("mutate0()\nmutate1()\nmutate0()\n")
+	tracker.UpdateScore(mixed, low)
+
+	top := tracker.TopSyscallsByScore(10)
+	if len(top) != 2 || top[0].Name != "mutate0" || top[1].Name != "mutate1" {
+		t.Fatalf("系统调用排序错误: %+v", top)
+	}
+	if top[0].Programs != 4 || top[1].Programs != 4 {
+		t.Errorf("程序数量错误: %+v", top)
+	}
+	if top[0].AverageScore <= top[1].AverageScore {
+		t.Errorf("高分程序的系统调用平均分不高于低分程序: %+v", top)
+	}
+	// 统计与跟踪器中的评分一致。
+	want := tracker.GetScoreByHash(mixed.Hash()).Total
+	for _, p := range progs {
+		want += tracker.GetScoreByHash(p.Hash()).Total
+	}
+	if math.Abs(top[0].TotalScore-want) > 1e-9 {
+		t.Errorf("总分错误: 期望 %v, 实际 %v", want, top[0].TotalScore)
+	}
+	if len(tracker.TopSyscallsByScore(1)) != 1 {
+		t.Errorf("没有按 n 截断结果")
+	}
+
+	// 失效的程序不再计入。
+	tracker.InvalidateProgram(mixed.Hash())
+	top = tracker.TopSyscallsByScore(10)
+	if top[0].Programs != 3 || top[1].Programs != 3 {
+		t.Errorf("失效的程序仍然计入: %+v", top)
+	}
+}
+
+func TestSequenceNovelty(t *testing.T) {
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	result := func(calls ...string) *ExecutionResult {
+		return &ExecutionResult{
+			Signal:       signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime:     1000000,
+			CallSequence: calls,
+		}
+	}
+
+	first := tracker.updateScore("prog1", false, result("open", "read", "close"))
+	if first.Sequence != 1.0 {
+		t.Errorf("新序列应获得最高分, 实际为 %f", first.Sequence)
+	}
+	var repeated *ProgScore
+	for i := 0; i < 3; i++ {
+		repeated = tracker.updateScore("prog1", false, result("open", "read", "close"))
+	}
+	if repeated.Sequence >= first.Sequence {
+		t.Errorf("重复的序列分数没有降低: %f >= %f", repeated.Sequence, first.Sequence)
+	}
+	// 相同的调用以不同的顺序出现是新的序列。
+	reordered := tracker.updateScore("prog2", false, result("close", "read", "open"))
+	if reordered.Sequence == repeated.Sequence || reordered.Sequence != 1.0 {
+		t.Errorf("调换顺序后的序列分数错误: %f (原顺序 %f)", reordered.Sequence, repeated.Sequence)
+	}
+
+	// 记录的序列数量有上限。
+	config := DefaultScoreConfig()
+	config.MaxTrackedSequences = 2
+	bounded := NewScoreTracker(config)
+	for _, call := range []string{"a", "b", "c", "d"} {
+		bounded.updateScore(call, false, result(call))
+	}
+	if len(bounded.sequenceFrequency) != 2 {
+		t.Errorf("记录的序列数量超过上限: %v", len(bounded.sequenceFrequency))
+	}
+}
+
+func TestCrashedKernelLogScore(t *testing.T) {
+	progs := generateScoringTestProgs(t, 3)
+	tracker := NewScoreTracker(DefaultScoreConfig())
+	score := func(p *prog.Prog, crashed bool, logs []string) *ProgScore {
+		return tracker.UpdateScore(p, &ExecutionResult{
+			Signal:     signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime:   1000000,
+			KernelLogs: logs,
+			Crashed:    crashed,
+			Retry:      true,
+		})
+	}
+	// 崩溃截断了日志: 没有匹配任何模式，内核日志分数仍反映崩溃。
+	crashed := score(progs[0], true, nil)
+	if crashed.KernelLog < crashedKernelLogScore {
+		t.Errorf("崩溃但没有日志的内核日志分数应不低于 %f, 实际 %f", crashedKernelLogScore, crashed.KernelLog)
+	}
+	clean := score(progs[1], false, nil)
+	if clean.KernelLog != 0 || crashed.Total <= clean.Total {
+		t.Errorf("崩溃的程序总分应更高: 崩溃 %+v, 正常 %+v", crashed, clean)
+	}
+	// 日志中匹配的模式越严重，崩溃的分数越高，但不超过 1。
+	kasan := score(progs[2], true, []string{"KASAN: use-after-free Read in foo"})
+	if kasan.KernelLog <= crashed.KernelLog || kasan.KernelLog > 1 {
+		t.Errorf("带 KASAN 报告的崩溃分数应在 (%f, 1] 内, 实际 %f", crashed.KernelLog, kasan.KernelLog)
+	}
+}
+
+func TestGetTopScoredCorpusProgs(t *testing.T) {
+	rnd := rand.New(testutil.RandSource(t))
+	tracker := trackerWithScores(100, rnd)
+	// 每三个程序中有一个保存到了语料库，其余是从未保存的生成/变异程序。
+	corpusProgs := make(map[string]bool)
+	for i := 0; i < 100; i += 3 {
+		hash := fmt.Sprintf("prog%v", i)
+		tracker.markInCorpus(hash)
+		corpusProgs[hash] = true
+	}
+	var want []ScoredProg
+	for _, sp := range topScoredProgsSorted(tracker, 100) {
+		if corpusProgs[sp.Hash] {
+			want = append(want, sp)
+		}
+	}
+	got := tracker.GetTopScoredCorpusProgs(10)
+	if len(got) != 10 {
+		t.Fatalf("期望 10 个语料库程序, 实际 %v 个", len(got))
+	}
+	for i := range got {
+		if got[i].Hash != want[i].Hash {
+			t.Fatalf("第 %v 名期望 %v, 实际 %v", i, want[i].Hash, got[i].Hash)
+		}
+	}
+	if all := tracker.GetTopScoredCorpusProgs(100); len(all) != len(corpusProgs) {
+		t.Errorf("期望 %v 个语料库程序, 实际 %v 个", len(corpusProgs), len(all))
+	}
+	// 评分失效时语料库标记一起删除，重新评分并再次保存到语料库后才被返回。
+	tracker.InvalidateProgram("prog0")
+	tracker.updateScore("prog0", false, &ExecutionResult{Signal: signal.FromRaw([]uint64{1}, 0)})
+	for _, sp := range tracker.GetTopScoredCorpusProgs(100) {
+		if sp.Hash == "prog0" {
+			t.Errorf("失效的程序不应被当作语料库程序返回")
+		}
+	}
+	tracker.markInCorpus("prog0")
+	if all := tracker.GetTopScoredCorpusProgs(100); len(all) != len(corpusProgs) {
+		t.Errorf("再次保存到语料库的程序应被返回")
+	}
+}
+
+func TestCorpusProgsBounded(t *testing.T) {
+	config := DefaultScoreConfig()
+	config.MaxTrackedProgs = 10
+	tracker := NewScoreTracker(config)
+	for i := 0; i < 100; i++ {
+		hash := fmt.Sprintf("prog%v", i)
+		tracker.updateScore(hash, false, &ExecutionResult{Signal: signal.FromRaw([]uint64{uint64(i)}, 0)})
+		tracker.markInCorpus(hash)
+		// 没有评分的程序不记录，否则它们的标记不会被淘汰
+		if tracker.markInCorpus(fmt.Sprintf("unscored%v", i)) {
+			t.Fatalf("记录了没有评分的程序")
+		}
+	}
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+	if len(tracker.corpusProgs) != config.MaxTrackedProgs {
+		t.Errorf("淘汰的评分的语料库标记没有删除: 记录了 %v 个程序", len(tracker.corpusProgs))
+	}
+}
+
+func TestRarityWarmup(t *testing.T) {
+	const warmup = 100
+	config := DefaultScoreConfig()
+	config.RarityWarmupExecs = warmup
+	tracker := NewScoreTracker(config)
+	// 每个执行的路径都是全新的，没有预热时稀有性总是满分。
+	rarity := func(i int) float64 {
+		return tracker.updateScore(fmt.Sprintf("prog%v", i), false, &ExecutionResult{
+			Signal:   signal.FromRaw([]uint64{uint64(i)}, 0),
+			ExecTime: 1000000,
+		}).Rarity
+	}
+	if got := rarity(0); got != neutralScore {
+		t.Errorf("第一个执行的稀有性应为中等分数, 实际 %f", got)
+	}
+	prev := neutralScore
+	for i := 1; i < warmup; i++ {
+		got := rarity(i)
+		if got <= prev || got >= 1 {
+			t.Fatalf("预热期间第 %v 个执行的稀有性 %f 应在 (%f, 1) 内", i, got, prev)
+		}
+		prev = got
+	}
+	for i := warmup; i < 2*warmup; i++ {
+		if got := rarity(i); got != 1 {
+			t.Fatalf("预热之后全新路径的稀有性应为满分, 第 %v 个执行实际 %f", i, got)
+		}
+	}
+
+	// 从快照恢复的统计包括已计入的执行数，重启后不需要重新预热。
+	data, err := tracker.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewScoreTracker(config)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	score := restored.updateScore("restored", false, &ExecutionResult{
+		Signal:   signal.FromRaw([]uint64{1 << 20}, 0),
+		ExecTime: 1000000,
+	})
+	if score.Rarity != 1 {
+		t.Errorf("恢复后全新路径的稀有性应为满分, 实际 %f", score.Rarity)
+	}
+}
+
+func TestScoreExcludedCalls(t *testing.T) {
+	progs := generateScoringTestProgs(t, 10)
+	// 找到一个调用和一个不包含该调用的程序。
+	var excluded, included *prog.Prog
+	var name string
+	for _, p := range progs {
+		for _, c := range p.Calls {
+			for _, other := range progs {
+				if !slices.ContainsFunc(other.Calls, func(oc *prog.Call) bool { return oc.Meta.CallName == c.Meta.CallName }) {
+					excluded, included, name = p, other, c.Meta.CallName
+				}
+			}
+		}
+	}
+	if excluded == nil {
+		t.Fatalf("所有测试程序都包含相同的调用")
+	}
+	config := DefaultScoreConfig()
+	config.ScoreExcludedCalls = map[string]bool{name: true}
+	tracker := NewScoreTracker(config)
+	result := func(p *prog.Prog) *ExecutionResult {
+		return &ExecutionResult{
+			Signal:       signal.FromRaw([]uint64{1, 2, 3}, 0),
+			ExecTime:     1000000,
+			KernelLogs:   []string{"KASAN: use-after-free Read in foo"},
+			Crashed:      true,
+			CallSequence: callSequence(p),
+		}
+	}
+	// 新覆盖和严重的内核日志都不影响包含被排除的调用的程序的评分。
+	score := tracker.UpdateScore(excluded, result(excluded))
+	if score.Total != neutralScore || score.Coverage != 0 || score.KernelLog != 0 {
+		t.Errorf("包含被排除的调用的程序应得到中等分数, 实际 %+v", score)
+	}
+	if stored := tracker.GetScoreByHash(excluded.Hash()); stored == nil || stored.Total != neutralScore {
+		t.Errorf("记录的评分错误: %+v", stored)
+	}
+	if pcs := tracker.DistinctPCs(); pcs != 0 {
+		t.Errorf("被排除的程序的执行不应更新评分统计, 记录了 %v 个 PC", pcs)
+	}
+	// 其他程序照常评分。
+	if score := tracker.UpdateScore(included, result(included)); score.Total <= neutralScore {
+		t.Errorf("没有被排除的程序的评分应高于中等分数, 实际 %+v", score)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"sync"
+	"time"
+)
+
+// smashWindow 估算剩余价值时考虑的最近 smash 作业数量
+const smashWindow = 8
+
+// smashStats 按程序记录最近若干次 smash 作业的变异成功情况，
+// 用于估算继续 smash 该程序的剩余价值 (收益递减)。
+type smashStats struct {
+	mu    sync.Mutex
+	progs map[string]*smashHistory
+}
+
+type smashHistory struct {
+	// 最近 smashWindow 次 smash 作业的 (成功变异数, 总变异数)，环形缓冲区
+	successful [smashWindow]int
+	total      [smashWindow]int
+	jobs       int
+	// 最近一次开始 smash 的时间
+	lastStart time.Time
+}
+
+func newSmashStats() *smashStats {
+	return &smashStats{
+		progs: make(map[string]*smashHistory),
+	}
+}
+
+// tryStart 检查程序距上一次 smash 是否已超过 cooldown，
+// 如果是则记录本次开始时间并返回 true，否则返回 false，调用方不应启动 smash 作业。
+// 记录的程序数超过 limit (0 表示不限制) 时先淘汰冷却期已过的记录，仍然超出时随机淘汰。
+// 只有 tryStart 会为新程序创建记录 (record 总在 tryStart 之后调用)，因此记录数受 limit 限制。
+func (ss *smashStats) tryStart(progHash string, cooldown time.Duration, limit int, now time.Time) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	h := ss.historyLocked(progHash)
+	if cooldown > 0 && !h.lastStart.IsZero() && now.Sub(h.lastStart) < cooldown {
+		return false
+	}
+	h.lastStart = now
+	if limit > 0 && len(ss.progs) > limit {
+		ss.pruneLocked(progHash, cooldown, limit, now)
+	}
+	return true
+}
+
+// pruneLocked 淘汰 keep 以外的记录，直到记录数不超过 limit
+func (ss *smashStats) pruneLocked(keep string, cooldown time.Duration, limit int, now time.Time) {
+	for hash, h := range ss.progs {
+		if hash != keep && cooldown > 0 && now.Sub(h.lastStart) >= cooldown {
+			delete(ss.progs, hash)
+		}
+	}
+	// 仍然超出上限时随机淘汰 (map 的遍历顺序是随机的)
+	for hash := range ss.progs {
+		if len(ss.progs) <= limit {
+			break
+		}
+		if hash != keep {
+			delete(ss.progs, hash)
+		}
+	}
+}
+
+// record 记录一次 smash 作业的结果
+func (ss *smashStats) record(progHash string, successful, total int) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	h := ss.historyLocked(progHash)
+	idx := h.jobs % smashWindow
+	h.successful[idx] = successful
+	h.total[idx] = total
+	h.jobs++
+}
+
+// remainingValue 返回继续 smash 该程序的剩余价值估计 (0.0-1.0)，
+// 即最近窗口内使评分提升的变异所占比例。没有历史记录的程序返回 1.0。
+func (ss *smashStats) remainingValue(progHash string) float64 {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	h := ss.progs[progHash]
+	if h == nil {
+		return 1.0
+	}
+	successful, total := 0, 0
+	for i := 0; i < min(h.jobs, smashWindow); i++ {
+		successful += h.successful[i]
+		total += h.total[i]
+	}
+	if total == 0 {
+		return 1.0
+	}
+	return float64(successful) / float64(total)
+}
+
+func (ss *smashStats) historyLocked(progHash string) *smashHistory {
+	h := ss.progs[progHash]
+	if h == nil {
+		h = new(smashHistory)
+		ss.progs[progHash] = h
+	}
+	return h
+}
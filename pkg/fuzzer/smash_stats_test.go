@@ -0,0 +1,68 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmashRemainingValue(t *testing.T) {
+	ss := newSmashStats()
+	// 没有历史的程序仍然值得 smash。
+	assert.Equal(t, 1.0, ss.remainingValue("new"))
+
+	// 早期有提升，但最近一个窗口内没有任何提升。
+	ss.record("flat", 10, 25)
+	for i := 0; i < smashWindow; i++ {
+		ss.record("flat", 0, 25)
+	}
+	assert.Equal(t, 0.0, ss.remainingValue("flat"))
+
+	ss.record("active", 0, 25)
+	ss.record("active", 5, 25)
+	assert.InDelta(t, 0.1, ss.remainingValue("active"), 1e-9)
+	assert.Less(t, ss.remainingValue("flat"), ss.remainingValue("active"))
+}
+
+func TestSmashCooldown(t *testing.T) {
+	ss := newSmashStats()
+	start := time.Now()
+	const cooldown = time.Minute
+	assert.True(t, ss.tryStart("prog", cooldown, 0, start))
+	// 冷却期内不能再次 smash，其他程序不受影响。
+	assert.False(t, ss.tryStart("prog", cooldown, 0, start.Add(cooldown/2)))
+	assert.True(t, ss.tryStart("other", cooldown, 0, start.Add(cooldown/2)))
+	// 冷却期结束后可以再次 smash，并重新开始计时。
+	assert.True(t, ss.tryStart("prog", cooldown, 0, start.Add(cooldown)))
+	assert.False(t, ss.tryStart("prog", cooldown, 0, start.Add(cooldown+time.Second)))
+	// 不设置冷却期时不限制。
+	assert.True(t, ss.tryStart("prog", 0, 0, start.Add(cooldown+time.Second)))
+}
+
+func TestSmashStatsBounded(t *testing.T) {
+	ss := newSmashStats()
+	start := time.Now()
+	const cooldown, limit = time.Minute, 3
+	for i, hash := range []string{"a", "b", "c"} {
+		assert.True(t, ss.tryStart(hash, cooldown, limit, start.Add(time.Duration(i)*time.Second)))
+	}
+	ss.record("a", 0, 25)
+	// 超出上限时先淘汰冷却期已过的记录: "a" 和 "b" 的冷却期已过，"c" 仍在冷却中。
+	assert.True(t, ss.tryStart("d", cooldown, limit, start.Add(cooldown+time.Second)))
+	assert.Len(t, ss.progs, 2)
+	assert.Contains(t, ss.progs, "c")
+	assert.Contains(t, ss.progs, "d")
+	// 被淘汰的程序失去了历史记录和冷却状态。
+	assert.Equal(t, 1.0, ss.remainingValue("a"))
+
+	// 所有记录都在冷却中时随机淘汰，但不淘汰正在开始的程序。
+	for _, hash := range []string{"e", "f", "g"} {
+		assert.True(t, ss.tryStart(hash, cooldown, limit, start.Add(cooldown+2*time.Second)))
+		assert.LessOrEqual(t, len(ss.progs), limit)
+		assert.Contains(t, ss.progs, hash)
+	}
+}
@@ -4,8 +4,12 @@
 package fuzzer
 
 import (
+	"fmt"
+	"math"
 	"sync/atomic"
+	"time"
 
+	"github.com/google/syzkaller/pkg/flatrpc"
 	"github.com/google/syzkaller/pkg/stat"
 	"github.com/google/syzkaller/prog"
 )
@@ -33,6 +37,13 @@ type Stats struct {
 	statExecHint            *stat.Val
 	statExecSeed            *stat.Val
 	statExecCollide         *stat.Val
+	statExecWeighted        *stat.Val
+	statScorerPCs           *stat.Val
+	statWeightedEmptyTop    *stat.Val
+	statWeightedResolveMiss *stat.Val
+	statScoreAverage        *stat.Val
+	statScoreSelected       *stat.Val
+	statSmashSuccess        *stat.Val
 }
 
 type SyscallStats struct {
@@ -80,5 +91,32 @@ func newStats(target *prog.Target) Stats {
 			stat.Rate{}, stat.StackedGraph("exec")),
 		statExecCollide: stat.New("exec collide", "Executions of programs in collide mode",
 			stat.Rate{}, stat.StackedGraph("exec")),
+		statExecWeighted: stat.New("exec weighted", "Executions of mutants from the score-weighted queue",
+			stat.Rate{}, stat.StackedGraph("exec")),
+		statWeightedEmptyTop: stat.New("weighted empty top", "Weighted selections that failed "+
+			"because no programs were scored yet", stat.Rate{}, stat.StackedGraph("weighted fail")),
+		statWeightedResolveMiss: stat.New("weighted resolve miss", "Weighted selections that failed "+
+			"because the selected program is not in the corpus", stat.Rate{}, stat.StackedGraph("weighted fail")),
+	}
+}
+
+// registerScoreStats exposes the program scoring metrics as stats.
+// Stats can only hold integers, so the [0, 1] metrics are stored in thousandths.
+func (stats *Stats) registerScoreStats(metrics *flatrpc.ScoreMetrics) {
+	thousandths := func(get func() float64) func() int {
+		return func() int { return int(math.Round(get() * 1000)) }
+	}
+	formatScore := func(v int, period time.Duration) string {
+		return fmt.Sprintf("%.3f", float64(v)/1000)
+	}
+	formatPercent := func(v int, period time.Duration) string {
+		return fmt.Sprintf("%.1f%%", float64(v)/10)
 	}
+	stats.statScoreAverage = stat.New("avg prog score", "Average score of executed programs",
+		thousandths(func() float64 { return metrics.Snapshot().AverageScore }), formatScore,
+		stat.Graph("scoring"))
+	stats.statScoreSelected = stat.New("score selected", "Share of executions selected by program score",
+		thousandths(metrics.GetScoreSelectionRatio), formatPercent, stat.Graph("scoring"))
+	stats.statSmashSuccess = stat.New("smash success", "Share of smash mutations that improved the program score",
+		thousandths(metrics.GetSmashSuccessRate), formatPercent, stat.Graph("scoring"))
 }
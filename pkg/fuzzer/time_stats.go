@@ -9,22 +9,27 @@ import (
 )
 
 // TimeStats 执行时间统计
+// 样本保存在容量为 maxSamples 的环形缓冲区中，满了以后每个新样本替换最旧的样本。
+// 均值和方差用 Welford 算法随样本的加入和淘汰增量更新，每次插入的代价为 O(1)，
+// 统计指标也不会因为批量淘汰而突变。
 type TimeStats struct {
 	mu sync.RWMutex
 	
-	// 样本数据
+	// 样本环形缓冲区，未满时按加入顺序排列
 	samples []uint64
+	// 缓冲区满后下一个被替换 (即最旧) 的样本的位置
+	next int
 	
 	// 统计指标
-	mean     float64
-	variance float64
-	stdDev   float64
+	mean float64
+	// 与均值之差的平方和 (Welford 算法的 M2)
+	m2 float64
 	
-	// 样本计数
+	// 样本计数 (包括已被淘汰的样本)
 	count int64
 	
-	// 是否需要重新计算统计指标
-	needRecalc bool
+	// 上次精确重新计算统计指标之后淘汰的样本数量，用于定期消除浮点误差的累积
+	evicted int
 	
 	// 最大样本数量 (避免内存无限增长)
 	maxSamples int
@@ -35,7 +40,6 @@ func NewTimeStats() *TimeStats {
 	return &TimeStats{
 		samples:    make([]uint64, 0, 1000),
 		maxSamples: 10000,
-		needRecalc: true,
 	}
 }
 
@@ -44,20 +48,98 @@ func (ts *TimeStats) AddSample(execTime uint64) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 	
-	ts.samples = append(ts.samples, execTime)
 	ts.count++
-	ts.needRecalc = true
+	if len(ts.samples) < ts.maxSamples {
+		ts.samples = append(ts.samples, execTime)
+		ts.addLocked(float64(execTime))
+		return
+	}
 	
-	// 如果样本数量超过限制，移除最旧的样本
-	if len(ts.samples) > ts.maxSamples {
-		// 移除前一半样本
-		copy(ts.samples, ts.samples[ts.maxSamples/2:])
-		ts.samples = ts.samples[:ts.maxSamples/2]
+	// 样本数量达到限制，替换最旧的样本
+	ts.removeLocked(float64(ts.samples[ts.next]))
+	ts.samples[ts.next] = execTime
+	ts.next = (ts.next + 1) % len(ts.samples)
+	ts.addLocked(float64(execTime))
+	
+	// 增量更新会累积浮点误差，每淘汰一整轮样本精确地重新计算一次，均摊代价仍为 O(1)
+	ts.evicted++
+	if ts.evicted >= len(ts.samples) {
+		ts.recalculateStats()
 	}
 }
 
-// CalculateAnomalyScore 计算时间异常分数
+// addLocked 把样本计入均值和方差，samples 中已经包含该样本
+func (ts *TimeStats) addLocked(x float64) {
+	delta := x - ts.mean
+	ts.mean += delta / float64(len(ts.samples))
+	ts.m2 += delta * (x - ts.mean)
+}
+
+// removeLocked 从均值和方差中去掉样本，samples 中仍然包含该样本
+func (ts *TimeStats) removeLocked(x float64) {
+	n := float64(len(ts.samples))
+	if n <= 1 {
+		ts.mean, ts.m2 = 0, 0
+		return
+	}
+	mean := (n*ts.mean - x) / (n - 1)
+	ts.m2 = max(ts.m2-(x-ts.mean)*(x-mean), 0)
+	ts.mean = mean
+}
+
+// AddTime 添加执行时间样本，与 AddSample 相同
+func (ts *TimeStats) AddTime(execTime uint64) {
+	ts.AddSample(execTime)
+}
+
+// TimeAnomalyMode 决定执行时间异常分数如何对待比均值快和比均值慢的执行
+type TimeAnomalyMode string
+
+const (
+	// TimeAnomalySymmetric 快慢两个方向的偏离同等计分 (默认)
+	TimeAnomalySymmetric TimeAnomalyMode = "symmetric"
+	// TimeAnomalySlow 只有比均值慢的执行计分，慢执行更可能意味着锁竞争或接近死循环的路径
+	TimeAnomalySlow TimeAnomalyMode = "slow"
+	// TimeAnomalySlowWeighted 两个方向都计分，但快执行的偏离只按 fastAnomalyFactor 计入
+	TimeAnomalySlowWeighted TimeAnomalyMode = "slow_weighted"
+)
+
+// fastAnomalyFactor 是 TimeAnomalySlowWeighted 模式下快执行偏离的权重
+const fastAnomalyFactor = 0.25
+
+// valid 检查模式是否已知，空字符串表示默认的 TimeAnomalySymmetric
+func (mode TimeAnomalyMode) valid() bool {
+	switch mode {
+	case "", TimeAnomalySymmetric, TimeAnomalySlow, TimeAnomalySlowWeighted:
+		return true
+	}
+	return false
+}
+
+// CalculateAnomalyScore 计算时间异常分数，快慢两个方向同等计分
 func (ts *TimeStats) CalculateAnomalyScore(execTime uint64) float64 {
+	return ts.AnomalyScore(execTime, TimeAnomalySymmetric)
+}
+
+// AnomalyScore 按给定的模式把 ZScore 转换为 0-1 范围的异常分数
+func (ts *TimeStats) AnomalyScore(execTime uint64, mode TimeAnomalyMode) float64 {
+	zScore := ts.ZScore(execTime)
+	switch mode {
+	case TimeAnomalySlow:
+		zScore = max(zScore, 0)
+	case TimeAnomalySlowWeighted:
+		if zScore < 0 {
+			zScore *= fastAnomalyFactor
+		}
+	}
+	
+	// Z-score > 2 被认为是显著异常
+	return math.Min(math.Abs(zScore)/2.0, 1.0)
+}
+
+// ZScore 返回执行时间相对于样本的有符号 Z-score (标准化分数)，比均值慢的执行为正。
+// 样本数量不足或样本没有差异时返回 0。
+func (ts *TimeStats) ZScore(execTime uint64) float64 {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 	
@@ -66,53 +148,31 @@ func (ts *TimeStats) CalculateAnomalyScore(execTime uint64) float64 {
 		return 0.0
 	}
 	
-	if ts.needRecalc {
-		ts.mu.RUnlock()
-		ts.mu.Lock()
-		ts.recalculateStats()
-		ts.mu.Unlock()
-		ts.mu.RLock()
-	}
-	
-	if ts.stdDev == 0 {
+	stdDev := ts.stdDevLocked()
+	if stdDev == 0 {
 		return 0.0
 	}
 	
-	// 计算 Z-score (标准化分数)
-	zScore := math.Abs(float64(execTime)-ts.mean) / ts.stdDev
-	
-	// 将 Z-score 转换为 0-1 范围的异常分数
-	// Z-score > 2 被认为是显著异常
-	anomalyScore := math.Min(zScore/2.0, 1.0)
-	
-	return anomalyScore
+	return (float64(execTime) - ts.mean) / stdDev
 }
 
-// recalculateStats 重新计算统计指标
-func (ts *TimeStats) recalculateStats() {
+// stdDevLocked 返回当前样本的 (总体) 标准差
+func (ts *TimeStats) stdDevLocked() float64 {
 	if len(ts.samples) == 0 {
-		return
-	}
-	
-	// 计算均值
-	sum := uint64(0)
-	for _, sample := range ts.samples {
-		sum += sample
+		return 0
 	}
-	ts.mean = float64(sum) / float64(len(ts.samples))
-	
-	// 计算方差
-	varianceSum := 0.0
-	for _, sample := range ts.samples {
-		diff := float64(sample) - ts.mean
-		varianceSum += diff * diff
+	return math.Sqrt(ts.m2 / float64(len(ts.samples)))
+}
+
+// recalculateStats 根据当前的全部样本精确地重新计算统计指标
+func (ts *TimeStats) recalculateStats() {
+	ts.mean, ts.m2, ts.evicted = 0, 0, 0
+	for i, sample := range ts.samples {
+		x := float64(sample)
+		delta := x - ts.mean
+		ts.mean += delta / float64(i+1)
+		ts.m2 += delta * (x - ts.mean)
 	}
-	ts.variance = varianceSum / float64(len(ts.samples))
-	
-	// 计算标准差
-	ts.stdDev = math.Sqrt(ts.variance)
-	
-	ts.needRecalc = false
 }
 
 // GetStats 获取统计信息
@@ -120,13 +180,44 @@ func (ts *TimeStats) GetStats() (mean, stdDev float64, count int64) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 	
-	if ts.needRecalc {
-		ts.mu.RUnlock()
-		ts.mu.Lock()
-		ts.recalculateStats()
-		ts.mu.Unlock()
-		ts.mu.RLock()
+	return ts.mean, ts.stdDevLocked(), ts.count
+}
+
+// GetMean 获取执行时间的均值
+func (ts *TimeStats) GetMean() float64 {
+	mean, _, _ := ts.GetStats()
+	return mean
+}
+
+// GetStdDev 获取执行时间的标准差
+func (ts *TimeStats) GetStdDev() float64 {
+	_, stdDev, _ := ts.GetStats()
+	return stdDev
+}
+
+// snapshot 返回按加入顺序排列的样本和样本计数的副本
+func (ts *TimeStats) snapshot() timeStatsSnapshot {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	samples := make([]uint64, 0, len(ts.samples))
+	samples = append(samples, ts.samples[ts.next:]...)
+	samples = append(samples, ts.samples[:ts.next]...)
+	return timeStatsSnapshot{
+		Samples: samples,
+		Count:   ts.count,
 	}
-	
-	return ts.mean, ts.stdDev, ts.count
-}
\ No newline at end of file
+}
+
+// restore 用快照替换样本，样本过多时只保留最新的 maxSamples 个
+func (ts *TimeStats) restore(snapshot timeStatsSnapshot) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	samples := snapshot.Samples
+	if len(samples) > ts.maxSamples {
+		samples = samples[len(samples)-ts.maxSamples:]
+	}
+	ts.samples = append(ts.samples[:0], samples...)
+	ts.next = 0
+	ts.count = max(snapshot.Count, int64(len(samples)))
+	ts.recalculateStats()
+}
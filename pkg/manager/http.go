@@ -947,6 +947,7 @@ func (serv *HTTPServer) httpJobs(w http.ResponseWriter, r *http.Request) {
 			Short: item.Name,
 			Execs: item.Execs.Load(),
 			Calls: strings.Join(item.Calls, ", "),
+			Score: item.Score(),
 		})
 	}
 	sort.Slice(data.Jobs, func(i, j int) bool {
@@ -1165,6 +1166,7 @@ type UIJobInfo struct {
 	Short string
 	Calls string
 	Execs int32
+	Score float64
 }
 
 type UITextPage struct {
@@ -50,8 +50,11 @@ type Config struct {
 	DebugTimeouts bool
 	Procs         int
 	Slowdown      int
-	pcBase        uint64
-	localModules  []*vminfo.KernelModule
+	// Extract the data used for program scoring (kernel log lines) when assembling program results.
+	// Otherwise the fuzzer extracts it from the output only for the results it scores.
+	ScoringInfo  bool
+	pcBase       uint64
+	localModules []*vminfo.KernelModule
 
 	// RPCServer closes the channel once the machine check has begun. Used for fault injection during testing.
 	machineCheckStarted chan struct{}
@@ -62,6 +65,8 @@ type RemoteConfig struct {
 	Manager Manager
 	Stats   Stats
 	Debug   bool
+	// See Config.ScoringInfo.
+	ScoringInfo bool
 }
 
 type Manager interface {
@@ -181,6 +186,7 @@ func New(cfg *RemoteConfig) (Server, error) {
 		PrintMachineCheck: true,
 		Procs:             cfg.Procs,
 		Slowdown:          cfg.Timeouts.Slowdown,
+		ScoringInfo:       cfg.ScoringInfo,
 		pcBase:            pcBase,
 		localModules:      cfg.LocalModules,
 	}, cfg.Manager), nil
@@ -541,6 +547,7 @@ func (serv *server) CreateInstance(id int, injectExec chan<- bool, updInfo dispa
 		filterSignal:  serv.cfg.FilterSignal,
 		debug:         serv.cfg.Debug,
 		debugTimeouts: serv.cfg.DebugTimeouts,
+		scoringInfo:   serv.cfg.ScoringInfo,
 		sysTarget:     serv.sysTarget,
 		injectExec:    injectExec,
 		infoc:         make(chan chan []byte),
@@ -33,6 +33,7 @@ type Runner struct {
 	filterSignal  bool
 	debug         bool
 	debugTimeouts bool
+	scoringInfo   bool
 	sysTarget     *targets.Target
 	stats         *runnerStats
 	finished      chan bool
@@ -449,7 +450,7 @@ func (runner *Runner) handleExecResult(msg *flatrpc.ExecResult) error {
 		}
 		runner.hanged[msg.Id] = true
 	}
-	req.Done(&queue.Result{
+	res := &queue.Result{
 		Executor: queue.ExecutorID{
 			VM:   runner.id,
 			Proc: int(msg.Proc),
@@ -458,10 +459,22 @@ func (runner *Runner) handleExecResult(msg *flatrpc.ExecResult) error {
 		Info:   msg.Info,
 		Output: slices.Clone(msg.Output),
 		Err:    resErr,
-	})
+	}
+	if runner.scoringInfo && req.Type == flatrpc.RequestTypeProgram {
+		res.Scoring = newScoringInfo(res.Info, res.Output)
+	}
+	req.Done(res)
 	return nil
 }
 
+// newScoringInfo extracts the data used for program scoring from the execution result once,
+// so that the fuzzer does not need to re-scan the raw output.
+func newScoringInfo(info *flatrpc.ProgInfo, output []byte) *flatrpc.ScoringProgInfo {
+	scoring := flatrpc.NewScoringProgInfo(info)
+	scoring.SetKernelLogs(flatrpc.ExtractKernelLogs(output))
+	return scoring
+}
+
 func (runner *Runner) convertCallInfo(call *flatrpc.CallInfo) {
 	call.Cover = runner.canonicalizer.Canonicalize(call.Cover)
 	call.Signal = runner.canonicalizer.Canonicalize(call.Signal)
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/google/syzkaller/pkg/hash"
 	"github.com/google/syzkaller/pkg/image"
 )
 
@@ -34,6 +35,11 @@ func (p *Prog) SerializeVerbose() []byte {
 	return p.serialize(true)
 }
 
+// Hash returns a stable identifier of the program: the hash of its serialized form.
+func (p *Prog) Hash() string {
+	return hash.String(p.Serialize())
+}
+
 func (p *Prog) serialize(verbose bool) []byte {
 	p.debugValidate()
 	ctx := &serializer{
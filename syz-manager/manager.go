@@ -80,6 +80,9 @@ type Manager struct {
 	reportGenerator *manager.ReportGeneratorWrapper
 	fresh           bool
 	coverFilters    manager.CoverageFilters
+	// Pre-parse the data used for program scoring when assembling program results,
+	// both in the RPC server and in snapshot mode (see rpcserver.Config.ScoringInfo).
+	scoringInfo bool
 
 	dash *dashapi.Dashboard
 	// This is specifically separated from dash, so that we can keep dash = nil when
@@ -305,6 +308,8 @@ func RunManager(mode *Mode, cfg *mgrconfig.Config) {
 		Manager: mgr,
 		Stats:   mgr.servStats,
 		Debug:   *flagDebug,
+
+		ScoringInfo: mgr.scoringInfo,
 	}
 	mgr.serv, err = rpcserver.New(rpcCfg)
 	if err != nil {
@@ -163,6 +163,10 @@ func (mgr *Manager) snapshotRun(inst *vm.Instance, builder *flatbuffers.Builder,
 	if req.ReturnOutput {
 		ret.Output = output
 	}
+	if mgr.scoringInfo {
+		ret.Scoring = flatrpc.NewScoringProgInfo(ret.Info)
+		ret.Scoring.SetKernelLogs(flatrpc.ExtractKernelLogs(output))
+	}
 	return ret, output, nil
 }
 